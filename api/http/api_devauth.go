@@ -17,10 +17,12 @@ import (
 	"encoding/json"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/mendersoftware/go-lib-micro/identity"
 
 	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/globalsign/mgo/bson"
 	"github.com/mendersoftware/go-lib-micro/log"
 	"github.com/mendersoftware/go-lib-micro/rest_utils"
 	"github.com/pkg/errors"
@@ -33,15 +35,22 @@ import (
 )
 
 const (
-	uriAuthReqs = "/api/devices/v1/authentication/auth_requests"
+	uriAuthReqs       = "/api/devices/v1/authentication/auth_requests"
+	uriAuthChallenges = "/api/devices/v1/authentication/challenges"
+	uriTokenRenew     = "/api/devices/v1/authentication/tokens/renew"
 
 	// internal API
 	uriTokenVerify        = "/api/internal/v1/devauth/tokens/verify"
+	uriTokenIntrospect    = "/api/internal/v1/devauth/tokens/introspect"
 	uriTenantLimit        = "/api/internal/v1/devauth/tenant/:id/limits/:name"
+	uriTenantTokenPolicy  = "/api/internal/v1/devauth/tenant/:id/token_policy"
 	uriTokens             = "/api/internal/v1/devauth/tokens"
 	uriTenants            = "/api/internal/v1/devauth/tenants"
 	uriTenantDeviceStatus = "/api/internal/v1/devauth/tenants/:tid/devices/:did/status"
 	uriTenantDevices      = "/api/internal/v1/devauth/tenants/:tid/devices"
+	uriJWKS               = "/api/internal/v1/devauth/.well-known/jwks.json"
+	uriTenantJWKS         = "/api/internal/v1/devauth/tenants/:tid/.well-known/jwks.json"
+	uriVerificationCache  = "/api/internal/v1/devauth/verification_cache"
 
 	// management API v2
 	v2uriDevices             = "/api/management/v2/devauth/devices"
@@ -49,15 +58,39 @@ const (
 	v2uriDevice              = "/api/management/v2/devauth/devices/:id"
 	v2uriDeviceAuthSet       = "/api/management/v2/devauth/devices/:id/auth/:aid"
 	v2uriDeviceAuthSetStatus = "/api/management/v2/devauth/devices/:id/auth/:aid/status"
+	v2uriDeviceTokens        = "/api/management/v2/devauth/devices/:id/tokens"
 	v2uriToken               = "/api/management/v2/devauth/tokens/:id"
 	v2uriDevicesLimit        = "/api/management/v2/devauth/limits/:name"
+	v2uriTrustedCAs          = "/api/management/v2/devauth/trusted_cas"
+	v2uriTrustedCA           = "/api/management/v2/devauth/trusted_cas/:id"
+	v2uriTokenScopes         = "/api/management/v2/devauth/token_scopes"
+	v2uriAuthLockouts        = "/api/management/v2/devauth/auth_lockouts"
+	// relaxed placeholder, lockout IDs contain IP addresses
+	v2uriAuthLockout = "/api/management/v2/devauth/auth_lockouts/#id"
+	v2uriClaimCodes  = "/api/management/v2/devauth/claim_codes"
+	v2uriClaimCode   = "/api/management/v2/devauth/claim_codes/:id"
+
+	v2uriAdmissionRules     = "/api/management/v2/devauth/admission_rules"
+	v2uriAdmissionRule      = "/api/management/v2/devauth/admission_rules/:id"
+	v2uriAdmissionRuleMatch = "/api/management/v2/devauth/admission_rules/match"
+	v2uriAdmissionWebhook   = "/api/management/v2/devauth/admission_webhook"
+
+	v2uriIdentitySchema           = "/api/management/v2/devauth/identity_schema"
+	v2uriIdentitySchemaViolations = "/api/management/v2/devauth/identity_schema/violations"
 
 	HdrAuthReqSign = "X-MEN-Signature"
+	// scope the token must be valid for, set by the API gateway on token
+	// verification
+	HdrRequiredScope = "X-MEN-Required-Scope"
+	// comma separated list of audiences the token must have been issued
+	// for, set by the API gateway on token verification
+	HdrAcceptedAudiences = "X-MEN-Accepted-Audiences"
 )
 
 var (
 	ErrIncorrectStatus = errors.New("incorrect device status")
 	ErrNoAuthHeader    = errors.New("no authorization header")
+	ErrNoToken         = errors.New("no token")
 
 	DevStatuses = []string{model.DevStatusPending, model.DevStatusRejected, model.DevStatusAccepted, model.DevStatusPreauth}
 )
@@ -78,18 +111,32 @@ func NewDevAuthApiHandlers(devAuth devauth.App, db store.DataStore) ApiHandler {
 	}
 }
 
-func (d *DevAuthApiHandlers) GetApp() (rest.App, error) {
-	routes := []*rest.Route{
+// deviceRoutes are the routes of the devices API
+func (d *DevAuthApiHandlers) deviceRoutes() []*rest.Route {
+	return []*rest.Route{
 		rest.Post(uriAuthReqs, d.SubmitAuthRequestHandler),
+		rest.Post(uriAuthChallenges, d.PostAuthChallengeHandler),
+		rest.Post(uriTokenRenew, d.RenewTokenHandler),
+	}
+}
+
+func (d *DevAuthApiHandlers) GetApp() (rest.App, error) {
+	routes := append(d.deviceRoutes(),
 		rest.Post(uriTokenVerify, d.VerifyTokenHandler),
+		rest.Post(uriTokenIntrospect, d.IntrospectTokenHandler),
 		rest.Delete(uriTokens, d.DeleteTokensHandler),
 
 		rest.Put(uriTenantLimit, d.PutTenantLimitHandler),
 		rest.Get(uriTenantLimit, d.GetTenantLimitHandler),
+		rest.Put(uriTenantTokenPolicy, d.PutTenantTokenPolicyHandler),
+		rest.Get(uriTenantTokenPolicy, d.GetTenantTokenPolicyHandler),
 
 		rest.Post(uriTenants, d.ProvisionTenantHandler),
 		rest.Get(uriTenantDeviceStatus, d.GetTenantDeviceStatus),
 		rest.Get(uriTenantDevices, d.GetTenantDevicesHandler),
+		rest.Get(uriJWKS, d.GetJWKSHandler),
+		rest.Get(uriTenantJWKS, d.GetTenantJWKSHandler),
+		rest.Get(uriVerificationCache, d.GetVerificationCacheStatsHandler),
 
 		// API v2
 		rest.Get(v2uriDevicesCount, d.GetDevicesCountHandler),
@@ -100,10 +147,45 @@ func (d *DevAuthApiHandlers) GetApp() (rest.App, error) {
 		rest.Delete(v2uriDeviceAuthSet, d.DeleteDeviceAuthSetHandler),
 		rest.Put(v2uriDeviceAuthSetStatus, d.UpdateDeviceStatusHandler),
 		rest.Get(v2uriDeviceAuthSetStatus, d.GetAuthSetStatusHandler),
+		rest.Get(v2uriDeviceTokens, d.GetDeviceTokensHandler),
+		rest.Delete(v2uriDeviceTokens, d.DeleteDeviceTokensHandler),
 		rest.Delete(v2uriToken, d.DeleteTokenHandler),
 		rest.Get(v2uriDevicesLimit, d.GetLimitHandler),
-	}
+		rest.Get(v2uriTrustedCAs, d.GetTrustedCAsHandler),
+		rest.Post(v2uriTrustedCAs, d.PostTrustedCAHandler),
+		rest.Delete(v2uriTrustedCA, d.DeleteTrustedCAHandler),
+		rest.Get(v2uriTokenScopes, d.GetTokenScopesHandler),
+		rest.Put(v2uriTokenScopes, d.PutTokenScopesHandler),
+		rest.Get(v2uriAuthLockouts, d.GetAuthLockoutsHandler),
+		rest.Delete(v2uriAuthLockout, d.DeleteAuthLockoutHandler),
+		rest.Get(v2uriClaimCodes, d.GetClaimCodesHandler),
+		rest.Post(v2uriClaimCodes, d.PostClaimCodeHandler),
+		rest.Delete(v2uriClaimCode, d.DeleteClaimCodeHandler),
+		rest.Get(v2uriAdmissionRules, d.GetAdmissionRulesHandler),
+		rest.Post(v2uriAdmissionRules, d.PostAdmissionRuleHandler),
+		rest.Post(v2uriAdmissionRuleMatch, d.MatchAdmissionRuleHandler),
+		rest.Get(v2uriAdmissionRule, d.GetAdmissionRuleHandler),
+		rest.Put(v2uriAdmissionRule, d.PutAdmissionRuleHandler),
+		rest.Delete(v2uriAdmissionRule, d.DeleteAdmissionRuleHandler),
+		rest.Get(v2uriAdmissionWebhook, d.GetAdmissionWebhookHandler),
+		rest.Put(v2uriAdmissionWebhook, d.PutAdmissionWebhookHandler),
+		rest.Delete(v2uriAdmissionWebhook, d.DeleteAdmissionWebhookHandler),
+		rest.Get(v2uriIdentitySchema, d.GetIdentitySchemaHandler),
+		rest.Put(v2uriIdentitySchema, d.PutIdentitySchemaHandler),
+		rest.Delete(v2uriIdentitySchema, d.DeleteIdentitySchemaHandler),
+		rest.Get(v2uriIdentitySchemaViolations, d.GetIdentitySchemaViolationsHandler),
+	)
+
+	return makeRouter(routes)
+}
+
+// GetDevicesApp returns the router of the devices API only, served to
+// devices authenticating with a client certificate
+func (d *DevAuthApiHandlers) GetDevicesApp() (rest.App, error) {
+	return makeRouter(d.deviceRoutes())
+}
 
+func makeRouter(routes []*rest.Route) (rest.App, error) {
 	app, err := rest.MakeRouter(
 		// augment routes with OPTIONS handler
 		AutogenOptionsRoutes(routes, AllowHeaderOptionsGenerator)...,
@@ -139,6 +221,15 @@ func (d *DevAuthApiHandlers) SubmitAuthRequestHandler(w rest.ResponseWriter, r *
 		return
 	}
 
+	// client certificates from the TLS handshake, unless the certificate
+	// chain is provided in the request itself
+	if authreq.Certificate == "" && !authreq.IsPSK() &&
+		r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		authreq.CertChain = r.TLS.PeerCertificates
+	}
+
+	authreq.RemoteIP = utils.RemoteIP(r.Request)
+
 	err = authreq.Validate()
 	if err != nil {
 		err = errors.Wrap(err, "invalid auth request")
@@ -153,8 +244,21 @@ func (d *DevAuthApiHandlers) SubmitAuthRequestHandler(w rest.ResponseWriter, r *
 		return
 	}
 
-	err = utils.VerifyAuthReqSign(signature, authreq.PubKeyStruct, body)
-	if err != nil {
+	if authreq.IsPSK() {
+		// the pre-shared key is known only to the service, see
+		// SubmitAuthRequest
+		authreq.Signature = signature
+		authreq.Body = body
+	} else if err = utils.VerifyAuthReqSign(signature, authreq.PubKeyStruct, body); err != nil {
+		switch lerr := d.devAuth.RecordAuthFailure(ctx, &authreq); lerr {
+		case nil:
+		case devauth.ErrAuthLockedOut:
+			rest_utils.RestErrWithWarningMsg(w, r, l, err,
+				http.StatusTooManyRequests, lerr.Error())
+			return
+		default:
+			l.Errorf("failed to record auth failure: %v", lerr)
+		}
 		rest_utils.RestErrWithLogMsg(w, r, l, err, http.StatusUnauthorized, "signature verification failed")
 		return
 	}
@@ -180,6 +284,10 @@ func (d *DevAuthApiHandlers) SubmitAuthRequestHandler(w rest.ResponseWriter, r *
 		rest_utils.RestErrWithWarningMsg(w, r, l, devauth.ErrDevAuthUnauthorized,
 			http.StatusUnauthorized, "unauthorized")
 		return
+	case devauth.ErrAuthLockedOut:
+		rest_utils.RestErrWithWarningMsg(w, r, l, err,
+			http.StatusTooManyRequests, err.Error())
+		return
 	case nil:
 		w.(http.ResponseWriter).Write([]byte(token))
 		w.Header().Set("Content-Type", "application/jwt")
@@ -190,6 +298,79 @@ func (d *DevAuthApiHandlers) SubmitAuthRequestHandler(w rest.ResponseWriter, r *
 	}
 }
 
+// RenewTokenHandler exchanges the device token for a new one; the request is
+// signed with the device key, like auth requests.
+func (d *DevAuthApiHandlers) RenewTokenHandler(w rest.ResponseWriter, r *rest.Request) {
+	var req model.TokenRenewReq
+
+	ctx := r.Context()
+
+	l := log.FromContext(ctx)
+
+	// raw body is covered by the signature
+	body, err := utils.ReadBodyRaw(r)
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode token renewal request")
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode token renewal request")
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	err = req.Validate()
+	if err != nil {
+		err = errors.Wrap(err, "invalid token renewal request")
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	req.Body = body
+	req.Signature = r.Header.Get(HdrAuthReqSign)
+	if req.Signature == "" {
+		rest_utils.RestErrWithLog(w, r, l, errors.New("missing request signature header"), http.StatusBadRequest)
+		return
+	}
+
+	token, err := d.devAuth.RenewToken(ctx, &req)
+	if err != nil {
+		if devauth.IsErrDevAuthUnauthorized(err) {
+			rest_utils.RestErrWithWarningMsg(w, r, l, err,
+				http.StatusUnauthorized, errors.Cause(err).Error())
+			return
+		} else if devauth.IsErrDevAuthBadRequest(err) {
+			rest_utils.RestErrWithWarningMsg(w, r, l, err,
+				http.StatusBadRequest, errors.Cause(err).Error())
+			return
+		}
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jwt")
+	w.(http.ResponseWriter).Write([]byte(token))
+}
+
+// PostAuthChallengeHandler issues a server nonce for the challenge-response
+// auth flow.
+func (d *DevAuthApiHandlers) PostAuthChallengeHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+
+	l := log.FromContext(ctx)
+
+	ch, err := d.devAuth.CreateAuthChallenge(ctx)
+	if err != nil {
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	_ = w.WriteJson(ch)
+}
+
 func (d *DevAuthApiHandlers) PostDevicesV2Handler(w rest.ResponseWriter, r *rest.Request) {
 	ctx := r.Context()
 
@@ -209,6 +390,12 @@ func (d *DevAuthApiHandlers) PostDevicesV2Handler(w rest.ResponseWriter, r *rest
 	}
 
 	err = d.devAuth.PreauthorizeDevice(ctx, reqDbModel)
+	if err != nil && devauth.IsErrDevAuthBadRequest(err) {
+		rest_utils.RestErrWithLogMsg(w, r, l, err,
+			http.StatusBadRequest, errors.Cause(err).Error())
+		return
+	}
+
 	switch err {
 	case nil:
 		w.WriteHeader(http.StatusCreated)
@@ -376,6 +563,76 @@ func (d *DevAuthApiHandlers) DeleteTokenHandler(w rest.ResponseWriter, r *rest.R
 	w.WriteHeader(http.StatusNoContent)
 }
 
+func (d *DevAuthApiHandlers) GetDeviceTokensHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+
+	l := log.FromContext(ctx)
+
+	page, perPage, err := rest_utils.ParsePagination(r)
+	if err != nil {
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	var filter model.TokenFilter
+
+	filter.Id, err = rest_utils.ParseQueryParmStr(r, "jti", false, nil)
+	if err != nil {
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	filter.AuthSetId, err = rest_utils.ParseQueryParmStr(r, "auth_id", false, nil)
+	if err != nil {
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	skip := (page - 1) * perPage
+	limit := perPage + 1
+	toks, err := d.devAuth.GetDeviceTokens(ctx, r.PathParam("id"),
+		uint(skip), uint(limit), filter)
+	if err != nil {
+		if err == store.ErrDevNotFound {
+			rest_utils.RestErrWithLog(w, r, l, err, http.StatusNotFound)
+			return
+		}
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	len := len(toks)
+	hasNext := false
+	if uint64(len) > perPage {
+		hasNext = true
+		len = int(perPage)
+	}
+
+	links := rest_utils.MakePageLinkHdrs(r, page, perPage, hasNext)
+
+	for _, l := range links {
+		w.Header().Add("Link", l)
+	}
+
+	w.WriteJson(toks[:len])
+}
+
+func (d *DevAuthApiHandlers) DeleteDeviceTokensHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+
+	l := log.FromContext(ctx)
+
+	err := d.devAuth.RevokeDeviceTokens(ctx, r.PathParam("id"))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case store.ErrDevNotFound:
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+	}
+}
+
 func (d *DevAuthApiHandlers) VerifyTokenHandler(w rest.ResponseWriter, r *rest.Request) {
 	ctx := r.Context()
 
@@ -388,13 +645,15 @@ func (d *DevAuthApiHandlers) VerifyTokenHandler(w rest.ResponseWriter, r *rest.R
 	}
 
 	// verify token
-	err = d.devAuth.VerifyToken(ctx, tokenStr)
+	err = d.devAuth.VerifyToken(ctx, tokenStr, r.Header.Get(HdrRequiredScope),
+		parseAudiences(r.Header.Get(HdrAcceptedAudiences)))
 	code := http.StatusOK
 	if err != nil {
 		switch err {
-		case jwt.ErrTokenExpired:
+		case jwt.ErrTokenExpired, devauth.ErrTokenScopeMissing:
 			code = http.StatusForbidden
-		case store.ErrTokenNotFound, jwt.ErrTokenInvalid:
+		case store.ErrTokenNotFound, jwt.ErrTokenInvalid,
+			devauth.ErrTokenAudienceMismatch:
 			code = http.StatusUnauthorized
 		default:
 			rest_utils.RestErrWithLogInternal(w, r, l, err)
@@ -406,6 +665,44 @@ func (d *DevAuthApiHandlers) VerifyTokenHandler(w rest.ResponseWriter, r *rest.R
 	w.WriteHeader(code)
 }
 
+// parseAudiences splits the comma separated list of audiences
+func parseAudiences(hdr string) []string {
+	var audiences []string
+	for _, aud := range strings.Split(hdr, ",") {
+		if aud = strings.TrimSpace(aud); aud != "" {
+			audiences = append(audiences, aud)
+		}
+	}
+	return audiences
+}
+
+// IntrospectTokenHandler describes the token as in RFC 7662. The token is
+// passed in the 'token' form parameter, or in the Authorization header as in
+// VerifyTokenHandler.
+func (d *DevAuthApiHandlers) IntrospectTokenHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+
+	l := log.FromContext(ctx)
+
+	tokenStr := r.PostFormValue("token")
+	if tokenStr == "" {
+		var err error
+		tokenStr, err = extractToken(r.Header)
+		if err != nil {
+			rest_utils.RestErrWithLog(w, r, l, ErrNoToken, http.StatusBadRequest)
+			return
+		}
+	}
+
+	res, err := d.devAuth.IntrospectToken(ctx, tokenStr)
+	if err != nil {
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	_ = w.WriteJson(res)
+}
+
 func (d *DevAuthApiHandlers) UpdateDeviceStatusHandler(w rest.ResponseWriter, r *rest.Request) {
 	ctx := r.Context()
 
@@ -516,6 +813,49 @@ func (d *DevAuthApiHandlers) GetTenantLimitHandler(w rest.ResponseWriter, r *res
 	w.WriteJson(LimitValue{lim.Value})
 }
 
+func (d *DevAuthApiHandlers) PutTenantTokenPolicyHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+
+	l := log.FromContext(ctx)
+
+	tenantId := r.PathParam("id")
+
+	var policy model.TokenPolicy
+	err := r.DecodeJsonPayload(&policy)
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode token policy")
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := policy.Validate(); err != nil {
+		err = errors.Wrap(err, "invalid token policy")
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := d.devAuth.SetTenantTokenPolicy(ctx, tenantId, policy); err != nil {
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *DevAuthApiHandlers) GetTenantTokenPolicyHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+
+	l := log.FromContext(ctx)
+
+	policy, err := d.devAuth.GetTenantTokenPolicy(ctx, r.PathParam("id"))
+	if err != nil {
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	_ = w.WriteJson(policy)
+}
+
 func (d *DevAuthApiHandlers) GetLimitHandler(w rest.ResponseWriter, r *rest.Request) {
 	ctx := r.Context()
 
@@ -633,6 +973,542 @@ func (d *DevAuthApiHandlers) GetTenantDeviceStatus(w rest.ResponseWriter, r *res
 	}
 }
 
+func (d *DevAuthApiHandlers) GetTrustedCAsHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	cas, err := d.devAuth.GetTrustedCAs(ctx)
+	if err != nil {
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteJson(cas)
+}
+
+func (d *DevAuthApiHandlers) PostTrustedCAHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	req, err := parseTrustedCAReq(r.Body)
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode trusted CA request")
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	err = d.devAuth.AddTrustedCA(ctx, req.getDbModel())
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusCreated)
+	case devauth.ErrTrustedCAExists:
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusConflict)
+	default:
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAuthApiHandlers) DeleteTrustedCAHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	err := d.devAuth.DeleteTrustedCA(ctx, r.PathParam("id"))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case devauth.ErrTrustedCANotFound:
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAuthApiHandlers) GetAuthLockoutsHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	page, perPage, err := rest_utils.ParsePagination(r)
+	if err != nil {
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	var filter model.AuthLockoutFilter
+
+	filter.Type, err = rest_utils.ParseQueryParmStr(r, "type", false,
+		[]string{model.AuthLockoutTypeIdentity, model.AuthLockoutTypeIP})
+	if err != nil {
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	locked, err := rest_utils.ParseQueryParmBool(r, "locked", false, nil)
+	if err != nil {
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+	if locked != nil && *locked {
+		now := time.Now()
+		filter.LockedAt = &now
+	}
+
+	skip := (page - 1) * perPage
+	limit := perPage + 1
+	lockouts, err := d.devAuth.GetAuthLockouts(ctx, uint(skip), uint(limit), filter)
+	if err != nil {
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	len := len(lockouts)
+	hasNext := false
+	if uint64(len) > perPage {
+		hasNext = true
+		len = int(perPage)
+	}
+
+	links := rest_utils.MakePageLinkHdrs(r, page, perPage, hasNext)
+
+	for _, l := range links {
+		w.Header().Add("Link", l)
+	}
+
+	_ = w.WriteJson(lockouts[:len])
+}
+
+func (d *DevAuthApiHandlers) DeleteAuthLockoutHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	err := d.devAuth.DeleteAuthLockout(ctx, r.PathParam("id"))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case devauth.ErrAuthLockoutNotFound:
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAuthApiHandlers) GetClaimCodesHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	codes, err := d.devAuth.GetClaimCodes(ctx)
+	if err != nil {
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteJson(codes)
+}
+
+// PostClaimCodeHandler generates a claim code; the response is the only
+// place the code is returned.
+func (d *DevAuthApiHandlers) PostClaimCodeHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	req, err := parseClaimCodeReq(r.Body)
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode claim code request")
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	code, err := d.devAuth.CreateClaimCode(ctx, req.ExpiresAt)
+	if err != nil {
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_ = w.WriteJson(code)
+}
+
+func (d *DevAuthApiHandlers) DeleteClaimCodeHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	err := d.devAuth.DeleteClaimCode(ctx, r.PathParam("id"))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case devauth.ErrClaimCodeNotFound:
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAuthApiHandlers) GetAdmissionRulesHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	rules, err := d.devAuth.GetAdmissionRules(ctx)
+	if err != nil {
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteJson(rules)
+}
+
+func (d *DevAuthApiHandlers) PostAdmissionRuleHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	rule, err := parseAdmissionRuleReq(r.Body)
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode admission rule request")
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	rule.Id = bson.NewObjectId().Hex()
+	rule.CreatedTs = &now
+	rule.UpdatedTs = &now
+
+	err = d.devAuth.AddAdmissionRule(ctx, rule)
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusCreated)
+		_ = w.WriteJson(rule)
+	case devauth.ErrAdmissionRuleExists:
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusConflict)
+	default:
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAuthApiHandlers) GetAdmissionRuleHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	rule, err := d.devAuth.GetAdmissionRule(ctx, r.PathParam("id"))
+	switch err {
+	case nil:
+		_ = w.WriteJson(rule)
+	case devauth.ErrAdmissionRuleNotFound:
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAuthApiHandlers) PutAdmissionRuleHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	rule, err := parseAdmissionRuleReq(r.Body)
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode admission rule request")
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	rule.Id = r.PathParam("id")
+	rule.UpdatedTs = &now
+
+	err = d.devAuth.UpdateAdmissionRule(ctx, rule)
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case devauth.ErrAdmissionRuleNotFound:
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAuthApiHandlers) DeleteAdmissionRuleHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	err := d.devAuth.DeleteAdmissionRule(ctx, r.PathParam("id"))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case devauth.ErrAdmissionRuleNotFound:
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+	}
+}
+
+// MatchAdmissionRuleHandler is a dry run of the admission rules, returning
+// the rule which would apply to a device with the given identity.
+func (d *DevAuthApiHandlers) MatchAdmissionRuleHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	req, err := parseAdmissionMatchReq(r.Body)
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode admission rule match request")
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	rule, err := d.devAuth.MatchAdmissionRule(ctx, req.IdData, req.keyType)
+	if err != nil {
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	rsp := admissionMatchRsp{
+		Rule:   rule,
+		Action: model.AdmissionActionPending,
+	}
+	if rule != nil {
+		rsp.Action = rule.Action
+	}
+
+	_ = w.WriteJson(rsp)
+}
+
+func (d *DevAuthApiHandlers) GetAdmissionWebhookHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	hook, err := d.devAuth.GetAdmissionWebhook(ctx)
+	switch err {
+	case nil:
+		// the secret is never returned
+		hook.Secret = ""
+		_ = w.WriteJson(hook)
+	case devauth.ErrWebhookNotFound:
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAuthApiHandlers) PutAdmissionWebhookHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	var hook model.AdmissionWebhook
+	err := r.DecodeJsonPayload(&hook)
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode admission webhook")
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := hook.Validate(); err != nil {
+		err = errors.Wrap(err, "invalid admission webhook")
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := d.devAuth.SetAdmissionWebhook(ctx, hook); err != nil {
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *DevAuthApiHandlers) DeleteAdmissionWebhookHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	err := d.devAuth.DeleteAdmissionWebhook(ctx)
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case devauth.ErrWebhookNotFound:
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAuthApiHandlers) GetIdentitySchemaHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	schema, err := d.devAuth.GetIdentitySchema(ctx)
+	switch err {
+	case nil:
+		_ = w.WriteJson(schema)
+	case devauth.ErrIdSchemaNotFound:
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAuthApiHandlers) PutIdentitySchemaHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	var schema model.IdentitySchema
+	err := r.DecodeJsonPayload(&schema)
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode identity schema")
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := schema.Validate(); err != nil {
+		err = errors.Wrap(err, "invalid identity schema")
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := d.devAuth.SetIdentitySchema(ctx, schema); err != nil {
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *DevAuthApiHandlers) DeleteIdentitySchemaHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	err := d.devAuth.DeleteIdentitySchema(ctx)
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case devauth.ErrIdSchemaNotFound:
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAuthApiHandlers) GetIdentitySchemaViolationsHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	page, perPage, err := rest_utils.ParsePagination(r)
+	if err != nil {
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	skip := (page - 1) * perPage
+	limit := perPage + 1
+	violations, err := d.devAuth.GetIdentitySchemaViolations(ctx, uint(skip), uint(limit))
+	if err != nil {
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	len := len(violations)
+	hasNext := false
+	if uint64(len) > perPage {
+		hasNext = true
+		len = int(perPage)
+	}
+
+	links := rest_utils.MakePageLinkHdrs(r, page, perPage, hasNext)
+
+	for _, l := range links {
+		w.Header().Add("Link", l)
+	}
+
+	_ = w.WriteJson(violations[:len])
+}
+
+func (d *DevAuthApiHandlers) GetTokenScopesHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	scopes, err := d.devAuth.GetTokenScopes(ctx)
+	if err != nil {
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	_ = w.WriteJson(scopes)
+}
+
+func (d *DevAuthApiHandlers) PutTokenScopesHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	var scopes model.TokenScopes
+	err := r.DecodeJsonPayload(&scopes)
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode token scopes")
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	if scopes.Scopes == nil {
+		rest_utils.RestErrWithLog(w, r, l,
+			errors.New("invalid token scopes: scopes must be provided"),
+			http.StatusBadRequest)
+		return
+	}
+
+	if err := scopes.Validate(); err != nil {
+		err = errors.Wrap(err, "invalid token scopes")
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := d.devAuth.SetTokenScopes(ctx, scopes); err != nil {
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetJWKSHandler publishes the device token verification keys as a JWK set.
+func (d *DevAuthApiHandlers) GetJWKSHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	jwks, err := d.devAuth.GetJWKS(ctx)
+	if err != nil {
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteJson(jwks)
+}
+
+// GetTenantJWKSHandler publishes the verification keys of the tenant's device
+// tokens as a JWK set.
+func (d *DevAuthApiHandlers) GetTenantJWKSHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	jwks, err := d.devAuth.GetTenantJWKS(ctx, r.PathParam("tid"))
+	if err != nil {
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteJson(jwks)
+}
+
+// GetVerificationCacheStatsHandler reports the counters of the token
+// verification cache of the instance.
+func (d *DevAuthApiHandlers) GetVerificationCacheStatsHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	stats, err := d.devAuth.GetVerificationCacheStats(ctx)
+	switch err {
+	case nil:
+		_ = w.WriteJson(stats)
+	case devauth.ErrVerificationCacheDisabled:
+		rest_utils.RestErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+	}
+}
+
 func (d *DevAuthApiHandlers) GetTenantDevicesHandler(w rest.ResponseWriter, r *rest.Request) {
 	ctx := r.Context()
 	l := log.FromContext(ctx)
@@ -15,14 +15,18 @@ package http
 
 import (
 	"context"
-	"crypto/rsa"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/ant0ine/go-json-rest/rest/test"
@@ -33,12 +37,14 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
+	"github.com/mendersoftware/deviceauth/cache"
 	"github.com/mendersoftware/deviceauth/client/tenant"
 	"github.com/mendersoftware/deviceauth/devauth"
 	"github.com/mendersoftware/deviceauth/devauth/mocks"
 	"github.com/mendersoftware/deviceauth/jwt"
 	"github.com/mendersoftware/deviceauth/model"
 	"github.com/mendersoftware/deviceauth/store"
+	"github.com/mendersoftware/deviceauth/utils"
 	mtest "github.com/mendersoftware/deviceauth/utils/testing"
 	mt "github.com/mendersoftware/go-lib-micro/testing"
 )
@@ -86,7 +92,7 @@ func makeMockApiHandler(t *testing.T, da devauth.App, db store.DataStore) http.H
 // - signed with an actual key
 // - signed with a bogus test value
 // - not signed at all
-func makeAuthReq(payload interface{}, key *rsa.PrivateKey, signature string, t *testing.T) *http.Request {
+func makeAuthReq(payload interface{}, key crypto.Signer, signature string, t *testing.T) *http.Request {
 	r := test.MakeSimpleRequest("POST",
 		"http://1.2.3.4/api/devices/v1/authentication/auth_requests",
 		payload)
@@ -114,6 +120,10 @@ func TestApiDevAuthSubmitAuthReq(t *testing.T) {
 
 	privkey := mtest.LoadPrivKey("testdata/private.pem", t)
 	pubkeyStr := mtest.LoadPubKeyStr("testdata/public.pem", t)
+	privkeyEC := mtest.LoadPrivKeyPKCS8("testdata/private_ecdsa.pem", t)
+	pubkeyECStr := mtest.LoadPubKeyStr("testdata/public_ecdsa.pem", t)
+	privkeyEd := mtest.LoadPrivKeyPKCS8("testdata/private_ed25519.pem", t)
+	pubkeyEdStr := mtest.LoadPubKeyStr("testdata/public_ed25519.pem", t)
 
 	testCases := []struct {
 		req *http.Request
@@ -276,6 +286,169 @@ func TestApiDevAuthSubmitAuthReq(t *testing.T) {
 			400,
 			RestError("invalid auth request: cannot decode public key"),
 		},
+		{
+			//ecdsa key, auth ok
+			makeAuthReq(
+				map[string]interface{}{
+					"id_data":      `{"sn":"0001"}`,
+					"pubkey":       pubkeyECStr,
+					"tenant_token": "tenant-0001",
+				},
+				privkeyEC,
+				"",
+				t),
+			"dummytoken",
+			nil,
+			200,
+			"dummytoken",
+		},
+		{
+			//ecdsa key, signed with a different key
+			makeAuthReq(
+				map[string]interface{}{
+					"id_data":      `{"sn":"0001"}`,
+					"pubkey":       pubkeyECStr,
+					"tenant_token": "tenant-0001",
+				},
+				privkey,
+				"",
+				t),
+			"",
+			nil,
+			401,
+			RestError("signature verification failed"),
+		},
+		{
+			//ed25519 key, auth ok
+			makeAuthReq(
+				map[string]interface{}{
+					"id_data":      `{"sn":"0001"}`,
+					"pubkey":       pubkeyEdStr,
+					"tenant_token": "tenant-0001",
+				},
+				privkeyEd,
+				"",
+				t),
+			"dummytoken",
+			nil,
+			200,
+			"dummytoken",
+		},
+		{
+			//timestamp and nonce, auth ok
+			makeAuthReq(
+				map[string]interface{}{
+					"id_data":   `{"sn":"0001"}`,
+					"pubkey":    pubkeyStr,
+					"timestamp": "2019-06-01T10:00:00Z",
+					"nonce":     "4e1e3b4a",
+				},
+				privkey,
+				"",
+				t),
+			"dummytoken",
+			nil,
+			200,
+			"dummytoken",
+		},
+		{
+			//server challenge, auth ok
+			makeAuthReq(
+				map[string]interface{}{
+					"id_data":   `{"sn":"0001"}`,
+					"pubkey":    pubkeyStr,
+					"challenge": "Hn6gGs3B0-Fp6MZ2Czs0aK3LGOt0a_KrDMEL7yX8SOU",
+				},
+				privkey,
+				"",
+				t),
+			"dummytoken",
+			nil,
+			200,
+			"dummytoken",
+		},
+		{
+			//unknown server challenge
+			makeAuthReq(
+				map[string]interface{}{
+					"id_data":   `{"sn":"0001"}`,
+					"pubkey":    pubkeyStr,
+					"challenge": "Hn6gGs3B0-Fp6MZ2Czs0aK3LGOt0a_KrDMEL7yX8SOU",
+				},
+				privkey,
+				"",
+				t),
+			"",
+			devauth.MakeErrDevAuthUnauthorized(devauth.ErrAuthChallengeInvalid),
+			401,
+			RestError(devauth.ErrAuthChallengeInvalid.Error()),
+		},
+		{
+			//timestamp without nonce
+			makeAuthReq(
+				map[string]interface{}{
+					"id_data":   `{"sn":"0001"}`,
+					"pubkey":    pubkeyStr,
+					"timestamp": "2019-06-01T10:00:00Z",
+				},
+				privkey,
+				"",
+				t),
+			"",
+			nil,
+			400,
+			RestError("invalid auth request: timestamp and nonce must be provided together"),
+		},
+		{
+			//nonce too long
+			makeAuthReq(
+				map[string]interface{}{
+					"id_data":   `{"sn":"0001"}`,
+					"pubkey":    pubkeyStr,
+					"timestamp": "2019-06-01T10:00:00Z",
+					"nonce":     strings.Repeat("a", 129),
+				},
+				privkey,
+				"",
+				t),
+			"",
+			nil,
+			400,
+			RestError("invalid auth request: nonce too long"),
+		},
+		{
+			//replayed request
+			makeAuthReq(
+				map[string]interface{}{
+					"id_data":   `{"sn":"0001"}`,
+					"pubkey":    pubkeyStr,
+					"timestamp": "2019-06-01T10:00:00Z",
+					"nonce":     "4e1e3b4a",
+				},
+				privkey,
+				"",
+				t),
+			"",
+			devauth.MakeErrDevAuthUnauthorized(devauth.ErrAuthReqReplayed),
+			401,
+			RestError(devauth.ErrAuthReqReplayed.Error()),
+		},
+		{
+			//locked out
+			makeAuthReq(
+				map[string]interface{}{
+					"id_data":      `{"sn":"0001"}`,
+					"pubkey":       pubkeyStr,
+					"tenant_token": "tenant-0001",
+				},
+				privkey,
+				"",
+				t),
+			"",
+			devauth.ErrAuthLockedOut,
+			429,
+			RestError(devauth.ErrAuthLockedOut.Error()),
+		},
 	}
 
 	for i := range testCases {
@@ -293,6 +466,10 @@ func TestApiDevAuthSubmitAuthReq(t *testing.T) {
 						return tc.devAuthToken
 					},
 					tc.devAuthErr)
+			da.On("RecordAuthFailure",
+				mtest.ContextMatcher(),
+				mock.AnythingOfType("*model.AuthReq")).
+				Return(nil)
 
 			apih := makeMockApiHandler(t, da, nil)
 
@@ -305,391 +482,2475 @@ func TestApiDevAuthSubmitAuthReq(t *testing.T) {
 	}
 }
 
-func TestApiV2DevAuthPreauthDevice(t *testing.T) {
+func TestApiDevAuthSubmitAuthReqAuthFailure(t *testing.T) {
 	t.Parallel()
 
 	// enforce specific field naming in errors returned by API
 	updateRestErrorFieldName()
 
+	privkey := mtest.LoadPrivKey("testdata/private.pem", t)
 	pubkeyStr := mtest.LoadPubKeyStr("testdata/public.pem", t)
 
-	type brokenPreAuthReq struct {
-		IdData string `json:"identity_data"`
-		PubKey string `json:"pubkey"`
+	testCases := map[string]struct {
+		remoteAddr   string
+		forwardedFor string
+		recordErr    error
+		remoteIP     string
+		code         int
+		body         string
+	}{
+		"failure recorded": {
+			remoteAddr: "10.0.0.1:1234",
+			remoteIP:   "10.0.0.1",
+			code:       401,
+			body:       RestError("signature verification failed"),
+		},
+		"failure recorded, forwarded": {
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: "192.168.1.1, 172.16.0.1",
+			remoteIP:     "172.16.0.1",
+			code:         401,
+			body:         RestError("signature verification failed"),
+		},
+		"locked out": {
+			remoteAddr: "10.0.0.1:1234",
+			recordErr:  devauth.ErrAuthLockedOut,
+			remoteIP:   "10.0.0.1",
+			code:       429,
+			body:       RestError(devauth.ErrAuthLockedOut.Error()),
+		},
+		"error recording failure": {
+			remoteAddr: "10.0.0.1:1234",
+			recordErr:  errors.New("db error"),
+			remoteIP:   "10.0.0.1",
+			code:       401,
+			body:       RestError("signature verification failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := makeAuthReq(
+				map[string]interface{}{
+					"id_data":      `{"sn":"0001"}`,
+					"pubkey":       pubkeyStr,
+					"tenant_token": "tenant-0001",
+				},
+				privkey,
+				"invalidsignature",
+				t)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+
+			da := &mocks.App{}
+			da.On("RecordAuthFailure",
+				mtest.ContextMatcher(),
+				mock.MatchedBy(func(r *model.AuthReq) bool {
+					return r.IdData == `{"sn":"0001"}` &&
+						r.TenantToken == "tenant-0001" &&
+						r.RemoteIP == tc.remoteIP
+				})).
+				Return(tc.recordErr)
+
+			apih := makeMockApiHandler(t, da, nil)
+
+			runTestRequest(t, apih, req, tc.code, tc.body)
+			da.AssertExpectations(t)
+		})
 	}
+}
+
+func TestApiDevAuthSubmitAuthReqPSK(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	pubkeyStr := mtest.LoadPubKeyStr("testdata/public.pem", t)
 
 	testCases := map[string]struct {
-		body interface{}
+		payload   map[string]interface{}
+		signature string
 
-		devAuthErr error
+		devAuthToken string
+		devAuthErr   error
 
-		checker mt.ResponseChecker
+		code int
+		body string
 	}{
 		"ok": {
-			body: &preAuthReq{
-				IdData: map[string]interface{}{
-					"sn": "0001",
-				},
-				PubKey: pubkeyStr,
-			},
-			checker: mt.NewJSONResponse(
-				http.StatusCreated,
-				nil,
-				nil),
-		},
-		"invalid: id data is not json": {
-			body: &brokenPreAuthReq{
-				IdData: `"sn":"0001"`,
-				PubKey: pubkeyStr,
+			payload: map[string]interface{}{
+				"id_data":         `{"sn":"0001"}`,
+				"credential_type": "psk",
+				"tenant_token":    "tenant-0001",
 			},
-			checker: mt.NewJSONResponse(
-				http.StatusBadRequest,
-				nil,
-				restError("failed to decode preauth request: json: cannot unmarshal string into Go struct field preAuthReq.identity_data of type map[string]interface {}")),
+			signature:    "c2lnbmF0dXJl",
+			devAuthToken: "dummytoken",
+			code:         200,
+			body:         "dummytoken",
 		},
-		"invalid: no id data": {
-			body: &preAuthReq{
-				PubKey: pubkeyStr,
+		"signature verification failed": {
+			payload: map[string]interface{}{
+				"id_data":         `{"sn":"0001"}`,
+				"credential_type": "psk",
 			},
-			checker: mt.NewJSONResponse(
-				http.StatusBadRequest,
-				nil,
-				restError("failed to decode preauth request: id_data: non zero value required;")),
+			signature:  "c2lnbmF0dXJl",
+			devAuthErr: devauth.MakeErrDevAuthUnauthorized(devauth.ErrAuthReqSignature),
+			code:       401,
+			body:       RestError("signature verification failed"),
 		},
-		"invalid: no pubkey": {
-			body: &preAuthReq{
-				IdData: map[string]interface{}{
-					"sn": "0001",
-				},
+		"psk not supported": {
+			payload: map[string]interface{}{
+				"id_data":         `{"sn":"0001"}`,
+				"credential_type": "psk",
 			},
-			checker: mt.NewJSONResponse(
-				http.StatusBadRequest,
-				nil,
-				restError("failed to decode preauth request: pubkey: non zero value required;")),
+			signature:  "c2lnbmF0dXJl",
+			devAuthErr: devauth.MakeErrDevAuthBadRequest(devauth.ErrPSKNotSupported),
+			code:       400,
+			body:       RestError(devauth.ErrPSKNotSupported.Error()),
 		},
-		"invalid: no body": {
-			checker: mt.NewJSONResponse(
-				http.StatusBadRequest,
-				nil,
-				restError("failed to decode preauth request: EOF")),
-		},
-		"invalid public key": {
-			body: &preAuthReq{
-				IdData: map[string]interface{}{
-					"sn": "0001",
-				},
-				PubKey: "invalid",
+		"error, missing signature": {
+			payload: map[string]interface{}{
+				"id_data":         `{"sn":"0001"}`,
+				"credential_type": "psk",
 			},
-			devAuthErr: devauth.ErrDeviceExists,
-			checker: mt.NewJSONResponse(
-				http.StatusBadRequest,
-				nil,
-				restError("failed to decode preauth request: cannot decode public key")),
+			code: 400,
+			body: RestError("missing request signature header"),
 		},
-		"devauth: device exists": {
-			body: &preAuthReq{
-				IdData: map[string]interface{}{
-					"sn": "0001",
-				},
-				PubKey: pubkeyStr,
+		"error, pubkey with psk": {
+			payload: map[string]interface{}{
+				"id_data":         `{"sn":"0001"}`,
+				"pubkey":          pubkeyStr,
+				"credential_type": "psk",
 			},
-			devAuthErr: devauth.ErrDeviceExists,
-			checker: mt.NewJSONResponse(
-				http.StatusConflict,
-				nil,
-				restError("device already exists")),
+			signature: "c2lnbmF0dXJl",
+			code:      400,
+			body:      RestError("invalid auth request: pubkey and certificate can't be used with psk credentials"),
 		},
-		"devauth: generic error": {
-			body: &preAuthReq{
-				IdData: map[string]interface{}{
-					"sn": "0001",
-				},
-				PubKey: pubkeyStr,
+		"error, unsupported credential type": {
+			payload: map[string]interface{}{
+				"id_data":         `{"sn":"0001"}`,
+				"credential_type": "password",
 			},
-			devAuthErr: errors.New("generic"),
-			checker: mt.NewJSONResponse(
-				http.StatusInternalServerError,
-				nil,
-				restError("internal error")),
+			signature: "c2lnbmF0dXJl",
+			code:      400,
+			body:      RestError("invalid auth request: unsupported credential_type"),
 		},
 	}
 
 	for name, tc := range testCases {
-		t.Run(fmt.Sprintf("tc %s", name), func(t *testing.T) {
+		t.Run(name, func(t *testing.T) {
+			req := makeAuthReq(tc.payload, nil, tc.signature, t)
+
+			body, err := json.Marshal(tc.payload)
+			assert.NoError(t, err)
+
+			// the signature is verified by the app
 			da := &mocks.App{}
-			da.On("PreauthorizeDevice",
+			da.On("SubmitAuthRequest",
 				mtest.ContextMatcher(),
-				mock.AnythingOfType("*model.PreAuthReq")).
-				Return(tc.devAuthErr)
+				mock.MatchedBy(func(r *model.AuthReq) bool {
+					return r.IsPSK() &&
+						r.Signature == tc.signature &&
+						string(r.Body) == string(body)
+				})).
+				Return(tc.devAuthToken, tc.devAuthErr)
 
 			apih := makeMockApiHandler(t, da, nil)
 
-			//make request
-			req := makeReq("POST",
-				"http://1.2.3.4/api/management/v2/devauth/devices",
-				"",
-				tc.body)
-
-			recorded := test.RunRequest(t, apih, req)
-			mt.CheckResponse(t, tc.checker, recorded)
+			runTestRequest(t, apih, req, tc.code, tc.body)
 		})
 	}
 }
 
-func TestApiV2DevAuthUpdateStatusDevice(t *testing.T) {
+func TestApiDevAuthPostAuthChallenge(t *testing.T) {
 	t.Parallel()
 
-	devs := map[string]struct {
-		dev *model.Device
-		err error
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	ch := &model.AuthChallenge{
+		Nonce:     "Hn6gGs3B0-Fp6MZ2Czs0aK3LGOt0a_KrDMEL7yX8SOU",
+		ExpiresAt: time.Date(2019, 6, 1, 10, 1, 0, 0, time.UTC),
+	}
+
+	tcases := map[string]struct {
+		daChallenge *model.AuthChallenge
+		daErr       error
+
+		checker mt.ResponseChecker
 	}{
-		"123,456": {
-			dev: &model.Device{
-				Id:     "foo",
-				PubKey: "foobar",
-				Status: "accepted",
-				IdData: "deadcafe",
-			},
-			err: nil,
-		},
-		"234,567": {
-			dev: nil,
-			err: devauth.ErrDevIdAuthIdMismatch,
-		},
-		"345,678": {
-			dev: nil,
-			err: errors.New("processing failed"),
+		"ok": {
+			daChallenge: ch,
+
+			checker: mt.NewJSONResponse(
+				http.StatusOK,
+				nil,
+				map[string]interface{}{
+					"nonce":      ch.Nonce,
+					"expires_at": "2019-06-01T10:01:00Z",
+				}),
 		},
-		"567,890": {
-			dev: &model.Device{
-				Id:     "foo",
-				PubKey: "foobar",
-				Status: "pending",
-				IdData: "deadcafe",
-			},
-			err: devauth.ErrMaxDeviceCountReached,
+		"error: generic": {
+			daErr: errors.New("generic error"),
+
+			checker: mt.NewJSONResponse(
+				http.StatusInternalServerError,
+				nil,
+				restError("internal error")),
 		},
 	}
 
-	mockaction := func(_ context.Context, dev_id string, auth_id string) error {
-		d, ok := devs[dev_id+","+auth_id]
-		if ok == false {
-			return store.ErrDevNotFound
-		}
-		if d.err != nil {
-			return d.err
-		}
-		return nil
+	for i := range tcases {
+		tc := tcases[i]
+		t.Run(fmt.Sprintf("tc %s", i), func(t *testing.T) {
+			t.Parallel()
+
+			req := makeReq("POST",
+				"http://1.2.3.4/api/devices/v1/authentication/challenges",
+				"",
+				nil)
+
+			da := &mocks.App{}
+			da.On("CreateAuthChallenge",
+				mtest.ContextMatcher(),
+			).Return(tc.daChallenge, tc.daErr)
+
+			apih := makeMockApiHandler(t, da, nil)
+
+			recorded := test.RunRequest(t, apih, req)
+			mt.CheckResponse(t, tc.checker, recorded)
+		})
 	}
-	da := &mocks.App{}
-	da.On("AcceptDeviceAuth",
-		mtest.ContextMatcher(),
-		mock.AnythingOfType("string"),
-		mock.AnythingOfType("string")).Return(mockaction)
-	da.On("RejectDeviceAuth",
-		mtest.ContextMatcher(),
-		mock.AnythingOfType("string"),
-		mock.AnythingOfType("string")).Return(mockaction)
-	da.On("ResetDeviceAuth",
-		mtest.ContextMatcher(),
-		mock.AnythingOfType("string"),
-		mock.AnythingOfType("string")).Return(mockaction)
+}
 
-	apih := makeMockApiHandler(t, da, nil)
-	// enforce specific field naming in errors returned by API
-	updateRestErrorFieldName()
+func TestApiDevAuthGetDevicesApp(t *testing.T) {
+	t.Parallel()
 
-	accstatus := DevAuthApiStatus{"accepted"}
-	rejstatus := DevAuthApiStatus{"rejected"}
-	penstatus := DevAuthApiStatus{"pending"}
+	tcases := map[string]struct {
+		method string
+		url    string
 
-	tcases := []struct {
-		req  *http.Request
 		code int
-		body string
 	}{
-		{
-			req: test.MakeSimpleRequest("PUT",
-				"http://1.2.3.4/api/management/v2/devauth/devices/123/auth/456/status", nil),
-			code: http.StatusBadRequest,
-			body: RestError("failed to decode status data: JSON payload is empty"),
-		},
-		{
-			req: test.MakeSimpleRequest("PUT",
-				"http://1.2.3.4/api/management/v2/devauth/devices/123/auth/456/status",
-				DevAuthApiStatus{"foo"}),
-			code: http.StatusBadRequest,
-			body: RestError("incorrect device status"),
-		},
-		{
-			req: test.MakeSimpleRequest("PUT",
-				"http://1.2.3.4/api/management/v2/devauth/devices/123/auth/456/status",
-				accstatus),
-			code: http.StatusNoContent,
-		},
-		{
-			req: test.MakeSimpleRequest("PUT",
-				"http://1.2.3.4/api/management/v2/devauth/devices/345/auth/678/status",
-				accstatus),
-			code: http.StatusInternalServerError,
-			body: RestError("internal error"),
-		},
-		{
-			req: test.MakeSimpleRequest("PUT",
-				"http://1.2.3.4/api/management/v2/devauth/devices/999/auth/123/status",
-				accstatus),
-			code: http.StatusNotFound,
-			body: RestError(store.ErrDevNotFound.Error()),
-		},
-		{
-			req: test.MakeSimpleRequest("PUT",
-				"http://1.2.3.4/api/management/v2/devauth/devices/123/auth/456/status",
-				rejstatus),
-			code: http.StatusNoContent,
-		},
-		{
-			req: test.MakeSimpleRequest("PUT",
-				"http://1.2.3.4/api/management/v2/devauth/devices/123/auth/456/status",
-				penstatus),
-			code: http.StatusNoContent,
+		"ok: devices API": {
+			method: "POST",
+			url:    "http://1.2.3.4/api/devices/v1/authentication/challenges",
+			code:   http.StatusOK,
 		},
-		{
-			req: test.MakeSimpleRequest("PUT",
-				"http://1.2.3.4/api/management/v2/devauth/devices/234/auth/567/status",
-				penstatus),
-			code: http.StatusBadRequest,
-			body: RestError("dev auth: dev ID and auth ID mismatch"),
+		"error: internal API": {
+			method: "GET",
+			url:    "http://1.2.3.4/api/internal/v1/devauth/.well-known/jwks.json",
+			code:   http.StatusNotFound,
 		},
-		{
-			req: test.MakeSimpleRequest("PUT",
-				"http://1.2.3.4/api/management/v2/devauth/devices/567/auth/890/status",
-				accstatus),
-			code: http.StatusUnprocessableEntity,
-			body: RestError("maximum number of accepted devices reached"),
+		"error: management API": {
+			method: "GET",
+			url:    "http://1.2.3.4/api/management/v2/devauth/devices",
+			code:   http.StatusNotFound,
 		},
 	}
 
-	for idx := range tcases {
-		tc := tcases[idx]
-		t.Run(fmt.Sprintf("tc %d", idx), func(t *testing.T) {
+	for i := range tcases {
+		tc := tcases[i]
+		t.Run(fmt.Sprintf("tc %s", i), func(t *testing.T) {
 			t.Parallel()
 
-			runTestRequest(t, apih, tc.req, tc.code, tc.body)
+			da := &mocks.App{}
+			da.On("CreateAuthChallenge",
+				mtest.ContextMatcher(),
+			).Return(&model.AuthChallenge{Nonce: "foo"}, nil)
+
+			app, err := NewDevAuthApiHandlers(da, nil).GetDevicesApp()
+			assert.NoError(t, err)
+
+			api := rest.NewApi()
+			api.Use(
+				&requestlog.RequestLogMiddleware{},
+				&requestid.RequestIdMiddleware{},
+			)
+			api.SetApp(app)
+
+			req := makeReq(tc.method, tc.url, "", nil)
+
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+			recorded.CodeIs(tc.code)
 		})
 	}
-
 }
 
-func TestApiDevAuthVerifyToken(t *testing.T) {
+func TestApiDevAuthRenewToken(t *testing.T) {
 	t.Parallel()
 
 	// enforce specific field naming in errors returned by API
 	updateRestErrorFieldName()
 
-	tcases := []struct {
-		req     *http.Request
+	tcases := map[string]struct {
+		body      interface{}
+		signature string
+
+		daToken string
+		daErr   error
+
 		code    int
-		body    string
-		headers map[string]string
-		err     error
+		resBody string
 	}{
-		{
-			req: test.MakeSimpleRequest("POST",
-				"http://1.2.3.4/api/internal/v1/devauth/tokens/verify", nil),
-			code: http.StatusUnauthorized,
-			body: RestError(ErrNoAuthHeader.Error()),
-			err:  nil,
+		"ok": {
+			body:      map[string]interface{}{"token": "oldtoken"},
+			signature: "c2lnbmF0dXJl",
+			daToken:   "newtoken",
+			code:      http.StatusOK,
+			resBody:   "newtoken",
 		},
-		{
-			req: test.MakeSimpleRequest("POST",
-				"http://1.2.3.4/api/internal/v1/devauth/tokens/verify", nil),
-			code: 200,
-			headers: map[string]string{
-				"authorization": "dummytoken",
-			},
-			err: nil,
+		"error: no token": {
+			body:      map[string]interface{}{},
+			signature: "c2lnbmF0dXJl",
+			code:      http.StatusBadRequest,
+			resBody:   RestError("invalid token renewal request: token must be provided"),
 		},
-		{
-			req: test.MakeSimpleRequest("POST",
-				"http://1.2.3.4/api/internal/v1/devauth/tokens/verify", nil),
-			code: http.StatusForbidden,
-			headers: map[string]string{
-				"authorization": "dummytoken",
-			},
-			err: jwt.ErrTokenExpired,
+		"error: no signature": {
+			body:    map[string]interface{}{"token": "oldtoken"},
+			code:    http.StatusBadRequest,
+			resBody: RestError("missing request signature header"),
 		},
-		{
-			req: test.MakeSimpleRequest("POST",
-				"http://1.2.3.4/api/internal/v1/devauth/tokens/verify", nil),
-			code: http.StatusUnauthorized,
-			headers: map[string]string{
-				"authorization": "dummytoken",
-			},
-			err: jwt.ErrTokenInvalid,
+		"error: unauthorized": {
+			body:      map[string]interface{}{"token": "oldtoken"},
+			signature: "c2lnbmF0dXJl",
+			daErr:     devauth.MakeErrDevAuthUnauthorized(jwt.ErrTokenExpired),
+			code:      http.StatusUnauthorized,
+			resBody:   RestError(jwt.ErrTokenExpired.Error()),
 		},
-		{
-			req: test.MakeSimpleRequest("POST",
-				"http://1.2.3.4/api/internal/v1/devauth/tokens/verify", nil),
-			code: 500,
-			body: RestError("internal error"),
-			headers: map[string]string{
-				"authorization": "dummytoken",
-			},
-			err: errors.New("some error that will only be logged"),
+		"error: psk not supported": {
+			body:      map[string]interface{}{"token": "oldtoken"},
+			signature: "c2lnbmF0dXJl",
+			daErr:     devauth.MakeErrDevAuthBadRequest(devauth.ErrPSKNotSupported),
+			code:      http.StatusBadRequest,
+			resBody:   RestError(devauth.ErrPSKNotSupported.Error()),
+		},
+		"error: generic": {
+			body:      map[string]interface{}{"token": "oldtoken"},
+			signature: "c2lnbmF0dXJl",
+			daErr:     errors.New("generic error"),
+			code:      http.StatusInternalServerError,
+			resBody:   RestError("internal error"),
 		},
 	}
 
 	for i := range tcases {
 		tc := tcases[i]
-		t.Run(fmt.Sprintf("tc %d", i), func(t *testing.T) {
+		t.Run(fmt.Sprintf("tc %s", i), func(t *testing.T) {
 			t.Parallel()
 
+			req := makeReq("POST",
+				"http://1.2.3.4/api/devices/v1/authentication/tokens/renew",
+				"",
+				tc.body)
+			if tc.signature != "" {
+				req.Header.Set(HdrAuthReqSign, tc.signature)
+			}
+
 			da := &mocks.App{}
-			da.On("VerifyToken",
+			da.On("RenewToken",
 				mtest.ContextMatcher(),
-				mock.AnythingOfType("string")).
-				Return(tc.err)
+				mock.MatchedBy(func(r *model.TokenRenewReq) bool {
+					return r.Token == "oldtoken" &&
+						r.Signature == tc.signature &&
+						string(r.Body) == `{"token":"oldtoken"}`
+				}),
+			).Return(tc.daToken, tc.daErr)
 
 			apih := makeMockApiHandler(t, da, nil)
-			if len(tc.headers) > 0 {
-				tc.req.Header.Set("authorization", tc.headers["authorization"])
+
+			recorded := runTestRequest(t, apih, req, tc.code, tc.resBody)
+			if tc.code == http.StatusOK {
+				assert.Equal(t, "application/jwt",
+					recorded.Recorder.HeaderMap.Get("Content-Type"))
 			}
-			runTestRequest(t, apih, tc.req, tc.code, tc.body)
 		})
 	}
-
 }
 
-func TestApiV2DevAuthDeleteToken(t *testing.T) {
+func TestApiDevAuthSubmitAuthReqCert(t *testing.T) {
 	t.Parallel()
 
 	// enforce specific field naming in errors returned by API
 	updateRestErrorFieldName()
 
-	tcases := []struct {
-		req  *http.Request
-		code int
-		body string
-		err  error
-	}{
-		{
-			req: test.MakeSimpleRequest("DELETE",
-				"http://1.2.3.4/api/management/v2/devauth/tokens/foo", nil),
-			code: http.StatusNoContent,
-			err:  nil,
-		},
-		{
-			req: test.MakeSimpleRequest("DELETE",
-				"http://1.2.3.4/api/management/v2/devauth/tokens/foo", nil),
+	privkey := mtest.LoadPrivKey("testdata/private.pem", t)
+	pubkeyStr := mtest.LoadPubKeyStr("testdata/public.pem", t)
+	privkeyEC := mtest.LoadPrivKeyPKCS8("testdata/private_ecdsa.pem", t)
+	pubkeyECStr := mtest.LoadPubKeyStr("testdata/public_ecdsa.pem", t)
+	chainStr := mtest.LoadCertChainStr(t,
+		"testdata/cert_device.pem",
+		"testdata/cert_intermediate.pem")
+	chain, err := utils.ParseCertChain(chainStr)
+	assert.NoError(t, err)
+
+	// identity derived from the device certificate
+	certIdData := `{"cn":"dev-0001","dns_names":["dev-0001.factory.example"],` +
+		`"serial_number":"SN0001","uris":["urn:dev:0001"]}`
+
+	withTLS := func(r *http.Request, certs []*x509.Certificate) *http.Request {
+		r.TLS = &tls.ConnectionState{PeerCertificates: certs}
+		return r
+	}
+
+	testCases := map[string]struct {
+		req *http.Request
+
+		devAuthToken string
+		devAuthErr   error
+
+		chainLen int
+
+		code int
+		body string
+	}{
+		"ok, certificate in request": {
+			req: makeAuthReq(
+				map[string]interface{}{
+					"certificate":  chainStr,
+					"tenant_token": "tenant-0001",
+				},
+				privkeyEC,
+				"",
+				t),
+			devAuthToken: "dummytoken",
+			chainLen:     2,
+			code:         200,
+			body:         "dummytoken",
+		},
+		"ok, certificate in request, matching identity": {
+			req: makeAuthReq(
+				map[string]interface{}{
+					"certificate": chainStr,
+					"id_data":     certIdData,
+					"pubkey":      pubkeyECStr,
+				},
+				privkeyEC,
+				"",
+				t),
+			devAuthToken: "dummytoken",
+			chainLen:     2,
+			code:         200,
+			body:         "dummytoken",
+		},
+		"ok, TLS client certificate": {
+			req: withTLS(makeAuthReq(
+				map[string]interface{}{
+					"tenant_token": "tenant-0001",
+				},
+				privkeyEC,
+				"",
+				t), chain[:1]),
+			devAuthToken: "dummytoken",
+			chainLen:     1,
+			code:         200,
+			body:         "dummytoken",
+		},
+		"ok, no TLS client certificate": {
+			req: withTLS(makeAuthReq(
+				map[string]interface{}{
+					"id_data": `{"sn":"0001"}`,
+					"pubkey":  pubkeyStr,
+				},
+				privkey,
+				"",
+				t), nil),
+			devAuthToken: "dummytoken",
+			code:         200,
+			body:         "dummytoken",
+		},
+		"error, pubkey mismatch": {
+			req: makeAuthReq(
+				map[string]interface{}{
+					"certificate": chainStr,
+					"pubkey":      pubkeyStr,
+				},
+				privkey,
+				"",
+				t),
+			code: 400,
+			body: RestError("invalid auth request: pubkey doesn't match the certificate"),
+		},
+		"error, id data mismatch": {
+			req: makeAuthReq(
+				map[string]interface{}{
+					"certificate": chainStr,
+					"id_data":     `{"sn":"0001"}`,
+				},
+				privkeyEC,
+				"",
+				t),
+			code: 400,
+			body: RestError("invalid auth request: id_data doesn't match the certificate"),
+		},
+		"error, invalid certificate": {
+			req: makeAuthReq(
+				map[string]interface{}{
+					"certificate": pubkeyECStr,
+				},
+				privkeyEC,
+				"",
+				t),
+			code: 400,
+			body: RestError("invalid auth request: unexpected PEM block type: PUBLIC KEY"),
+		},
+		"error, signed with a different key": {
+			req: makeAuthReq(
+				map[string]interface{}{
+					"certificate": chainStr,
+				},
+				privkey,
+				"",
+				t),
+			code: 401,
+			body: RestError("signature verification failed"),
+		},
+		"error, untrusted certificate": {
+			req: makeAuthReq(
+				map[string]interface{}{
+					"certificate": chainStr,
+				},
+				privkeyEC,
+				"",
+				t),
+			devAuthErr: devauth.MakeErrDevAuthUnauthorized(
+				errors.New("no trusted CA certificates")),
+			chainLen: 2,
+			code:     401,
+			body:     RestError("no trusted CA certificates"),
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(fmt.Sprintf("tc %s", name), func(t *testing.T) {
+			da := &mocks.App{}
+			da.On("SubmitAuthRequest",
+				mtest.ContextMatcher(),
+				mock.MatchedBy(func(r *model.AuthReq) bool {
+					if tc.chainLen == 0 {
+						return len(r.CertChain) == 0
+					}
+					return len(r.CertChain) == tc.chainLen &&
+						r.IdData == certIdData &&
+						r.PubKey == pubkeyECStr
+				})).
+				Return(tc.devAuthToken, tc.devAuthErr)
+			da.On("RecordAuthFailure",
+				mtest.ContextMatcher(),
+				mock.AnythingOfType("*model.AuthReq")).
+				Return(nil)
+
+			apih := makeMockApiHandler(t, da, nil)
+
+			runTestRequest(t, apih, tc.req, tc.code, tc.body)
+		})
+	}
+}
+
+func TestApiV2DevAuthPreauthDevice(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	pubkeyStr := mtest.LoadPubKeyStr("testdata/public.pem", t)
+	pubkeyEdStr := mtest.LoadPubKeyStr("testdata/public_ed25519.pem", t)
+
+	type brokenPreAuthReq struct {
+		IdData string `json:"identity_data"`
+		PubKey string `json:"pubkey"`
+	}
+
+	testCases := map[string]struct {
+		body interface{}
+
+		devAuthErr error
+
+		checker mt.ResponseChecker
+	}{
+		"ok": {
+			body: &preAuthReq{
+				IdData: map[string]interface{}{
+					"sn": "0001",
+				},
+				PubKey: pubkeyStr,
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusCreated,
+				nil,
+				nil),
+		},
+		"ok, ed25519 key": {
+			body: &preAuthReq{
+				IdData: map[string]interface{}{
+					"sn": "0001",
+				},
+				PubKey: pubkeyEdStr,
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusCreated,
+				nil,
+				nil),
+		},
+		"invalid: id data is not json": {
+			body: &brokenPreAuthReq{
+				IdData: `"sn":"0001"`,
+				PubKey: pubkeyStr,
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("failed to decode preauth request: json: cannot unmarshal string into Go struct field preAuthReq.identity_data of type map[string]interface {}")),
+		},
+		"invalid: no id data": {
+			body: &preAuthReq{
+				PubKey: pubkeyStr,
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("failed to decode preauth request: id_data: non zero value required;")),
+		},
+		"invalid: no pubkey": {
+			body: &preAuthReq{
+				IdData: map[string]interface{}{
+					"sn": "0001",
+				},
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("failed to decode preauth request: pubkey: non zero value required;")),
+		},
+		"invalid: no body": {
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("failed to decode preauth request: EOF")),
+		},
+		"ok, psk": {
+			body: &preAuthReq{
+				IdData: map[string]interface{}{
+					"sn": "0001",
+				},
+				PSK: "MDEyMzQ1Njc4OWFiY2RlZg==",
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusCreated,
+				nil,
+				nil),
+		},
+		"invalid: pubkey and psk": {
+			body: &preAuthReq{
+				IdData: map[string]interface{}{
+					"sn": "0001",
+				},
+				PubKey: pubkeyStr,
+				PSK:    "MDEyMzQ1Njc4OWFiY2RlZg==",
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("failed to decode preauth request: pubkey and psk can't be both provided")),
+		},
+		"invalid: psk too short": {
+			body: &preAuthReq{
+				IdData: map[string]interface{}{
+					"sn": "0001",
+				},
+				PSK: "MDEyMzQ1Njc=",
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("failed to decode preauth request: psk: must be at least 16 bytes long")),
+		},
+		"invalid: psk not base64": {
+			body: &preAuthReq{
+				IdData: map[string]interface{}{
+					"sn": "0001",
+				},
+				PSK: "not base64",
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("failed to decode preauth request: psk: invalid base64 encoding: illegal base64 data at input byte 3")),
+		},
+		"devauth: psk not supported": {
+			body: &preAuthReq{
+				IdData: map[string]interface{}{
+					"sn": "0001",
+				},
+				PSK: "MDEyMzQ1Njc4OWFiY2RlZg==",
+			},
+			devAuthErr: devauth.MakeErrDevAuthBadRequest(devauth.ErrPSKNotSupported),
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError(devauth.ErrPSKNotSupported.Error())),
+		},
+		"devauth: identity data doesn't match the schema": {
+			body: &preAuthReq{
+				IdData: map[string]interface{}{
+					"sn": "0001",
+				},
+				PubKey: pubkeyStr,
+			},
+			devAuthErr: devauth.MakeErrDevAuthBadRequest(model.IdentityDataErrors{
+				{Attribute: "mac", Message: "required attribute missing"},
+			}),
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("identity data does not match the schema: mac: required attribute missing")),
+		},
+		"invalid public key": {
+			body: &preAuthReq{
+				IdData: map[string]interface{}{
+					"sn": "0001",
+				},
+				PubKey: "invalid",
+			},
+			devAuthErr: devauth.ErrDeviceExists,
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("failed to decode preauth request: cannot decode public key")),
+		},
+		"devauth: device exists": {
+			body: &preAuthReq{
+				IdData: map[string]interface{}{
+					"sn": "0001",
+				},
+				PubKey: pubkeyStr,
+			},
+			devAuthErr: devauth.ErrDeviceExists,
+			checker: mt.NewJSONResponse(
+				http.StatusConflict,
+				nil,
+				restError("device already exists")),
+		},
+		"devauth: generic error": {
+			body: &preAuthReq{
+				IdData: map[string]interface{}{
+					"sn": "0001",
+				},
+				PubKey: pubkeyStr,
+			},
+			devAuthErr: errors.New("generic"),
+			checker: mt.NewJSONResponse(
+				http.StatusInternalServerError,
+				nil,
+				restError("internal error")),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(fmt.Sprintf("tc %s", name), func(t *testing.T) {
+			da := &mocks.App{}
+			da.On("PreauthorizeDevice",
+				mtest.ContextMatcher(),
+				mock.AnythingOfType("*model.PreAuthReq")).
+				Return(tc.devAuthErr)
+
+			apih := makeMockApiHandler(t, da, nil)
+
+			//make request
+			req := makeReq("POST",
+				"http://1.2.3.4/api/management/v2/devauth/devices",
+				"",
+				tc.body)
+
+			recorded := test.RunRequest(t, apih, req)
+			mt.CheckResponse(t, tc.checker, recorded)
+		})
+	}
+}
+
+func TestApiV2DevAuthUpdateStatusDevice(t *testing.T) {
+	t.Parallel()
+
+	devs := map[string]struct {
+		dev *model.Device
+		err error
+	}{
+		"123,456": {
+			dev: &model.Device{
+				Id:     "foo",
+				PubKey: "foobar",
+				Status: "accepted",
+				IdData: "deadcafe",
+			},
+			err: nil,
+		},
+		"234,567": {
+			dev: nil,
+			err: devauth.ErrDevIdAuthIdMismatch,
+		},
+		"345,678": {
+			dev: nil,
+			err: errors.New("processing failed"),
+		},
+		"567,890": {
+			dev: &model.Device{
+				Id:     "foo",
+				PubKey: "foobar",
+				Status: "pending",
+				IdData: "deadcafe",
+			},
+			err: devauth.ErrMaxDeviceCountReached,
+		},
+	}
+
+	mockaction := func(_ context.Context, dev_id string, auth_id string) error {
+		d, ok := devs[dev_id+","+auth_id]
+		if ok == false {
+			return store.ErrDevNotFound
+		}
+		if d.err != nil {
+			return d.err
+		}
+		return nil
+	}
+	da := &mocks.App{}
+	da.On("AcceptDeviceAuth",
+		mtest.ContextMatcher(),
+		mock.AnythingOfType("string"),
+		mock.AnythingOfType("string")).Return(mockaction)
+	da.On("RejectDeviceAuth",
+		mtest.ContextMatcher(),
+		mock.AnythingOfType("string"),
+		mock.AnythingOfType("string")).Return(mockaction)
+	da.On("ResetDeviceAuth",
+		mtest.ContextMatcher(),
+		mock.AnythingOfType("string"),
+		mock.AnythingOfType("string")).Return(mockaction)
+
+	apih := makeMockApiHandler(t, da, nil)
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	accstatus := DevAuthApiStatus{"accepted"}
+	rejstatus := DevAuthApiStatus{"rejected"}
+	penstatus := DevAuthApiStatus{"pending"}
+
+	tcases := []struct {
+		req  *http.Request
+		code int
+		body string
+	}{
+		{
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v2/devauth/devices/123/auth/456/status", nil),
+			code: http.StatusBadRequest,
+			body: RestError("failed to decode status data: JSON payload is empty"),
+		},
+		{
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v2/devauth/devices/123/auth/456/status",
+				DevAuthApiStatus{"foo"}),
+			code: http.StatusBadRequest,
+			body: RestError("incorrect device status"),
+		},
+		{
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v2/devauth/devices/123/auth/456/status",
+				accstatus),
+			code: http.StatusNoContent,
+		},
+		{
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v2/devauth/devices/345/auth/678/status",
+				accstatus),
+			code: http.StatusInternalServerError,
+			body: RestError("internal error"),
+		},
+		{
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v2/devauth/devices/999/auth/123/status",
+				accstatus),
+			code: http.StatusNotFound,
+			body: RestError(store.ErrDevNotFound.Error()),
+		},
+		{
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v2/devauth/devices/123/auth/456/status",
+				rejstatus),
+			code: http.StatusNoContent,
+		},
+		{
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v2/devauth/devices/123/auth/456/status",
+				penstatus),
+			code: http.StatusNoContent,
+		},
+		{
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v2/devauth/devices/234/auth/567/status",
+				penstatus),
+			code: http.StatusBadRequest,
+			body: RestError("dev auth: dev ID and auth ID mismatch"),
+		},
+		{
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v2/devauth/devices/567/auth/890/status",
+				accstatus),
+			code: http.StatusUnprocessableEntity,
+			body: RestError("maximum number of accepted devices reached"),
+		},
+	}
+
+	for idx := range tcases {
+		tc := tcases[idx]
+		t.Run(fmt.Sprintf("tc %d", idx), func(t *testing.T) {
+			t.Parallel()
+
+			runTestRequest(t, apih, tc.req, tc.code, tc.body)
+		})
+	}
+
+}
+
+func TestApiDevAuthVerifyToken(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	tcases := []struct {
+		req       *http.Request
+		code      int
+		body      string
+		headers   map[string]string
+		scope     string
+		audiences []string
+		err       error
+	}{
+		{
+			req: test.MakeSimpleRequest("POST",
+				"http://1.2.3.4/api/internal/v1/devauth/tokens/verify", nil),
+			code: http.StatusUnauthorized,
+			body: RestError(ErrNoAuthHeader.Error()),
+			err:  nil,
+		},
+		{
+			req: test.MakeSimpleRequest("POST",
+				"http://1.2.3.4/api/internal/v1/devauth/tokens/verify", nil),
+			code: 200,
+			headers: map[string]string{
+				"authorization": "dummytoken",
+			},
+			err: nil,
+		},
+		{
+			req: test.MakeSimpleRequest("POST",
+				"http://1.2.3.4/api/internal/v1/devauth/tokens/verify", nil),
+			code: http.StatusForbidden,
+			headers: map[string]string{
+				"authorization": "dummytoken",
+			},
+			err: jwt.ErrTokenExpired,
+		},
+		{
+			req: test.MakeSimpleRequest("POST",
+				"http://1.2.3.4/api/internal/v1/devauth/tokens/verify", nil),
+			code: http.StatusUnauthorized,
+			headers: map[string]string{
+				"authorization": "dummytoken",
+			},
+			err: jwt.ErrTokenInvalid,
+		},
+		{
+			req: test.MakeSimpleRequest("POST",
+				"http://1.2.3.4/api/internal/v1/devauth/tokens/verify", nil),
+			code: 200,
+			headers: map[string]string{
+				"authorization":        "dummytoken",
+				"X-MEN-Required-Scope": "deployments",
+			},
+			scope: "deployments",
+		},
+		{
+			req: test.MakeSimpleRequest("POST",
+				"http://1.2.3.4/api/internal/v1/devauth/tokens/verify", nil),
+			code: http.StatusForbidden,
+			headers: map[string]string{
+				"authorization":        "dummytoken",
+				"X-MEN-Required-Scope": "deployments",
+			},
+			scope: "deployments",
+			err:   devauth.ErrTokenScopeMissing,
+		},
+		{
+			req: test.MakeSimpleRequest("POST",
+				"http://1.2.3.4/api/internal/v1/devauth/tokens/verify", nil),
+			code: 200,
+			headers: map[string]string{
+				"authorization":            "dummytoken",
+				"X-MEN-Accepted-Audiences": "aud1, aud2,",
+			},
+			audiences: []string{"aud1", "aud2"},
+		},
+		{
+			req: test.MakeSimpleRequest("POST",
+				"http://1.2.3.4/api/internal/v1/devauth/tokens/verify", nil),
+			code: http.StatusUnauthorized,
+			headers: map[string]string{
+				"authorization":            "dummytoken",
+				"X-MEN-Accepted-Audiences": "aud1",
+			},
+			audiences: []string{"aud1"},
+			err:       devauth.ErrTokenAudienceMismatch,
+		},
+		{
+			req: test.MakeSimpleRequest("POST",
+				"http://1.2.3.4/api/internal/v1/devauth/tokens/verify", nil),
+			code: 500,
+			body: RestError("internal error"),
+			headers: map[string]string{
+				"authorization": "dummytoken",
+			},
+			err: errors.New("some error that will only be logged"),
+		},
+	}
+
+	for i := range tcases {
+		tc := tcases[i]
+		t.Run(fmt.Sprintf("tc %d", i), func(t *testing.T) {
+			t.Parallel()
+
+			da := &mocks.App{}
+			da.On("VerifyToken",
+				mtest.ContextMatcher(),
+				mock.AnythingOfType("string"),
+				tc.scope,
+				tc.audiences).
+				Return(tc.err)
+
+			apih := makeMockApiHandler(t, da, nil)
+			for h, v := range tc.headers {
+				tc.req.Header.Set(h, v)
+			}
+			runTestRequest(t, apih, tc.req, tc.code, tc.body)
+		})
+	}
+
+}
+
+func TestApiDevAuthIntrospectToken(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	active := &model.TokenIntrospection{
+		Active:       true,
+		Subject:      "dev1",
+		Tenant:       "tenant1",
+		ExpiresAt:    1600000000,
+		IssuedAt:     1599996400,
+		ID:           "jti1",
+		AuthSetId:    "aid1",
+		DeviceStatus: model.DevStatusAccepted,
+	}
+
+	tcases := map[string]struct {
+		form string
+		auth string
+
+		daToken string
+		daRes   *model.TokenIntrospection
+		daErr   error
+
+		checker mt.ResponseChecker
+	}{
+		"ok, form": {
+			form: "token=dummytoken&token_type_hint=access_token",
+
+			daToken: "dummytoken",
+			daRes:   active,
+
+			checker: mt.NewJSONResponse(
+				http.StatusOK,
+				nil,
+				active),
+		},
+		"ok, header": {
+			auth: "Bearer dummytoken",
+
+			daToken: "dummytoken",
+			daRes:   active,
+
+			checker: mt.NewJSONResponse(
+				http.StatusOK,
+				nil,
+				active),
+		},
+		"ok, not active": {
+			form: "token=dummytoken",
+
+			daToken: "dummytoken",
+			daRes:   &model.TokenIntrospection{Active: false},
+
+			checker: mt.NewJSONResponse(
+				http.StatusOK,
+				nil,
+				map[string]interface{}{"active": false}),
+		},
+		"error: no token": {
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError(ErrNoToken.Error())),
+		},
+		"error: generic": {
+			form: "token=dummytoken",
+
+			daToken: "dummytoken",
+			daErr:   errors.New("generic error"),
+
+			checker: mt.NewJSONResponse(
+				http.StatusInternalServerError,
+				nil,
+				restError("internal error")),
+		},
+	}
+
+	for i := range tcases {
+		tc := tcases[i]
+		t.Run(fmt.Sprintf("tc %s", i), func(t *testing.T) {
+			t.Parallel()
+
+			req, _ := http.NewRequest("POST",
+				"http://1.2.3.4/api/internal/v1/devauth/tokens/introspect",
+				strings.NewReader(tc.form))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tc.auth != "" {
+				req.Header.Set("Authorization", tc.auth)
+			}
+			req.Header.Add(requestid.RequestIdHeader, "test")
+
+			da := &mocks.App{}
+			da.On("IntrospectToken",
+				mtest.ContextMatcher(),
+				tc.daToken,
+			).Return(tc.daRes, tc.daErr)
+
+			apih := makeMockApiHandler(t, da, nil)
+
+			recorded := test.RunRequest(t, apih, req)
+			mt.CheckResponse(t, tc.checker, recorded)
+		})
+	}
+}
+
+func TestApiV2DevAuthDeleteToken(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	tcases := []struct {
+		req  *http.Request
+		code int
+		body string
+		err  error
+	}{
+		{
+			req: test.MakeSimpleRequest("DELETE",
+				"http://1.2.3.4/api/management/v2/devauth/tokens/foo", nil),
+			code: http.StatusNoContent,
+			err:  nil,
+		},
+		{
+			req: test.MakeSimpleRequest("DELETE",
+				"http://1.2.3.4/api/management/v2/devauth/tokens/foo", nil),
+			code: http.StatusNotFound,
+			err:  store.ErrTokenNotFound,
+		},
+		{
+			req: test.MakeSimpleRequest("DELETE",
+				"http://1.2.3.4/api/management/v2/devauth/tokens/foo", nil),
+			code: http.StatusInternalServerError,
+			body: RestError("internal error"),
+			err:  errors.New("some error that will only be logged"),
+		},
+	}
+
+	for i := range tcases {
+		tc := tcases[i]
+		t.Run(fmt.Sprintf("tc %d", i), func(t *testing.T) {
+			t.Parallel()
+
+			da := &mocks.App{}
+			da.On("RevokeToken",
+				mtest.ContextMatcher(),
+				mock.AnythingOfType("string")).
+				Return(tc.err)
+
+			apih := makeMockApiHandler(t, da, nil)
+			runTestRequest(t, apih, tc.req, tc.code, tc.body)
+		})
+	}
+
+}
+
+func TestApiV2DevAuthGetDeviceTokens(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	iat := time.Unix(1500000000, 0).UTC()
+	exp := iat.Add(time.Hour)
+
+	mkTokens := func(num int) []model.Token {
+		var toks []model.Token
+		for i := 0; i < num; i++ {
+			toks = append(toks, model.Token{
+				Id:        strconv.Itoa(i),
+				DevId:     "foo",
+				AuthSetId: "aid",
+				Token:     "secret",
+				IssuedAt:  &iat,
+				ExpiresAt: &exp,
+			})
+		}
+		return toks
+	}
+
+	tcases := map[string]struct {
+		query string
+
+		skip, limit uint
+		filter      model.TokenFilter
+
+		daTokens []model.Token
+		daErr    error
+
+		code  int
+		body  []model.Token
+		links []string
+		err   string
+	}{
+		"ok": {
+			limit:    21,
+			daTokens: mkTokens(3),
+			code:     http.StatusOK,
+			body:     mkTokens(3),
+			links: []string{
+				fmt.Sprintf(`<%s?page=1&per_page=20>; rel="first"`, "http://1.2.3.4/api/management/v2/devauth/devices/foo/tokens"),
+			},
+		},
+		"ok, filter and paging": {
+			query:    "?jti=1&auth_id=aid&page=2&per_page=2",
+			skip:     2,
+			limit:    3,
+			filter:   model.TokenFilter{Id: "1", AuthSetId: "aid"},
+			daTokens: mkTokens(3),
+			code:     http.StatusOK,
+			body:     mkTokens(2),
+			links: []string{
+				`<http://1.2.3.4/api/management/v2/devauth/devices/foo/tokens?auth_id=aid&jti=1&page=1&per_page=2>; rel="prev"`,
+				`<http://1.2.3.4/api/management/v2/devauth/devices/foo/tokens?auth_id=aid&jti=1&page=3&per_page=2>; rel="next"`,
+				`<http://1.2.3.4/api/management/v2/devauth/devices/foo/tokens?auth_id=aid&jti=1&page=1&per_page=2>; rel="first"`,
+			},
+		},
+		"error, bad paging": {
+			query: "?page=0",
+			code:  http.StatusBadRequest,
+			err:   "Param page is out of bounds",
+		},
+		"error, device not found": {
+			limit: 21,
+			daErr: store.ErrDevNotFound,
+			code:  http.StatusNotFound,
+			err:   store.ErrDevNotFound.Error(),
+		},
+		"error, generic": {
+			limit: 21,
+			daErr: errors.New("generic error"),
+			code:  http.StatusInternalServerError,
+			err:   "internal error",
+		},
+	}
+
+	for n := range tcases {
+		tc := tcases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			da := &mocks.App{}
+			da.On("GetDeviceTokens",
+				mtest.ContextMatcher(),
+				"foo",
+				tc.skip,
+				tc.limit,
+				tc.filter).
+				Return(tc.daTokens, tc.daErr)
+
+			apih := makeMockApiHandler(t, da, nil)
+
+			req := makeReq("GET",
+				"http://1.2.3.4/api/management/v2/devauth/devices/foo/tokens"+tc.query,
+				"",
+				nil)
+
+			recorded := test.RunRequest(t, apih, req)
+			recorded.CodeIs(tc.code)
+
+			if tc.err != "" {
+				recorded.BodyIs(RestError(tc.err))
+				return
+			}
+
+			// the raw token is never included
+			assert.NotContains(t, recorded.Recorder.Body.String(), "secret")
+			recorded.BodyIs(toJsonString(t, tc.body))
+
+			for _, l := range tc.links {
+				assert.Equal(t, l, ExtractHeader("Link", l, recorded))
+			}
+		})
+	}
+}
+
+func TestApiV2DevAuthDeleteDeviceTokens(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	tcases := map[string]struct {
+		daErr error
+
+		checker mt.ResponseChecker
+	}{
+		"ok": {
+			checker: mt.NewJSONResponse(
+				http.StatusNoContent,
+				nil,
+				nil),
+		},
+		"error, device not found": {
+			daErr: store.ErrDevNotFound,
+			checker: mt.NewJSONResponse(
+				http.StatusNotFound,
+				nil,
+				restError(store.ErrDevNotFound.Error())),
+		},
+		"error, generic": {
+			daErr: errors.New("generic error"),
+			checker: mt.NewJSONResponse(
+				http.StatusInternalServerError,
+				nil,
+				restError("internal error")),
+		},
+	}
+
+	for n := range tcases {
+		tc := tcases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			da := &mocks.App{}
+			da.On("RevokeDeviceTokens",
+				mtest.ContextMatcher(),
+				"foo").
+				Return(tc.daErr)
+
+			apih := makeMockApiHandler(t, da, nil)
+
+			req := makeReq("DELETE",
+				"http://1.2.3.4/api/management/v2/devauth/devices/foo/tokens",
+				"",
+				nil)
+
+			recorded := test.RunRequest(t, apih, req)
+			mt.CheckResponse(t, tc.checker, recorded)
+		})
+	}
+}
+
+func TestApiV2DevAuthGetAuthLockouts(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	ts := time.Unix(1500000000, 0).UTC()
+
+	mkLockouts := func(num int) []model.AuthLockout {
+		var lockouts []model.AuthLockout
+		for i := 0; i < num; i++ {
+			lockout := model.NewIPAuthLockout("10.0.0." + strconv.Itoa(i))
+			lockout.Failures = 3
+			lockout.LastFailureTs = ts
+			lockouts = append(lockouts, *lockout)
+		}
+		return lockouts
+	}
+
+	tcases := map[string]struct {
+		query string
+
+		skip, limit uint
+		locked      bool
+		lockType    string
+
+		daLockouts []model.AuthLockout
+		daErr      error
+
+		code  int
+		body  []model.AuthLockout
+		links []string
+		err   string
+	}{
+		"ok": {
+			limit:      21,
+			daLockouts: mkLockouts(3),
+			code:       http.StatusOK,
+			body:       mkLockouts(3),
+			links: []string{
+				`<http://1.2.3.4/api/management/v2/devauth/auth_lockouts?page=1&per_page=20>; rel="first"`,
+			},
+		},
+		"ok, filter and paging": {
+			query:      "?type=ip&locked=true&page=2&per_page=2",
+			skip:       2,
+			limit:      3,
+			locked:     true,
+			lockType:   model.AuthLockoutTypeIP,
+			daLockouts: mkLockouts(3),
+			code:       http.StatusOK,
+			body:       mkLockouts(2),
+			links: []string{
+				`<http://1.2.3.4/api/management/v2/devauth/auth_lockouts?locked=true&page=1&per_page=2&type=ip>; rel="prev"`,
+				`<http://1.2.3.4/api/management/v2/devauth/auth_lockouts?locked=true&page=3&per_page=2&type=ip>; rel="next"`,
+				`<http://1.2.3.4/api/management/v2/devauth/auth_lockouts?locked=true&page=1&per_page=2&type=ip>; rel="first"`,
+			},
+		},
+		"ok, not locked": {
+			query:      "?locked=false",
+			limit:      21,
+			daLockouts: []model.AuthLockout{},
+			code:       http.StatusOK,
+			body:       []model.AuthLockout{},
+		},
+		"error, bad paging": {
+			query: "?page=0",
+			code:  http.StatusBadRequest,
+			err:   "Param page is out of bounds",
+		},
+		"error, bad type": {
+			query: "?type=foo",
+			code:  http.StatusBadRequest,
+			err:   "Param type must be one of [identity ip]",
+		},
+		"error, bad locked": {
+			query: "?locked=foo",
+			code:  http.StatusBadRequest,
+			err:   "Can't parse param locked",
+		},
+		"error, generic": {
+			limit: 21,
+			daErr: errors.New("generic error"),
+			code:  http.StatusInternalServerError,
+			err:   "internal error",
+		},
+	}
+
+	for n := range tcases {
+		tc := tcases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			da := &mocks.App{}
+			da.On("GetAuthLockouts",
+				mtest.ContextMatcher(),
+				tc.skip,
+				tc.limit,
+				mock.MatchedBy(func(f model.AuthLockoutFilter) bool {
+					return f.Type == tc.lockType &&
+						len(f.Ids) == 0 &&
+						(f.LockedAt != nil) == tc.locked
+				})).
+				Return(tc.daLockouts, tc.daErr)
+
+			apih := makeMockApiHandler(t, da, nil)
+
+			req := makeReq("GET",
+				"http://1.2.3.4/api/management/v2/devauth/auth_lockouts"+tc.query,
+				"",
+				nil)
+
+			recorded := test.RunRequest(t, apih, req)
+			recorded.CodeIs(tc.code)
+
+			if tc.err != "" {
+				recorded.BodyIs(RestError(tc.err))
+				return
+			}
+
+			recorded.BodyIs(toJsonString(t, tc.body))
+
+			for _, l := range tc.links {
+				assert.Equal(t, l, ExtractHeader("Link", l, recorded))
+			}
+		})
+	}
+}
+
+func TestApiV2DevAuthDeleteAuthLockout(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	tcases := map[string]struct {
+		daErr error
+
+		checker mt.ResponseChecker
+	}{
+		"ok": {
+			checker: mt.NewJSONResponse(
+				http.StatusNoContent,
+				nil,
+				nil),
+		},
+		"error, lockout not found": {
+			daErr: devauth.ErrAuthLockoutNotFound,
+			checker: mt.NewJSONResponse(
+				http.StatusNotFound,
+				nil,
+				restError(devauth.ErrAuthLockoutNotFound.Error())),
+		},
+		"error, generic": {
+			daErr: errors.New("generic error"),
+			checker: mt.NewJSONResponse(
+				http.StatusInternalServerError,
+				nil,
+				restError("internal error")),
+		},
+	}
+
+	for n := range tcases {
+		tc := tcases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			da := &mocks.App{}
+			da.On("DeleteAuthLockout",
+				mtest.ContextMatcher(),
+				"ip:10.0.0.1").
+				Return(tc.daErr)
+
+			apih := makeMockApiHandler(t, da, nil)
+
+			req := makeReq("DELETE",
+				"http://1.2.3.4/api/management/v2/devauth/auth_lockouts/ip:10.0.0.1",
+				"",
+				nil)
+
+			recorded := test.RunRequest(t, apih, req)
+			mt.CheckResponse(t, tc.checker, recorded)
+		})
+	}
+}
+
+func TestApiV2DevAuthGetClaimCodes(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	expiresAt := time.Date(2019, 6, 8, 10, 0, 0, 0, time.UTC)
+	codes := []model.ClaimCode{
+		{
+			Id:        "code-1",
+			CreatedTs: time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC),
+			ExpiresAt: &expiresAt,
+		},
+		{
+			Id:        "code-2",
+			CreatedTs: time.Date(2019, 6, 2, 10, 0, 0, 0, time.UTC),
+		},
+	}
+
+	tcases := map[string]struct {
+		daCodes []model.ClaimCode
+		daErr   error
+
+		checker mt.ResponseChecker
+	}{
+		"ok": {
+			daCodes: codes,
+			checker: mt.NewJSONResponse(
+				http.StatusOK,
+				nil,
+				codes),
+		},
+		"ok, empty": {
+			daCodes: []model.ClaimCode{},
+			checker: mt.NewJSONResponse(
+				http.StatusOK,
+				nil,
+				[]model.ClaimCode{}),
+		},
+		"error, generic": {
+			daErr: errors.New("generic error"),
+			checker: mt.NewJSONResponse(
+				http.StatusInternalServerError,
+				nil,
+				restError("internal error")),
+		},
+	}
+
+	for n := range tcases {
+		tc := tcases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			da := &mocks.App{}
+			da.On("GetClaimCodes",
+				mtest.ContextMatcher()).
+				Return(tc.daCodes, tc.daErr)
+
+			apih := makeMockApiHandler(t, da, nil)
+
+			req := makeReq("GET",
+				"http://1.2.3.4/api/management/v2/devauth/claim_codes",
+				"",
+				nil)
+
+			recorded := test.RunRequest(t, apih, req)
+			mt.CheckResponse(t, tc.checker, recorded)
+		})
+	}
+}
+
+func TestApiV2DevAuthPostClaimCode(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	code := &model.ClaimCode{
+		Id:        "code-1",
+		Code:      "MFRG-GZDF-MZTW-Q2LK",
+		CreatedTs: time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC),
+		ExpiresAt: &expiresAt,
+	}
+
+	tcases := map[string]struct {
+		body interface{}
+
+		daExpiresAt *time.Time
+		daCode      *model.ClaimCode
+		daErr       error
+
+		checker mt.ResponseChecker
+	}{
+		"ok": {
+			body: map[string]interface{}{
+				"expires_at": expiresAt,
+			},
+			daExpiresAt: &expiresAt,
+			daCode:      code,
+			checker: mt.NewJSONResponse(
+				http.StatusCreated,
+				nil,
+				code),
+		},
+		"ok, no body": {
+			daCode: code,
+			checker: mt.NewJSONResponse(
+				http.StatusCreated,
+				nil,
+				code),
+		},
+		"error, expired": {
+			body: map[string]interface{}{
+				"expires_at": "2019-06-01T10:00:00Z",
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("failed to decode claim code request: expires_at: must be in the future")),
+		},
+		"error, bad body": {
+			body: map[string]interface{}{
+				"expires_at": 3600,
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("failed to decode claim code request: json: cannot unmarshal number into Go struct field claimCodeReq.expires_at of type time.Time")),
+		},
+		"error, generic": {
+			daErr: errors.New("generic error"),
+			checker: mt.NewJSONResponse(
+				http.StatusInternalServerError,
+				nil,
+				restError("internal error")),
+		},
+	}
+
+	for n := range tcases {
+		tc := tcases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			da := &mocks.App{}
+			da.On("CreateClaimCode",
+				mtest.ContextMatcher(),
+				mock.MatchedBy(func(t *time.Time) bool {
+					if tc.daExpiresAt == nil {
+						return t == nil
+					}
+					return t != nil && t.Equal(*tc.daExpiresAt)
+				})).
+				Return(tc.daCode, tc.daErr)
+
+			apih := makeMockApiHandler(t, da, nil)
+
+			req := makeReq("POST",
+				"http://1.2.3.4/api/management/v2/devauth/claim_codes",
+				"",
+				tc.body)
+
+			recorded := test.RunRequest(t, apih, req)
+			mt.CheckResponse(t, tc.checker, recorded)
+		})
+	}
+}
+
+func TestApiV2DevAuthDeleteClaimCode(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	tcases := map[string]struct {
+		daErr error
+
+		checker mt.ResponseChecker
+	}{
+		"ok": {
+			checker: mt.NewJSONResponse(
+				http.StatusNoContent,
+				nil,
+				nil),
+		},
+		"error, claim code not found": {
+			daErr: devauth.ErrClaimCodeNotFound,
+			checker: mt.NewJSONResponse(
+				http.StatusNotFound,
+				nil,
+				restError(devauth.ErrClaimCodeNotFound.Error())),
+		},
+		"error, generic": {
+			daErr: errors.New("generic error"),
+			checker: mt.NewJSONResponse(
+				http.StatusInternalServerError,
+				nil,
+				restError("internal error")),
+		},
+	}
+
+	for n := range tcases {
+		tc := tcases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			da := &mocks.App{}
+			da.On("DeleteClaimCode",
+				mtest.ContextMatcher(),
+				"code-1").
+				Return(tc.daErr)
+
+			apih := makeMockApiHandler(t, da, nil)
+
+			req := makeReq("DELETE",
+				"http://1.2.3.4/api/management/v2/devauth/claim_codes/code-1",
+				"",
+				nil)
+
+			recorded := test.RunRequest(t, apih, req)
+			mt.CheckResponse(t, tc.checker, recorded)
+		})
+	}
+}
+
+func TestApiV2DevAuthPostAdmissionRule(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	rule := map[string]interface{}{
+		"name":     "factory",
+		"priority": 10,
+		"conditions": []map[string]interface{}{
+			{"attribute": "ip", "match": "cidr", "value": "10.1.0.0/16"},
+		},
+		"key_types": []string{"ecdsa"},
+		"action":    "accept",
+	}
+
+	tcases := map[string]struct {
+		body interface{}
+
+		daErr error
+
+		status  int
+		checker mt.ResponseChecker
+	}{
+		"ok": {
+			body:   rule,
+			status: http.StatusCreated,
+		},
+		"error, invalid rule": {
+			body: map[string]interface{}{
+				"name": "factory",
+				"conditions": []map[string]interface{}{
+					{"attribute": "ip", "match": "cidr", "value": "10.1.0.0"},
+				},
+				"action": "accept",
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("failed to decode admission rule request: conditions[0]: value: invalid CIDR: invalid CIDR address: 10.1.0.0")),
+		},
+		"error, no conditions": {
+			body: map[string]interface{}{
+				"name":   "factory",
+				"action": "reject",
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("failed to decode admission rule request: rule must have conditions or key types")),
+		},
+		"error, bad action": {
+			body: map[string]interface{}{
+				"name":      "factory",
+				"key_types": []string{"rsa"},
+				"action":    "decommission",
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("failed to decode admission rule request: action: must be one of [accept reject pending]")),
+		},
+		"error, exists": {
+			body:  rule,
+			daErr: devauth.ErrAdmissionRuleExists,
+			checker: mt.NewJSONResponse(
+				http.StatusConflict,
+				nil,
+				restError(devauth.ErrAdmissionRuleExists.Error())),
+		},
+		"error, generic": {
+			body:  rule,
+			daErr: errors.New("generic error"),
+			checker: mt.NewJSONResponse(
+				http.StatusInternalServerError,
+				nil,
+				restError("internal error")),
+		},
+	}
+
+	for n := range tcases {
+		tc := tcases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			da := &mocks.App{}
+			da.On("AddAdmissionRule",
+				mtest.ContextMatcher(),
+				mock.MatchedBy(func(r *model.AdmissionRule) bool {
+					return r.Id != "" &&
+						r.Name == "factory" &&
+						r.Priority == 10 &&
+						r.CreatedTs != nil
+				})).
+				Return(tc.daErr)
+
+			apih := makeMockApiHandler(t, da, nil)
+
+			req := makeReq("POST",
+				"http://1.2.3.4/api/management/v2/devauth/admission_rules",
+				"",
+				tc.body)
+
+			recorded := test.RunRequest(t, apih, req)
+			if tc.checker != nil {
+				mt.CheckResponse(t, tc.checker, recorded)
+				return
+			}
+
+			recorded.CodeIs(tc.status)
+			var created model.AdmissionRule
+			err := recorded.DecodeJsonPayload(&created)
+			assert.NoError(t, err)
+			assert.NotEmpty(t, created.Id)
+			assert.Equal(t, model.AdmissionActionAccept, created.Action)
+		})
+	}
+}
+
+func TestApiV2DevAuthPutAdmissionRule(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	tcases := map[string]struct {
+		body interface{}
+
+		daErr error
+
+		checker mt.ResponseChecker
+	}{
+		"ok": {
+			body: map[string]interface{}{
+				"name":      "rsa",
+				"key_types": []string{"rsa"},
+				"action":    "reject",
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusNoContent,
+				nil,
+				nil),
+		},
+		"error, bad key type": {
+			body: map[string]interface{}{
+				"name":      "rsa",
+				"key_types": []string{"dsa"},
+				"action":    "reject",
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("failed to decode admission rule request: key_types: must be one of [rsa ecdsa ed25519]")),
+		},
+		"error, not found": {
+			body: map[string]interface{}{
+				"name":      "rsa",
+				"key_types": []string{"rsa"},
+				"action":    "reject",
+			},
+			daErr: devauth.ErrAdmissionRuleNotFound,
+			checker: mt.NewJSONResponse(
+				http.StatusNotFound,
+				nil,
+				restError(devauth.ErrAdmissionRuleNotFound.Error())),
+		},
+	}
+
+	for n := range tcases {
+		tc := tcases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			da := &mocks.App{}
+			da.On("UpdateAdmissionRule",
+				mtest.ContextMatcher(),
+				mock.MatchedBy(func(r *model.AdmissionRule) bool {
+					return r.Id == "rule-1" && r.UpdatedTs != nil
+				})).
+				Return(tc.daErr)
+
+			apih := makeMockApiHandler(t, da, nil)
+
+			req := makeReq("PUT",
+				"http://1.2.3.4/api/management/v2/devauth/admission_rules/rule-1",
+				"",
+				tc.body)
+
+			recorded := test.RunRequest(t, apih, req)
+			mt.CheckResponse(t, tc.checker, recorded)
+		})
+	}
+}
+
+func TestApiV2DevAuthMatchAdmissionRule(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	rule := &model.AdmissionRule{
+		Id:       "rule-1",
+		Name:     "rsa",
+		KeyTypes: []string{utils.KeyTypeRSA},
+		Action:   model.AdmissionActionReject,
+	}
+
+	tcases := map[string]struct {
+		body interface{}
+
+		daKeyType string
+		daRule    *model.AdmissionRule
+		daErr     error
+
+		checker mt.ResponseChecker
+	}{
+		"ok": {
+			body: map[string]interface{}{
+				"identity_data": map[string]interface{}{"sn": "0001"},
+				"pubkey":        mtest.LoadPubKeyStr("testdata/public.pem", t),
+			},
+			daKeyType: utils.KeyTypeRSA,
+			daRule:    rule,
+			checker: mt.NewJSONResponse(
+				http.StatusOK,
+				nil,
+				admissionMatchRsp{
+					Rule:   rule,
+					Action: model.AdmissionActionReject,
+				}),
+		},
+		"ok, no rule matching": {
+			body: map[string]interface{}{
+				"identity_data": map[string]interface{}{"sn": "0001"},
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusOK,
+				nil,
+				admissionMatchRsp{
+					Action: model.AdmissionActionPending,
+				}),
+		},
+		"error, no identity data": {
+			body: map[string]interface{}{
+				"pubkey": mtest.LoadPubKeyStr("testdata/public.pem", t),
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("failed to decode admission rule match request: identity_data: non zero value required")),
+		},
+		"error, bad pubkey": {
+			body: map[string]interface{}{
+				"identity_data": map[string]interface{}{"sn": "0001"},
+				"pubkey":        "foo",
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("failed to decode admission rule match request: pubkey: cannot decode public key")),
+		},
+		"error, generic": {
+			body: map[string]interface{}{
+				"identity_data": map[string]interface{}{"sn": "0001"},
+			},
+			daErr: errors.New("generic error"),
+			checker: mt.NewJSONResponse(
+				http.StatusInternalServerError,
+				nil,
+				restError("internal error")),
+		},
+	}
+
+	for n := range tcases {
+		tc := tcases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			da := &mocks.App{}
+			da.On("MatchAdmissionRule",
+				mtest.ContextMatcher(),
+				map[string]interface{}{"sn": "0001"},
+				tc.daKeyType).
+				Return(tc.daRule, tc.daErr)
+
+			apih := makeMockApiHandler(t, da, nil)
+
+			req := makeReq("POST",
+				"http://1.2.3.4/api/management/v2/devauth/admission_rules/match",
+				"",
+				tc.body)
+
+			recorded := test.RunRequest(t, apih, req)
+			mt.CheckResponse(t, tc.checker, recorded)
+		})
+	}
+}
+
+func TestApiV2GetDevice(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	dev := &model.Device{
+		Id:     "foo",
+		IdData: `{"mac": "00:00:00:01"}`,
+		IdDataStruct: map[string]interface{}{
+			"mac": "00:00:00:01",
+		},
+		PubKey: "pubkey",
+		Status: model.DevStatusPending,
+		AuthSets: []model.AuthSet{
+			model.AuthSet{
+				Id:       "1",
+				DeviceId: "foo",
+				IdData:   `{"mac": "00:00:00:01"}`,
+				IdDataStruct: map[string]interface{}{
+					"mac": "00:00:00:01",
+				},
+			},
+		},
+	}
+
+	apiDev, _ := deviceV2FromDbModel(dev)
+
+	tcases := []struct {
+		req *http.Request
+
+		device *model.Device
+		err    error
+
+		code int
+		body string
+	}{
+		{
+			req: test.MakeSimpleRequest("GET",
+				"http://1.2.3.4/api/management/v2/devauth/devices/foo", nil),
+			device: dev,
+			err:    nil,
+
+			code: http.StatusOK,
+			body: string(asJSON(apiDev)),
+		},
+		{
+			req: test.MakeSimpleRequest("GET",
+				"http://1.2.3.4/api/management/v2/devauth/devices/bar", nil),
+			device: nil,
+			err:    store.ErrDevNotFound,
+
+			code: http.StatusNotFound,
+			body: RestError("device not found"),
+		},
+		{
+			req: test.MakeSimpleRequest("GET",
+				"http://1.2.3.4/api/management/v2/devauth/devices/bar", nil),
+			device: nil,
+			err:    errors.New("generic error"),
+
+			code: http.StatusInternalServerError,
+			body: RestError("internal error"),
+		},
+	}
+
+	for i := range tcases {
+		tc := tcases[i]
+
+		t.Run(fmt.Sprintf("tc %d", i), func(t *testing.T) {
+			t.Parallel()
+
+			da := &mocks.App{}
+			da.On("GetDevice",
+				mtest.ContextMatcher(),
+				mock.AnythingOfType("string")).
+				Return(tc.device, tc.err)
+
+			apih := makeMockApiHandler(t, da, nil)
+			runTestRequest(t, apih, tc.req, tc.code, tc.body)
+		})
+	}
+}
+
+func TestApiV2GetDevices(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	devs := []model.Device{
+		{
+			Id:     "id1",
+			PubKey: "pubkey",
+			Status: model.DevStatusPending,
+		},
+		{
+			Id:     "id2",
+			PubKey: "pubkey2",
+			Status: model.DevStatusRejected,
+		},
+		{
+			Id:     "id3",
+			PubKey: "pubkey3",
+			Status: model.DevStatusRejected,
+		},
+		{
+			Id:     "id4",
+			PubKey: "pubkey4",
+			Status: model.DevStatusAccepted,
+		},
+		{
+			Id:     "id5",
+			PubKey: "pubkey5",
+			Status: model.DevStatusPreauth,
+		},
+	}
+
+	outDevs, err := devicesV2FromDbModel(devs)
+	assert.NoError(t, err)
+
+	tcases := map[string]struct {
+		req     *http.Request
+		code    int
+		body    string
+		devices []model.Device
+		err     error
+		skip    uint
+		limit   uint
+	}{
+		"ok": {
+			req: test.MakeSimpleRequest("GET",
+				"http://1.2.3.4/api/management/v2/devauth/devices", nil),
+			code:    http.StatusOK,
+			devices: devs,
+			err:     nil,
+			skip:    0,
+			limit:   rest_utils.PerPageDefault + 1,
+			body:    string(asJSON(outDevs)),
+		},
+		"no devices": {
+			req: test.MakeSimpleRequest("GET",
+				"http://1.2.3.4/api/management/v2/devauth/devices", nil),
+			code:    http.StatusOK,
+			devices: []model.Device{},
+			skip:    0,
+			limit:   rest_utils.PerPageDefault + 1,
+			err:     nil,
+			body:    "[]",
+		},
+		// this test does not check if the devices were skipped
+		// it is only checking if endpoint limits number of devices in the response
+		"limit number of devices": {
+			req: test.MakeSimpleRequest("GET",
+				"http://1.2.3.4/api/management/v2/devauth/devices?page=2&per_page=2", nil),
+			devices: devs,
+			skip:    2,
+			limit:   3,
+			code:    http.StatusOK,
+			// reqquested 2 devices per page, so expect only 2
+			body: string(asJSON(outDevs[:2])),
+		},
+		"internal error": {
+			req: test.MakeSimpleRequest("GET",
+				"http://1.2.3.4/api/management/v2/devauth/devices?page=2&per_page=2", nil),
+			skip:  2,
+			limit: 3,
+			code:  http.StatusInternalServerError,
+			err:   errors.New("failed"),
+			body:  RestError("internal error"),
+		},
+	}
+
+	for name := range tcases {
+		tc := tcases[name]
+		t.Run(fmt.Sprintf("tc %s", name), func(t *testing.T) {
+			t.Parallel()
+
+			da := &mocks.App{}
+			da.On("GetDevices",
+				mtest.ContextMatcher(),
+				tc.skip, tc.limit, mock.AnythingOfType("store.DeviceFilter")).Return(
+				tc.devices, tc.err)
+
+			apih := makeMockApiHandler(t, da, nil)
+			runTestRequest(t, apih, tc.req, tc.code, tc.body)
+		})
+	}
+}
+
+func asJSON(sth interface{}) []byte {
+	data, _ := json.Marshal(sth)
+	return data
+}
+
+func TestApiV2DevAuthDecommissionDevice(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	tcases := []struct {
+		req  *http.Request
+		code int
+		body string
+		err  error
+	}{
+		{
+			req: test.MakeSimpleRequest("DELETE",
+				"http://1.2.3.4/api/management/v2/devauth/devices/foo", nil),
+			code: http.StatusNoContent,
+			err:  nil,
+		},
+		{
+			req: test.MakeSimpleRequest("DELETE",
+				"http://1.2.3.4/api/management/v2/devauth/devices/foo", nil),
 			code: http.StatusNotFound,
-			err:  store.ErrTokenNotFound,
+			err:  store.ErrDevNotFound,
+		},
+		{
+			req: test.MakeSimpleRequest("DELETE",
+				"http://1.2.3.4/api/management/v2/devauth/devices/foo", nil),
+			code: http.StatusInternalServerError,
+			body: RestError("internal error"),
+			err:  errors.New("some error that will only be logged"),
+		},
+	}
+
+	for i := range tcases {
+		tc := tcases[i]
+		t.Run(fmt.Sprintf("tc %d", i), func(t *testing.T) {
+			t.Parallel()
+
+			da := &mocks.App{}
+			da.On("DecommissionDevice",
+				mtest.ContextMatcher(),
+				mock.AnythingOfType("string")).
+				Return(tc.err)
+
+			apih := makeMockApiHandler(t, da, nil)
+			runTestRequest(t, apih, tc.req, tc.code, tc.body)
+		})
+	}
+}
+
+func TestApiDevAuthPutTenantLimit(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	tcases := []struct {
+		req    *http.Request
+		code   int
+		body   string
+		tenant string
+		limit  model.Limit
+		err    error
+	}{
+		{
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/internal/v1/devauth/tenant/foo/limits/max_devices",
+				map[string]int{
+					"limit": 123,
+				}),
+			limit: model.Limit{
+				Name:  model.LimitMaxDeviceCount,
+				Value: 123,
+			},
+			tenant: "foo",
+			code:   http.StatusNoContent,
+		},
+		{
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/internal/v1/devauth/tenant/foo/limits/max_devices",
+				[]string{"garbage"}),
+			code: http.StatusBadRequest,
+			body: RestError("failed to decode limit request: json: cannot unmarshal array into Go value of type http.LimitValue"),
+		},
+		{
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/internal/v1/devauth/tenant/foo/limits/bogus-limit",
+				map[string]int{
+					"limit": 123,
+				}),
+			code: http.StatusBadRequest,
+			body: RestError("unsupported limit bogus-limit"),
+		},
+		{
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/internal/v1/devauth/tenant/foo/limits/max_devices",
+				map[string]int{
+					"limit": 123,
+				}),
+			tenant: "foo",
+			limit:  model.Limit{Name: model.LimitMaxDeviceCount, Value: 123},
+			code:   http.StatusInternalServerError,
+			err:    errors.New("failed"),
+			body:   RestError("internal error"),
+		},
+	}
+
+	for i := range tcases {
+		tc := tcases[i]
+		t.Run(fmt.Sprintf("tc %d", i), func(t *testing.T) {
+			t.Parallel()
+
+			da := &mocks.App{}
+			da.On("SetTenantLimit",
+				mtest.ContextMatcher(),
+				tc.tenant,
+				tc.limit).
+				Return(tc.err)
+
+			apih := makeMockApiHandler(t, da, nil)
+			runTestRequest(t, apih, tc.req, tc.code, tc.body)
+		})
+	}
+}
+
+func TestApiV2DevAuthGetLimit(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	tcases := []struct {
+		limit string
+
+		daLimit *model.Limit
+		daErr   error
+
+		code int
+		body string
+	}{
+		{
+			limit: "max_devices",
+
+			daLimit: &model.Limit{
+				Name:  model.LimitMaxDeviceCount,
+				Value: 123,
+			},
+			daErr: nil,
+
+			code: http.StatusOK,
+			body: string(asJSON(
+				LimitValue{
+					Limit: 123,
+				},
+			)),
 		},
 		{
-			req: test.MakeSimpleRequest("DELETE",
-				"http://1.2.3.4/api/management/v2/devauth/tokens/foo", nil),
+			limit: "bogus",
+
+			code: http.StatusBadRequest,
+			body: RestError("unsupported limit bogus"),
+		},
+		{
+			limit: "max_devices",
+
+			daLimit: nil,
+			daErr:   errors.New("generic error"),
+
 			code: http.StatusInternalServerError,
 			body: RestError("internal error"),
-			err:  errors.New("some error that will only be logged"),
 		},
 	}
 
@@ -698,79 +2959,68 @@ func TestApiV2DevAuthDeleteToken(t *testing.T) {
 		t.Run(fmt.Sprintf("tc %d", i), func(t *testing.T) {
 			t.Parallel()
 
+			req := test.MakeSimpleRequest("GET",
+				"http://1.2.3.4/api/management/v2/devauth/limits/"+tc.limit,
+				nil)
+
 			da := &mocks.App{}
-			da.On("RevokeToken",
+			da.On("GetLimit",
 				mtest.ContextMatcher(),
-				mock.AnythingOfType("string")).
-				Return(tc.err)
+				tc.limit).
+				Return(tc.daLimit, tc.daErr)
 
 			apih := makeMockApiHandler(t, da, nil)
-			runTestRequest(t, apih, tc.req, tc.code, tc.body)
+			runTestRequest(t, apih, req, tc.code, tc.body)
 		})
 	}
-
 }
 
-func TestApiV2GetDevice(t *testing.T) {
+func TestApiDevAuthGetTenantLimit(t *testing.T) {
 	t.Parallel()
 
 	// enforce specific field naming in errors returned by API
 	updateRestErrorFieldName()
 
-	dev := &model.Device{
-		Id:     "foo",
-		IdData: `{"mac": "00:00:00:01"}`,
-		IdDataStruct: map[string]interface{}{
-			"mac": "00:00:00:01",
-		},
-		PubKey: "pubkey",
-		Status: model.DevStatusPending,
-		AuthSets: []model.AuthSet{
-			model.AuthSet{
-				Id:       "1",
-				DeviceId: "foo",
-				IdData:   `{"mac": "00:00:00:01"}`,
-				IdDataStruct: map[string]interface{}{
-					"mac": "00:00:00:01",
-				},
-			},
-		},
-	}
-
-	apiDev, _ := deviceV2FromDbModel(dev)
-
 	tcases := []struct {
-		req *http.Request
+		limit    string
+		tenantId string
 
-		device *model.Device
-		err    error
+		daLimit *model.Limit
+		daErr   error
 
 		code int
 		body string
 	}{
 		{
-			req: test.MakeSimpleRequest("GET",
-				"http://1.2.3.4/api/management/v2/devauth/devices/foo", nil),
-			device: dev,
-			err:    nil,
+			limit:    "max_devices",
+			tenantId: "tenant-foo",
+
+			daLimit: &model.Limit{
+				Name:  model.LimitMaxDeviceCount,
+				Value: 123,
+			},
+			daErr: nil,
 
 			code: http.StatusOK,
-			body: string(asJSON(apiDev)),
+			body: string(asJSON(
+				LimitValue{
+					Limit: 123,
+				},
+			)),
 		},
 		{
-			req: test.MakeSimpleRequest("GET",
-				"http://1.2.3.4/api/management/v2/devauth/devices/bar", nil),
-			device: nil,
-			err:    store.ErrDevNotFound,
+			limit:    "bogus",
+			tenantId: "tenant-foo",
 
-			code: http.StatusNotFound,
-			body: RestError("device not found"),
+			code: http.StatusBadRequest,
+			body: RestError("unsupported limit bogus"),
 		},
 		{
-			req: test.MakeSimpleRequest("GET",
-				"http://1.2.3.4/api/management/v2/devauth/devices/bar", nil),
-			device: nil,
-			err:    errors.New("generic error"),
+			limit:    "max_devices",
+			tenantId: "tenant-foo",
+
+			daLimit: nil,
+			daErr:   errors.New("generic error"),
 
 			code: http.StatusInternalServerError,
 			body: RestError("internal error"),
@@ -779,134 +3029,223 @@ func TestApiV2GetDevice(t *testing.T) {
 
 	for i := range tcases {
 		tc := tcases[i]
-
 		t.Run(fmt.Sprintf("tc %d", i), func(t *testing.T) {
 			t.Parallel()
 
+			req := test.MakeSimpleRequest("GET",
+				"http://1.2.3.4/api/internal/v1/devauth/tenant/"+
+					tc.tenantId+
+					"/limits/"+
+					tc.limit,
+
+				nil)
+
 			da := &mocks.App{}
-			da.On("GetDevice",
+			da.On("GetTenantLimit",
 				mtest.ContextMatcher(),
-				mock.AnythingOfType("string")).
-				Return(tc.device, tc.err)
+				tc.limit,
+				tc.tenantId).
+				Return(tc.daLimit, tc.daErr)
 
 			apih := makeMockApiHandler(t, da, nil)
-			runTestRequest(t, apih, tc.req, tc.code, tc.body)
+			runTestRequest(t, apih, req, tc.code, tc.body)
 		})
 	}
 }
 
-func TestApiV2GetDevices(t *testing.T) {
+func TestApiV2DevAuthGetDevicesCount(t *testing.T) {
 	t.Parallel()
 
 	// enforce specific field naming in errors returned by API
 	updateRestErrorFieldName()
 
-	devs := []model.Device{
-		{
-			Id:     "id1",
-			PubKey: "pubkey",
-			Status: model.DevStatusPending,
-		},
+	tcases := []struct {
+		req    *http.Request
+		status string
+
+		daCnt int
+		daErr error
+
+		code int
+		body string
+	}{
 		{
-			Id:     "id2",
-			PubKey: "pubkey2",
-			Status: model.DevStatusRejected,
+			status: "pending",
+
+			daCnt: 5,
+			daErr: nil,
+
+			code: http.StatusOK,
+			body: string(asJSON(
+				model.Count{
+					Count: 5,
+				},
+			)),
 		},
 		{
-			Id:     "id3",
-			PubKey: "pubkey3",
-			Status: model.DevStatusRejected,
+			status: "accepted",
+
+			daCnt: 0,
+			daErr: nil,
+
+			code: http.StatusOK,
+			body: string(asJSON(
+				model.Count{
+					Count: 0,
+				},
+			)),
 		},
 		{
-			Id:     "id4",
-			PubKey: "pubkey4",
-			Status: model.DevStatusAccepted,
+			status: "rejected",
+
+			daCnt: 4,
+			daErr: nil,
+
+			code: http.StatusOK,
+			body: string(asJSON(
+				model.Count{
+					Count: 4,
+				},
+			)),
 		},
 		{
-			Id:     "id5",
-			PubKey: "pubkey5",
-			Status: model.DevStatusPreauth,
-		},
-	}
+			status: model.DevStatusPreauth,
 
-	outDevs, err := devicesV2FromDbModel(devs)
-	assert.NoError(t, err)
+			daCnt: 7,
+			daErr: nil,
 
-	tcases := map[string]struct {
-		req     *http.Request
-		code    int
-		body    string
-		devices []model.Device
-		err     error
-		skip    uint
-		limit   uint
-	}{
-		"ok": {
-			req: test.MakeSimpleRequest("GET",
-				"http://1.2.3.4/api/management/v2/devauth/devices", nil),
-			code:    http.StatusOK,
-			devices: devs,
-			err:     nil,
-			skip:    0,
-			limit:   rest_utils.PerPageDefault + 1,
-			body:    string(asJSON(outDevs)),
+			code: http.StatusOK,
+			body: string(asJSON(
+				model.Count{
+					Count: 7,
+				},
+			)),
 		},
-		"no devices": {
-			req: test.MakeSimpleRequest("GET",
-				"http://1.2.3.4/api/management/v2/devauth/devices", nil),
-			code:    http.StatusOK,
-			devices: []model.Device{},
-			skip:    0,
-			limit:   rest_utils.PerPageDefault + 1,
-			err:     nil,
-			body:    "[]",
+		{
+			status: "",
+
+			daCnt: 10,
+			daErr: nil,
+
+			code: http.StatusOK,
+			body: string(asJSON(
+				model.Count{
+					Count: 10,
+				},
+			)),
 		},
-		// this test does not check if the devices were skipped
-		// it is only checking if endpoint limits number of devices in the response
-		"limit number of devices": {
-			req: test.MakeSimpleRequest("GET",
-				"http://1.2.3.4/api/management/v2/devauth/devices?page=2&per_page=2", nil),
-			devices: devs,
-			skip:    2,
-			limit:   3,
-			code:    http.StatusOK,
-			// reqquested 2 devices per page, so expect only 2
-			body: string(asJSON(outDevs[:2])),
+		{
+			status: "bogus",
+
+			code: http.StatusBadRequest,
+			body: RestError("status must be one of: pending, accepted, rejected, preauthorized"),
 		},
-		"internal error": {
-			req: test.MakeSimpleRequest("GET",
-				"http://1.2.3.4/api/management/v2/devauth/devices?page=2&per_page=2", nil),
-			skip:  2,
-			limit: 3,
-			code:  http.StatusInternalServerError,
-			err:   errors.New("failed"),
-			body:  RestError("internal error"),
+		{
+			status: "accepted",
+
+			daErr: errors.New("generic error"),
+
+			code: http.StatusInternalServerError,
+			body: RestError("internal error"),
 		},
 	}
 
-	for name := range tcases {
-		tc := tcases[name]
-		t.Run(fmt.Sprintf("tc %s", name), func(t *testing.T) {
+	for i := range tcases {
+		tc := tcases[i]
+		t.Run(fmt.Sprintf("tc %d", i), func(t *testing.T) {
 			t.Parallel()
 
+			url := "http://1.2.3.4/api/management/v2/devauth/devices/count"
+			if tc.status != "" {
+				url += "?status=" + tc.status
+			}
+
+			req := test.MakeSimpleRequest("GET", url, nil)
+
 			da := &mocks.App{}
-			da.On("GetDevices",
+			da.On("GetDevCountByStatus",
 				mtest.ContextMatcher(),
-				tc.skip, tc.limit, mock.AnythingOfType("store.DeviceFilter")).Return(
-				tc.devices, tc.err)
+				tc.status).
+				Return(tc.daCnt, tc.daErr)
 
 			apih := makeMockApiHandler(t, da, nil)
-			runTestRequest(t, apih, tc.req, tc.code, tc.body)
+			runTestRequest(t, apih, req, tc.code, tc.body)
 		})
 	}
 }
 
-func asJSON(sth interface{}) []byte {
-	data, _ := json.Marshal(sth)
-	return data
+func TestApiDevAuthPostTenants(t *testing.T) {
+	testCases := map[string]struct {
+		req        *http.Request
+		devAuthErr error
+		respCode   int
+		respBody   string
+	}{
+		"ok": {
+			req: test.MakeSimpleRequest("POST",
+				"http://1.2.3.4/api/internal/v1/devauth/tenants",
+				model.NewTenant{TenantId: "foo"}),
+			respCode: 201,
+			respBody: "",
+		},
+		"error: empty request": {
+			req: test.MakeSimpleRequest("POST",
+				"http://1.2.3.4/api/internal/v1/devauth/tenants",
+				nil),
+			respCode: 400,
+			respBody: RestError("EOF"),
+		},
+		"error: no tenant_id": {
+			req: test.MakeSimpleRequest("POST",
+				"http://1.2.3.4/api/internal/v1/devauth/tenants",
+				model.NewTenant{TenantId: ""},
+			),
+			respCode: 400,
+			respBody: RestError("tenant_id must be provided"),
+		},
+		"error: generic": {
+			req: test.MakeSimpleRequest("POST",
+				"http://1.2.3.4/api/internal/v1/devauth/tenants",
+				model.NewTenant{TenantId: "foo"},
+			),
+			devAuthErr: errors.New("can't provision tenant"),
+			respCode:   500,
+			respBody:   RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		da := &mocks.App{}
+
+		da.On("ProvisionTenant",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			mock.AnythingOfType("string")).Return(tc.devAuthErr)
+
+		apih := makeMockApiHandler(t, da, nil)
+
+		rest.ErrorFieldName = "error"
+
+		runTestRequest(t, apih, tc.req, tc.respCode, tc.respBody)
+	}
 }
 
-func TestApiV2DevAuthDecommissionDevice(t *testing.T) {
+func makeReq(method, url, auth string, body interface{}) *http.Request {
+	req := test.MakeSimpleRequest(method, url, body)
+
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	req.Header.Add(requestid.RequestIdHeader, "test")
+
+	return req
+}
+
+func restError(status string) map[string]interface{} {
+	return map[string]interface{}{"error": status, "request_id": "test"}
+}
+
+func TestApiV2DevAuthDeleteDeviceAuthSet(t *testing.T) {
 	t.Parallel()
 
 	// enforce specific field naming in errors returned by API
@@ -920,19 +3259,26 @@ func TestApiV2DevAuthDecommissionDevice(t *testing.T) {
 	}{
 		{
 			req: test.MakeSimpleRequest("DELETE",
-				"http://1.2.3.4/api/management/v2/devauth/devices/foo", nil),
+				"http://1.2.3.4/api/management/v2/devauth/devices/foo/auth/bar", nil),
 			code: http.StatusNoContent,
 			err:  nil,
 		},
 		{
 			req: test.MakeSimpleRequest("DELETE",
-				"http://1.2.3.4/api/management/v2/devauth/devices/foo", nil),
+				"http://1.2.3.4/api/management/v2/devauth/devices/foo/auth/bar", nil),
+			code: http.StatusInternalServerError,
+			body: RestError("internal error"),
+			err:  store.ErrAuthSetNotFound,
+		},
+		{
+			req: test.MakeSimpleRequest("DELETE",
+				"http://1.2.3.4/api/management/v2/devauth/devices/foo/auth/bar", nil),
 			code: http.StatusNotFound,
 			err:  store.ErrDevNotFound,
 		},
 		{
 			req: test.MakeSimpleRequest("DELETE",
-				"http://1.2.3.4/api/management/v2/devauth/devices/foo", nil),
+				"http://1.2.3.4/api/management/v2/devauth/devices/foo/auth/bar", nil),
 			code: http.StatusInternalServerError,
 			body: RestError("internal error"),
 			err:  errors.New("some error that will only be logged"),
@@ -945,9 +3291,10 @@ func TestApiV2DevAuthDecommissionDevice(t *testing.T) {
 			t.Parallel()
 
 			da := &mocks.App{}
-			da.On("DecommissionDevice",
+			da.On("DeleteAuthSet",
 				mtest.ContextMatcher(),
-				mock.AnythingOfType("string")).
+				"foo",
+				"bar").
 				Return(tc.err)
 
 			apih := makeMockApiHandler(t, da, nil)
@@ -956,522 +3303,915 @@ func TestApiV2DevAuthDecommissionDevice(t *testing.T) {
 	}
 }
 
-func TestApiDevAuthPutTenantLimit(t *testing.T) {
+func TestApiDeleteTokens(t *testing.T) {
 	t.Parallel()
 
 	// enforce specific field naming in errors returned by API
 	updateRestErrorFieldName()
 
-	tcases := []struct {
-		req    *http.Request
-		code   int
-		body   string
-		tenant string
-		limit  model.Limit
-		err    error
+	tcases := map[string]struct {
+		tenantId string
+		deviceId string
+
+		devAuthErr error
+
+		checker mt.ResponseChecker
 	}{
-		{
-			req: test.MakeSimpleRequest("PUT",
-				"http://1.2.3.4/api/internal/v1/devauth/tenant/foo/limits/max_devices",
-				map[string]int{
-					"limit": 123,
-				}),
-			limit: model.Limit{
-				Name:  model.LimitMaxDeviceCount,
-				Value: 123,
-			},
-			tenant: "foo",
-			code:   http.StatusNoContent,
+		"ok, all tokens": {
+			tenantId: "foo",
+			checker: mt.NewJSONResponse(
+				http.StatusNoContent,
+				nil,
+				nil),
 		},
-		{
-			req: test.MakeSimpleRequest("PUT",
-				"http://1.2.3.4/api/internal/v1/devauth/tenant/foo/limits/max_devices",
-				[]string{"garbage"}),
-			code: http.StatusBadRequest,
-			body: RestError("failed to decode limit request: json: cannot unmarshal array into Go value of type http.LimitValue"),
+		"ok, device's tokens": {
+			tenantId: "foo",
+			deviceId: "dev-foo",
+			checker: mt.NewJSONResponse(
+				http.StatusNoContent,
+				nil,
+				nil),
 		},
-		{
-			req: test.MakeSimpleRequest("PUT",
-				"http://1.2.3.4/api/internal/v1/devauth/tenant/foo/limits/bogus-limit",
-				map[string]int{
-					"limit": 123,
-				}),
-			code: http.StatusBadRequest,
-			body: RestError("unsupported limit bogus-limit"),
+		"error, no tenant id": {
+			deviceId: "dev-foo",
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("tenant_id must be provided")),
 		},
-		{
-			req: test.MakeSimpleRequest("PUT",
-				"http://1.2.3.4/api/internal/v1/devauth/tenant/foo/limits/max_devices",
-				map[string]int{
-					"limit": 123,
-				}),
-			tenant: "foo",
-			limit:  model.Limit{Name: model.LimitMaxDeviceCount, Value: 123},
-			code:   http.StatusInternalServerError,
-			err:    errors.New("failed"),
-			body:   RestError("internal error"),
+		"error, devauth": {
+			tenantId:   "foo",
+			devAuthErr: errors.New("generic error"),
+			checker: mt.NewJSONResponse(
+				http.StatusInternalServerError,
+				nil,
+				restError("internal error")),
 		},
 	}
 
-	for i := range tcases {
-		tc := tcases[i]
-		t.Run(fmt.Sprintf("tc %d", i), func(t *testing.T) {
+	for n := range tcases {
+		tc := tcases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
 			t.Parallel()
 
 			da := &mocks.App{}
-			da.On("SetTenantLimit",
+			da.On("DeleteTokens",
 				mtest.ContextMatcher(),
-				tc.tenant,
-				tc.limit).
-				Return(tc.err)
+				tc.tenantId,
+				tc.deviceId).
+				Return(tc.devAuthErr)
+
+			//make request
+			url := fmt.Sprintf("http://1.2.3.4/api/internal/v1/devauth/tokens?tenant_id=%v&device_id=%v",
+				tc.tenantId,
+				tc.deviceId)
+
+			req := makeReq("DELETE",
+				url,
+				"",
+				nil)
 
 			apih := makeMockApiHandler(t, da, nil)
-			runTestRequest(t, apih, tc.req, tc.code, tc.body)
+
+			recorded := test.RunRequest(t, apih, req)
+			mt.CheckResponse(t, tc.checker, recorded)
 		})
 	}
 }
 
-func TestApiV2DevAuthGetLimit(t *testing.T) {
+func TestApiDevAuthGetTenantDeviceStatus(t *testing.T) {
 	t.Parallel()
 
 	// enforce specific field naming in errors returned by API
 	updateRestErrorFieldName()
 
-	tcases := []struct {
-		limit string
+	tcases := map[string]struct {
+		tid string
+		did string
 
-		daLimit *model.Limit
-		daErr   error
+		daStatus *model.Status
+		daErr    error
 
-		code int
-		body string
+		checker mt.ResponseChecker
 	}{
-		{
-			limit: "max_devices",
+		"ok": {
+			tid: "foo",
+			did: "bar",
 
-			daLimit: &model.Limit{
-				Name:  model.LimitMaxDeviceCount,
-				Value: 123,
-			},
-			daErr: nil,
+			daStatus: &model.Status{Status: "accepted"},
 
-			code: http.StatusOK,
-			body: string(asJSON(
-				LimitValue{
-					Limit: 123,
-				},
-			)),
+			checker: mt.NewJSONResponse(
+				http.StatusOK,
+				nil,
+				model.Status{Status: "accepted"}),
 		},
-		{
-			limit: "bogus",
+		"error: tenant id empty": {
+			did: "bar",
 
-			code: http.StatusBadRequest,
-			body: RestError("unsupported limit bogus"),
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("tenant id (tid) cannot be empty")),
 		},
-		{
-			limit: "max_devices",
+		"error: device id empty": {
+			tid: "foo",
 
-			daLimit: nil,
-			daErr:   errors.New("generic error"),
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("device id (did) cannot be empty")),
+		},
+		"error: not found": {
+			tid: "foo",
+			did: "bar",
 
-			code: http.StatusInternalServerError,
-			body: RestError("internal error"),
+			daErr: devauth.ErrDeviceNotFound,
+
+			checker: mt.NewJSONResponse(
+				http.StatusNotFound,
+				nil,
+				restError("device not found")),
+		},
+		"error: generic": {
+			tid: "foo",
+			did: "bar",
+
+			daErr: errors.New("generic error"),
+
+			checker: mt.NewJSONResponse(
+				http.StatusInternalServerError,
+				nil,
+				restError("internal error")),
 		},
 	}
 
 	for i := range tcases {
 		tc := tcases[i]
-		t.Run(fmt.Sprintf("tc %d", i), func(t *testing.T) {
+		t.Run(fmt.Sprintf("tc %s", i), func(t *testing.T) {
 			t.Parallel()
 
-			req := test.MakeSimpleRequest("GET",
-				"http://1.2.3.4/api/management/v2/devauth/limits/"+tc.limit,
+			req := makeReq("GET",
+				fmt.Sprintf("http://1.2.3.4/api/internal/v1/devauth/tenants/%s/devices/%s/status", tc.tid, tc.did),
+				"",
 				nil)
 
 			da := &mocks.App{}
-			da.On("GetLimit",
+			da.On("GetTenantDeviceStatus",
 				mtest.ContextMatcher(),
-				tc.limit).
-				Return(tc.daLimit, tc.daErr)
+				tc.tid,
+				tc.did,
+			).Return(tc.daStatus, tc.daErr)
 
 			apih := makeMockApiHandler(t, da, nil)
-			runTestRequest(t, apih, req, tc.code, tc.body)
+
+			recorded := test.RunRequest(t, apih, req)
+			mt.CheckResponse(t, tc.checker, recorded)
 		})
 	}
 }
 
-func TestApiDevAuthGetTenantLimit(t *testing.T) {
+func TestApiDevAuthGetJWKS(t *testing.T) {
 	t.Parallel()
 
 	// enforce specific field naming in errors returned by API
 	updateRestErrorFieldName()
 
-	tcases := []struct {
-		limit    string
-		tenantId string
+	jwks := &jwt.JWKS{
+		Keys: []jwt.JWK{
+			{
+				KeyID:     "foo",
+				KeyType:   "OKP",
+				Algorithm: jwt.AlgEdDSA,
+				Use:       jwt.JWKUseSignature,
+				Curve:     "Ed25519",
+				X:         "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo",
+			},
+		},
+	}
 
-		daLimit *model.Limit
-		daErr   error
+	tcases := map[string]struct {
+		daJWKS *jwt.JWKS
+		daErr  error
 
-		code int
-		body string
+		checker mt.ResponseChecker
 	}{
-		{
-			limit:    "max_devices",
-			tenantId: "tenant-foo",
-
-			daLimit: &model.Limit{
-				Name:  model.LimitMaxDeviceCount,
-				Value: 123,
-			},
-			daErr: nil,
-
-			code: http.StatusOK,
-			body: string(asJSON(
-				LimitValue{
-					Limit: 123,
-				},
-			)),
-		},
-		{
-			limit:    "bogus",
-			tenantId: "tenant-foo",
+		"ok": {
+			daJWKS: jwks,
 
-			code: http.StatusBadRequest,
-			body: RestError("unsupported limit bogus"),
+			checker: mt.NewJSONResponse(
+				http.StatusOK,
+				nil,
+				jwks),
 		},
-		{
-			limit:    "max_devices",
-			tenantId: "tenant-foo",
-
-			daLimit: nil,
-			daErr:   errors.New("generic error"),
+		"error: generic": {
+			daErr: errors.New("generic error"),
 
-			code: http.StatusInternalServerError,
-			body: RestError("internal error"),
+			checker: mt.NewJSONResponse(
+				http.StatusInternalServerError,
+				nil,
+				restError("internal error")),
 		},
 	}
 
 	for i := range tcases {
 		tc := tcases[i]
-		t.Run(fmt.Sprintf("tc %d", i), func(t *testing.T) {
+		t.Run(fmt.Sprintf("tc %s", i), func(t *testing.T) {
 			t.Parallel()
 
-			req := test.MakeSimpleRequest("GET",
-				"http://1.2.3.4/api/internal/v1/devauth/tenant/"+
-					tc.tenantId+
-					"/limits/"+
-					tc.limit,
-
+			req := makeReq("GET",
+				"http://1.2.3.4/api/internal/v1/devauth/.well-known/jwks.json",
+				"",
 				nil)
 
 			da := &mocks.App{}
-			da.On("GetTenantLimit",
+			da.On("GetJWKS",
 				mtest.ContextMatcher(),
-				tc.limit,
-				tc.tenantId).
-				Return(tc.daLimit, tc.daErr)
+			).Return(tc.daJWKS, tc.daErr)
 
 			apih := makeMockApiHandler(t, da, nil)
-			runTestRequest(t, apih, req, tc.code, tc.body)
+
+			recorded := test.RunRequest(t, apih, req)
+			mt.CheckResponse(t, tc.checker, recorded)
 		})
 	}
 }
 
-func TestApiV2DevAuthGetDevicesCount(t *testing.T) {
+func TestApiDevAuthGetVerificationCacheStats(t *testing.T) {
 	t.Parallel()
 
 	// enforce specific field naming in errors returned by API
 	updateRestErrorFieldName()
 
-	tcases := []struct {
-		req    *http.Request
-		status string
+	stats := &cache.Stats{
+		Hits:   10,
+		Misses: 2,
+		Size:   2,
+	}
 
-		daCnt int
-		daErr error
+	tcases := map[string]struct {
+		daStats *cache.Stats
+		daErr   error
 
-		code int
-		body string
+		checker mt.ResponseChecker
 	}{
-		{
-			status: "pending",
-
-			daCnt: 5,
-			daErr: nil,
+		"ok": {
+			daStats: stats,
 
-			code: http.StatusOK,
-			body: string(asJSON(
-				model.Count{
-					Count: 5,
-				},
-			)),
+			checker: mt.NewJSONResponse(
+				http.StatusOK,
+				nil,
+				stats),
 		},
-		{
-			status: "accepted",
+		"error: cache disabled": {
+			daErr: devauth.ErrVerificationCacheDisabled,
 
-			daCnt: 0,
-			daErr: nil,
+			checker: mt.NewJSONResponse(
+				http.StatusNotFound,
+				nil,
+				restError(devauth.ErrVerificationCacheDisabled.Error())),
+		},
+		"error: generic": {
+			daErr: errors.New("generic error"),
 
-			code: http.StatusOK,
-			body: string(asJSON(
-				model.Count{
-					Count: 0,
-				},
-			)),
+			checker: mt.NewJSONResponse(
+				http.StatusInternalServerError,
+				nil,
+				restError("internal error")),
 		},
-		{
-			status: "rejected",
+	}
 
-			daCnt: 4,
-			daErr: nil,
+	for i := range tcases {
+		tc := tcases[i]
+		t.Run(fmt.Sprintf("tc %s", i), func(t *testing.T) {
+			t.Parallel()
 
-			code: http.StatusOK,
-			body: string(asJSON(
-				model.Count{
-					Count: 4,
-				},
-			)),
-		},
-		{
-			status: model.DevStatusPreauth,
+			req := makeReq("GET",
+				"http://1.2.3.4/api/internal/v1/devauth/verification_cache",
+				"",
+				nil)
 
-			daCnt: 7,
-			daErr: nil,
+			da := &mocks.App{}
+			da.On("GetVerificationCacheStats",
+				mtest.ContextMatcher(),
+			).Return(tc.daStats, tc.daErr)
+
+			apih := makeMockApiHandler(t, da, nil)
+
+			recorded := test.RunRequest(t, apih, req)
+			mt.CheckResponse(t, tc.checker, recorded)
+		})
+	}
+}
+
+func TestApiDevAuthGetTenantJWKS(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
 
-			code: http.StatusOK,
-			body: string(asJSON(
-				model.Count{
-					Count: 7,
-				},
-			)),
+	jwks := &jwt.JWKS{
+		Keys: []jwt.JWK{
+			{
+				KeyID:     "foo",
+				KeyType:   "OKP",
+				Algorithm: jwt.AlgEdDSA,
+				Use:       jwt.JWKUseSignature,
+				Curve:     "Ed25519",
+				X:         "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo",
+			},
 		},
-		{
-			status: "",
+	}
 
-			daCnt: 10,
-			daErr: nil,
+	tcases := map[string]struct {
+		daJWKS *jwt.JWKS
+		daErr  error
 
-			code: http.StatusOK,
-			body: string(asJSON(
-				model.Count{
-					Count: 10,
-				},
-			)),
-		},
-		{
-			status: "bogus",
+		checker mt.ResponseChecker
+	}{
+		"ok": {
+			daJWKS: jwks,
 
-			code: http.StatusBadRequest,
-			body: RestError("status must be one of: pending, accepted, rejected, preauthorized"),
+			checker: mt.NewJSONResponse(
+				http.StatusOK,
+				nil,
+				jwks),
 		},
-		{
-			status: "accepted",
-
+		"error: generic": {
 			daErr: errors.New("generic error"),
 
-			code: http.StatusInternalServerError,
-			body: RestError("internal error"),
+			checker: mt.NewJSONResponse(
+				http.StatusInternalServerError,
+				nil,
+				restError("internal error")),
 		},
 	}
 
 	for i := range tcases {
 		tc := tcases[i]
-		t.Run(fmt.Sprintf("tc %d", i), func(t *testing.T) {
+		t.Run(fmt.Sprintf("tc %s", i), func(t *testing.T) {
 			t.Parallel()
 
-			url := "http://1.2.3.4/api/management/v2/devauth/devices/count"
-			if tc.status != "" {
-				url += "?status=" + tc.status
-			}
-
-			req := test.MakeSimpleRequest("GET", url, nil)
+			req := makeReq("GET",
+				"http://1.2.3.4/api/internal/v1/devauth/tenants/foo/.well-known/jwks.json",
+				"",
+				nil)
 
 			da := &mocks.App{}
-			da.On("GetDevCountByStatus",
+			da.On("GetTenantJWKS",
 				mtest.ContextMatcher(),
-				tc.status).
-				Return(tc.daCnt, tc.daErr)
+				"foo",
+			).Return(tc.daJWKS, tc.daErr)
 
 			apih := makeMockApiHandler(t, da, nil)
-			runTestRequest(t, apih, req, tc.code, tc.body)
+
+			recorded := test.RunRequest(t, apih, req)
+			mt.CheckResponse(t, tc.checker, recorded)
 		})
 	}
 }
 
-func TestApiDevAuthPostTenants(t *testing.T) {
-	testCases := map[string]struct {
-		req        *http.Request
-		devAuthErr error
-		respCode   int
-		respBody   string
+func TestApiV2DevAuthTrustedCAs(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	caStr := mtest.LoadCertChainStr(t, "testdata/cert_ca.pem")
+	caCerts, err := utils.ParseCertChain(caStr)
+	assert.NoError(t, err)
+	ca := model.NewTrustedCA(caCerts[0])
+
+	tcases := map[string]struct {
+		method string
+		url    string
+		body   interface{}
+
+		daMethod string
+		daArg    interface{}
+		daRet    []interface{}
+
+		checker mt.ResponseChecker
 	}{
-		"ok": {
-			req: test.MakeSimpleRequest("POST",
-				"http://1.2.3.4/api/internal/v1/devauth/tenants",
-				model.NewTenant{TenantId: "foo"}),
-			respCode: 201,
-			respBody: "",
+		"list, ok": {
+			method:   "GET",
+			url:      "http://1.2.3.4/api/management/v2/devauth/trusted_cas",
+			daMethod: "GetTrustedCAs",
+			daRet:    []interface{}{[]model.TrustedCA{*ca}, nil},
+			checker: mt.NewJSONResponse(
+				http.StatusOK,
+				nil,
+				[]model.TrustedCA{*ca}),
 		},
-		"error: empty request": {
-			req: test.MakeSimpleRequest("POST",
-				"http://1.2.3.4/api/internal/v1/devauth/tenants",
+		"list, error": {
+			method:   "GET",
+			url:      "http://1.2.3.4/api/management/v2/devauth/trusted_cas",
+			daMethod: "GetTrustedCAs",
+			daRet:    []interface{}{nil, errors.New("generic error")},
+			checker: mt.NewJSONResponse(
+				http.StatusInternalServerError,
+				nil,
+				restError("internal error")),
+		},
+		"add, ok": {
+			method:   "POST",
+			url:      "http://1.2.3.4/api/management/v2/devauth/trusted_cas",
+			body:     map[string]interface{}{"certificate": caStr},
+			daMethod: "AddTrustedCA",
+			daArg: mock.MatchedBy(func(c *model.TrustedCA) bool {
+				return c.Id == ca.Id &&
+					c.Subject == "CN=Factory Root CA,O=Factory" &&
+					c.Certificate == caStr
+			}),
+			daRet: []interface{}{nil},
+			checker: mt.NewJSONResponse(
+				http.StatusCreated,
+				nil,
 				nil),
-			respCode: 400,
-			respBody: RestError("EOF"),
 		},
-		"error: no tenant_id": {
-			req: test.MakeSimpleRequest("POST",
-				"http://1.2.3.4/api/internal/v1/devauth/tenants",
-				model.NewTenant{TenantId: ""},
-			),
-			respCode: 400,
-			respBody: RestError("tenant_id must be provided"),
+		"add, exists": {
+			method:   "POST",
+			url:      "http://1.2.3.4/api/management/v2/devauth/trusted_cas",
+			body:     map[string]interface{}{"certificate": caStr},
+			daMethod: "AddTrustedCA",
+			daArg:    mock.AnythingOfType("*model.TrustedCA"),
+			daRet:    []interface{}{devauth.ErrTrustedCAExists},
+			checker: mt.NewJSONResponse(
+				http.StatusConflict,
+				nil,
+				restError(devauth.ErrTrustedCAExists.Error())),
 		},
-		"error: generic": {
-			req: test.MakeSimpleRequest("POST",
-				"http://1.2.3.4/api/internal/v1/devauth/tenants",
-				model.NewTenant{TenantId: "foo"},
-			),
-			devAuthErr: errors.New("can't provision tenant"),
-			respCode:   500,
-			respBody:   RestError("internal error"),
+		"add, not a CA": {
+			method: "POST",
+			url:    "http://1.2.3.4/api/management/v2/devauth/trusted_cas",
+			body: map[string]interface{}{
+				"certificate": mtest.LoadCertChainStr(t, "testdata/cert_device.pem"),
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("failed to decode trusted CA request: certificate: not a CA certificate")),
+		},
+		"add, more than one certificate": {
+			method: "POST",
+			url:    "http://1.2.3.4/api/management/v2/devauth/trusted_cas",
+			body: map[string]interface{}{
+				"certificate": mtest.LoadCertChainStr(t,
+					"testdata/cert_intermediate.pem",
+					"testdata/cert_ca.pem"),
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("failed to decode trusted CA request: certificate: exactly one certificate expected")),
+		},
+		"add, missing certificate": {
+			method: "POST",
+			url:    "http://1.2.3.4/api/management/v2/devauth/trusted_cas",
+			body:   map[string]interface{}{},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("failed to decode trusted CA request: certificate: non zero value required;")),
+		},
+		"delete, ok": {
+			method:   "DELETE",
+			url:      "http://1.2.3.4/api/management/v2/devauth/trusted_cas/foo",
+			daMethod: "DeleteTrustedCA",
+			daArg:    "foo",
+			daRet:    []interface{}{nil},
+			checker: mt.NewJSONResponse(
+				http.StatusNoContent,
+				nil,
+				nil),
+		},
+		"delete, not found": {
+			method:   "DELETE",
+			url:      "http://1.2.3.4/api/management/v2/devauth/trusted_cas/foo",
+			daMethod: "DeleteTrustedCA",
+			daArg:    "foo",
+			daRet:    []interface{}{devauth.ErrTrustedCANotFound},
+			checker: mt.NewJSONResponse(
+				http.StatusNotFound,
+				nil,
+				restError(devauth.ErrTrustedCANotFound.Error())),
 		},
 	}
 
-	for name, tc := range testCases {
-		t.Logf("test case: %s", name)
-		da := &mocks.App{}
+	for n := range tcases {
+		tc := tcases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
 
-		da.On("ProvisionTenant",
-			mock.MatchedBy(func(c context.Context) bool { return true }),
-			mock.AnythingOfType("string")).Return(tc.devAuthErr)
+			da := &mocks.App{}
+			if tc.daMethod != "" {
+				args := []interface{}{mtest.ContextMatcher()}
+				if tc.daArg != nil {
+					args = append(args, tc.daArg)
+				}
+				da.On(tc.daMethod, args...).Return(tc.daRet...)
+			}
 
-		apih := makeMockApiHandler(t, da, nil)
+			req := makeReq(tc.method, tc.url, "", tc.body)
 
-		rest.ErrorFieldName = "error"
+			apih := makeMockApiHandler(t, da, nil)
 
-		runTestRequest(t, apih, tc.req, tc.respCode, tc.respBody)
+			recorded := test.RunRequest(t, apih, req)
+			mt.CheckResponse(t, tc.checker, recorded)
+		})
 	}
 }
 
-func makeReq(method, url, auth string, body interface{}) *http.Request {
-	req := test.MakeSimpleRequest(method, url, body)
+func TestApiDevAuthTenantTokenPolicy(t *testing.T) {
+	t.Parallel()
 
-	if auth != "" {
-		req.Header.Set("Authorization", auth)
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	url := "http://1.2.3.4/api/internal/v1/devauth/tenant/foo/token_policy"
+
+	tcases := map[string]struct {
+		method string
+		body   interface{}
+
+		daMethod string
+		daArgs   []interface{}
+		daRet    []interface{}
+
+		checker mt.ResponseChecker
+	}{
+		"get, ok": {
+			method:   "GET",
+			daMethod: "GetTenantTokenPolicy",
+			daArgs:   []interface{}{"foo"},
+			daRet:    []interface{}{&model.TokenPolicy{Audience: "aud"}, nil},
+			checker: mt.NewJSONResponse(
+				http.StatusOK,
+				nil,
+				map[string]interface{}{"audience": "aud"}),
+		},
+		"get, error": {
+			method:   "GET",
+			daMethod: "GetTenantTokenPolicy",
+			daArgs:   []interface{}{"foo"},
+			daRet:    []interface{}{nil, errors.New("generic error")},
+			checker: mt.NewJSONResponse(
+				http.StatusInternalServerError,
+				nil,
+				restError("internal error")),
+		},
+		"put, ok": {
+			method:   "PUT",
+			body:     map[string]interface{}{"audience": "aud"},
+			daMethod: "SetTenantTokenPolicy",
+			daArgs:   []interface{}{"foo", model.TokenPolicy{Audience: "aud"}},
+			daRet:    []interface{}{nil},
+			checker: mt.NewJSONResponse(
+				http.StatusNoContent,
+				nil,
+				nil),
+		},
+		"put, ok, all settings": {
+			method: "PUT",
+			body: map[string]interface{}{
+				"audience":          "aud",
+				"expiration_time":   3600,
+				"max_device_tokens": 2,
+				"claims":            map[string]interface{}{"fleet": "trucks"},
+			},
+			daMethod: "SetTenantTokenPolicy",
+			daArgs: []interface{}{"foo", model.TokenPolicy{
+				Audience:        "aud",
+				ExpirationTime:  3600,
+				MaxDeviceTokens: 2,
+				Claims:          map[string]interface{}{"fleet": "trucks"},
+			}},
+			daRet: []interface{}{nil},
+			checker: mt.NewJSONResponse(
+				http.StatusNoContent,
+				nil,
+				nil),
+		},
+		"put, invalid expiration time": {
+			method: "PUT",
+			body:   map[string]interface{}{"expiration_time": -1},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("invalid token policy: expiration time can't be negative")),
+		},
+		"put, reserved claim": {
+			method: "PUT",
+			body: map[string]interface{}{
+				"claims": map[string]interface{}{"mender.tenant": "bar"},
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("invalid token policy: reserved claim: mender.tenant")),
+		},
+		"put, bad request": {
+			method: "PUT",
+			body:   map[string]interface{}{"audience": 123},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("failed to decode token policy: json: cannot unmarshal number into Go struct field TokenPolicy.audience of type string")),
+		},
+		"put, error": {
+			method:   "PUT",
+			body:     map[string]interface{}{"audience": "aud"},
+			daMethod: "SetTenantTokenPolicy",
+			daArgs:   []interface{}{"foo", model.TokenPolicy{Audience: "aud"}},
+			daRet:    []interface{}{errors.New("generic error")},
+			checker: mt.NewJSONResponse(
+				http.StatusInternalServerError,
+				nil,
+				restError("internal error")),
+		},
 	}
-	req.Header.Add(requestid.RequestIdHeader, "test")
 
-	return req
-}
+	for n := range tcases {
+		tc := tcases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
 
-func restError(status string) map[string]interface{} {
-	return map[string]interface{}{"error": status, "request_id": "test"}
+			da := &mocks.App{}
+			if tc.daMethod != "" {
+				args := append([]interface{}{mtest.ContextMatcher()}, tc.daArgs...)
+				da.On(tc.daMethod, args...).Return(tc.daRet...)
+			}
+
+			req := makeReq(tc.method, url, "", tc.body)
+
+			apih := makeMockApiHandler(t, da, nil)
+
+			recorded := test.RunRequest(t, apih, req)
+			mt.CheckResponse(t, tc.checker, recorded)
+		})
+	}
 }
 
-func TestApiV2DevAuthDeleteDeviceAuthSet(t *testing.T) {
+func TestApiV2DevAuthTokenScopes(t *testing.T) {
 	t.Parallel()
 
 	// enforce specific field naming in errors returned by API
 	updateRestErrorFieldName()
 
-	tcases := []struct {
-		req  *http.Request
-		code int
-		body string
-		err  error
+	url := "http://1.2.3.4/api/management/v2/devauth/token_scopes"
+
+	tcases := map[string]struct {
+		method string
+		body   interface{}
+
+		daMethod string
+		daArg    interface{}
+		daRet    []interface{}
+
+		checker mt.ResponseChecker
 	}{
-		{
-			req: test.MakeSimpleRequest("DELETE",
-				"http://1.2.3.4/api/management/v2/devauth/devices/foo/auth/bar", nil),
-			code: http.StatusNoContent,
-			err:  nil,
+		"get, ok": {
+			method:   "GET",
+			daMethod: "GetTokenScopes",
+			daRet: []interface{}{
+				&model.TokenScopes{Scopes: []string{"deployments"}},
+				nil,
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusOK,
+				nil,
+				map[string]interface{}{"scopes": []string{"deployments"}}),
+		},
+		"get, error": {
+			method:   "GET",
+			daMethod: "GetTokenScopes",
+			daRet:    []interface{}{nil, errors.New("generic error")},
+			checker: mt.NewJSONResponse(
+				http.StatusInternalServerError,
+				nil,
+				restError("internal error")),
+		},
+		"put, ok": {
+			method: "PUT",
+			body: map[string]interface{}{
+				"scopes": []string{"deployments", "inventory"},
+			},
+			daMethod: "SetTokenScopes",
+			daArg: model.TokenScopes{
+				Scopes: []string{"deployments", "inventory"},
+			},
+			daRet: []interface{}{nil},
+			checker: mt.NewJSONResponse(
+				http.StatusNoContent,
+				nil,
+				nil),
 		},
-		{
-			req: test.MakeSimpleRequest("DELETE",
-				"http://1.2.3.4/api/management/v2/devauth/devices/foo/auth/bar", nil),
-			code: http.StatusInternalServerError,
-			body: RestError("internal error"),
-			err:  store.ErrAuthSetNotFound,
+		"put, ok, empty": {
+			method:   "PUT",
+			body:     map[string]interface{}{"scopes": []string{}},
+			daMethod: "SetTokenScopes",
+			daArg:    model.TokenScopes{Scopes: []string{}},
+			daRet:    []interface{}{nil},
+			checker: mt.NewJSONResponse(
+				http.StatusNoContent,
+				nil,
+				nil),
 		},
-		{
-			req: test.MakeSimpleRequest("DELETE",
-				"http://1.2.3.4/api/management/v2/devauth/devices/foo/auth/bar", nil),
-			code: http.StatusNotFound,
-			err:  store.ErrDevNotFound,
+		"put, missing scopes": {
+			method: "PUT",
+			body:   map[string]interface{}{},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("invalid token scopes: scopes must be provided")),
 		},
-		{
-			req: test.MakeSimpleRequest("DELETE",
-				"http://1.2.3.4/api/management/v2/devauth/devices/foo/auth/bar", nil),
-			code: http.StatusInternalServerError,
-			body: RestError("internal error"),
-			err:  errors.New("some error that will only be logged"),
+		"put, invalid scope": {
+			method: "PUT",
+			body: map[string]interface{}{
+				"scopes": []string{"deployments inventory"},
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("invalid token scopes: scopes must be non-empty and can't contain whitespace")),
+		},
+		"put, duplicate scope": {
+			method: "PUT",
+			body: map[string]interface{}{
+				"scopes": []string{"inventory", "inventory"},
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("invalid token scopes: duplicate scope: inventory")),
+		},
+		"put, error": {
+			method:   "PUT",
+			body:     map[string]interface{}{"scopes": []string{"inventory"}},
+			daMethod: "SetTokenScopes",
+			daArg:    model.TokenScopes{Scopes: []string{"inventory"}},
+			daRet:    []interface{}{errors.New("generic error")},
+			checker: mt.NewJSONResponse(
+				http.StatusInternalServerError,
+				nil,
+				restError("internal error")),
 		},
 	}
 
-	for i := range tcases {
-		tc := tcases[i]
-		t.Run(fmt.Sprintf("tc %d", i), func(t *testing.T) {
+	for n := range tcases {
+		tc := tcases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
 			t.Parallel()
 
 			da := &mocks.App{}
-			da.On("DeleteAuthSet",
-				mtest.ContextMatcher(),
-				"foo",
-				"bar").
-				Return(tc.err)
+			if tc.daMethod != "" {
+				args := []interface{}{mtest.ContextMatcher()}
+				if tc.daArg != nil {
+					args = append(args, tc.daArg)
+				}
+				da.On(tc.daMethod, args...).Return(tc.daRet...)
+			}
+
+			req := makeReq(tc.method, url, "", tc.body)
 
 			apih := makeMockApiHandler(t, da, nil)
-			runTestRequest(t, apih, tc.req, tc.code, tc.body)
+
+			recorded := test.RunRequest(t, apih, req)
+			mt.CheckResponse(t, tc.checker, recorded)
 		})
 	}
 }
 
-func TestApiDeleteTokens(t *testing.T) {
+func TestApiV2DevAuthAdmissionWebhook(t *testing.T) {
 	t.Parallel()
 
 	// enforce specific field naming in errors returned by API
 	updateRestErrorFieldName()
 
+	url := "http://1.2.3.4/api/management/v2/devauth/admission_webhook"
+
+	hook := model.AdmissionWebhook{
+		URL:    "https://assets.example.com/admission",
+		Secret: "0123456789abcdef",
+	}
+
 	tcases := map[string]struct {
-		tenantId string
-		deviceId string
+		method string
+		body   interface{}
 
-		devAuthErr error
+		daMethod string
+		daArg    interface{}
+		daRet    []interface{}
 
 		checker mt.ResponseChecker
 	}{
-		"ok, all tokens": {
-			tenantId: "foo",
+		"get, ok": {
+			method:   "GET",
+			daMethod: "GetAdmissionWebhook",
+			daRet:    []interface{}{&hook, nil},
 			checker: mt.NewJSONResponse(
-				http.StatusNoContent,
+				http.StatusOK,
 				nil,
-				nil),
+				map[string]interface{}{"url": hook.URL}),
 		},
-		"ok, device's tokens": {
-			tenantId: "foo",
-			deviceId: "dev-foo",
+		"get, not set": {
+			method:   "GET",
+			daMethod: "GetAdmissionWebhook",
+			daRet:    []interface{}{nil, devauth.ErrWebhookNotFound},
+			checker: mt.NewJSONResponse(
+				http.StatusNotFound,
+				nil,
+				restError(devauth.ErrWebhookNotFound.Error())),
+		},
+		"put, ok": {
+			method: "PUT",
+			body: map[string]interface{}{
+				"url":    hook.URL,
+				"secret": hook.Secret,
+			},
+			daMethod: "SetAdmissionWebhook",
+			daArg:    hook,
+			daRet:    []interface{}{nil},
 			checker: mt.NewJSONResponse(
 				http.StatusNoContent,
 				nil,
 				nil),
 		},
-		"error, no tenant id": {
-			deviceId: "dev-foo",
+		"put, relative url": {
+			method: "PUT",
+			body: map[string]interface{}{
+				"url":    "/admission",
+				"secret": hook.Secret,
+			},
 			checker: mt.NewJSONResponse(
 				http.StatusBadRequest,
 				nil,
-				restError("tenant_id must be provided")),
+				restError("invalid admission webhook: url: must be an absolute https URL")),
 		},
-		"error, devauth": {
-			tenantId:   "foo",
-			devAuthErr: errors.New("generic error"),
+		"put, plain http": {
+			method: "PUT",
+			body: map[string]interface{}{
+				"url":    "http://assets.example.com/admission",
+				"secret": hook.Secret,
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("invalid admission webhook: url: must be an absolute https URL")),
+		},
+		"put, metadata endpoint": {
+			method: "PUT",
+			body: map[string]interface{}{
+				"url":    "https://169.254.169.254/latest/meta-data",
+				"secret": hook.Secret,
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("invalid admission webhook: url: must not point to a loopback, link-local or private address")),
+		},
+		"put, short secret": {
+			method: "PUT",
+			body: map[string]interface{}{
+				"url":    hook.URL,
+				"secret": "secret",
+			},
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("invalid admission webhook: secret: must be at least 16 characters long")),
+		},
+		"put, error": {
+			method: "PUT",
+			body: map[string]interface{}{
+				"url":    hook.URL,
+				"secret": hook.Secret,
+			},
+			daMethod: "SetAdmissionWebhook",
+			daArg:    hook,
+			daRet:    []interface{}{errors.New("generic error")},
 			checker: mt.NewJSONResponse(
 				http.StatusInternalServerError,
 				nil,
 				restError("internal error")),
 		},
+		"delete, ok": {
+			method:   "DELETE",
+			daMethod: "DeleteAdmissionWebhook",
+			daRet:    []interface{}{nil},
+			checker: mt.NewJSONResponse(
+				http.StatusNoContent,
+				nil,
+				nil),
+		},
+		"delete, not set": {
+			method:   "DELETE",
+			daMethod: "DeleteAdmissionWebhook",
+			daRet:    []interface{}{devauth.ErrWebhookNotFound},
+			checker: mt.NewJSONResponse(
+				http.StatusNotFound,
+				nil,
+				restError(devauth.ErrWebhookNotFound.Error())),
+		},
 	}
 
 	for n := range tcases {
@@ -1480,21 +4220,15 @@ func TestApiDeleteTokens(t *testing.T) {
 			t.Parallel()
 
 			da := &mocks.App{}
-			da.On("DeleteTokens",
-				mtest.ContextMatcher(),
-				tc.tenantId,
-				tc.deviceId).
-				Return(tc.devAuthErr)
-
-			//make request
-			url := fmt.Sprintf("http://1.2.3.4/api/internal/v1/devauth/tokens?tenant_id=%v&device_id=%v",
-				tc.tenantId,
-				tc.deviceId)
+			if tc.daMethod != "" {
+				args := []interface{}{mtest.ContextMatcher()}
+				if tc.daArg != nil {
+					args = append(args, tc.daArg)
+				}
+				da.On(tc.daMethod, args...).Return(tc.daRet...)
+			}
 
-			req := makeReq("DELETE",
-				url,
-				"",
-				nil)
+			req := makeReq(tc.method, url, "", tc.body)
 
 			apih := makeMockApiHandler(t, da, nil)
 
@@ -1504,93 +4238,257 @@ func TestApiDeleteTokens(t *testing.T) {
 	}
 }
 
-func TestApiDevAuthGetTenantDeviceStatus(t *testing.T) {
+func TestApiV2DevAuthIdentitySchema(t *testing.T) {
 	t.Parallel()
 
 	// enforce specific field naming in errors returned by API
 	updateRestErrorFieldName()
 
+	url := "http://1.2.3.4/api/management/v2/devauth/identity_schema"
+
+	additional := false
+	schema := model.IdentitySchema{
+		Required: []string{"mac"},
+		Properties: map[string]model.IdentityProperty{
+			"mac": {
+				Type:    model.IdentityTypeString,
+				Pattern: "^([0-9a-f]{2}:){5}[0-9a-f]{2}$",
+			},
+			"sku": {
+				Type: model.IdentityTypeString,
+				Enum: []string{"acme-1", "acme-2"},
+			},
+		},
+		AdditionalProperties: &additional,
+	}
+
 	tcases := map[string]struct {
-		tid string
-		did string
+		method string
+		body   interface{}
 
-		daStatus *model.Status
-		daErr    error
+		daMethod string
+		daArg    interface{}
+		daRet    []interface{}
 
 		checker mt.ResponseChecker
 	}{
-		"ok": {
-			tid: "foo",
-			did: "bar",
-
-			daStatus: &model.Status{Status: "accepted"},
-
+		"get, ok": {
+			method:   "GET",
+			daMethod: "GetIdentitySchema",
+			daRet:    []interface{}{&schema, nil},
 			checker: mt.NewJSONResponse(
 				http.StatusOK,
 				nil,
-				model.Status{Status: "accepted"}),
+				schema),
 		},
-		"error: tenant id empty": {
-			did: "bar",
-
+		"get, not set": {
+			method:   "GET",
+			daMethod: "GetIdentitySchema",
+			daRet:    []interface{}{nil, devauth.ErrIdSchemaNotFound},
 			checker: mt.NewJSONResponse(
-				http.StatusBadRequest,
+				http.StatusNotFound,
 				nil,
-				restError("tenant id (tid) cannot be empty")),
+				restError(devauth.ErrIdSchemaNotFound.Error())),
 		},
-		"error: device id empty": {
-			tid: "foo",
-
+		"put, ok": {
+			method:   "PUT",
+			body:     schema,
+			daMethod: "SetIdentitySchema",
+			daArg:    schema,
+			daRet:    []interface{}{nil},
+			checker: mt.NewJSONResponse(
+				http.StatusNoContent,
+				nil,
+				nil),
+		},
+		"put, bad type": {
+			method: "PUT",
+			body: map[string]interface{}{
+				"properties": map[string]interface{}{
+					"mac": map[string]interface{}{"type": "object"},
+				},
+			},
 			checker: mt.NewJSONResponse(
 				http.StatusBadRequest,
 				nil,
-				restError("device id (did) cannot be empty")),
+				restError("invalid identity schema: properties: mac: type: must be one of [string number integer boolean array]")),
 		},
-		"error: not found": {
-			tid: "foo",
-			did: "bar",
-
-			daErr: devauth.ErrDeviceNotFound,
-
+		"put, bad pattern": {
+			method: "PUT",
+			body: map[string]interface{}{
+				"properties": map[string]interface{}{
+					"mac": map[string]interface{}{
+						"type":    "string",
+						"pattern": "^([0-9a-f]{2}",
+					},
+				},
+			},
 			checker: mt.NewJSONResponse(
-				http.StatusNotFound,
+				http.StatusBadRequest,
 				nil,
-				restError("device not found")),
+				restError("invalid identity schema: properties: mac: pattern: invalid regular expression: error parsing regexp: missing closing ): `^([0-9a-f]{2}`")),
 		},
-		"error: generic": {
-			tid: "foo",
-			did: "bar",
-
-			daErr: errors.New("generic error"),
-
+		"put, error": {
+			method:   "PUT",
+			body:     schema,
+			daMethod: "SetIdentitySchema",
+			daArg:    schema,
+			daRet:    []interface{}{errors.New("generic error")},
 			checker: mt.NewJSONResponse(
 				http.StatusInternalServerError,
 				nil,
 				restError("internal error")),
 		},
+		"delete, ok": {
+			method:   "DELETE",
+			daMethod: "DeleteIdentitySchema",
+			daRet:    []interface{}{nil},
+			checker: mt.NewJSONResponse(
+				http.StatusNoContent,
+				nil,
+				nil),
+		},
+		"delete, not set": {
+			method:   "DELETE",
+			daMethod: "DeleteIdentitySchema",
+			daRet:    []interface{}{devauth.ErrIdSchemaNotFound},
+			checker: mt.NewJSONResponse(
+				http.StatusNotFound,
+				nil,
+				restError(devauth.ErrIdSchemaNotFound.Error())),
+		},
 	}
 
-	for i := range tcases {
-		tc := tcases[i]
-		t.Run(fmt.Sprintf("tc %s", i), func(t *testing.T) {
+	for n := range tcases {
+		tc := tcases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
 			t.Parallel()
 
-			req := makeReq("GET",
-				fmt.Sprintf("http://1.2.3.4/api/internal/v1/devauth/tenants/%s/devices/%s/status", tc.tid, tc.did),
-				"",
-				nil)
+			da := &mocks.App{}
+			if tc.daMethod != "" {
+				args := []interface{}{mtest.ContextMatcher()}
+				if tc.daArg != nil {
+					args = append(args, tc.daArg)
+				}
+				da.On(tc.daMethod, args...).Return(tc.daRet...)
+			}
+
+			req := makeReq(tc.method, url, "", tc.body)
+
+			apih := makeMockApiHandler(t, da, nil)
+
+			recorded := test.RunRequest(t, apih, req)
+			mt.CheckResponse(t, tc.checker, recorded)
+		})
+	}
+}
+
+func TestApiV2DevAuthGetIdentitySchemaViolations(t *testing.T) {
+	t.Parallel()
+
+	// enforce specific field naming in errors returned by API
+	updateRestErrorFieldName()
+
+	mkViolations := func(num int) []model.IdentitySchemaViolation {
+		violations := []model.IdentitySchemaViolation{}
+		for i := 0; i < num; i++ {
+			violations = append(violations, model.IdentitySchemaViolation{
+				DeviceId: "dev-" + strconv.Itoa(i),
+				IdData:   `{"sn":"000` + strconv.Itoa(i) + `"}`,
+				Errors: model.IdentityDataErrors{
+					{Attribute: "mac", Message: "required attribute missing"},
+				},
+			})
+		}
+		return violations
+	}
+
+	tcases := map[string]struct {
+		query string
+
+		skip, limit uint
+
+		daViolations []model.IdentitySchemaViolation
+		daErr        error
+
+		code  int
+		body  []model.IdentitySchemaViolation
+		links []string
+		err   string
+	}{
+		"ok": {
+			limit:        21,
+			daViolations: mkViolations(3),
+			code:         http.StatusOK,
+			body:         mkViolations(3),
+			links: []string{
+				`<http://1.2.3.4/api/management/v2/devauth/identity_schema/violations?page=1&per_page=20>; rel="first"`,
+			},
+		},
+		"ok, paging": {
+			query:        "?page=2&per_page=2",
+			skip:         2,
+			limit:        3,
+			daViolations: mkViolations(3),
+			code:         http.StatusOK,
+			body:         mkViolations(2),
+			links: []string{
+				`<http://1.2.3.4/api/management/v2/devauth/identity_schema/violations?page=1&per_page=2>; rel="prev"`,
+				`<http://1.2.3.4/api/management/v2/devauth/identity_schema/violations?page=3&per_page=2>; rel="next"`,
+				`<http://1.2.3.4/api/management/v2/devauth/identity_schema/violations?page=1&per_page=2>; rel="first"`,
+			},
+		},
+		"ok, none": {
+			limit:        21,
+			daViolations: mkViolations(0),
+			code:         http.StatusOK,
+			body:         mkViolations(0),
+		},
+		"error, bad paging": {
+			query: "?page=0",
+			code:  http.StatusBadRequest,
+			err:   "Param page is out of bounds",
+		},
+		"error, generic": {
+			limit: 21,
+			daErr: errors.New("generic error"),
+			code:  http.StatusInternalServerError,
+			err:   "internal error",
+		},
+	}
+
+	for n := range tcases {
+		tc := tcases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
 
 			da := &mocks.App{}
-			da.On("GetTenantDeviceStatus",
+			da.On("GetIdentitySchemaViolations",
 				mtest.ContextMatcher(),
-				tc.tid,
-				tc.did,
-			).Return(tc.daStatus, tc.daErr)
+				tc.skip,
+				tc.limit).
+				Return(tc.daViolations, tc.daErr)
 
 			apih := makeMockApiHandler(t, da, nil)
 
+			req := makeReq("GET",
+				"http://1.2.3.4/api/management/v2/devauth/identity_schema/violations"+tc.query,
+				"",
+				nil)
+
 			recorded := test.RunRequest(t, apih, req)
-			mt.CheckResponse(t, tc.checker, recorded)
+			recorded.CodeIs(tc.code)
+
+			if tc.err != "" {
+				recorded.BodyIs(RestError(tc.err))
+				return
+			}
+
+			recorded.BodyIs(toJsonString(t, tc.body))
+
+			for _, l := range tc.links {
+				assert.Equal(t, l, ExtractHeader("Link", l, recorded))
+			}
 		})
 	}
 }
@@ -21,4 +21,6 @@ import (
 type ApiHandler interface {
 	// produce a rest.App with routing setup or an error
 	GetApp() (rest.App, error)
+	// produce a rest.App with the routes of the devices API only
+	GetDevicesApp() (rest.App, error)
 }
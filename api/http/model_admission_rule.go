@@ -0,0 +1,101 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package http
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceauth/model"
+	"github.com/mendersoftware/deviceauth/utils"
+)
+
+type admissionRuleReq struct {
+	Name       string                     `json:"name"`
+	Priority   int                        `json:"priority"`
+	Conditions []model.AdmissionCondition `json:"conditions"`
+	KeyTypes   []string                   `json:"key_types"`
+	Action     string                     `json:"action"`
+}
+
+func parseAdmissionRuleReq(source io.Reader) (*model.AdmissionRule, error) {
+	jd := json.NewDecoder(source)
+
+	var req admissionRuleReq
+
+	if err := jd.Decode(&req); err != nil {
+		return nil, err
+	}
+
+	rule := &model.AdmissionRule{
+		Name:       req.Name,
+		Priority:   req.Priority,
+		Conditions: req.Conditions,
+		KeyTypes:   req.KeyTypes,
+		Action:     req.Action,
+	}
+
+	if err := rule.Validate(); err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+type admissionMatchReq struct {
+	IdData map[string]interface{} `json:"identity_data"`
+	// optional, the key type of the device
+	PubKey string `json:"pubkey"`
+
+	keyType string
+}
+
+type admissionMatchRsp struct {
+	Rule   *model.AdmissionRule `json:"rule"`
+	Action string               `json:"action"`
+}
+
+func parseAdmissionMatchReq(source io.Reader) (*admissionMatchReq, error) {
+	jd := json.NewDecoder(source)
+
+	var req admissionMatchReq
+
+	if err := jd.Decode(&req); err != nil {
+		return nil, err
+	}
+
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	return &req, nil
+}
+
+func (r *admissionMatchReq) validate() error {
+	if len(r.IdData) == 0 {
+		return errors.New("identity_data: non zero value required")
+	}
+
+	if r.PubKey != "" {
+		key, err := utils.ParsePubKey(r.PubKey)
+		if err != nil {
+			return errors.Wrap(err, "pubkey")
+		}
+		r.keyType = utils.PubKeyType(key)
+	}
+
+	return nil
+}
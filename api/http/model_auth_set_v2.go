@@ -20,20 +20,22 @@ import (
 )
 
 type authSetV2 struct {
-	Id        string                 `json:"id"`
-	IdData    map[string]interface{} `json:"identity_data"`
-	PubKey    string                 `json:"pubkey"`
-	Timestamp *time.Time             `json:"ts"`
-	Status    string                 `json:"status"`
+	Id             string                 `json:"id"`
+	IdData         map[string]interface{} `json:"identity_data"`
+	PubKey         string                 `json:"pubkey"`
+	CredentialType string                 `json:"credential_type,omitempty"`
+	Timestamp      *time.Time             `json:"ts"`
+	Status         string                 `json:"status"`
 }
 
 func authSetV2FromDbModel(dbAuthSet *model.AuthSet) (*authSetV2, error) {
 	return &authSetV2{
-		Id:        dbAuthSet.Id,
-		IdData:    dbAuthSet.IdDataStruct,
-		PubKey:    dbAuthSet.PubKey,
-		Timestamp: dbAuthSet.Timestamp,
-		Status:    dbAuthSet.Status,
+		Id:             dbAuthSet.Id,
+		IdData:         dbAuthSet.IdDataStruct,
+		PubKey:         dbAuthSet.PubKey,
+		CredentialType: dbAuthSet.CredentialType,
+		Timestamp:      dbAuthSet.Timestamp,
+		Status:         dbAuthSet.Status,
 	}, nil
 }
 
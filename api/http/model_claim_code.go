@@ -0,0 +1,52 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package http
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type claimCodeReq struct {
+	// optional, the code doesn't expire if not set
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func parseClaimCodeReq(source io.Reader) (*claimCodeReq, error) {
+	jd := json.NewDecoder(source)
+
+	var req claimCodeReq
+
+	// the body is optional
+	if err := jd.Decode(&req); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	return &req, nil
+}
+
+func (r *claimCodeReq) validate() error {
+	if r.ExpiresAt != nil && !r.ExpiresAt.After(time.Now()) {
+		return errors.New("expires_at: must be in the future")
+	}
+
+	return nil
+}
@@ -14,7 +14,7 @@
 package http
 
 import (
-	"crypto/rsa"
+	"encoding/base64"
 	"encoding/json"
 	"io"
 
@@ -26,9 +26,16 @@ import (
 	"github.com/mendersoftware/deviceauth/utils"
 )
 
+// minimum length of the pre-shared keys, in bytes
+const minPSKLength = 16
+
 type preAuthReq struct {
 	IdData map[string]interface{} `json:"identity_data" valid:"-"`
-	PubKey string                 `json:"pubkey" valid:"required"`
+	PubKey string                 `json:"pubkey" valid:"-"`
+	// base64 encoded pre-shared key, instead of the public key
+	PSK string `json:"psk" valid:"-"`
+
+	psk []byte
 }
 
 func parsePreAuthReq(source io.Reader) (*preAuthReq, error) {
@@ -60,18 +67,32 @@ func (r *preAuthReq) validate() error {
 		return err
 	}
 
+	if r.PSK != "" {
+		if r.PubKey != "" {
+			return errors.New("pubkey and psk can't be both provided")
+		}
+		psk, err := base64.StdEncoding.DecodeString(r.PSK)
+		if err != nil {
+			return errors.Wrap(err, "psk: invalid base64 encoding")
+		}
+		if len(psk) < minPSKLength {
+			return errors.Errorf("psk: must be at least %d bytes long", minPSKLength)
+		}
+		r.psk = psk
+		return nil
+	}
+
+	if r.PubKey == "" {
+		return errors.New("pubkey: non zero value required;")
+	}
+
 	//normalize key
 	key, err := utils.ParsePubKey(r.PubKey)
 	if err != nil {
 		return err
 	}
 
-	keyStruct, ok := key.(*rsa.PublicKey)
-	if !ok {
-		return errors.New("cannot decode public key")
-	}
-
-	serialized, err := utils.SerializePubKey(keyStruct)
+	serialized, err := utils.SerializePubKey(key)
 	if err != nil {
 		return err
 	}
@@ -82,7 +103,7 @@ func (r *preAuthReq) validate() error {
 }
 
 func (r *preAuthReq) getDbModel() (*model.PreAuthReq, error) {
-	enc, err := json.Marshal(r.IdData)
+	enc, err := utils.JsonMarshalCanonical(r.IdData)
 	if err != nil {
 		return nil, err
 	}
@@ -92,5 +113,6 @@ func (r *preAuthReq) getDbModel() (*model.PreAuthReq, error) {
 		AuthSetId: bson.NewObjectId().Hex(),
 		IdData:    string(enc),
 		PubKey:    r.PubKey,
+		PSK:       r.psk,
 	}, nil
 }
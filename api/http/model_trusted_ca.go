@@ -0,0 +1,75 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package http
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"io"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceauth/model"
+	"github.com/mendersoftware/deviceauth/utils"
+)
+
+type trustedCAReq struct {
+	Certificate string `json:"certificate" valid:"required"`
+
+	cert *x509.Certificate
+}
+
+func parseTrustedCAReq(source io.Reader) (*trustedCAReq, error) {
+	jd := json.NewDecoder(source)
+
+	var req trustedCAReq
+
+	if err := jd.Decode(&req); err != nil {
+		return nil, err
+	}
+
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	return &req, nil
+}
+
+func (r *trustedCAReq) validate() error {
+	if _, err := govalidator.ValidateStruct(*r); err != nil {
+		return err
+	}
+
+	certs, err := utils.ParseCertChain(r.Certificate)
+	if err != nil {
+		return err
+	}
+
+	if len(certs) != 1 {
+		return errors.New("certificate: exactly one certificate expected")
+	}
+
+	if !certs[0].IsCA {
+		return errors.New("certificate: not a CA certificate")
+	}
+
+	r.cert = certs[0]
+
+	return nil
+}
+
+func (r *trustedCAReq) getDbModel() *model.TrustedCA {
+	return model.NewTrustedCA(r.cert)
+}
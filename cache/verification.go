@@ -0,0 +1,246 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/mendersoftware/deviceauth/jwt"
+	"github.com/mendersoftware/deviceauth/model"
+)
+
+// VerificationEntry is a positive token verification result: the token was
+// found in the DB, its auth set is accepted and the device is not being
+// decommissioned.
+type VerificationEntry struct {
+	Token   *jwt.Token
+	AuthSet *model.AuthSet
+	Device  *model.Device
+}
+
+// Stats are the cache counters.
+type Stats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Size   int    `json:"size"`
+}
+
+// VerificationCache is a bounded cache of token verification results, keyed
+// by token ID ('jti'). Entries expire after the configured TTL, or with the
+// token, whichever comes first; the least recently used entries are evicted
+// when the cache is full.
+//
+// The cache is local to the instance: invalidations done by other instances
+// are only picked up when the entries expire, so the TTL bounds how long a
+// revoked token may still be accepted.
+type VerificationCache struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	// entries by device, auth set and tenant, so that invalidations don't
+	// scan the whole cache
+	devices  index
+	authSets index
+	tenants  index
+	// bumped on every invalidation, see Generation
+	gen uint64
+
+	hits   uint64
+	misses uint64
+}
+
+type item struct {
+	jti       string
+	entry     VerificationEntry
+	expiresAt time.Time
+}
+
+func (it *item) deviceId() string {
+	return it.entry.Device.Id
+}
+
+func (it *item) authSetId() string {
+	return it.entry.AuthSet.Id
+}
+
+func (it *item) tenantId() string {
+	return it.entry.Token.Claims.Tenant
+}
+
+// index maps keys to the cache entries having them
+type index map[string]map[*list.Element]struct{}
+
+func (ix index) add(key string, el *list.Element) {
+	els, ok := ix[key]
+	if !ok {
+		els = map[*list.Element]struct{}{}
+		ix[key] = els
+	}
+	els[el] = struct{}{}
+}
+
+func (ix index) remove(key string, el *list.Element) {
+	els := ix[key]
+	delete(els, el)
+	if len(els) == 0 {
+		delete(ix, key)
+	}
+}
+
+func NewVerificationCache(size int, ttl time.Duration) *VerificationCache {
+	return &VerificationCache{
+		size:     size,
+		ttl:      ttl,
+		now:      time.Now,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element, size),
+		devices:  index{},
+		authSets: index{},
+		tenants:  index{},
+	}
+}
+
+// Get returns the cached verification result for the token ID.
+func (c *VerificationCache) Get(jti string) (*VerificationEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[jti]
+	if ok && !c.now().Before(el.Value.(*item).expiresAt) {
+		c.remove(el)
+		ok = false
+	}
+
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	c.hits++
+	c.lru.MoveToFront(el)
+
+	entry := el.Value.(*item).entry
+	return &entry, true
+}
+
+// Generation returns a value that changes on every invalidation. It must be
+// read before fetching the data to be cached and passed to Put, so that
+// results fetched concurrently with an invalidation are not cached.
+func (c *VerificationCache) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.gen
+}
+
+// Put caches the verification result, unless the cache was invalidated since
+// `gen` was obtained.
+func (c *VerificationCache) Put(gen uint64, entry VerificationEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen || c.size <= 0 {
+		return
+	}
+
+	expiresAt := c.now().Add(c.ttl)
+	if exp := time.Unix(entry.Token.Claims.ExpiresAt, 0); exp.Before(expiresAt) {
+		expiresAt = exp
+	}
+
+	jti := entry.Token.Claims.ID
+	if el, ok := c.entries[jti]; ok {
+		c.remove(el)
+	}
+
+	for c.lru.Len() >= c.size {
+		c.remove(c.lru.Back())
+	}
+
+	it := &item{
+		jti:       jti,
+		entry:     entry,
+		expiresAt: expiresAt,
+	}
+	el := c.lru.PushFront(it)
+	c.entries[jti] = el
+	c.devices.add(it.deviceId(), el)
+	c.authSets.add(it.authSetId(), el)
+	c.tenants.add(it.tenantId(), el)
+}
+
+// InvalidateToken drops the result cached for the token ID.
+func (c *VerificationCache) InvalidateToken(jti string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	if el, ok := c.entries[jti]; ok {
+		c.remove(el)
+	}
+}
+
+// InvalidateDevice drops the results cached for the tokens of the device.
+func (c *VerificationCache) InvalidateDevice(devId string) {
+	c.invalidate(c.devices, devId)
+}
+
+// InvalidateAuthSet drops the results cached for the tokens issued for the
+// auth set.
+func (c *VerificationCache) InvalidateAuthSet(authId string) {
+	c.invalidate(c.authSets, authId)
+}
+
+// InvalidateTenant drops the results cached for the tokens of the tenant.
+func (c *VerificationCache) InvalidateTenant(tenantId string) {
+	c.invalidate(c.tenants, tenantId)
+}
+
+func (c *VerificationCache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Stats{
+		Hits:   c.hits,
+		Misses: c.misses,
+		Size:   c.lru.Len(),
+	}
+}
+
+func (c *VerificationCache) invalidate(ix index, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+
+	for el := range ix[key] {
+		c.remove(el)
+	}
+}
+
+func (c *VerificationCache) remove(el *list.Element) {
+	it := el.Value.(*item)
+
+	c.lru.Remove(el)
+	delete(c.entries, it.jti)
+	c.devices.remove(it.deviceId(), el)
+	c.authSets.remove(it.authSetId(), el)
+	c.tenants.remove(it.tenantId(), el)
+}
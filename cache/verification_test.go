@@ -0,0 +1,202 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deviceauth/jwt"
+	"github.com/mendersoftware/deviceauth/model"
+)
+
+var testNow = time.Unix(1600000000, 0)
+
+func makeEntry(jti, tenant, authId, devId string) VerificationEntry {
+	return VerificationEntry{
+		Token: &jwt.Token{Claims: jwt.Claims{
+			ID:        jti,
+			Tenant:    tenant,
+			ExpiresAt: testNow.Unix() + 3600,
+		}},
+		AuthSet: &model.AuthSet{Id: authId, DeviceId: devId},
+		Device:  &model.Device{Id: devId},
+	}
+}
+
+func makeCache(size int, ttl time.Duration) (*VerificationCache, *time.Time) {
+	now := testNow
+	c := NewVerificationCache(size, ttl)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestVerificationCacheGetPut(t *testing.T) {
+	c, now := makeCache(2, time.Minute)
+
+	_, ok := c.Get("jti1")
+	assert.False(t, ok)
+
+	e1 := makeEntry("jti1", "", "aid1", "dev1")
+	c.Put(c.Generation(), e1)
+
+	out, ok := c.Get("jti1")
+	assert.True(t, ok)
+	assert.Equal(t, e1, *out)
+
+	// TTL expired
+	*now = now.Add(time.Minute)
+	_, ok = c.Get("jti1")
+	assert.False(t, ok)
+
+	assert.Equal(t, Stats{Hits: 1, Misses: 2, Size: 0}, c.Stats())
+}
+
+func TestVerificationCacheTokenExpiry(t *testing.T) {
+	c, now := makeCache(2, time.Hour)
+
+	e := makeEntry("jti1", "", "aid1", "dev1")
+	e.Token.Claims.ExpiresAt = testNow.Unix() + 10
+	c.Put(c.Generation(), e)
+
+	_, ok := c.Get("jti1")
+	assert.True(t, ok)
+
+	*now = now.Add(10 * time.Second)
+	_, ok = c.Get("jti1")
+	assert.False(t, ok)
+}
+
+func TestVerificationCacheEviction(t *testing.T) {
+	c, _ := makeCache(2, time.Minute)
+
+	c.Put(c.Generation(), makeEntry("jti1", "", "aid1", "dev1"))
+	c.Put(c.Generation(), makeEntry("jti2", "", "aid2", "dev2"))
+
+	// jti1 becomes the most recently used one
+	_, ok := c.Get("jti1")
+	assert.True(t, ok)
+
+	c.Put(c.Generation(), makeEntry("jti3", "", "aid3", "dev3"))
+
+	_, ok = c.Get("jti2")
+	assert.False(t, ok)
+	_, ok = c.Get("jti1")
+	assert.True(t, ok)
+	_, ok = c.Get("jti3")
+	assert.True(t, ok)
+
+	assert.Equal(t, 2, c.Stats().Size)
+
+	// evicted entries are dropped from the indexes
+	assert.Len(t, c.devices, 2)
+	assert.NotContains(t, c.devices, "dev2")
+	assert.NotContains(t, c.authSets, "aid2")
+}
+
+func TestVerificationCacheIndexes(t *testing.T) {
+	c, now := makeCache(10, time.Minute)
+
+	c.Put(c.Generation(), makeEntry("jti1", "tenant1", "aid1", "dev1"))
+	c.Put(c.Generation(), makeEntry("jti2", "tenant1", "aid1", "dev1"))
+	// replaced
+	c.Put(c.Generation(), makeEntry("jti2", "tenant1", "aid2", "dev2"))
+
+	assert.Len(t, c.devices["dev1"], 1)
+	assert.Len(t, c.devices["dev2"], 1)
+	assert.Len(t, c.tenants["tenant1"], 2)
+
+	c.InvalidateAuthSet("aid1")
+	assert.NotContains(t, c.devices, "dev1")
+	assert.Len(t, c.tenants["tenant1"], 1)
+
+	// expired
+	*now = now.Add(2 * time.Minute)
+	_, ok := c.Get("jti2")
+	assert.False(t, ok)
+
+	assert.Empty(t, c.entries)
+	assert.Empty(t, c.devices)
+	assert.Empty(t, c.authSets)
+	assert.Empty(t, c.tenants)
+}
+
+func TestVerificationCacheDisabled(t *testing.T) {
+	c, _ := makeCache(0, time.Minute)
+
+	c.Put(c.Generation(), makeEntry("jti1", "", "aid1", "dev1"))
+
+	_, ok := c.Get("jti1")
+	assert.False(t, ok)
+}
+
+func TestVerificationCacheInvalidate(t *testing.T) {
+	testCases := map[string]struct {
+		invalidate func(c *VerificationCache)
+		remaining  []string
+	}{
+		"token": {
+			invalidate: func(c *VerificationCache) { c.InvalidateToken("jti1") },
+			remaining:  []string{"jti2", "jti3", "jti4"},
+		},
+		"device": {
+			invalidate: func(c *VerificationCache) { c.InvalidateDevice("dev1") },
+			remaining:  []string{"jti3", "jti4"},
+		},
+		"auth set": {
+			invalidate: func(c *VerificationCache) { c.InvalidateAuthSet("aid2") },
+			remaining:  []string{"jti1", "jti3", "jti4"},
+		},
+		"tenant": {
+			invalidate: func(c *VerificationCache) { c.InvalidateTenant("tenant1") },
+			remaining:  []string{"jti4"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			c, _ := makeCache(10, time.Minute)
+
+			c.Put(c.Generation(), makeEntry("jti1", "tenant1", "aid1", "dev1"))
+			c.Put(c.Generation(), makeEntry("jti2", "tenant1", "aid2", "dev1"))
+			c.Put(c.Generation(), makeEntry("jti3", "tenant1", "aid3", "dev3"))
+			c.Put(c.Generation(), makeEntry("jti4", "tenant2", "aid4", "dev4"))
+
+			tc.invalidate(c)
+
+			remaining := []string{}
+			for _, jti := range []string{"jti1", "jti2", "jti3", "jti4"} {
+				if _, ok := c.Get(jti); ok {
+					remaining = append(remaining, jti)
+				}
+			}
+			assert.Equal(t, tc.remaining, remaining)
+		})
+	}
+}
+
+func TestVerificationCacheInvalidateRace(t *testing.T) {
+	c, _ := makeCache(10, time.Minute)
+
+	// verification result fetched from the DB while the device is
+	// being invalidated
+	gen := c.Generation()
+	c.InvalidateDevice("dev1")
+	c.Put(gen, makeEntry("jti1", "", "aid1", "dev1"))
+
+	_, ok := c.Get("jti1")
+	assert.False(t, ok)
+}
@@ -0,0 +1,278 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package admission
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceauth/model"
+	"github.com/mendersoftware/deviceauth/utils"
+)
+
+const (
+	// header carrying the signature of the webhook response:
+	// BASE64(HMAC-SHA256(secret, body))
+	HdrSignature = "X-MEN-Signature"
+
+	// default timeout of a single request
+	defaultReqTimeout = time.Duration(2) * time.Second
+	// default bound of all attempts
+	defaultMaxDuration = time.Duration(5) * time.Second
+	// default pause between attempts
+	defaultRetryInterval = time.Duration(200) * time.Millisecond
+
+	maxRspSize = 64 * 1024
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid response signature")
+	ErrNotHTTPS         = errors.New("admission webhook URL must be https")
+	ErrAddressForbidden = errors.New("admission webhook address not allowed")
+)
+
+// Config conveys client configuration
+type Config struct {
+	// Timeout of a single request
+	Timeout time.Duration
+	// Number of retries of failed requests
+	Retries int
+	// Pause between the attempts
+	RetryInterval time.Duration
+	// Bound of the time spent on all attempts
+	MaxDuration time.Duration
+	// Webhook hosts allowed at loopback, link-local or private addresses,
+	// e.g. services run by the operator; other hosts must resolve to public
+	// addresses only
+	PrivateHosts []string
+}
+
+// Req is the body of the request to the webhook
+type Req struct {
+	TenantId  string `json:"tenant_id,omitempty"`
+	DeviceId  string `json:"device_id"`
+	AuthSetId string `json:"auth_set_id"`
+	// Identity data of the device
+	IdData map[string]interface{} `json:"identity_data"`
+	// Type of the public key, one of utils.KeyType*
+	KeyType string `json:"key_type"`
+	// Hex encoded SHA256 hash of the DER encoded public key
+	KeyFingerprint string `json:"key_fingerprint"`
+}
+
+// Rsp is the body of the (signed) webhook response
+type Rsp struct {
+	// Auth set the decision applies to, as in the request
+	AuthSetId string `json:"auth_set_id"`
+	// One of the model.AdmissionAction* constants
+	Action string `json:"action"`
+}
+
+// ClientRunner is an interface of admission webhook client
+type ClientRunner interface {
+	CheckAdmission(ctx context.Context, hook model.AdmissionWebhook, req Req) (*Rsp, error)
+}
+
+// Client is an opaque implementation of admission webhook client. Implements
+// ClientRunner interface
+type Client struct {
+	conf   Config
+	client *http.Client
+}
+
+// CheckAdmission asks the webhook about the admission of the device; failed
+// requests are retried until the number of retries or the maximum duration
+// is exceeded.
+func (c *Client) CheckAdmission(ctx context.Context, hook model.AdmissionWebhook, req Req) (*Rsp, error) {
+	l := log.FromContext(ctx)
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to serialize admission webhook request")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.conf.MaxDuration)
+	defer cancel()
+
+	for attempt := 0; ; attempt++ {
+		rsp, retry, err := c.checkAdmission(ctx, hook, req, body)
+		if err == nil {
+			return rsp, nil
+		}
+
+		if !retry || attempt >= c.conf.Retries {
+			return nil, err
+		}
+
+		l.Warnf("admission webhook request failed, retrying: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(c.conf.RetryInterval):
+		}
+	}
+}
+
+// checkAdmission makes a single request to the webhook; returns whether the
+// request can be retried on failure.
+func (c *Client) checkAdmission(ctx context.Context, hook model.AdmissionWebhook, req Req, body []byte) (*Rsp, bool, error) {
+	httpReq, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to create request")
+	}
+
+	// identity data isn't sent in plaintext, also to webhooks set up
+	// before https was required
+	if httpReq.URL.Scheme != "https" {
+		return nil, false, ErrNotHTTPS
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(ctx, c.conf.Timeout)
+	defer cancel()
+
+	httpRsp, err := c.client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		if uerr, ok := err.(*url.Error); ok && uerr.Err == ErrAddressForbidden {
+			return nil, false, ErrAddressForbidden
+		}
+		return nil, true, errors.Wrap(err, "admission webhook request failed")
+	}
+	defer httpRsp.Body.Close()
+
+	switch {
+	case httpRsp.StatusCode == http.StatusOK:
+	case httpRsp.StatusCode == http.StatusTooManyRequests,
+		httpRsp.StatusCode >= http.StatusInternalServerError:
+		return nil, true, errors.Errorf(
+			"admission webhook request failed with status %v", httpRsp.Status)
+	default:
+		return nil, false, errors.Errorf(
+			"admission webhook request failed with status %v", httpRsp.Status)
+	}
+
+	rspBody, err := ioutil.ReadAll(io.LimitReader(httpRsp.Body, maxRspSize))
+	if err != nil {
+		return nil, true, errors.Wrap(err, "failed to read admission webhook response")
+	}
+
+	if err := utils.VerifyAuthReqHMAC(httpRsp.Header.Get(HdrSignature),
+		[]byte(hook.Secret), rspBody); err != nil {
+		return nil, false, ErrInvalidSignature
+	}
+
+	var rsp Rsp
+	if err := json.Unmarshal(rspBody, &rsp); err != nil {
+		return nil, false, errors.Wrap(err, "failed to parse admission webhook response")
+	}
+
+	// signed responses can't be replayed for other devices
+	if rsp.AuthSetId != req.AuthSetId {
+		return nil, false, errors.Errorf(
+			"admission webhook response for auth set %s, expected %s",
+			rsp.AuthSetId, req.AuthSetId)
+	}
+
+	if !utils.ContainsString(rsp.Action, model.AdmissionActions) {
+		return nil, false, errors.Errorf(
+			"invalid admission webhook action: %s", rsp.Action)
+	}
+
+	return &rsp, false, nil
+}
+
+// NewClient creates a client with given config.
+func NewClient(c Config) *Client {
+	if c.Timeout == 0 {
+		c.Timeout = defaultReqTimeout
+	}
+	if c.MaxDuration == 0 {
+		c.MaxDuration = defaultMaxDuration
+	}
+	if c.RetryInterval == 0 {
+		c.RetryInterval = defaultRetryInterval
+	}
+
+	client := &Client{
+		conf: c,
+	}
+	client.client = &http.Client{
+		Transport: &http.Transport{
+			// the addresses are checked when dialing, not of a proxy
+			Proxy:               nil,
+			DialContext:         client.dialContext,
+			TLSHandshakeTimeout: c.Timeout,
+		},
+		// redirects could lead anywhere, also to plain http
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return client
+}
+
+// dialContext connects to the webhook host, unless it resolves to
+// a loopback, link-local or private address and isn't one of
+// Config.PrivateHosts. The address checked is the one dialed, so that the
+// check can't be bypassed by resolving the host again to another address
+// (DNS rebinding).
+func (c *Client) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, errors.Errorf("no addresses of %s", host)
+	}
+
+	if !utils.ContainsString(host, c.conf.PrivateHosts) {
+		for _, ip := range ips {
+			if !utils.IsPublicIP(ip.IP) {
+				log.FromContext(ctx).Warnf(
+					"admission webhook host %s resolves to non-public address %s",
+					host, ip.IP)
+				return nil, ErrAddressForbidden
+			}
+		}
+	}
+
+	var dialer net.Dialer
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, network,
+			net.JoinHostPort(ip.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
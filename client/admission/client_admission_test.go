@@ -0,0 +1,311 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package admission
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deviceauth/model"
+	mtesting "github.com/mendersoftware/deviceauth/utils/testing"
+)
+
+const secret = "0123456789abcdef"
+
+type stubRsp struct {
+	status int
+	body   string
+	secret string
+	delay  time.Duration
+}
+
+// newStubServer returns a webhook stub replying with the consecutive
+// responses, repeating the last one.
+func newStubServer(t *testing.T, rsps ...stubRsp) (*httptest.Server, *int32) {
+	calls := new(int32)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		n := int(atomic.AddInt32(calls, 1)) - 1
+		if n >= len(rsps) {
+			n = len(rsps) - 1
+		}
+		rsp := rsps[n]
+
+		var req Req
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(body, &req))
+		assert.Equal(t, "aset-1", req.AuthSetId)
+
+		if rsp.delay > 0 {
+			select {
+			case <-time.After(rsp.delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if rsp.secret != "" {
+			w.Header().Set(HdrSignature,
+				string(mtesting.AuthReqHMAC([]byte(rsp.body), []byte(rsp.secret))))
+		}
+		w.WriteHeader(rsp.status)
+		w.Write([]byte(rsp.body))
+	}))
+
+	return srv, calls
+}
+
+// newTestClient returns a client trusting the certificate of the stub
+// server.
+func newTestClient(srv *httptest.Server, conf Config) *Client {
+	c := NewClient(conf)
+	c.client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{
+		RootCAs: srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs,
+	}
+	return c
+}
+
+func TestGetClient(t *testing.T) {
+	t.Parallel()
+
+	c := NewClient(Config{})
+	assert.NotNil(t, c)
+	assert.Equal(t, defaultReqTimeout, c.conf.Timeout)
+	assert.Equal(t, defaultMaxDuration, c.conf.MaxDuration)
+}
+
+func TestCheckAdmission(t *testing.T) {
+	t.Parallel()
+
+	accept := `{"auth_set_id":"aset-1","action":"accept"}`
+
+	testCases := map[string]struct {
+		rsps []stubRsp
+
+		rsp   *Rsp
+		calls int32
+		err   string
+	}{
+		"ok": {
+			rsps: []stubRsp{
+				{status: http.StatusOK, body: accept, secret: secret},
+			},
+			rsp:   &Rsp{AuthSetId: "aset-1", Action: model.AdmissionActionAccept},
+			calls: 1,
+		},
+		"ok, retried": {
+			rsps: []stubRsp{
+				{status: http.StatusServiceUnavailable},
+				{status: http.StatusTooManyRequests},
+				{status: http.StatusOK, body: accept, secret: secret},
+			},
+			rsp:   &Rsp{AuthSetId: "aset-1", Action: model.AdmissionActionAccept},
+			calls: 3,
+		},
+		"ok, retried after timeout": {
+			rsps: []stubRsp{
+				{status: http.StatusOK, body: accept, secret: secret, delay: time.Second},
+				{status: http.StatusOK, body: accept, secret: secret},
+			},
+			rsp:   &Rsp{AuthSetId: "aset-1", Action: model.AdmissionActionAccept},
+			calls: 2,
+		},
+		"error, retries exceeded": {
+			rsps: []stubRsp{
+				{status: http.StatusInternalServerError},
+			},
+			calls: 3,
+			err:   "admission webhook request failed with status 500 Internal Server Error",
+		},
+		"error, not retried": {
+			rsps: []stubRsp{
+				{status: http.StatusBadRequest},
+			},
+			calls: 1,
+			err:   "admission webhook request failed with status 400 Bad Request",
+		},
+		"error, bad signature": {
+			rsps: []stubRsp{
+				{status: http.StatusOK, body: accept, secret: "fedcba9876543210"},
+			},
+			calls: 1,
+			err:   ErrInvalidSignature.Error(),
+		},
+		"error, not signed": {
+			rsps: []stubRsp{
+				{status: http.StatusOK, body: accept},
+			},
+			calls: 1,
+			err:   ErrInvalidSignature.Error(),
+		},
+		"error, other auth set": {
+			rsps: []stubRsp{
+				{
+					status: http.StatusOK,
+					body:   `{"auth_set_id":"aset-2","action":"accept"}`,
+					secret: secret,
+				},
+			},
+			calls: 1,
+			err:   "admission webhook response for auth set aset-2, expected aset-1",
+		},
+		"error, bad action": {
+			rsps: []stubRsp{
+				{
+					status: http.StatusOK,
+					body:   `{"auth_set_id":"aset-1","action":"decommission"}`,
+					secret: secret,
+				},
+			},
+			calls: 1,
+			err:   "invalid admission webhook action: decommission",
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			srv, calls := newStubServer(t, tc.rsps...)
+			defer srv.Close()
+
+			c := newTestClient(srv, Config{
+				Timeout:       100 * time.Millisecond,
+				Retries:       2,
+				RetryInterval: 10 * time.Millisecond,
+				PrivateHosts:  []string{"127.0.0.1"},
+			})
+
+			rsp, err := c.CheckAdmission(context.Background(),
+				model.AdmissionWebhook{URL: srv.URL, Secret: secret},
+				Req{
+					DeviceId:  "dev-1",
+					AuthSetId: "aset-1",
+					IdData:    map[string]interface{}{"sn": "0001"},
+				})
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				assert.Nil(t, rsp)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.rsp, rsp)
+			}
+			assert.Equal(t, tc.calls, atomic.LoadInt32(calls))
+		})
+	}
+}
+
+func TestCheckAdmissionMaxDuration(t *testing.T) {
+	t.Parallel()
+
+	srv, _ := newStubServer(t, stubRsp{
+		status: http.StatusOK,
+		delay:  5 * time.Second,
+	})
+	defer srv.Close()
+
+	c := newTestClient(srv, Config{
+		Timeout:      time.Second,
+		Retries:      10,
+		MaxDuration:  300 * time.Millisecond,
+		PrivateHosts: []string{"127.0.0.1"},
+	})
+
+	start := time.Now()
+	_, err := c.CheckAdmission(context.Background(),
+		model.AdmissionWebhook{URL: srv.URL, Secret: secret},
+		Req{AuthSetId: "aset-1"})
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestCheckAdmissionAddress(t *testing.T) {
+	t.Parallel()
+
+	accept := `{"auth_set_id":"aset-1","action":"accept"}`
+
+	testCases := map[string]struct {
+		url          string
+		privateHosts []string
+
+		calls int32
+		err   error
+	}{
+		"ok, private host allowed": {
+			privateHosts: []string{"127.0.0.1"},
+			calls:        1,
+		},
+		"error, private address": {
+			err: ErrAddressForbidden,
+		},
+		"error, private host name": {
+			url:          "https://localhost:%s",
+			privateHosts: []string{"127.0.0.1"},
+			err:          ErrAddressForbidden,
+		},
+		"error, plain http": {
+			url:          "http://127.0.0.1:%s",
+			privateHosts: []string{"127.0.0.1"},
+			err:          ErrNotHTTPS,
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			srv, calls := newStubServer(t, stubRsp{
+				status: http.StatusOK, body: accept, secret: secret,
+			})
+			defer srv.Close()
+
+			url := srv.URL
+			if tc.url != "" {
+				u, err := neturl.Parse(srv.URL)
+				assert.NoError(t, err)
+				url = fmt.Sprintf(tc.url, u.Port())
+			}
+
+			c := newTestClient(srv, Config{
+				Retries:      2,
+				PrivateHosts: tc.privateHosts,
+			})
+
+			_, err := c.CheckAdmission(context.Background(),
+				model.AdmissionWebhook{URL: url, Secret: secret},
+				Req{AuthSetId: "aset-1"})
+			if tc.err != nil {
+				assert.Equal(t, tc.err, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.calls, atomic.LoadInt32(calls))
+		})
+	}
+}
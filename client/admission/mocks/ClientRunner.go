@@ -0,0 +1,47 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package mocks
+
+import admission "github.com/mendersoftware/deviceauth/client/admission"
+import context "context"
+import mock "github.com/stretchr/testify/mock"
+import model "github.com/mendersoftware/deviceauth/model"
+
+// ClientRunner is an autogenerated mock type for the ClientRunner type
+type ClientRunner struct {
+	mock.Mock
+}
+
+// CheckAdmission provides a mock function with given fields: ctx, hook, req
+func (_m *ClientRunner) CheckAdmission(ctx context.Context, hook model.AdmissionWebhook, req admission.Req) (*admission.Rsp, error) {
+	ret := _m.Called(ctx, hook, req)
+
+	var r0 *admission.Rsp
+	if rf, ok := ret.Get(0).(func(context.Context, model.AdmissionWebhook, admission.Req) *admission.Rsp); ok {
+		r0 = rf(ctx, hook, req)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*admission.Rsp)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.AdmissionWebhook, admission.Req) error); ok {
+		r1 = rf(ctx, hook, req)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
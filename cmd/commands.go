@@ -60,7 +60,14 @@ func Migrate(c config.Reader, tenant string, listTenantsFlag bool) error {
 		return listTenants(db)
 	}
 
-	db = db.WithAutomigrate().(*mongo.DataStoreMongo)
+	idDataNormalizers, err := model.ParseIdDataNormalizers(
+		c.GetStringSlice(dconfig.SettingIdDataNormalizers))
+	if err != nil {
+		return errors.Wrap(err, "invalid identity normalizers")
+	}
+
+	db = db.WithAutomigrate().(*mongo.DataStoreMongo).
+		WithIdDataNormalizers(idDataNormalizers)
 
 	tenantCtx := identity.WithContext(context.Background(), &identity.Identity{
 		Tenant: tenant,
@@ -92,21 +99,62 @@ func listTenants(db *mongo.DataStoreMongo) error {
 	return nil
 }
 
-func Maintenance(decommissioningCleanupFlag bool, tenant string, dryRunFlag bool) error {
+func Maintenance(decommissioningCleanupFlag, rehashIdDataFlag bool, tenant string, dryRunFlag bool) error {
 	db, err := mongo.NewDataStoreMongo(makeDataStoreConfig())
 	if err != nil {
 		return errors.Wrap(err, "failed to connect to db")
 	}
 
-	return maintenanceWithDataStore(decommissioningCleanupFlag, tenant, dryRunFlag, db)
+	idDataNormalizers, err := model.ParseIdDataNormalizers(
+		config.Config.GetStringSlice(dconfig.SettingIdDataNormalizers))
+	if err != nil {
+		return errors.Wrap(err, "invalid identity normalizers")
+	}
+	db = db.WithIdDataNormalizers(idDataNormalizers)
+
+	return maintenanceWithDataStore(decommissioningCleanupFlag, rehashIdDataFlag, tenant, dryRunFlag, db)
 }
 
-func maintenanceWithDataStore(decommissioningCleanupFlag bool, tenant string, dryRunFlag bool, db *mongo.DataStoreMongo) error {
+func maintenanceWithDataStore(decommissioningCleanupFlag, rehashIdDataFlag bool, tenant string, dryRunFlag bool, db *mongo.DataStoreMongo) error {
 	// cleanup devauth database from leftovers after failed decommissioning
 	if decommissioningCleanupFlag {
 		return decommissioningCleanup(db, tenant, dryRunFlag)
 	}
 
+	// recompute the identity data hashes, after changing the normalizers
+	if rehashIdDataFlag {
+		return rehashIdData(db, tenant, dryRunFlag)
+	}
+
+	return nil
+}
+
+func rehashIdData(db *mongo.DataStoreMongo, tenant string, dryRunFlag bool) error {
+	dbs := []string{mstore.DbNameForTenant(tenant, mongo.DbName)}
+	if tenant == "" {
+		tdbs, err := db.GetTenantDbs()
+		if err != nil {
+			return errors.Wrap(err, "failed to retrieve tenant DBs")
+		}
+		dbs = append(tdbs, mongo.DbName)
+	}
+
+	for _, dbName := range dbs {
+		println("database: ", dbName)
+
+		collisions, err := db.RehashIdData(context.Background(), dbName, dryRunFlag)
+		if err != nil {
+			return err
+		}
+
+		if len(collisions) > 0 {
+			fmt.Println("devices to be merged (device, device with the same identity data, identity data):")
+			for _, c := range collisions {
+				fmt.Println(c.DeviceId, c.OtherDeviceId, c.IdData)
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -43,7 +43,7 @@ func TestMaintenance(t *testing.T) {
 	config.Config.SetEnvPrefix("DEVICEAUTH")
 	config.Config.AutomaticEnv()
 
-	err := Maintenance(true, "", false)
+	err := Maintenance(true, false, "", false)
 	assert.NoError(t, err)
 }
 
@@ -168,7 +168,7 @@ func TestMaintenanceWithDataStore(t *testing.T) {
 			assert.NoError(t, err)
 		}
 
-		err := maintenanceWithDataStore(tc.decommissioningCleanupFlag, tc.tenant, tc.dryRunFlag, ds)
+		err := maintenanceWithDataStore(tc.decommissioningCleanupFlag, false, tc.tenant, tc.dryRunFlag, ds)
 		assert.NoError(t, err)
 
 		session.Close()
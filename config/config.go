@@ -21,6 +21,14 @@ const (
 	SettingListen        = "listen"
 	SettingListenDefault = ":8080"
 
+	// mutual TLS listener for certificate based device authentication;
+	// disabled if empty
+	SettingListenTLS        = "listen_tls"
+	SettingListenTLSDefault = ""
+
+	SettingServerTLSCertPath = "server_tls_cert_path"
+	SettingServerTLSKeyPath  = "server_tls_key_path"
+
 	SettingMiddleware        = "middleware"
 	SettingMiddlewareDefault = "prod"
 
@@ -51,21 +59,132 @@ const (
 	SettingServerPrivKeyPath        = "server_priv_key_path"
 	SettingServerPrivKeyPathDefault = "/etc/deviceauth/rsa/private.pem"
 
+	// JWT signing algorithm; picked based on the private key type if empty
+	SettingServerPrivKeyAlg        = "server_priv_key_alg"
+	SettingServerPrivKeyAlgDefault = ""
+
+	// additional keys accepted for JWT verification, but not used for
+	// signing (staged or retired keys during key rotation), as path[:alg]
+	SettingServerVerifyKeyPaths = "server_verify_key_paths"
+
+	// key encrypting the tenants' own signing keys; tenant keys are
+	// disabled if empty
+	SettingTenantKeysEncryptionKeyPath        = "tenant_keys_encryption_key_path"
+	SettingTenantKeysEncryptionKeyPathDefault = ""
+
+	// key encrypting the devices' pre-shared keys; pre-shared key
+	// credentials are disabled if empty
+	SettingPSKEncryptionKeyPath        = "psk_encryption_key_path"
+	SettingPSKEncryptionKeyPathDefault = ""
+
 	SettingJWTIssuer        = "jwt_issuer"
 	SettingJWTIssuerDefault = "Mender"
 
+	// JWT audience; audience checks are disabled if empty
+	SettingJWTAudience        = "jwt_audience"
+	SettingJWTAudienceDefault = ""
+
 	SettingJWTExpirationTimeout        = "jwt_exp_timeout"
 	SettingJWTExpirationTimeoutDefault = "604800" //one week
 
+	// hand out an existing token if valid for at least that many seconds,
+	// instead of issuing a new one; disabled if 0
+	SettingJWTReuseMinLifetime        = "jwt_reuse_min_lifetime"
+	SettingJWTReuseMinLifetimeDefault = "0"
+
+	SettingMaxDeviceTokens        = "max_device_tokens"
+	SettingMaxDeviceTokensDefault = "0" // no limit
+
+	// expired tokens can still be renewed for that many seconds
+	SettingJWTRenewalGracePeriod        = "jwt_renewal_grace_period"
+	SettingJWTRenewalGracePeriodDefault = "86400" // one day
+
+	// max difference between the auth request timestamp and the server
+	// time; replay protection is disabled if 0
+	SettingAuthReqMaxClockSkew        = "auth_req_max_clock_skew"
+	SettingAuthReqMaxClockSkewDefault = "300" // seconds
+
+	// validity of the challenges of the challenge-response auth flow
+	SettingAuthChallengeTTL        = "auth_challenge_ttl"
+	SettingAuthChallengeTTLDefault = "60" // seconds
+
+	// lockout of identities and IP addresses after failed signature
+	// verifications; disabled if the threshold is 0
+	SettingAuthLockoutThreshold        = "auth_lockout_threshold"
+	SettingAuthLockoutThresholdDefault = "10"
+
+	SettingAuthLockoutWindow        = "auth_lockout_window"
+	SettingAuthLockoutWindowDefault = "600" // seconds
+
+	SettingAuthLockoutDuration        = "auth_lockout_duration"
+	SettingAuthLockoutDurationDefault = "900" // seconds
+
 	SettingMaxDevicesLimitDefault        = "max_devices_limit_default"
 	SettingMaxDevicesLimitDefaultDefault = "0" // no limit
 
+	// cache of token verification results; disabled if the size is 0
+	SettingVerifyCacheSize        = "verify_cache_size"
+	SettingVerifyCacheSizeDefault = "0"
+
+	SettingVerifyCacheTTL        = "verify_cache_ttl"
+	SettingVerifyCacheTTLDefault = "60" // seconds
+
+	// request rate limits of the device, management and internal APIs,
+	// in requests per second; disabled if 0
+	SettingRateLimitDevicesRate        = "rate_limit_devices_rate"
+	SettingRateLimitDevicesRateDefault = "0"
+
+	SettingRateLimitDevicesBurst        = "rate_limit_devices_burst"
+	SettingRateLimitDevicesBurstDefault = "10"
+
+	SettingRateLimitManagementRate        = "rate_limit_management_rate"
+	SettingRateLimitManagementRateDefault = "0"
+
+	SettingRateLimitManagementBurst        = "rate_limit_management_burst"
+	SettingRateLimitManagementBurstDefault = "50"
+
+	SettingRateLimitInternalRate        = "rate_limit_internal_rate"
+	SettingRateLimitInternalRateDefault = "0"
+
+	SettingRateLimitInternalBurst        = "rate_limit_internal_burst"
+	SettingRateLimitInternalBurstDefault = "100"
+
+	// keep the rate limits in the database, shared by the instances
+	SettingRateLimitShared        = "rate_limit_shared"
+	SettingRateLimitSharedDefault = false
+
+	// admission webhooks of the tenants
+	SettingAdmissionWebhooks        = "admission_webhooks"
+	SettingAdmissionWebhooksDefault = false
+
+	SettingAdmissionWebhookTimeout        = "admission_webhook_timeout"
+	SettingAdmissionWebhookTimeoutDefault = "2" // seconds
+
+	SettingAdmissionWebhookRetries        = "admission_webhook_retries"
+	SettingAdmissionWebhookRetriesDefault = "2"
+
+	// bound of all the attempts
+	SettingAdmissionWebhookMaxDuration        = "admission_webhook_max_duration"
+	SettingAdmissionWebhookMaxDurationDefault = "5" // seconds
+
+	// webhook hosts allowed at loopback, link-local or private addresses
+	SettingAdmissionWebhookPrivateHosts = "admission_webhook_private_hosts"
+
+	// normalizers of the identity attributes, applied before hashing:
+	// attribute:normalizer[,normalizer...]
+	SettingIdDataNormalizers = "identity_normalizers"
+
+	// how often the pending and rejected auth sets past the tenants'
+	// TTL are purged; disabled if 0
+	SettingAuthSetPurgeInterval        = "auth_set_purge_interval"
+	SettingAuthSetPurgeIntervalDefault = "3600" // seconds
 )
 
 var (
 	Validators = []config.Validator{}
 	Defaults   = []config.Default{
 		{Key: SettingListen, Value: SettingListenDefault},
+		{Key: SettingListenTLS, Value: SettingListenTLSDefault},
 		{Key: SettingMiddleware, Value: SettingMiddlewareDefault},
 		{Key: SettingDb, Value: SettingDbDefault},
 		{Key: SettingDevAdmAddr, Value: SettingDevAdmAddrDefault},
@@ -73,10 +192,36 @@ var (
 		{Key: SettingOrchestratorAddr, Value: SettingOrchestratorAddrDefault},
 		{Key: SettingTenantAdmAddr, Value: SettingTenantAdmAddrDefault},
 		{Key: SettingServerPrivKeyPath, Value: SettingServerPrivKeyPathDefault},
+		{Key: SettingServerPrivKeyAlg, Value: SettingServerPrivKeyAlgDefault},
+		{Key: SettingTenantKeysEncryptionKeyPath, Value: SettingTenantKeysEncryptionKeyPathDefault},
+		{Key: SettingPSKEncryptionKeyPath, Value: SettingPSKEncryptionKeyPathDefault},
 		{Key: SettingJWTIssuer, Value: SettingJWTIssuerDefault},
+		{Key: SettingJWTAudience, Value: SettingJWTAudienceDefault},
 		{Key: SettingJWTExpirationTimeout, Value: SettingJWTExpirationTimeoutDefault},
+		{Key: SettingJWTReuseMinLifetime, Value: SettingJWTReuseMinLifetimeDefault},
+		{Key: SettingMaxDeviceTokens, Value: SettingMaxDeviceTokensDefault},
+		{Key: SettingJWTRenewalGracePeriod, Value: SettingJWTRenewalGracePeriodDefault},
+		{Key: SettingAuthReqMaxClockSkew, Value: SettingAuthReqMaxClockSkewDefault},
+		{Key: SettingAuthChallengeTTL, Value: SettingAuthChallengeTTLDefault},
+		{Key: SettingAuthLockoutThreshold, Value: SettingAuthLockoutThresholdDefault},
+		{Key: SettingAuthLockoutWindow, Value: SettingAuthLockoutWindowDefault},
+		{Key: SettingAuthLockoutDuration, Value: SettingAuthLockoutDurationDefault},
 		{Key: SettingDbSSL, Value: SettingDbSSLDefault},
 		{Key: SettingDbSSLSkipVerify, Value: SettingDbSSLSkipVerifyDefault},
 		{Key: SettingMaxDevicesLimitDefault, Value: SettingMaxDevicesLimitDefaultDefault},
+		{Key: SettingVerifyCacheSize, Value: SettingVerifyCacheSizeDefault},
+		{Key: SettingVerifyCacheTTL, Value: SettingVerifyCacheTTLDefault},
+		{Key: SettingRateLimitDevicesRate, Value: SettingRateLimitDevicesRateDefault},
+		{Key: SettingRateLimitDevicesBurst, Value: SettingRateLimitDevicesBurstDefault},
+		{Key: SettingRateLimitManagementRate, Value: SettingRateLimitManagementRateDefault},
+		{Key: SettingRateLimitManagementBurst, Value: SettingRateLimitManagementBurstDefault},
+		{Key: SettingRateLimitInternalRate, Value: SettingRateLimitInternalRateDefault},
+		{Key: SettingRateLimitInternalBurst, Value: SettingRateLimitInternalBurstDefault},
+		{Key: SettingRateLimitShared, Value: SettingRateLimitSharedDefault},
+		{Key: SettingAdmissionWebhooks, Value: SettingAdmissionWebhooksDefault},
+		{Key: SettingAdmissionWebhookTimeout, Value: SettingAdmissionWebhookTimeoutDefault},
+		{Key: SettingAdmissionWebhookRetries, Value: SettingAdmissionWebhookRetriesDefault},
+		{Key: SettingAdmissionWebhookMaxDuration, Value: SettingAdmissionWebhookMaxDurationDefault},
+		{Key: SettingAuthSetPurgeInterval, Value: SettingAuthSetPurgeIntervalDefault},
 	}
 )
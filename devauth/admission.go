@@ -0,0 +1,252 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devauth
+
+import (
+	"context"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceauth/client/admission"
+	"github.com/mendersoftware/deviceauth/model"
+	"github.com/mendersoftware/deviceauth/store"
+	"github.com/mendersoftware/deviceauth/utils"
+)
+
+func (d *DevAuth) AddAdmissionRule(ctx context.Context, rule *model.AdmissionRule) error {
+	err := d.db.AddAdmissionRule(ctx, *rule)
+	switch err {
+	case nil:
+		return nil
+	case store.ErrObjectExists:
+		return ErrAdmissionRuleExists
+	default:
+		return errors.Wrap(err, "failed to add admission rule")
+	}
+}
+
+func (d *DevAuth) GetAdmissionRules(ctx context.Context) ([]model.AdmissionRule, error) {
+	rules, err := d.db.GetAdmissionRules(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list admission rules")
+	}
+	return rules, nil
+}
+
+func (d *DevAuth) GetAdmissionRule(ctx context.Context, id string) (*model.AdmissionRule, error) {
+	rule, err := d.db.GetAdmissionRule(ctx, id)
+	switch err {
+	case nil:
+		return rule, nil
+	case store.ErrAdmissionRuleNotFound:
+		return nil, ErrAdmissionRuleNotFound
+	default:
+		return nil, errors.Wrap(err, "failed to get admission rule")
+	}
+}
+
+func (d *DevAuth) UpdateAdmissionRule(ctx context.Context, rule *model.AdmissionRule) error {
+	err := d.db.UpdateAdmissionRule(ctx, *rule)
+	switch err {
+	case nil:
+		return nil
+	case store.ErrAdmissionRuleNotFound:
+		return ErrAdmissionRuleNotFound
+	default:
+		return errors.Wrap(err, "failed to update admission rule")
+	}
+}
+
+func (d *DevAuth) DeleteAdmissionRule(ctx context.Context, id string) error {
+	err := d.db.DeleteAdmissionRule(ctx, id)
+	switch err {
+	case nil:
+		return nil
+	case store.ErrAdmissionRuleNotFound:
+		return ErrAdmissionRuleNotFound
+	default:
+		return errors.Wrap(err, "failed to delete admission rule")
+	}
+}
+
+// MatchAdmissionRule returns the admission rule applying to the identity
+// data and the key type; nil if no rule matches.
+func (d *DevAuth) MatchAdmissionRule(ctx context.Context, idData map[string]interface{}, keyType string) (*model.AdmissionRule, error) {
+	rules, err := d.db.GetAdmissionRules(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list admission rules")
+	}
+
+	for i := range rules {
+		if rules[i].Matches(idData, keyType) {
+			return &rules[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// applyAdmissionRules accepts or rejects the pending auth set, as per the
+// matching admission rule.
+func (d *DevAuth) applyAdmissionRules(ctx context.Context, idData map[string]interface{}, r *model.AuthReq, aset *model.AuthSet) error {
+	rule, err := d.MatchAdmissionRule(ctx, idData, utils.PubKeyType(r.PubKeyStruct))
+	if err != nil || rule == nil {
+		return err
+	}
+
+	log.FromContext(ctx).Infof("admission rule %s (%s) matched device %s auth set %s: %s",
+		rule.Id, rule.Name, aset.DeviceId, aset.Id, rule.Action)
+
+	return d.admitAuthSet(ctx, aset, rule.Action)
+}
+
+// admitAuthSet applies the admission action to the pending auth set.
+// Accepting is subject to the device limit; the auth set is left pending if
+// the limit is reached. New keys of accepted or preauthorized devices are
+// left pending too: rules and webhooks only see the identity data and the
+// key type, which don't prove the key is the device's.
+func (d *DevAuth) admitAuthSet(ctx context.Context, aset *model.AuthSet, action string) error {
+	switch action {
+	case model.AdmissionActionAccept:
+		dev, err := d.db.GetDeviceById(ctx, aset.DeviceId)
+		if err != nil {
+			return errors.Wrap(err, "failed to fetch device")
+		}
+		if dev.Status == model.DevStatusAccepted ||
+			dev.Status == model.DevStatusPreauth {
+			log.FromContext(ctx).Warnf("device %s is %s, new auth set %s left pending",
+				aset.DeviceId, dev.Status, aset.Id)
+			return nil
+		}
+
+		allow, err := d.canAcceptDevice(ctx)
+		if err != nil {
+			return err
+		}
+		if !allow {
+			log.FromContext(ctx).Warnf("device %s left pending: %v",
+				aset.DeviceId, ErrMaxDeviceCountReached)
+			return nil
+		}
+
+		if err := d.rejectAcceptedAuthSets(ctx, aset.DeviceId); err != nil {
+			return err
+		}
+
+		return d.autoAcceptAuthSet(ctx, aset)
+
+	case model.AdmissionActionReject:
+		if err := d.db.UpdateAuthSetById(ctx, aset.Id, model.AuthSetUpdate{
+			Status: model.DevStatusRejected,
+		}); err != nil {
+			return errors.Wrap(err, "failed to update auth set status")
+		}
+
+		if err := d.updateDeviceStatus(ctx, aset.DeviceId, ""); err != nil {
+			return err
+		}
+
+		aset.Status = model.DevStatusRejected
+	}
+
+	return nil
+}
+
+// WithAdmissionWebhooks enables the admission webhooks of the tenants.
+func (d *DevAuth) WithAdmissionWebhooks(c admission.ClientRunner) *DevAuth {
+	d.cAdmission = c
+	return d
+}
+
+func (d *DevAuth) GetAdmissionWebhook(ctx context.Context) (*model.AdmissionWebhook, error) {
+	hook, err := d.db.GetAdmissionWebhook(ctx)
+	switch err {
+	case nil:
+		return hook, nil
+	case store.ErrAdmissionWebhookNotFound:
+		return nil, ErrWebhookNotFound
+	default:
+		return nil, errors.Wrap(err, "failed to get admission webhook")
+	}
+}
+
+func (d *DevAuth) SetAdmissionWebhook(ctx context.Context, hook model.AdmissionWebhook) error {
+	if err := d.db.PutAdmissionWebhook(ctx, hook); err != nil {
+		return errors.Wrap(err, "failed to set admission webhook")
+	}
+	return nil
+}
+
+func (d *DevAuth) DeleteAdmissionWebhook(ctx context.Context) error {
+	err := d.db.DeleteAdmissionWebhook(ctx)
+	switch err {
+	case nil:
+		return nil
+	case store.ErrAdmissionWebhookNotFound:
+		return ErrWebhookNotFound
+	default:
+		return errors.Wrap(err, "failed to delete admission webhook")
+	}
+}
+
+// applyAdmissionWebhook asks the (tenant's) admission webhook, if any, about
+// the pending auth set, and accepts or rejects it as per the response. The
+// auth set is left pending if the webhook fails; the client bounds the time
+// spent on it.
+func (d *DevAuth) applyAdmissionWebhook(ctx context.Context, idData map[string]interface{}, r *model.AuthReq, aset *model.AuthSet) error {
+	if d.cAdmission == nil {
+		return nil
+	}
+
+	l := log.FromContext(ctx)
+
+	hook, err := d.db.GetAdmissionWebhook(ctx)
+	switch err {
+	case nil:
+	case store.ErrAdmissionWebhookNotFound:
+		return nil
+	default:
+		return errors.Wrap(err, "failed to get admission webhook")
+	}
+
+	req := admission.Req{
+		DeviceId:  aset.DeviceId,
+		AuthSetId: aset.Id,
+		IdData:    idData,
+		KeyType:   utils.PubKeyType(r.PubKeyStruct),
+	}
+	if id := identity.FromContext(ctx); id != nil {
+		req.TenantId = id.Tenant
+	}
+	if r.PubKeyStruct != nil {
+		req.KeyFingerprint, err = utils.PubKeyFingerprint(r.PubKeyStruct)
+		if err != nil {
+			return errors.Wrap(err, "failed to compute key fingerprint")
+		}
+	}
+
+	rsp, err := d.cAdmission.CheckAdmission(ctx, *hook, req)
+	if err != nil {
+		l.Warnf("admission webhook failed, device %s left pending: %v",
+			aset.DeviceId, err)
+		return nil
+	}
+
+	l.Infof("admission webhook decided on device %s auth set %s: %s",
+		aset.DeviceId, aset.Id, rsp.Action)
+
+	return d.admitAuthSet(ctx, aset, rsp.Action)
+}
@@ -0,0 +1,584 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devauth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceauth/client/admission"
+	madmission "github.com/mendersoftware/deviceauth/client/admission/mocks"
+	"github.com/mendersoftware/deviceauth/client/orchestrator"
+	morchestrator "github.com/mendersoftware/deviceauth/client/orchestrator/mocks"
+	"github.com/mendersoftware/deviceauth/model"
+	"github.com/mendersoftware/deviceauth/store"
+	mstore "github.com/mendersoftware/deviceauth/store/mocks"
+	"github.com/mendersoftware/deviceauth/utils"
+	mtesting "github.com/mendersoftware/deviceauth/utils/testing"
+)
+
+func TestDevAuthMatchAdmissionRule(t *testing.T) {
+	t.Parallel()
+
+	rules := []model.AdmissionRule{
+		{
+			Id: "factory-network",
+			Conditions: []model.AdmissionCondition{
+				{Attribute: "ip", Match: model.AdmissionMatchCIDR, Value: "10.1.0.0/16"},
+				{Attribute: "sku", Match: model.AdmissionMatchPrefix, Value: "acme-"},
+			},
+			Action: model.AdmissionActionAccept,
+		},
+		{
+			Id: "test-devices",
+			Conditions: []model.AdmissionCondition{
+				{Attribute: "sn", Match: model.AdmissionMatchRegex, Value: "^TEST-[0-9]+$"},
+			},
+			Action: model.AdmissionActionReject,
+		},
+		{
+			Id: "known-mac",
+			Conditions: []model.AdmissionCondition{
+				{Attribute: "mac", Match: model.AdmissionMatchExact, Value: "00:01:02:03:04:05"},
+			},
+			KeyTypes: []string{utils.KeyTypeEd25519},
+			Action:   model.AdmissionActionAccept,
+		},
+		{
+			Id:       "rsa",
+			KeyTypes: []string{utils.KeyTypeRSA},
+			Action:   model.AdmissionActionPending,
+		},
+	}
+
+	testCases := map[string]struct {
+		idData  map[string]interface{}
+		keyType string
+		dbErr   error
+
+		rule string
+		err  error
+	}{
+		"all conditions match": {
+			idData: map[string]interface{}{
+				"ip":  "10.1.2.3",
+				"sku": "acme-sensor",
+			},
+			keyType: utils.KeyTypeECDSA,
+			rule:    "factory-network",
+		},
+		"not all conditions match": {
+			idData: map[string]interface{}{
+				"ip":  "10.2.2.3",
+				"sku": "acme-sensor",
+			},
+			keyType: utils.KeyTypeECDSA,
+		},
+		"first rule matching": {
+			idData: map[string]interface{}{
+				"ip":  "10.1.2.3",
+				"sku": "acme-sensor",
+				"sn":  "TEST-0001",
+			},
+			keyType: utils.KeyTypeRSA,
+			rule:    "factory-network",
+		},
+		"regex": {
+			idData: map[string]interface{}{
+				"sn": "TEST-0001",
+			},
+			keyType: utils.KeyTypeECDSA,
+			rule:    "test-devices",
+		},
+		"regex, no match": {
+			idData: map[string]interface{}{
+				"sn": "TEST-0001-X",
+			},
+			keyType: utils.KeyTypeECDSA,
+		},
+		"any of the values": {
+			idData: map[string]interface{}{
+				"mac": []interface{}{"00:01:02:03:04:06", "00:01:02:03:04:05"},
+			},
+			keyType: utils.KeyTypeEd25519,
+			rule:    "known-mac",
+		},
+		"key type mismatch": {
+			idData: map[string]interface{}{
+				"mac": "00:01:02:03:04:05",
+			},
+			keyType: utils.KeyTypeECDSA,
+		},
+		"key type only": {
+			idData: map[string]interface{}{
+				"mac": "00:01:02:03:04:05",
+			},
+			keyType: utils.KeyTypeRSA,
+			rule:    "rsa",
+		},
+		"cidr, not an ip": {
+			idData: map[string]interface{}{
+				"ip":  "foo",
+				"sku": "acme-sensor",
+			},
+			keyType: utils.KeyTypeECDSA,
+		},
+		"error": {
+			dbErr: errors.New("db error"),
+			err:   errors.New("failed to list admission rules: db error"),
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := mstore.DataStore{}
+			db.On("GetAdmissionRules", ctx).Return(rules, tc.dbErr)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+
+			rule, err := devauth.MatchAdmissionRule(ctx, tc.idData, tc.keyType)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else if tc.rule != "" {
+				assert.NoError(t, err)
+				if assert.NotNil(t, rule) {
+					assert.Equal(t, tc.rule, rule.Id)
+				}
+			} else {
+				assert.NoError(t, err)
+				assert.Nil(t, rule)
+			}
+		})
+	}
+}
+
+func TestDevAuthApplyAdmissionRules(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		action    string
+		limit     uint64
+		devStatus string
+
+		status string
+	}{
+		"accept": {
+			action: model.AdmissionActionAccept,
+			status: model.DevStatusAccepted,
+		},
+		"accept, new key of accepted device": {
+			action:    model.AdmissionActionAccept,
+			devStatus: model.DevStatusAccepted,
+			status:    model.DevStatusPending,
+		},
+		"accept, new key of preauthorized device": {
+			action:    model.AdmissionActionAccept,
+			devStatus: model.DevStatusPreauth,
+			status:    model.DevStatusPending,
+		},
+		"accept, device limit reached": {
+			action: model.AdmissionActionAccept,
+			limit:  5,
+			status: model.DevStatusPending,
+		},
+		"reject": {
+			action: model.AdmissionActionReject,
+			status: model.DevStatusRejected,
+		},
+		"pending": {
+			action: model.AdmissionActionPending,
+			status: model.DevStatusPending,
+		},
+		"no rule matching": {
+			status: model.DevStatusPending,
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			aset := &model.AuthSet{
+				Id:       "aset-1",
+				DeviceId: "dev-1",
+				Status:   model.DevStatusPending,
+			}
+
+			rules := []model.AdmissionRule{}
+			if tc.action != "" {
+				rules = append(rules, model.AdmissionRule{
+					Id: "rule-1",
+					Conditions: []model.AdmissionCondition{
+						{Attribute: "sn", Match: model.AdmissionMatchExact, Value: "0001"},
+					},
+					Action: tc.action,
+				})
+			}
+
+			db := mstore.DataStore{}
+			db.On("GetAdmissionRules", ctx).Return(rules, nil)
+			db.On("GetLimit", ctx, model.LimitMaxDeviceCount).
+				Return(&model.Limit{Value: tc.limit}, nil)
+			db.On("GetDevCountByStatus", ctx, model.DevStatusAccepted).
+				Return(5, nil)
+			db.On("UpdateAuthSet", ctx,
+				mock.AnythingOfType("bson.M"),
+				model.AuthSetUpdate{Status: model.DevStatusRejected}).
+				Return(store.ErrAuthSetNotFound)
+			devStatus := model.DevStatusPending
+			if tc.devStatus != "" {
+				devStatus = tc.devStatus
+			}
+			db.On("GetDeviceById", ctx, "dev-1").
+				Return(&model.Device{Id: "dev-1", Status: devStatus}, nil)
+			db.On("UpdateAuthSetById", ctx, "aset-1",
+				model.AuthSetUpdate{Status: tc.status}).
+				Return(nil)
+			db.On("GetDeviceStatus", ctx, "dev-1").
+				Return(model.DevStatusRejected, nil)
+			db.On("UpdateDevice", ctx,
+				model.Device{Id: "dev-1"},
+				mock.MatchedBy(func(u model.DeviceUpdate) bool {
+					return u.Status == tc.status
+				})).
+				Return(nil)
+
+			co := morchestrator.ClientRunner{}
+			co.On("SubmitProvisionDeviceJob", ctx,
+				mock.MatchedBy(func(r orchestrator.ProvisionDeviceReq) bool {
+					return r.Device.Id == "dev-1"
+				})).
+				Return(nil)
+
+			devauth := NewDevAuth(&db, &co, nil, Config{})
+
+			err := devauth.applyAdmissionRules(ctx,
+				map[string]interface{}{"sn": "0001"},
+				&model.AuthReq{IdData: `{"sn":"0001"}`},
+				aset)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.status, aset.Status)
+			if tc.status == model.DevStatusAccepted {
+				co.AssertExpectations(t)
+			} else {
+				co.AssertNotCalled(t, "SubmitProvisionDeviceJob", ctx, mock.Anything)
+			}
+			if tc.status == model.DevStatusPending {
+				db.AssertNotCalled(t, "UpdateAuthSetById", ctx, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestDevAuthGetAdmissionRule(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		rule  *model.AdmissionRule
+		dbErr error
+
+		err error
+	}{
+		"ok": {
+			rule: &model.AdmissionRule{Id: "foo"},
+		},
+		"not found": {
+			dbErr: store.ErrAdmissionRuleNotFound,
+			err:   ErrAdmissionRuleNotFound,
+		},
+		"error": {
+			dbErr: errors.New("db error"),
+			err:   errors.New("failed to get admission rule: db error"),
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := mstore.DataStore{}
+			db.On("GetAdmissionRule", ctx, "foo").Return(tc.rule, tc.dbErr)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+
+			rule, err := devauth.GetAdmissionRule(ctx, "foo")
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+				assert.Nil(t, rule)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.rule, rule)
+			}
+		})
+	}
+}
+
+func TestDevAuthApplyAdmissionWebhook(t *testing.T) {
+	t.Parallel()
+
+	pubkeyStr := mtesting.LoadPubKeyStr("testdata/public_ed25519.pem", t)
+	pubkey, err := utils.ParsePubKey(pubkeyStr)
+	assert.NoError(t, err)
+	fingerprint, err := utils.PubKeyFingerprint(pubkey)
+	assert.NoError(t, err)
+
+	hook := &model.AdmissionWebhook{
+		URL:    "https://assets.example.com/admission",
+		Secret: "0123456789abcdef",
+	}
+
+	testCases := map[string]struct {
+		noClient  bool
+		hook      *model.AdmissionWebhook
+		hookErr   error
+		rsp       *admission.Rsp
+		rspErr    error
+		devStatus string
+
+		status string
+		err    error
+	}{
+		"accept": {
+			hook:   hook,
+			rsp:    &admission.Rsp{AuthSetId: "aset-1", Action: model.AdmissionActionAccept},
+			status: model.DevStatusAccepted,
+		},
+		"accept, new key of accepted device": {
+			hook:      hook,
+			rsp:       &admission.Rsp{AuthSetId: "aset-1", Action: model.AdmissionActionAccept},
+			devStatus: model.DevStatusAccepted,
+			status:    model.DevStatusPending,
+		},
+		"reject": {
+			hook:   hook,
+			rsp:    &admission.Rsp{AuthSetId: "aset-1", Action: model.AdmissionActionReject},
+			status: model.DevStatusRejected,
+		},
+		"pending": {
+			hook:   hook,
+			rsp:    &admission.Rsp{AuthSetId: "aset-1", Action: model.AdmissionActionPending},
+			status: model.DevStatusPending,
+		},
+		"webhook failed": {
+			hook:   hook,
+			rspErr: errors.New("admission webhook request failed"),
+			status: model.DevStatusPending,
+		},
+		"webhook not set": {
+			hookErr: store.ErrAdmissionWebhookNotFound,
+			status:  model.DevStatusPending,
+		},
+		"webhooks disabled": {
+			noClient: true,
+			hook:     hook,
+			status:   model.DevStatusPending,
+		},
+		"error": {
+			hookErr: errors.New("db error"),
+			status:  model.DevStatusPending,
+			err:     errors.New("failed to get admission webhook: db error"),
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := identity.WithContext(context.Background(), &identity.Identity{
+				Tenant: "tenant-1",
+			})
+
+			aset := &model.AuthSet{
+				Id:       "aset-1",
+				DeviceId: "dev-1",
+				Status:   model.DevStatusPending,
+			}
+
+			db := mstore.DataStore{}
+			db.On("GetAdmissionWebhook", ctx).Return(tc.hook, tc.hookErr)
+			db.On("GetLimit", ctx, model.LimitMaxDeviceCount).
+				Return(&model.Limit{Value: 0}, nil)
+			db.On("UpdateAuthSet", ctx,
+				mock.AnythingOfType("bson.M"),
+				model.AuthSetUpdate{Status: model.DevStatusRejected}).
+				Return(store.ErrAuthSetNotFound)
+			devStatus := model.DevStatusPending
+			if tc.devStatus != "" {
+				devStatus = tc.devStatus
+			}
+			db.On("GetDeviceById", ctx, "dev-1").
+				Return(&model.Device{Id: "dev-1", Status: devStatus}, nil)
+			db.On("UpdateAuthSetById", ctx, "aset-1",
+				model.AuthSetUpdate{Status: tc.status}).
+				Return(nil)
+			db.On("GetDeviceStatus", ctx, "dev-1").
+				Return(model.DevStatusRejected, nil)
+			db.On("UpdateDevice", ctx,
+				model.Device{Id: "dev-1"},
+				mock.MatchedBy(func(u model.DeviceUpdate) bool {
+					return u.Status == tc.status
+				})).
+				Return(nil)
+
+			co := morchestrator.ClientRunner{}
+			co.On("SubmitProvisionDeviceJob", ctx,
+				mock.AnythingOfType("orchestrator.ProvisionDeviceReq")).
+				Return(nil)
+
+			ca := madmission.ClientRunner{}
+			ca.On("CheckAdmission", ctx, *hook,
+				admission.Req{
+					TenantId:       "tenant-1",
+					DeviceId:       "dev-1",
+					AuthSetId:      "aset-1",
+					IdData:         map[string]interface{}{"sn": "0001"},
+					KeyType:        utils.KeyTypeEd25519,
+					KeyFingerprint: fingerprint,
+				}).
+				Return(tc.rsp, tc.rspErr)
+
+			devauth := NewDevAuth(&db, &co, nil, Config{})
+			if !tc.noClient {
+				devauth = devauth.WithAdmissionWebhooks(&ca)
+			}
+
+			err := devauth.applyAdmissionWebhook(ctx,
+				map[string]interface{}{"sn": "0001"},
+				&model.AuthReq{
+					IdData:       `{"sn":"0001"}`,
+					PubKey:       pubkeyStr,
+					PubKeyStruct: pubkey,
+				},
+				aset)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.status, aset.Status)
+			if tc.noClient {
+				db.AssertNotCalled(t, "GetAdmissionWebhook", ctx)
+			}
+			if tc.rsp == nil || tc.devStatus != "" {
+				db.AssertNotCalled(t, "UpdateAuthSetById", ctx, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestDevAuthProcessAuthRequestAdmissionWebhook(t *testing.T) {
+	t.Parallel()
+
+	pubkeyStr := mtesting.LoadPubKeyStr("testdata/public_ed25519.pem", t)
+	pubkey, err := utils.ParsePubKey(pubkeyStr)
+	assert.NoError(t, err)
+
+	idData := `{"sn":"0001"}`
+	_, idDataSha256, err := parseIdData(idData)
+	assert.NoError(t, err)
+
+	// the webhook is asked only about new auth sets, not on every retry
+	// of the device
+	testCases := map[string]struct {
+		addAuthSetErr error
+
+		asked bool
+	}{
+		"new auth set": {
+			asked: true,
+		},
+		"existing auth set": {
+			addAuthSetErr: store.ErrObjectExists,
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := mstore.DataStore{}
+			db.On("AddDevice", ctx, mock.AnythingOfType("model.Device")).
+				Return(store.ErrObjectExists)
+			db.On("GetDeviceByIdentityDataHash", ctx, idDataSha256).
+				Return(&model.Device{Id: "dev-1"}, nil)
+			db.On("GetLimit", ctx, model.LimitMaxAuthSetsPerDevice).
+				Return(nil, store.ErrLimitNotFound)
+			db.On("AddAuthSet", ctx, mock.AnythingOfType("model.AuthSet")).
+				Return(tc.addAuthSetErr)
+			db.On("GetDeviceStatus", ctx, "dev-1").
+				Return(model.DevStatusPending, nil)
+			db.On("UpdateDevice", ctx,
+				model.Device{Id: "dev-1"},
+				mock.AnythingOfType("model.DeviceUpdate")).
+				Return(nil)
+			db.On("GetAuthSetByIdDataHashKey", ctx, idDataSha256, pubkeyStr).
+				Return(&model.AuthSet{
+					Id:       "aset-1",
+					DeviceId: "dev-1",
+					Status:   model.DevStatusPending,
+				}, nil)
+			db.On("GetAdmissionRules", ctx).Return([]model.AdmissionRule{}, nil)
+			db.On("GetAdmissionWebhook", ctx).
+				Return(&model.AdmissionWebhook{URL: "https://assets.example.com"}, nil)
+
+			ca := madmission.ClientRunner{}
+			ca.On("CheckAdmission", ctx,
+				mock.AnythingOfType("model.AdmissionWebhook"),
+				mock.AnythingOfType("admission.Req")).
+				Return(&admission.Rsp{
+					AuthSetId: "aset-1",
+					Action:    model.AdmissionActionPending,
+				}, nil)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{}).
+				WithAdmissionWebhooks(&ca)
+
+			aset, err := devauth.processAuthRequest(ctx, &model.AuthReq{
+				IdData:       idData,
+				PubKey:       pubkeyStr,
+				PubKeyStruct: pubkey,
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, model.DevStatusPending, aset.Status)
+
+			if tc.asked {
+				ca.AssertExpectations(t)
+			} else {
+				ca.AssertNotCalled(t, "CheckAdmission", ctx, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
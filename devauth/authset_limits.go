@@ -0,0 +1,145 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devauth
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceauth/model"
+	"github.com/mendersoftware/deviceauth/store"
+)
+
+const (
+	// expired auth sets purged at once
+	authSetPurgeBatchSize = 100
+)
+
+var (
+	// auth sets which may be evicted or purged; accepted ones are in use,
+	// preauthorized ones were set up by the user
+	evictableAuthSetStatuses = []string{
+		model.DevStatusPending,
+		model.DevStatusRejected,
+	}
+)
+
+// evictAuthSets deletes the oldest pending or rejected auth sets of the
+// device exceeding model.LimitMaxAuthSetsPerDevice, sparing the auth set
+// with id 'keep'.
+func (d *DevAuth) evictAuthSets(ctx context.Context, devId, keep string) error {
+	l := log.FromContext(ctx)
+
+	limit, err := d.GetLimit(ctx, model.LimitMaxAuthSetsPerDevice)
+	if err != nil {
+		return errors.Wrap(err, "can't get auth sets limit")
+	}
+
+	if limit.Value == 0 {
+		return nil
+	}
+
+	asets, err := d.db.GetAuthSetsForDevice(ctx, devId)
+	if err != nil {
+		return errors.Wrap(err, "db get auth sets error")
+	}
+
+	if uint64(len(asets)) <= limit.Value {
+		return nil
+	}
+	excess := uint64(len(asets)) - limit.Value
+
+	// auth sets without a timestamp predate it, oldest
+	sort.SliceStable(asets, func(i, j int) bool {
+		if asets[j].Timestamp == nil {
+			return false
+		}
+		return asets[i].Timestamp == nil ||
+			asets[i].Timestamp.Before(*asets[j].Timestamp)
+	})
+
+	for _, aset := range asets {
+		if excess == 0 {
+			break
+		}
+		if aset.Id == keep ||
+			(aset.Status != model.DevStatusPending &&
+				aset.Status != model.DevStatusRejected) {
+			continue
+		}
+
+		err := d.db.DeleteAuthSetForDevice(ctx, devId, aset.Id)
+		if err != nil && err != store.ErrAuthSetNotFound {
+			return errors.Wrap(err, "db delete auth set error")
+		}
+		if d.cache != nil {
+			d.cache.InvalidateAuthSet(aset.Id)
+		}
+
+		l.Infof("auth set %v of device %v evicted, max %d auth sets per device",
+			aset.Id, devId, limit.Value)
+		excess--
+	}
+
+	return nil
+}
+
+// PurgeAuthSets deletes the pending and rejected auth sets submitted longer
+// than model.LimitAuthSetTTL ago, and updates the status of their devices.
+// Returns the number of auth sets deleted.
+func (d *DevAuth) PurgeAuthSets(ctx context.Context) (int, error) {
+	ttl, err := d.GetLimit(ctx, model.LimitAuthSetTTL)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't get auth sets TTL")
+	}
+
+	if ttl.Value == 0 {
+		return 0, nil
+	}
+
+	before := time.Now().Add(-time.Duration(ttl.Value) * time.Second)
+
+	purged := 0
+	for {
+		asets, err := d.db.GetAuthSetsBefore(ctx, before,
+			evictableAuthSetStatuses, authSetPurgeBatchSize)
+		if err != nil {
+			return purged, errors.Wrap(err, "db get auth sets error")
+		}
+
+		for _, aset := range asets {
+			err := d.db.DeleteAuthSetForDevice(ctx, aset.DeviceId, aset.Id)
+			if err != nil && err != store.ErrAuthSetNotFound {
+				return purged, errors.Wrap(err, "db delete auth set error")
+			}
+			if d.cache != nil {
+				d.cache.InvalidateAuthSet(aset.Id)
+			}
+
+			// the device is kept, with no auth sets left it's rejected
+			if err := d.updateDeviceStatus(ctx, aset.DeviceId, ""); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+
+		if len(asets) < authSetPurgeBatchSize {
+			return purged, nil
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceauth/model"
+	"github.com/mendersoftware/deviceauth/store"
+	mstore "github.com/mendersoftware/deviceauth/store/mocks"
+	uto "github.com/mendersoftware/deviceauth/utils/to"
+)
+
+func TestDevAuthEvictAuthSets(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	aset := func(id, status string, age int) model.AuthSet {
+		return model.AuthSet{
+			Id:        id,
+			DeviceId:  "dev-1",
+			Status:    status,
+			Timestamp: uto.TimePtr(now.Add(-time.Duration(age) * time.Hour)),
+		}
+	}
+
+	asets := []model.AuthSet{
+		aset("new", model.DevStatusPending, 0),
+		aset("accepted", model.DevStatusAccepted, 5),
+		aset("pending-1", model.DevStatusPending, 2),
+		aset("preauth", model.DevStatusPreauth, 4),
+		aset("rejected", model.DevStatusRejected, 3),
+		aset("pending-2", model.DevStatusPending, 1),
+		{Id: "legacy", DeviceId: "dev-1", Status: model.DevStatusPending},
+	}
+
+	testCases := map[string]struct {
+		limit    *model.Limit
+		limitErr error
+		asetsErr error
+		delErr   error
+
+		evicted []string
+		err     error
+	}{
+		"ok": {
+			limit:   &model.Limit{Value: 4},
+			evicted: []string{"legacy", "rejected", "pending-1"},
+		},
+		"ok, accepted and preauthorized kept": {
+			limit: &model.Limit{Value: 1},
+			evicted: []string{
+				"legacy", "rejected", "pending-1", "pending-2",
+			},
+		},
+		"ok, below limit": {
+			limit: &model.Limit{Value: 7},
+		},
+		"ok, no limit": {
+			limitErr: store.ErrLimitNotFound,
+		},
+		"error, limit": {
+			limitErr: errors.New("db error"),
+			err:      errors.New("can't get auth sets limit: db error"),
+		},
+		"error, auth sets": {
+			limit:    &model.Limit{Value: 4},
+			asetsErr: errors.New("db error"),
+			err:      errors.New("db get auth sets error: db error"),
+		},
+		"error, delete": {
+			limit:   &model.Limit{Value: 4},
+			delErr:  errors.New("db error"),
+			evicted: []string{"legacy"},
+			err:     errors.New("db delete auth set error: db error"),
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			// sorted in place
+			devAsets := make([]model.AuthSet, len(asets))
+			copy(devAsets, asets)
+
+			db := mstore.DataStore{}
+			db.On("GetLimit", ctx, model.LimitMaxAuthSetsPerDevice).
+				Return(tc.limit, tc.limitErr)
+			db.On("GetAuthSetsForDevice", ctx, "dev-1").
+				Return(devAsets, tc.asetsErr)
+			db.On("DeleteAuthSetForDevice", ctx, "dev-1",
+				mock.AnythingOfType("string")).
+				Return(tc.delErr)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+
+			err := devauth.evictAuthSets(ctx, "dev-1", "new")
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+
+			db.AssertNumberOfCalls(t, "DeleteAuthSetForDevice", len(tc.evicted))
+			for _, id := range tc.evicted {
+				db.AssertCalled(t, "DeleteAuthSetForDevice", ctx, "dev-1", id)
+			}
+		})
+	}
+}
+
+func TestDevAuthPurgeAuthSets(t *testing.T) {
+	t.Parallel()
+
+	asets := make([]model.AuthSet, authSetPurgeBatchSize+10)
+	for i := range asets {
+		asets[i] = model.AuthSet{
+			Id:       fmt.Sprintf("aset-%d", i),
+			DeviceId: fmt.Sprintf("dev-%d", i),
+			Status:   model.DevStatusPending,
+		}
+	}
+
+	testCases := map[string]struct {
+		ttl      *model.Limit
+		ttlErr   error
+		asets    [][]model.AuthSet
+		asetsErr error
+		delErr   error
+		updErr   error
+
+		purged int
+		err    error
+	}{
+		"ok": {
+			ttl:    &model.Limit{Value: 3600},
+			asets:  [][]model.AuthSet{asets[:2]},
+			purged: 2,
+		},
+		"ok, batches": {
+			ttl: &model.Limit{Value: 3600},
+			asets: [][]model.AuthSet{
+				asets[:authSetPurgeBatchSize],
+				asets[authSetPurgeBatchSize:],
+			},
+			purged: len(asets),
+		},
+		"ok, already deleted": {
+			ttl:    &model.Limit{Value: 3600},
+			asets:  [][]model.AuthSet{asets[:1]},
+			delErr: store.ErrAuthSetNotFound,
+			purged: 1,
+		},
+		"ok, no TTL": {
+			ttlErr: store.ErrLimitNotFound,
+		},
+		"error, TTL": {
+			ttlErr: errors.New("db error"),
+			err:    errors.New("can't get auth sets TTL: db error"),
+		},
+		"error, auth sets": {
+			ttl:      &model.Limit{Value: 3600},
+			asets:    [][]model.AuthSet{nil},
+			asetsErr: errors.New("db error"),
+			err:      errors.New("db get auth sets error: db error"),
+		},
+		"error, delete": {
+			ttl:    &model.Limit{Value: 3600},
+			asets:  [][]model.AuthSet{asets[:2]},
+			delErr: errors.New("db error"),
+			err:    errors.New("db delete auth set error: db error"),
+		},
+		"error, device status": {
+			ttl:    &model.Limit{Value: 3600},
+			asets:  [][]model.AuthSet{asets[:2]},
+			updErr: errors.New("db error"),
+			err:    errors.New("failed to update device status: db error"),
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := mstore.DataStore{}
+			db.On("GetLimit", ctx, model.LimitAuthSetTTL).
+				Return(tc.ttl, tc.ttlErr)
+			for _, batch := range tc.asets {
+				db.On("GetAuthSetsBefore", ctx,
+					mock.MatchedBy(func(before time.Time) bool {
+						ttl := time.Duration(tc.ttl.Value) * time.Second
+						return time.Since(before) >= ttl &&
+							time.Since(before) < ttl+time.Minute
+					}),
+					[]string{model.DevStatusPending, model.DevStatusRejected},
+					authSetPurgeBatchSize).
+					Return(batch, tc.asetsErr).Once()
+			}
+			db.On("DeleteAuthSetForDevice", ctx,
+				mock.AnythingOfType("string"),
+				mock.AnythingOfType("string")).
+				Return(tc.delErr)
+			db.On("GetDeviceStatus", ctx, mock.AnythingOfType("string")).
+				Return("", store.ErrAuthSetNotFound)
+			db.On("UpdateDevice", ctx,
+				mock.AnythingOfType("model.Device"),
+				mock.MatchedBy(func(up model.DeviceUpdate) bool {
+					return up.Status == model.DevStatusRejected
+				})).
+				Return(tc.updErr)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+
+			purged, err := devauth.PurgeAuthSets(ctx)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+				db.AssertNumberOfCalls(t, "DeleteAuthSetForDevice", tc.purged)
+			}
+			assert.Equal(t, tc.purged, purged)
+		})
+	}
+}
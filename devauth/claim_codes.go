@@ -0,0 +1,143 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devauth
+
+import (
+	"context"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceauth/model"
+	"github.com/mendersoftware/deviceauth/store"
+)
+
+// CreateClaimCode generates a one-time code accepting the auth set of the
+// device presenting it; the code expires at `expiresAt`, if set.
+func (d *DevAuth) CreateClaimCode(ctx context.Context, expiresAt *time.Time) (*model.ClaimCode, error) {
+	code, err := model.NewClaimCode(expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.db.AddClaimCode(ctx, *code); err != nil {
+		return nil, errors.Wrap(err, "db add claim code error")
+	}
+
+	return code, nil
+}
+
+func (d *DevAuth) GetClaimCodes(ctx context.Context) ([]model.ClaimCode, error) {
+	codes, err := d.db.GetClaimCodes(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list claim codes")
+	}
+
+	// expired codes are dropped by the db eventually
+	now := time.Now()
+	res := codes[:0]
+	for _, c := range codes {
+		if !c.Expired(now) {
+			res = append(res, c)
+		}
+	}
+
+	return res, nil
+}
+
+func (d *DevAuth) DeleteClaimCode(ctx context.Context, id string) error {
+	err := d.db.DeleteClaimCode(ctx, id)
+	switch err {
+	case nil:
+		return nil
+	case store.ErrClaimCodeNotFound:
+		return ErrClaimCodeNotFound
+	default:
+		return errors.Wrap(err, "failed to delete claim code")
+	}
+}
+
+// redeemClaimCode consumes the claim code of the auth request, and accepts
+// the (pending) auth set. Claim codes onboard new devices only: new keys of
+// accepted or preauthorized devices are left pending, as the code isn't
+// bound to the device. The code isn't consumed then, nor if the device limit
+// is reached already, and is restored if the auth set can't be accepted.
+func (d *DevAuth) redeemClaimCode(ctx context.Context, r *model.AuthReq, aset *model.AuthSet) error {
+	dev, err := d.db.GetDeviceById(ctx, aset.DeviceId)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch device")
+	}
+	if dev.Status == model.DevStatusAccepted ||
+		dev.Status == model.DevStatusPreauth {
+		log.FromContext(ctx).Warnf("device %s is %s, claim code not redeemed, "+
+			"new auth set %s left pending", aset.DeviceId, dev.Status, aset.Id)
+		return nil
+	}
+
+	allow, err := d.canAcceptDevice(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !allow {
+		return ErrMaxDeviceCountReached
+	}
+
+	code, err := d.db.ConsumeClaimCode(ctx, model.ClaimCodeId(r.ClaimCode))
+	switch err {
+	case nil:
+	case store.ErrClaimCodeNotFound:
+		return d.claimCodeInvalid(ctx, r)
+	default:
+		return errors.Wrap(err, "db consume claim code error")
+	}
+
+	if code.Expired(time.Now()) {
+		return d.claimCodeInvalid(ctx, r)
+	}
+
+	l := log.FromContext(ctx)
+
+	err = d.rejectAcceptedAuthSets(ctx, aset.DeviceId)
+	if err == nil {
+		err = d.autoAcceptAuthSet(ctx, aset)
+	}
+	if err != nil {
+		// the code is consumed first so that it's redeemed only once
+		if rerr := d.db.AddClaimCode(ctx, *code); rerr != nil {
+			l.Errorf("failed to restore claim code %s: %v", code.Id, rerr)
+		}
+		return err
+	}
+
+	l.Infof("claim code %s redeemed by device %s auth set %s",
+		code.Id, aset.DeviceId, aset.Id)
+
+	return nil
+}
+
+// claimCodeInvalid counts the invalid claim code as a failed auth request,
+// as codes could be guessed otherwise.
+func (d *DevAuth) claimCodeInvalid(ctx context.Context, r *model.AuthReq) error {
+	switch err := d.recordAuthFailure(ctx, r); err {
+	case nil:
+	case ErrAuthLockedOut:
+		return err
+	default:
+		log.FromContext(ctx).Errorf("failed to record auth failure: %v", err)
+	}
+
+	return MakeErrDevAuthUnauthorized(ErrClaimCodeInvalid)
+}
@@ -0,0 +1,278 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devauth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceauth/client/orchestrator"
+	morchestrator "github.com/mendersoftware/deviceauth/client/orchestrator/mocks"
+	"github.com/mendersoftware/deviceauth/model"
+	"github.com/mendersoftware/deviceauth/store"
+	mstore "github.com/mendersoftware/deviceauth/store/mocks"
+)
+
+func TestDevAuthCreateClaimCode(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	expiresAt := time.Date(2019, 6, 8, 12, 0, 0, 0, time.FixedZone("CEST", 2*3600))
+
+	db := mstore.DataStore{}
+	db.On("AddClaimCode", ctx,
+		mock.MatchedBy(func(c model.ClaimCode) bool {
+			return c.Id == model.ClaimCodeId(c.Code) &&
+				c.ExpiresAt.Equal(expiresAt) &&
+				c.ExpiresAt.Location() == time.UTC
+		})).
+		Return(nil)
+
+	devauth := NewDevAuth(&db, nil, nil, Config{})
+
+	code, err := devauth.CreateClaimCode(ctx, &expiresAt)
+	assert.NoError(t, err)
+	assert.Regexp(t, "^[A-Z2-7]{4}-[A-Z2-7]{4}-[A-Z2-7]{4}-[A-Z2-7]{4}$", code.Code)
+
+	// the code is typed in by hand
+	assert.Equal(t, code.Id, model.ClaimCodeId(
+		strings.Replace(code.Code, "-", " ", -1)))
+	assert.Equal(t, code.Id, model.ClaimCodeId(
+		strings.ToLower(strings.Replace(code.Code, "-", "", -1))))
+
+	db.AssertExpectations(t)
+}
+
+func TestDevAuthGetClaimCodes(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	db := mstore.DataStore{}
+	db.On("GetClaimCodes", ctx).
+		Return([]model.ClaimCode{
+			{Id: "expired", ExpiresAt: &past},
+			{Id: "valid", ExpiresAt: &future},
+			{Id: "no expiration"},
+		}, nil)
+
+	devauth := NewDevAuth(&db, nil, nil, Config{})
+
+	codes, err := devauth.GetClaimCodes(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []model.ClaimCode{
+		{Id: "valid", ExpiresAt: &future},
+		{Id: "no expiration"},
+	}, codes)
+}
+
+func TestDevAuthDeleteClaimCode(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		dbErr error
+		err   error
+	}{
+		"ok": {},
+		"not found": {
+			dbErr: store.ErrClaimCodeNotFound,
+			err:   ErrClaimCodeNotFound,
+		},
+		"error": {
+			dbErr: errors.New("db error"),
+			err:   errors.New("failed to delete claim code: db error"),
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := mstore.DataStore{}
+			db.On("DeleteClaimCode", ctx, "foo").Return(tc.dbErr)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+
+			err := devauth.DeleteClaimCode(ctx, "foo")
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDevAuthRedeemClaimCode(t *testing.T) {
+	t.Parallel()
+
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	testCases := map[string]struct {
+		devStatus  string
+		limit      uint64
+		devCount   int
+		code       *model.ClaimCode
+		consumeErr error
+		provErr    error
+
+		pending  bool
+		restored bool
+		err      error
+	}{
+		"ok": {
+			code: &model.ClaimCode{Id: model.ClaimCodeId("ABCD-EFGH")},
+		},
+		"ok, not expired": {
+			code: &model.ClaimCode{
+				Id:        model.ClaimCodeId("ABCD-EFGH"),
+				ExpiresAt: &future,
+			},
+		},
+		"error, expired": {
+			code: &model.ClaimCode{
+				Id:        model.ClaimCodeId("ABCD-EFGH"),
+				ExpiresAt: &past,
+			},
+			err: MakeErrDevAuthUnauthorized(ErrClaimCodeInvalid),
+		},
+		"error, not found": {
+			consumeErr: store.ErrClaimCodeNotFound,
+			err:        MakeErrDevAuthUnauthorized(ErrClaimCodeInvalid),
+		},
+		"ok, device accepted, left pending": {
+			devStatus: model.DevStatusAccepted,
+			code:      &model.ClaimCode{Id: model.ClaimCodeId("ABCD-EFGH")},
+			pending:   true,
+		},
+		"ok, device preauthorized, left pending": {
+			devStatus: model.DevStatusPreauth,
+			code:      &model.ClaimCode{Id: model.ClaimCodeId("ABCD-EFGH")},
+			pending:   true,
+		},
+		"error, device limit reached": {
+			limit:    5,
+			devCount: 5,
+			err:      ErrMaxDeviceCountReached,
+		},
+		"error, db": {
+			consumeErr: errors.New("db error"),
+			err:        errors.New("db consume claim code error: db error"),
+		},
+		"error, accept": {
+			code:     &model.ClaimCode{Id: model.ClaimCodeId("ABCD-EFGH")},
+			provErr:  errors.New("orchestrator error"),
+			restored: true,
+			err:      errors.New("submit device provisioning job error: orchestrator error"),
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			aset := &model.AuthSet{
+				Id:       "aset-1",
+				DeviceId: "dev-1",
+				Status:   model.DevStatusPending,
+			}
+
+			devStatus := tc.devStatus
+			if devStatus == "" {
+				devStatus = model.DevStatusPending
+			}
+
+			db := mstore.DataStore{}
+			db.On("GetLimit", ctx, model.LimitMaxDeviceCount).
+				Return(&model.Limit{Value: tc.limit}, nil)
+			db.On("GetDevCountByStatus", ctx, model.DevStatusAccepted).
+				Return(tc.devCount, nil)
+			db.On("ConsumeClaimCode", ctx, model.ClaimCodeId("abcd-efgh")).
+				Return(tc.code, tc.consumeErr)
+			db.On("UpdateAuthSet", ctx,
+				mock.AnythingOfType("bson.M"),
+				model.AuthSetUpdate{Status: model.DevStatusRejected}).
+				Return(store.ErrAuthSetNotFound)
+			db.On("GetDeviceById", ctx, "dev-1").
+				Return(&model.Device{Id: "dev-1", Status: devStatus}, nil)
+			db.On("UpdateAuthSetById", ctx, "aset-1",
+				model.AuthSetUpdate{Status: model.DevStatusAccepted}).
+				Return(nil)
+			db.On("UpdateDevice", ctx,
+				model.Device{Id: "dev-1"},
+				mock.MatchedBy(func(u model.DeviceUpdate) bool {
+					return u.Status == model.DevStatusAccepted
+				})).
+				Return(nil)
+			db.On("AddClaimCode", ctx,
+				model.ClaimCode{Id: model.ClaimCodeId("ABCD-EFGH")}).
+				Return(nil)
+
+			co := morchestrator.ClientRunner{}
+			co.On("SubmitProvisionDeviceJob", ctx,
+				mock.MatchedBy(func(r orchestrator.ProvisionDeviceReq) bool {
+					return r.Device.Id == "dev-1"
+				})).
+				Return(tc.provErr)
+
+			devauth := NewDevAuth(&db, &co, nil, Config{})
+
+			err := devauth.redeemClaimCode(ctx, &model.AuthReq{
+				IdData:    `{"sn":"0001"}`,
+				ClaimCode: "abcd-efgh",
+			}, aset)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+				if tc.provErr == nil {
+					co.AssertNotCalled(t, "SubmitProvisionDeviceJob", ctx, mock.Anything)
+				}
+				assert.Equal(t, model.DevStatusPending, aset.Status)
+			} else if tc.pending {
+				assert.NoError(t, err)
+				co.AssertNotCalled(t, "SubmitProvisionDeviceJob", ctx, mock.Anything)
+				db.AssertNotCalled(t, "UpdateAuthSet", ctx, mock.Anything, mock.Anything)
+				assert.Equal(t, model.DevStatusPending, aset.Status)
+			} else {
+				assert.NoError(t, err)
+				co.AssertExpectations(t)
+				assert.Equal(t, model.DevStatusAccepted, aset.Status)
+			}
+
+			if tc.limit > 0 || tc.pending {
+				db.AssertNotCalled(t, "ConsumeClaimCode", ctx, mock.Anything)
+			}
+			if tc.restored {
+				db.AssertCalled(t, "AddClaimCode", ctx,
+					model.ClaimCode{Id: model.ClaimCodeId("ABCD-EFGH")})
+			} else {
+				db.AssertNotCalled(t, "AddClaimCode", ctx, mock.Anything)
+			}
+		})
+	}
+}
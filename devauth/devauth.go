@@ -16,6 +16,7 @@ package devauth
 import (
 	"context"
 	"crypto/sha256"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -33,12 +34,15 @@ import (
 	"github.com/pkg/errors"
 	"github.com/satori/go.uuid"
 
+	"github.com/mendersoftware/deviceauth/cache"
+	"github.com/mendersoftware/deviceauth/client/admission"
 	"github.com/mendersoftware/deviceauth/client/orchestrator"
 	"github.com/mendersoftware/deviceauth/client/tenant"
 	"github.com/mendersoftware/deviceauth/jwt"
 	"github.com/mendersoftware/deviceauth/model"
 	"github.com/mendersoftware/deviceauth/store"
 	"github.com/mendersoftware/deviceauth/store/mongo"
+	"github.com/mendersoftware/deviceauth/utils"
 	uto "github.com/mendersoftware/deviceauth/utils/to"
 )
 
@@ -54,6 +58,27 @@ var (
 	ErrDeviceExists          = errors.New("device already exists")
 	ErrDeviceNotFound        = errors.New("device not found")
 	ErrDevAuthBadRequest     = errors.New(MsgErrDevAuthBadRequest)
+	ErrTrustedCAExists       = errors.New("trusted CA already exists")
+	ErrTrustedCANotFound     = errors.New("trusted CA not found")
+	ErrAuthReqExpired        = errors.New("auth request timestamp out of the allowed clock skew")
+	ErrAuthReqReplayed       = errors.New("auth request nonce already used")
+	ErrAuthReqNoNonce        = errors.New("auth request timestamp and nonce required")
+	ErrAuthChallengeInvalid  = errors.New("auth challenge invalid or expired")
+	ErrTokenScopeNotAllowed  = errors.New("token scope not allowed")
+	ErrTokenScopeMissing     = errors.New("token lacks the required scope")
+	ErrTokenAudienceMismatch = errors.New("token not issued for the accepted audiences")
+	ErrAuthLockedOut         = errors.New("too many failed auth requests, try again later")
+	ErrAuthLockoutNotFound   = errors.New("auth lockout not found")
+	ErrAuthReqSignature      = errors.New("signature verification failed")
+	ErrPSKNotSupported       = errors.New("pre-shared key credentials not supported")
+	ErrClaimCodeInvalid      = errors.New("claim code invalid or expired")
+	ErrClaimCodeNotFound     = errors.New("claim code not found")
+	ErrAdmissionRuleExists   = errors.New("admission rule already exists")
+	ErrAdmissionRuleNotFound = errors.New("admission rule not found")
+	ErrWebhookNotFound       = errors.New("admission webhook not set")
+	ErrIdSchemaNotFound      = errors.New("identity schema not set")
+
+	ErrVerificationCacheDisabled = errors.New("verification cache disabled")
 )
 
 func IsErrDevAuthUnauthorized(e error) bool {
@@ -88,6 +113,9 @@ func simpleApiClientGetter() apiclient.HttpRunner {
 // this device auth service interface
 type App interface {
 	SubmitAuthRequest(ctx context.Context, r *model.AuthReq) (string, error)
+	RecordAuthFailure(ctx context.Context, r *model.AuthReq) error
+	CreateAuthChallenge(ctx context.Context) (*model.AuthChallenge, error)
+	RenewToken(ctx context.Context, r *model.TokenRenewReq) (string, error)
 
 	GetDevices(ctx context.Context, skip, limit uint, filter store.DeviceFilter) ([]model.Device, error)
 	GetDevice(ctx context.Context, dev_id string) (*model.Device, error)
@@ -98,13 +126,19 @@ type App interface {
 	ResetDeviceAuth(ctx context.Context, dev_id string, auth_id string) error
 	PreauthorizeDevice(ctx context.Context, req *model.PreAuthReq) error
 	GetDeviceToken(ctx context.Context, dev_id string) (*model.Token, error)
+	GetDeviceTokens(ctx context.Context, dev_id string, skip, limit uint, filter model.TokenFilter) ([]model.Token, error)
 
 	RevokeToken(ctx context.Context, token_id string) error
-	VerifyToken(ctx context.Context, token string) error
+	RevokeDeviceTokens(ctx context.Context, dev_id string) error
+	VerifyToken(ctx context.Context, token string, scope string, audiences []string) error
+	IntrospectToken(ctx context.Context, token string) (*model.TokenIntrospection, error)
 	DeleteTokens(ctx context.Context, tenant_id, device_id string) error
 
 	SetTenantLimit(ctx context.Context, tenant_id string, limit model.Limit) error
 
+	SetTenantTokenPolicy(ctx context.Context, tenant_id string, policy model.TokenPolicy) error
+	GetTenantTokenPolicy(ctx context.Context, tenant_id string) (*model.TokenPolicy, error)
+
 	GetLimit(ctx context.Context, name string) (*model.Limit, error)
 	GetTenantLimit(ctx context.Context, name, tenant_id string) (*model.Limit, error)
 
@@ -113,6 +147,41 @@ type App interface {
 	ProvisionTenant(ctx context.Context, tenant_id string) error
 
 	GetTenantDeviceStatus(ctx context.Context, tenantId, deviceId string) (*model.Status, error)
+
+	GetJWKS(ctx context.Context) (*jwt.JWKS, error)
+
+	GetVerificationCacheStats(ctx context.Context) (*cache.Stats, error)
+	GetTenantJWKS(ctx context.Context, tenantId string) (*jwt.JWKS, error)
+
+	AddTrustedCA(ctx context.Context, ca *model.TrustedCA) error
+	GetTrustedCAs(ctx context.Context) ([]model.TrustedCA, error)
+	DeleteTrustedCA(ctx context.Context, id string) error
+
+	GetTokenScopes(ctx context.Context) (*model.TokenScopes, error)
+	SetTokenScopes(ctx context.Context, scopes model.TokenScopes) error
+
+	GetAuthLockouts(ctx context.Context, skip, limit uint, filter model.AuthLockoutFilter) ([]model.AuthLockout, error)
+	DeleteAuthLockout(ctx context.Context, id string) error
+
+	CreateClaimCode(ctx context.Context, expiresAt *time.Time) (*model.ClaimCode, error)
+	GetClaimCodes(ctx context.Context) ([]model.ClaimCode, error)
+	DeleteClaimCode(ctx context.Context, id string) error
+
+	AddAdmissionRule(ctx context.Context, rule *model.AdmissionRule) error
+	GetAdmissionRules(ctx context.Context) ([]model.AdmissionRule, error)
+	GetAdmissionRule(ctx context.Context, id string) (*model.AdmissionRule, error)
+	UpdateAdmissionRule(ctx context.Context, rule *model.AdmissionRule) error
+	DeleteAdmissionRule(ctx context.Context, id string) error
+	MatchAdmissionRule(ctx context.Context, idData map[string]interface{}, keyType string) (*model.AdmissionRule, error)
+
+	GetAdmissionWebhook(ctx context.Context) (*model.AdmissionWebhook, error)
+	SetAdmissionWebhook(ctx context.Context, hook model.AdmissionWebhook) error
+	DeleteAdmissionWebhook(ctx context.Context) error
+
+	GetIdentitySchema(ctx context.Context) (*model.IdentitySchema, error)
+	SetIdentitySchema(ctx context.Context, schema model.IdentitySchema) error
+	DeleteIdentitySchema(ctx context.Context) error
+	GetIdentitySchemaViolations(ctx context.Context, skip, limit uint) ([]model.IdentitySchemaViolation, error)
 }
 
 type DevAuth struct {
@@ -123,15 +192,48 @@ type DevAuth struct {
 	clientGetter ApiClientGetter
 	verifyTenant bool
 	config       Config
+	cache        *cache.VerificationCache
+	tenantKeys   *tenantKeys
+	// key encrypting the pre-shared keys of the devices; pre-shared key
+	// credentials are disabled if not set
+	pskEncKey []byte
+	// client of the tenants' admission webhooks; webhooks are disabled if
+	// not set
+	cAdmission admission.ClientRunner
 }
 
 type Config struct {
 	// token issuer
 	Issuer string
+	// token audience, may be overridden in the tenant token policy;
+	// audience checks are disabled if empty
+	Audience string
 	// token expiration time
 	ExpirationTime int64
 	// max devices limit default
 	MaxDevicesLimitDefault uint64
+	// if set, an unexpired token of the auth set is handed out again,
+	// instead of issuing a new one, if valid for at least that many seconds
+	TokenReuseMinLifetime int64
+	// max number of unexpired tokens per device; the oldest ones are
+	// deleted when issuing a new token (0 - no limit)
+	MaxDeviceTokens uint
+	// seconds after expiration during which a token can still be renewed
+	TokenRenewalGracePeriod int64
+	// max difference between the auth request timestamp and the server
+	// time, in seconds (0 - replay protection disabled)
+	AuthReqMaxClockSkew int64
+	// validity of auth challenges, in seconds
+	AuthChallengeTTL int64
+	// number of failed signature verifications after which the device
+	// identity or source IP is locked out (0 - lockouts disabled)
+	AuthLockoutThreshold int
+	// seconds without failures after which counting restarts
+	AuthLockoutWindow int64
+	// lockout duration, in seconds
+	AuthLockoutDuration int64
+	// normalizers of the identity attributes, applied before hashing
+	IdDataNormalizers model.IdDataNormalizers
 }
 
 func NewDevAuth(d store.DataStore, co orchestrator.ClientRunner,
@@ -186,7 +288,11 @@ func (d *DevAuth) getDeviceFromAuthRequest(ctx context.Context, r *model.AuthReq
 
 func (d *DevAuth) signToken(ctx context.Context) jwt.SignFunc {
 	return func(t *jwt.Token) (string, error) {
-		return d.jwt.ToJWT(t)
+		h, err := d.tokenHandler(ctx, t.Claims.Tenant, true)
+		if err != nil {
+			return "", err
+		}
+		return h.ToJWT(t)
 	}
 }
 
@@ -252,61 +358,127 @@ func (d *DevAuth) SubmitAuthRequest(ctx context.Context, r *model.AuthReq) (stri
 		ctx = tctx
 	}
 
-	// first, try to handle preauthorization
-	authSet, err := d.processPreAuthRequest(ctx, r)
-	if err != nil {
+	if err := d.canonicalizeIdData(&r.IdData); err != nil {
 		return "", err
 	}
 
-	// if not a preauth request, process with regular auth request handling
-	if authSet == nil {
-		authSet, err = d.processAuthRequest(ctx, r)
+	if err := d.checkAuthLockout(ctx, r); err != nil {
+		return "", err
+	}
+
+	// requests signed with a pre-shared key are verified here, as the key
+	// is known only once the auth set is found
+	var pskAuthSet *model.AuthSet
+	if r.IsPSK() {
+		aset, err := d.verifyPSKAuthReq(ctx, r)
 		if err != nil {
 			return "", err
 		}
+		pskAuthSet = aset
 	}
 
-	uid, err := uuid.NewV4()
+	if r.Challenge != "" {
+		if err := d.verifyAuthChallenge(ctx, r.Challenge); err != nil {
+			return "", err
+		}
+	} else if err := d.checkAuthReqReplay(ctx, r); err != nil {
+		return "", err
+	}
+
+	scope, err := d.checkTokenScope(ctx, r.Scope)
 	if err != nil {
-		l.Errorf("failed to assign uuid: %v", err)
 		return "", err
 	}
 
-	// request was already present in DB, check its status
-	if authSet.Status == model.DevStatusAccepted {
-		rawJwt := &jwt.Token{
-			Claims: jwt.Claims{
-				ID:        uid.String(),
-				Issuer:    d.config.Issuer,
-				ExpiresAt: time.Now().Unix() + d.config.ExpirationTime,
-				Subject:   authSet.DeviceId,
-				Device:    true,
-			},
+	// devices presenting a certificate must chain up to a trusted CA
+	if len(r.CertChain) > 0 {
+		if err := d.verifyCertChain(ctx, r.CertChain); err != nil {
+			return "", err
 		}
+	}
+
+	if err := d.checkAuthReqIdData(ctx, r); err != nil {
+		return "", err
+	}
+
+	var authSet *model.AuthSet
+	if pskAuthSet != nil {
+		authSet, err = d.processPSKAuthRequest(ctx, pskAuthSet)
+	} else {
+		// first, try to handle preauthorization
+		authSet, err = d.processPreAuthRequest(ctx, r)
+	}
+	if err != nil {
+		return "", err
+	}
 
-		if d.verifyTenant {
-			// update token tenant claim if needed
-			ident := identity.FromContext(ctx)
-			if ident != nil && ident.Tenant != "" {
-				rawJwt.Claims.Tenant = ident.Tenant
+	// if not a preauth request, process with regular auth request handling,
+	// auto-accepting auth sets backed by a trusted certificate
+	if authSet == nil {
+		if len(r.CertChain) > 0 {
+			authSet, err = d.processCertAuthRequest(ctx, r)
+		} else {
+			authSet, err = d.processAuthRequest(ctx, r)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// the claim code, if any, accepts the new auth set
+	if r.ClaimCode != "" && authSet.Status == model.DevStatusPending {
+		if err := d.redeemClaimCode(ctx, r, authSet); err != nil {
+			return "", err
+		}
+	}
+
+	// request was already present in DB, check its status
+	if authSet.Status == model.DevStatusAccepted {
+		// the token of the auth set rotated, revoked once replaced
+		var superseded *model.Token
+		if d.config.TokenReuseMinLifetime > 0 {
+			token, reusable, err := d.getReusableToken(ctx, authSet, scope)
+			if err != nil {
+				return "", err
+			}
+			if reusable {
+				l.Infof("Token %v reused for device %v auth set %v",
+					token.Id, authSet.DeviceId, authSet.Id)
+				return token.Token, nil
 			}
+			superseded = token
 		}
 
-		// sign and encode as JWT
-		raw, err := rawJwt.MarshalJWT(d.signToken(ctx))
+		policy, err := d.tokenPolicy(ctx)
 		if err != nil {
-			return "", errors.Wrap(err, "generate token error")
+			return "", err
 		}
 
-		token := model.NewToken(rawJwt.Claims.ID, authSet.DeviceId, string(raw))
-		token = token.WithAuthSet(authSet)
-
-		if err := d.db.AddToken(ctx, *token); err != nil {
-			return "", errors.Wrap(err, "add token error")
+		token, err := d.issueToken(ctx, authSet, scope, policy)
+		if err != nil {
+			return "", err
 		}
 
 		l.Infof("Token %v assigned to device %v auth set %v",
 			token.Id, authSet.DeviceId, authSet.Id)
+
+		// the new token is valid already, failing to clean up is not
+		// fatal
+		if superseded != nil {
+			if err := d.deleteDeviceToken(ctx, superseded.Id); err != nil {
+				l.Errorf("failed to delete superseded token %v of device %v: %v",
+					superseded.Id, authSet.DeviceId, err)
+			}
+		}
+		if policy.MaxDeviceTokens > 0 {
+			err := d.pruneDeviceTokens(ctx, authSet.DeviceId, token.Id,
+				policy.MaxDeviceTokens)
+			if err != nil {
+				l.Errorf("failed to delete previous tokens of device %v: %v",
+					authSet.DeviceId, err)
+			}
+		}
+
 		return token.Token, nil
 	}
 
@@ -315,9 +487,408 @@ func (d *DevAuth) SubmitAuthRequest(ctx context.Context, r *model.AuthReq) (stri
 
 }
 
-func (d *DevAuth) processPreAuthRequest(ctx context.Context, r *model.AuthReq) (*model.AuthSet, error) {
-	var deviceAlreadyAccepted bool
+// issueToken signs a new token for the accepted auth set, as per the token
+// policy, and stores it; the token is restricted to the scope, if not empty.
+func (d *DevAuth) issueToken(ctx context.Context, authSet *model.AuthSet, scope string, policy *model.TokenPolicy) (*model.Token, error) {
+	uid, err := uuid.NewV4()
+	if err != nil {
+		log.FromContext(ctx).Errorf("failed to assign uuid: %v", err)
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	rawJwt := &jwt.Token{
+		Claims: jwt.Claims{
+			ID:        uid.String(),
+			Issuer:    d.config.Issuer,
+			Audience:  policy.Audience,
+			IssuedAt:  now,
+			ExpiresAt: now + policy.ExpirationTime,
+			Subject:   authSet.DeviceId,
+			Scope:     scope,
+			Device:    true,
+			Extra:     policy.Claims,
+		},
+	}
+
+	if d.verifyTenant {
+		// update token tenant claim if needed
+		ident := identity.FromContext(ctx)
+		if ident != nil && ident.Tenant != "" {
+			rawJwt.Claims.Tenant = ident.Tenant
+		}
+	}
+
+	// sign and encode as JWT
+	raw, err := rawJwt.MarshalJWT(d.signToken(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "generate token error")
+	}
+
+	token := model.NewToken(rawJwt.Claims.ID, authSet.DeviceId, string(raw))
+	token = token.WithAuthSet(authSet).
+		WithValidity(rawJwt.Claims.IssuedAt, rawJwt.Claims.ExpiresAt)
+	token.Scope = scope
+
+	if err := d.db.AddToken(ctx, *token); err != nil {
+		return nil, errors.Wrap(err, "add token error")
+	}
+
+	return token, nil
+}
+
+// tokenPolicy returns the tenant's token policy, with the settings it
+// leaves unset taken from the server configuration. The audience is empty
+// if audience checks are disabled.
+func (d *DevAuth) tokenPolicy(ctx context.Context) (*model.TokenPolicy, error) {
+	tenantPolicy, err := d.db.GetTokenPolicy(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "db get token policy error")
+	}
+
+	policy := *tenantPolicy
+	if d.config.Audience == "" {
+		policy.Audience = ""
+	} else if policy.Audience == "" {
+		policy.Audience = d.config.Audience
+	}
+	if policy.ExpirationTime == 0 {
+		policy.ExpirationTime = d.config.ExpirationTime
+	}
+	if policy.MaxDeviceTokens == 0 {
+		policy.MaxDeviceTokens = d.config.MaxDeviceTokens
+	}
+
+	return &policy, nil
+}
+
+// tokenAudience returns the audience of the device tokens: the tenant's
+// audience, if set, or the server audience. Empty if audience checks are
+// disabled.
+func (d *DevAuth) tokenAudience(ctx context.Context) (string, error) {
+	if d.config.Audience == "" {
+		return "", nil
+	}
+
+	policy, err := d.tokenPolicy(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return policy.Audience, nil
+}
+
+// checkTokenScope checks that the requested scopes are allowed for the
+// tenant, and returns them as a single space separated list.
+func (d *DevAuth) checkTokenScope(ctx context.Context, scope string) (string, error) {
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		return "", nil
+	}
+
+	allowed, err := d.db.GetTokenScopes(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "db get token scopes error")
+	}
+
+	for _, s := range scopes {
+		if !allowed.Allows(s) {
+			log.FromContext(ctx).Warnf("token scope %s not allowed", s)
+			return "", MakeErrDevAuthBadRequest(ErrTokenScopeNotAllowed)
+		}
+	}
+
+	return strings.Join(scopes, " "), nil
+}
+
+// CreateAuthChallenge issues a server nonce, to be included in the
+// following auth request of the device.
+func (d *DevAuth) CreateAuthChallenge(ctx context.Context) (*model.AuthChallenge, error) {
+	ch, err := model.NewAuthChallenge(time.Duration(d.config.AuthChallengeTTL) * time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.db.AddAuthChallenge(ctx, *ch); err != nil {
+		return nil, errors.Wrap(err, "db add auth challenge error")
+	}
+
+	return ch, nil
+}
+
+// verifyAuthChallenge consumes the challenge; it can be used only once, and
+// only until it expires. The auth request signature, covering the challenge,
+// proves the possession of the device key.
+func (d *DevAuth) verifyAuthChallenge(ctx context.Context, nonce string) error {
+	ch, err := d.db.ConsumeAuthChallenge(ctx, nonce)
+	switch err {
+	case nil:
+	case store.ErrAuthChallengeNotFound:
+		return MakeErrDevAuthUnauthorized(ErrAuthChallengeInvalid)
+	default:
+		return errors.Wrap(err, "db consume auth challenge error")
+	}
+
+	if !time.Now().Before(ch.ExpiresAt) {
+		return MakeErrDevAuthUnauthorized(ErrAuthChallengeInvalid)
+	}
+
+	return nil
+}
+
+// checkAuthReqReplay rejects auth requests issued outside of the allowed
+// clock skew, or reusing a nonce of the device. Requests without timestamp
+// and nonce are accepted, unless the tenant requires them, even if the
+// timestamps and nonces aren't checked (no clock skew allowed).
+func (d *DevAuth) checkAuthReqReplay(ctx context.Context, r *model.AuthReq) error {
+	if r.Timestamp == nil {
+		strict, err := d.GetLimit(ctx, model.LimitStrictAuthReqs)
+		if err != nil {
+			return errors.Wrap(err, "can't get auth requests policy")
+		}
+		if strict.Value != 0 {
+			return MakeErrDevAuthUnauthorized(ErrAuthReqNoNonce)
+		}
+		return nil
+	}
+
+	if d.config.AuthReqMaxClockSkew <= 0 {
+		return nil
+	}
+
+	skew := time.Duration(d.config.AuthReqMaxClockSkew) * time.Second
+	now := time.Now()
+	if r.Timestamp.Before(now.Add(-skew)) || r.Timestamp.After(now.Add(skew)) {
+		return MakeErrDevAuthUnauthorized(ErrAuthReqExpired)
+	}
 
+	_, idDataSha256, err := parseIdData(r.IdData)
+	if err != nil {
+		return MakeErrDevAuthBadRequest(err)
+	}
+
+	// the nonce needs to be remembered only as long as the timestamp is
+	// accepted
+	nonce := model.NewAuthNonce(idDataSha256, r.Nonce, r.Timestamp.Add(skew))
+	switch err := d.db.AddAuthNonce(ctx, *nonce); err {
+	case nil:
+		return nil
+	case store.ErrObjectExists:
+		return MakeErrDevAuthUnauthorized(ErrAuthReqReplayed)
+	default:
+		return errors.Wrap(err, "db add auth nonce error")
+	}
+}
+
+// authLockouts returns the lockouts tracking the auth request: of the device
+// identity, if valid, and of the source IP, if known.
+func authLockouts(r *model.AuthReq) []*model.AuthLockout {
+	lockouts := []*model.AuthLockout{}
+
+	if _, idDataSha256, err := parseIdData(r.IdData); err == nil {
+		lockouts = append(lockouts,
+			model.NewIdentityAuthLockout(idDataSha256, r.IdData))
+	}
+	if r.RemoteIP != "" {
+		lockouts = append(lockouts, model.NewIPAuthLockout(r.RemoteIP))
+	}
+
+	return lockouts
+}
+
+// checkAuthLockout rejects auth requests of locked out device identities,
+// or from locked out IPs.
+func (d *DevAuth) checkAuthLockout(ctx context.Context, r *model.AuthReq) error {
+	if d.config.AuthLockoutThreshold <= 0 {
+		return nil
+	}
+
+	ids := []string{}
+	for _, lockout := range authLockouts(r) {
+		ids = append(ids, lockout.Id)
+	}
+
+	now := time.Now()
+	locked, err := d.db.GetAuthLockouts(ctx, 0, 1, model.AuthLockoutFilter{
+		Ids:      ids,
+		LockedAt: &now,
+	})
+	if err != nil {
+		return errors.Wrap(err, "db get auth lockouts error")
+	}
+
+	if len(locked) > 0 {
+		log.FromContext(ctx).Warnf("auth request rejected, %s locked out until %s",
+			locked[0].Id, locked[0].LockedUntil)
+		return ErrAuthLockedOut
+	}
+
+	return nil
+}
+
+// RecordAuthFailure counts a failed signature verification of the auth
+// request, for the device identity and the source IP, and locks them out
+// once failing too often. Returns ErrAuthLockedOut if locked out.
+func (d *DevAuth) RecordAuthFailure(ctx context.Context, r *model.AuthReq) error {
+	if d.config.AuthLockoutThreshold <= 0 {
+		return nil
+	}
+
+	// failures are tracked in the tenant DB, only for verified tenants
+	if d.verifyTenant {
+		tctx, err := d.verifyTenantToken(ctx, r.TenantToken)
+		if err != nil {
+			log.FromContext(ctx).Warnf("failed auth request not tracked: %v", err)
+			return nil
+		}
+		ctx = tctx
+	}
+
+	// malformed identity data is not tracked, see authLockouts
+	if idData, err := model.CanonicalIdData(r.IdData,
+		d.config.IdDataNormalizers); err == nil {
+		r.IdData = idData
+	}
+
+	return d.recordAuthFailure(ctx, r)
+}
+
+// recordAuthFailure counts the failure of an auth request of a verified
+// tenant (see RecordAuthFailure).
+func (d *DevAuth) recordAuthFailure(ctx context.Context, r *model.AuthReq) error {
+	if d.config.AuthLockoutThreshold <= 0 {
+		return nil
+	}
+
+	l := log.FromContext(ctx)
+
+	now := time.Now().UTC()
+	window := time.Duration(d.config.AuthLockoutWindow) * time.Second
+	duration := time.Duration(d.config.AuthLockoutDuration) * time.Second
+
+	locked := false
+	for _, lockout := range authLockouts(r) {
+		lockout.LastFailureTs = now
+		// long enough for a lockout starting now
+		lockout.ExpiresAt = now.Add(window + duration)
+
+		res, err := d.db.AddAuthFailure(ctx, *lockout, now.Add(-window))
+		if err != nil {
+			return errors.Wrap(err, "db add auth failure error")
+		}
+
+		if res.IsLocked(now) {
+			locked = true
+			continue
+		}
+
+		if res.Failures >= d.config.AuthLockoutThreshold {
+			l.Warnf("%s locked out after %d failed auth requests",
+				res.Id, res.Failures)
+			err := d.db.SetAuthLockedUntil(ctx, res.Id, now.Add(duration))
+			if err != nil && err != store.ErrAuthLockoutNotFound {
+				return errors.Wrap(err, "db set auth lockout error")
+			}
+			locked = true
+		}
+	}
+
+	if locked {
+		return ErrAuthLockedOut
+	}
+	return nil
+}
+
+func (d *DevAuth) GetAuthLockouts(ctx context.Context, skip, limit uint, filter model.AuthLockoutFilter) ([]model.AuthLockout, error) {
+	lockouts, err := d.db.GetAuthLockouts(ctx, skip, limit, filter)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get auth lockouts")
+	}
+	return lockouts, nil
+}
+
+// DeleteAuthLockout lifts the lockout, and resets the failure count.
+func (d *DevAuth) DeleteAuthLockout(ctx context.Context, id string) error {
+	err := d.db.DeleteAuthLockout(ctx, id)
+	switch err {
+	case nil:
+		return nil
+	case store.ErrAuthLockoutNotFound:
+		return ErrAuthLockoutNotFound
+	default:
+		return errors.Wrap(err, "failed to delete auth lockout")
+	}
+}
+
+// getReusableToken returns the latest unexpired token of the auth set for
+// the scope, if any, and whether it's valid for at least
+// TokenReuseMinLifetime
+func (d *DevAuth) getReusableToken(ctx context.Context, authSet *model.AuthSet, scope string) (*model.Token, bool, error) {
+	toks, err := d.db.GetTokens(ctx, 0, 1, model.TokenFilter{
+		DevId:     authSet.DeviceId,
+		AuthSetId: authSet.Id,
+	})
+	if err != nil {
+		return nil, false, errors.Wrap(err, "db get tokens error")
+	}
+
+	if len(toks) == 0 || toks[0].Scope != scope {
+		return nil, false, nil
+	}
+	if toks[0].ExpiresAt == nil || toks[0].Token == "" {
+		return &toks[0], false, nil
+	}
+
+	minExpiresAt := time.Now().Add(time.Duration(d.config.TokenReuseMinLifetime) * time.Second)
+	if toks[0].ExpiresAt.Before(minExpiresAt) {
+		return &toks[0], false, nil
+	}
+
+	return &toks[0], true, nil
+}
+
+// pruneDeviceTokens deletes the oldest unexpired device tokens exceeding
+// maxTokens, never the token with id 'keep' - the one just issued, which
+// may sort after older tokens issued in the same second
+func (d *DevAuth) pruneDeviceTokens(ctx context.Context, devId, keep string, maxTokens uint) error {
+	toks, err := d.db.GetTokens(ctx, 0, 0,
+		model.TokenFilter{DevId: devId})
+	if err != nil {
+		return errors.Wrap(err, "db get tokens error")
+	}
+
+	// newest first, the kept token counts in
+	kept := uint(1)
+	for _, tok := range toks {
+		if tok.Id == keep {
+			continue
+		}
+		if kept < maxTokens {
+			kept++
+			continue
+		}
+
+		if err := d.deleteDeviceToken(ctx, tok.Id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteDeviceToken revokes the token, if not deleted already
+func (d *DevAuth) deleteDeviceToken(ctx context.Context, jti string) error {
+	err := d.db.DeleteToken(ctx, jti)
+	if err != nil && err != store.ErrTokenNotFound {
+		return errors.Wrap(err, "db delete token error")
+	}
+	if d.cache != nil {
+		d.cache.InvalidateToken(jti)
+	}
+	return nil
+}
+
+func (d *DevAuth) processPreAuthRequest(ctx context.Context, r *model.AuthReq) (*model.AuthSet, error) {
 	_, idDataSha256, err := parseIdData(r.IdData)
 	if err != nil {
 		return nil, MakeErrDevAuthBadRequest(err)
@@ -339,12 +910,95 @@ func (d *DevAuth) processPreAuthRequest(ctx context.Context, r *model.AuthReq) (
 		return nil, nil
 	}
 
+	if err := d.autoAcceptAuthSet(ctx, aset); err != nil {
+		return nil, err
+	}
+
+	return aset, nil
+}
+
+// processCertAuthRequest records the auth set of a device authenticated with
+// a trusted certificate (see verifyCertChain) and accepts it, unless it has
+// been explicitly rejected
+func (d *DevAuth) processCertAuthRequest(ctx context.Context, r *model.AuthReq) (*model.AuthSet, error) {
+	aset, err := d.processAuthRequest(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if aset.Status != model.DevStatusPending {
+		return aset, nil
+	}
+
+	// e.g. when a device is issued a new certificate
+	if err := d.rejectAcceptedAuthSets(ctx, aset.DeviceId); err != nil {
+		return nil, err
+	}
+
+	if err := d.autoAcceptAuthSet(ctx, aset); err != nil {
+		return nil, err
+	}
+
+	return aset, nil
+}
+
+// rejectAcceptedAuthSets rejects the accepted and preauthorized auth sets of
+// the device before another one is accepted automatically, as with a manual
+// accept
+func (d *DevAuth) rejectAcceptedAuthSets(ctx context.Context, devId string) error {
+	if err := d.db.UpdateAuthSet(ctx,
+		bson.M{
+			model.AuthSetKeyDeviceId: devId,
+			"$or": []bson.M{
+				bson.M{model.AuthSetKeyStatus: model.DevStatusAccepted},
+				bson.M{model.AuthSetKeyStatus: model.DevStatusPreauth},
+			},
+		},
+		model.AuthSetUpdate{
+			Status: model.DevStatusRejected,
+		}); err != nil && err != store.ErrAuthSetNotFound {
+		return errors.Wrap(err, "failed to reject auth sets")
+	}
+	d.invalidateDevice(devId)
+
+	return nil
+}
+
+// verifyCertChain checks the device certificate chain against the (tenant's)
+// trusted CAs
+func (d *DevAuth) verifyCertChain(ctx context.Context, chain []*x509.Certificate) error {
+	cas, err := d.db.GetTrustedCAs(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch trusted CAs")
+	}
+
+	roots := make([]*x509.Certificate, 0, len(cas))
+	for _, ca := range cas {
+		certs, err := utils.ParseCertChain(ca.Certificate)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse trusted CA %s", ca.Id)
+		}
+		roots = append(roots, certs[0])
+	}
+
+	if err := utils.VerifyCertChain(chain, roots); err != nil {
+		return MakeErrDevAuthUnauthorized(err)
+	}
+
+	return nil
+}
+
+// autoAcceptAuthSet accepts the auth set without user intervention,
+// provisioning the device if it wasn't accepted before
+func (d *DevAuth) autoAcceptAuthSet(ctx context.Context, aset *model.AuthSet) error {
+	var deviceAlreadyAccepted bool
+
 	// check the device status
 	// if the device status is accepted then do not trigger provisioning workflow
 	// this needs to be checked before changing authentication set status
 	dev, err := d.db.GetDeviceById(ctx, aset.DeviceId)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	if dev.Status == model.DevStatusAccepted {
@@ -354,11 +1008,11 @@ func (d *DevAuth) processPreAuthRequest(ctx context.Context, r *model.AuthReq) (
 	// auth set is ok for auto-accepting, check device limit
 	allow, err := d.canAcceptDevice(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	if !allow {
-		return nil, ErrMaxDeviceCountReached
+		return ErrMaxDeviceCountReached
 	}
 
 	if !deviceAlreadyAccepted {
@@ -374,7 +1028,7 @@ func (d *DevAuth) processPreAuthRequest(ctx context.Context, r *model.AuthReq) (
 					Id: aset.DeviceId,
 				},
 			}); err != nil {
-			return nil, errors.Wrap(err, "submit device provisioning job error")
+			return errors.Wrap(err, "submit device provisioning job error")
 		}
 	}
 
@@ -382,15 +1036,15 @@ func (d *DevAuth) processPreAuthRequest(ctx context.Context, r *model.AuthReq) (
 	if err := d.db.UpdateAuthSetById(ctx, aset.Id, model.AuthSetUpdate{
 		Status: model.DevStatusAccepted,
 	}); err != nil {
-		return nil, errors.Wrap(err, "failed to update auth set status")
+		return errors.Wrap(err, "failed to update auth set status")
 	}
 
 	if err := d.updateDeviceStatus(ctx, aset.DeviceId, model.DevStatusAccepted); err != nil {
-		return nil, err
+		return err
 	}
 
 	aset.Status = model.DevStatusAccepted
-	return aset, nil
+	return nil
 }
 
 func (d *DevAuth) updateDeviceStatus(ctx context.Context, devId, status string) error {
@@ -452,6 +1106,7 @@ func (d *DevAuth) processAuthRequest(ctx context.Context, r *model.AuthReq) (*mo
 	if err != nil && err != store.ErrObjectExists {
 		return nil, err
 	}
+	created := err == nil
 
 	// update the device status
 	if err := d.updateDeviceStatus(ctx, dev.Id, ""); err != nil {
@@ -466,6 +1121,28 @@ func (d *DevAuth) processAuthRequest(ctx context.Context, r *model.AuthReq) (*mo
 		return nil, errors.New("failed to locate device auth set")
 	}
 
+	// a device regenerating its key on every boot piles up auth sets
+	if created {
+		if err := d.evictAuthSets(ctx, dev.Id, areq.Id); err != nil {
+			return nil, err
+		}
+	}
+
+	// admission rules decide on the pending auth sets
+	if areq.Status == model.DevStatusPending {
+		if err := d.applyAdmissionRules(ctx, idDataStruct, r, areq); err != nil {
+			return nil, err
+		}
+	}
+
+	// the webhook is asked once, about the new auth sets left pending by
+	// the rules
+	if created && areq.Status == model.DevStatusPending {
+		if err := d.applyAdmissionWebhook(ctx, idDataStruct, r, areq); err != nil {
+			return nil, err
+		}
+	}
+
 	return areq, nil
 }
 
@@ -540,6 +1217,7 @@ func (d *DevAuth) DecommissionDevice(ctx context.Context, devId string) error {
 	if err := d.db.DeleteTokenByDevId(ctx, devId); err != nil && err != store.ErrTokenNotFound {
 		return errors.Wrap(err, "db delete device tokens error")
 	}
+	d.invalidateDevice(devId)
 
 	// delete device
 	return d.db.DeleteDevice(ctx, devId)
@@ -566,12 +1244,16 @@ func (d *DevAuth) DeleteAuthSet(ctx context.Context, devId string, authId string
 		if err := d.db.DeleteTokenByDevId(ctx, devId); err != nil && err != store.ErrTokenNotFound {
 			return errors.Wrap(err, "db delete device tokens error")
 		}
+		d.invalidateDevice(devId)
 	}
 
 	// delete device authorization set
 	if err := d.db.DeleteAuthSetForDevice(ctx, devId, authId); err != nil {
 		return err
 	}
+	if d.cache != nil {
+		d.cache.InvalidateAuthSet(authId)
+	}
 
 	// only delete the device if the set is 'preauthorized'
 	// otherwise device data may live in other services too, and is a case for decommissioning
@@ -698,6 +1380,10 @@ func (d *DevAuth) setAuthSetStatus(ctx context.Context, device_id string, auth_i
 		return errors.Wrap(err, "db update device auth set error")
 	}
 
+	// drop cached verification results only once the auth sets are
+	// updated, so that the old statuses don't get cached again
+	defer d.invalidateDevice(device_id)
+
 	if status == model.DevStatusAccepted {
 		return d.updateDeviceStatus(ctx, device_id, status)
 	} else {
@@ -713,6 +1399,19 @@ func (d *DevAuth) ResetDeviceAuth(ctx context.Context, device_id string, auth_id
 	return d.setAuthSetStatus(ctx, device_id, auth_id, model.DevStatusPending)
 }
 
+// canonicalizeIdData normalizes the identity data, as configured, and
+// re-encodes it in the canonical form the identity hash is computed of.
+func (d *DevAuth) canonicalizeIdData(idData *string) error {
+	canonical, err := model.CanonicalIdData(*idData, d.config.IdDataNormalizers)
+	if err != nil {
+		return MakeErrDevAuthBadRequest(
+			errors.Wrapf(err, "failed to parse identity data: %s", *idData))
+	}
+
+	*idData = canonical
+	return nil
+}
+
 func parseIdData(idData string) (map[string]interface{}, []byte, error) {
 	var idDataStruct map[string]interface{}
 	var idDataSha256 []byte
@@ -730,6 +1429,10 @@ func parseIdData(idData string) (map[string]interface{}, []byte, error) {
 }
 
 func (d *DevAuth) PreauthorizeDevice(ctx context.Context, req *model.PreAuthReq) error {
+	if err := d.canonicalizeIdData(&req.IdData); err != nil {
+		return err
+	}
+
 	// try add device, if a device with the given id_data exists -
 	// the unique index on id_data will prevent it (conflict)
 	// this is the only safeguard against id data conflict - we won't try to handle it
@@ -744,19 +1447,13 @@ func (d *DevAuth) PreauthorizeDevice(ctx context.Context, req *model.PreAuthReq)
 		return MakeErrDevAuthBadRequest(err)
 	}
 
+	if err := d.validateIdData(ctx, idDataStruct); err != nil {
+		return err
+	}
+
 	dev.IdDataStruct = idDataStruct
 	dev.IdDataSha256 = idDataSha256
 
-	err = d.db.AddDevice(ctx, *dev)
-	switch err {
-	case nil:
-		break
-	case store.ErrObjectExists:
-		return ErrDeviceExists
-	default:
-		return errors.Wrap(err, "failed to add device")
-	}
-
 	// record authentication request
 	authset := model.AuthSet{
 		Id:           req.AuthSetId,
@@ -769,6 +1466,22 @@ func (d *DevAuth) PreauthorizeDevice(ctx context.Context, req *model.PreAuthReq)
 		Timestamp:    uto.TimePtr(time.Now()),
 	}
 
+	if len(req.PSK) > 0 {
+		if err := d.encryptPSK(&authset, req.PSK); err != nil {
+			return err
+		}
+	}
+
+	err = d.db.AddDevice(ctx, *dev)
+	switch err {
+	case nil:
+		break
+	case store.ErrObjectExists:
+		return ErrDeviceExists
+	default:
+		return errors.Wrap(err, "failed to add device")
+	}
+
 	err = d.db.AddAuthSet(ctx, authset)
 	switch err {
 	case nil:
@@ -780,8 +1493,39 @@ func (d *DevAuth) PreauthorizeDevice(ctx context.Context, req *model.PreAuthReq)
 	}
 }
 
-func (*DevAuth) GetDeviceToken(ctx context.Context, dev_id string) (*model.Token, error) {
-	return nil, errors.New("not implemented")
+// GetDeviceToken returns the most recently issued, unexpired token of the
+// device
+func (d *DevAuth) GetDeviceToken(ctx context.Context, dev_id string) (*model.Token, error) {
+	toks, err := d.db.GetTokens(ctx, 0, 1, model.TokenFilter{DevId: dev_id})
+	if err != nil {
+		return nil, errors.Wrap(err, "db get tokens error")
+	}
+
+	if len(toks) == 0 {
+		return nil, store.ErrTokenNotFound
+	}
+
+	return &toks[0], nil
+}
+
+func (d *DevAuth) GetDeviceTokens(ctx context.Context, dev_id string, skip, limit uint,
+	filter model.TokenFilter) ([]model.Token, error) {
+
+	if _, err := d.db.GetDeviceById(ctx, dev_id); err != nil {
+		if err != store.ErrDevNotFound {
+			return nil, errors.Wrap(err, "db get device by id error")
+		}
+		return nil, err
+	}
+
+	filter.DevId = dev_id
+
+	toks, err := d.db.GetTokens(ctx, skip, limit, filter)
+	if err != nil {
+		return nil, errors.Wrap(err, "db get tokens error")
+	}
+
+	return toks, nil
 }
 
 func (d *DevAuth) RevokeToken(ctx context.Context, token_id string) error {
@@ -790,7 +1534,41 @@ func (d *DevAuth) RevokeToken(ctx context.Context, token_id string) error {
 
 	l.Warnf("Revoke token with jti: %s", token_id)
 
-	return d.db.DeleteToken(ctx, token_id)
+	err := d.db.DeleteToken(ctx, token_id)
+	if d.cache != nil {
+		d.cache.InvalidateToken(token_id)
+	}
+
+	return err
+}
+
+func (d *DevAuth) RevokeDeviceTokens(ctx context.Context, dev_id string) error {
+	l := log.FromContext(ctx)
+
+	if _, err := d.db.GetDeviceById(ctx, dev_id); err != nil {
+		if err != store.ErrDevNotFound {
+			return errors.Wrap(err, "db get device by id error")
+		}
+		return err
+	}
+
+	l.Warnf("Revoke all tokens of device: %s", dev_id)
+
+	err := d.db.DeleteTokenByDevId(ctx, dev_id)
+	if err != nil && err != store.ErrTokenNotFound {
+		return errors.Wrap(err, "db delete device tokens error")
+	}
+	d.invalidateDevice(dev_id)
+
+	return nil
+}
+
+// invalidateDevice drops the cached verification results for the device
+// tokens
+func (d *DevAuth) invalidateDevice(devId string) {
+	if d.cache != nil {
+		d.cache.InvalidateDevice(devId)
+	}
 }
 
 func verifyTenantClaim(ctx context.Context, verifyTenant bool, tenant string) error {
@@ -810,38 +1588,151 @@ func verifyTenantClaim(ctx context.Context, verifyTenant bool, tenant string) er
 	return nil
 }
 
-func (d *DevAuth) VerifyToken(ctx context.Context, raw string) error {
+// VerifyToken verifies the token; if `scope` is not empty, the token must be
+// valid for the scope, and if `audiences` are given, it must have been issued
+// for one of them.
+func (d *DevAuth) VerifyToken(ctx context.Context, raw string, scope string, audiences []string) error {
+	token, _, _, err := d.verifyToken(ctx, raw)
+	if err != nil {
+		return err
+	}
+
+	if len(audiences) > 0 && !token.Claims.HasAudience(audiences...) {
+		log.FromContext(ctx).Errorf("Token %s issued for audience %q, not accepted",
+			token.Claims.ID, token.Claims.Audience)
+		return ErrTokenAudienceMismatch
+	}
+
+	if scope != "" && !token.Claims.HasScope(scope) {
+		log.FromContext(ctx).Errorf("Token %s lacks scope %s",
+			token.Claims.ID, scope)
+		return ErrTokenScopeMissing
+	}
+
+	return nil
+}
+
+// IntrospectToken runs the same checks as VerifyToken and describes the
+// token. Tokens failing the checks are reported as not active; an error is
+// returned only if the checks could not be completed.
+func (d *DevAuth) IntrospectToken(ctx context.Context, raw string) (*model.TokenIntrospection, error) {
+	token, auth, dev, err := d.verifyToken(ctx, raw)
+	switch err {
+	case nil:
+	case jwt.ErrTokenExpired, jwt.ErrTokenInvalid, store.ErrTokenNotFound,
+		store.ErrAuthSetNotFound, store.ErrDevNotFound:
+		return &model.TokenIntrospection{Active: false}, nil
+	default:
+		return nil, err
+	}
+
+	return &model.TokenIntrospection{
+		Active:       true,
+		Subject:      token.Claims.Subject,
+		Tenant:       token.Claims.Tenant,
+		ExpiresAt:    token.Claims.ExpiresAt,
+		IssuedAt:     token.Claims.IssuedAt,
+		Audience:     token.Claims.Audience,
+		ID:           token.Claims.ID,
+		Scope:        token.Claims.Scope,
+		AuthSetId:    auth.Id,
+		DeviceStatus: dev.Status,
+	}, nil
+}
+
+// verifyToken checks the token signature and claims, that it was not
+// revoked, that its auth set is accepted and that the device is not being
+// decommissioned.
+func (d *DevAuth) verifyToken(ctx context.Context, raw string) (*jwt.Token, *model.AuthSet, *model.Device, error) {
 
 	l := log.FromContext(ctx)
 
+	h, err := d.verificationHandler(ctx, raw)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
 	token := &jwt.Token{}
 
-	err := token.UnmarshalJWT([]byte(raw), d.jwt.FromJWT)
+	err = token.UnmarshalJWT([]byte(raw), h.FromJWT)
 	jti := token.Claims.ID
 	if err != nil {
 		if err == jwt.ErrTokenExpired && jti != "" {
 			l.Errorf("Token %s expired: %v", jti, err)
+			// the token can still be renewed
+			if d.inRenewalGracePeriod(token) {
+				return nil, nil, nil, jwt.ErrTokenExpired
+			}
 			err := d.db.DeleteToken(ctx, jti)
 			if err == store.ErrTokenNotFound {
 				l.Errorf("Token %s not found", jti)
-				return err
+				return nil, nil, nil, err
 			}
 			if err != nil {
-				return errors.Wrapf(err, "Cannot delete token with jti: %s : %s", jti, err)
+				return nil, nil, nil, errors.Wrapf(err, "Cannot delete token with jti: %s : %s", jti, err)
 			}
-			return jwt.ErrTokenExpired
+			return nil, nil, nil, jwt.ErrTokenExpired
 		}
 		l.Errorf("Token %s invalid: %v", jti, err)
-		return jwt.ErrTokenInvalid
+		return nil, nil, nil, jwt.ErrTokenInvalid
 	}
 
+	if err := d.verifyTokenClaims(ctx, token); err != nil {
+		return nil, nil, nil, err
+	}
+
+	var gen uint64
+	if d.cache != nil {
+		if entry, ok := d.cache.Get(jti); ok {
+			return token, entry.AuthSet, entry.Device, nil
+		}
+		gen = d.cache.Generation()
+	}
+
+	auth, dev, err := d.verifyTokenState(ctx, token)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if d.cache != nil {
+		d.cache.Put(gen, cache.VerificationEntry{
+			Token:   token,
+			AuthSet: auth,
+			Device:  dev,
+		})
+	}
+
+	return token, auth, dev, nil
+}
+
+// verifyTokenClaims checks that the token was issued to a device, and its
+// tenant claim.
+func (d *DevAuth) verifyTokenClaims(ctx context.Context, token *jwt.Token) error {
 	if token.Claims.Device != true {
-		l.Errorf("not a device token")
+		log.FromContext(ctx).Errorf("not a device token")
 		return jwt.ErrTokenInvalid
 	}
 
-	if err := verifyTenantClaim(ctx, d.verifyTenant, token.Claims.Tenant); err != nil {
-		return err
+	return verifyTenantClaim(ctx, d.verifyTenant, token.Claims.Tenant)
+}
+
+// verifyTokenState checks the token audience, that the token was not
+// revoked, that its auth set is accepted and that the device is not being
+// decommissioned.
+func (d *DevAuth) verifyTokenState(ctx context.Context, token *jwt.Token) (*model.AuthSet, *model.Device, error) {
+
+	l := log.FromContext(ctx)
+
+	jti := token.Claims.ID
+
+	aud, err := d.tokenAudience(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if aud != "" && !token.Claims.HasAudience(aud) {
+		l.Errorf("Token %s issued for audience %q, expected %q",
+			jti, token.Claims.Audience, aud)
+		return nil, nil, jwt.ErrTokenInvalid
 	}
 
 	// check if token is in the system
@@ -849,9 +1740,9 @@ func (d *DevAuth) VerifyToken(ctx context.Context, raw string) error {
 	if err != nil {
 		if err == store.ErrTokenNotFound {
 			l.Errorf("Token %s not found", jti)
-			return err
+			return nil, nil, err
 		}
-		return errors.Wrapf(err, "Cannot get token with id: %s from database: %s", jti, err)
+		return nil, nil, errors.Wrapf(err, "Cannot get token with id: %s from database: %s", jti, err)
 	}
 
 	auth, err := d.db.GetAuthSetById(ctx, tok.AuthSetId)
@@ -859,27 +1750,122 @@ func (d *DevAuth) VerifyToken(ctx context.Context, raw string) error {
 		if err == store.ErrTokenNotFound {
 			l.Errorf("Token %s auth set %s not found",
 				jti, tok.AuthSetId)
-			return err
+			return nil, nil, err
 		}
-		return err
+		return nil, nil, err
 	}
 
 	if auth.Status != model.DevStatusAccepted {
-		return jwt.ErrTokenInvalid
+		return nil, nil, jwt.ErrTokenInvalid
 	}
 
 	// reject authentication for device that is in the process of
 	// decommissioning
 	dev, err := d.db.GetDeviceById(ctx, auth.DeviceId)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	if dev.Decommissioning {
 		l.Errorf("Token %s rejected, device %s is being decommissioned", jti, auth.DeviceId)
-		return jwt.ErrTokenInvalid
+		return nil, nil, jwt.ErrTokenInvalid
 	}
 
-	return nil
+	return auth, dev, nil
+}
+
+// inRenewalGracePeriod checks if the expired token can still be renewed.
+func (d *DevAuth) inRenewalGracePeriod(token *jwt.Token) bool {
+	return time.Now().Unix() < token.Claims.ExpiresAt+d.config.TokenRenewalGracePeriod
+}
+
+// RenewToken issues a new token in exchange for a valid, or recently
+// expired, token of the device. The request must be signed with the key of
+// the token's auth set; the old token is revoked, and can't be renewed
+// again.
+func (d *DevAuth) RenewToken(ctx context.Context, r *model.TokenRenewReq) (string, error) {
+	l := log.FromContext(ctx)
+
+	h, err := d.verificationHandler(ctx, r.Token)
+	if err != nil {
+		return "", err
+	}
+
+	token := &jwt.Token{}
+	err = token.UnmarshalJWT([]byte(r.Token), h.FromJWT)
+	switch {
+	case err == nil:
+	case err == jwt.ErrTokenExpired && d.inRenewalGracePeriod(token):
+	case err == jwt.ErrTokenExpired:
+		return "", MakeErrDevAuthUnauthorized(err)
+	default:
+		l.Errorf("Token invalid: %v", err)
+		return "", MakeErrDevAuthUnauthorized(jwt.ErrTokenInvalid)
+	}
+
+	if err := d.verifyTokenClaims(ctx, token); err != nil {
+		return "", MakeErrDevAuthUnauthorized(err)
+	}
+
+	// the token is not necessarily presented in the Authorization header
+	ctx = identity.WithContext(ctx, &identity.Identity{
+		Subject:  token.Claims.Subject,
+		Tenant:   token.Claims.Tenant,
+		IsDevice: true,
+	})
+
+	jti := token.Claims.ID
+	authSet, _, err := d.verifyTokenState(ctx, token)
+	switch err {
+	case nil:
+	case jwt.ErrTokenInvalid, store.ErrTokenNotFound,
+		store.ErrAuthSetNotFound, store.ErrDevNotFound:
+		return "", MakeErrDevAuthUnauthorized(err)
+	default:
+		return "", err
+	}
+
+	if authSet.IsPSK() {
+		if err := d.verifyPSKSignature(authSet, r.Signature, r.Body); err != nil {
+			return "", err
+		}
+	} else {
+		key, err := utils.ParsePubKey(authSet.PubKey)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to parse auth set key")
+		}
+		if err := utils.VerifyAuthReqSign(r.Signature, key, r.Body); err != nil {
+			return "", MakeErrDevAuthUnauthorized(err)
+		}
+	}
+
+	// revoke the old token first, only one of concurrent renewals may
+	// succeed
+	err = d.db.DeleteToken(ctx, jti)
+	switch err {
+	case nil:
+	case store.ErrTokenNotFound:
+		return "", MakeErrDevAuthUnauthorized(err)
+	default:
+		return "", errors.Wrapf(err, "db delete token error")
+	}
+	if d.cache != nil {
+		d.cache.InvalidateToken(jti)
+	}
+
+	policy, err := d.tokenPolicy(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	newToken, err := d.issueToken(ctx, authSet, token.Claims.Scope, policy)
+	if err != nil {
+		return "", err
+	}
+
+	l.Infof("Token %v renewed as %v for device %v auth set %v",
+		jti, newToken.Id, authSet.DeviceId, authSet.Id)
+
+	return newToken.Token, nil
 }
 
 func (d *DevAuth) GetLimit(ctx context.Context, name string) (*model.Limit, error) {
@@ -915,6 +1901,24 @@ func (d *DevAuth) WithTenantVerification(c tenant.ClientRunner) *DevAuth {
 	return d
 }
 
+// WithVerificationCache enables caching of positive token verification
+// results.
+func (d *DevAuth) WithVerificationCache(c *cache.VerificationCache) *DevAuth {
+	d.cache = c
+	return d
+}
+
+// GetVerificationCacheStats returns the counters of the verification cache
+// of the instance.
+func (d *DevAuth) GetVerificationCacheStats(ctx context.Context) (*cache.Stats, error) {
+	if d.cache == nil {
+		return nil, ErrVerificationCacheDisabled
+	}
+
+	stats := d.cache.Stats()
+	return &stats, nil
+}
+
 func (d *DevAuth) SetTenantLimit(ctx context.Context, tenant_id string, limit model.Limit) error {
 	l := log.FromContext(ctx)
 
@@ -933,6 +1937,40 @@ func (d *DevAuth) SetTenantLimit(ctx context.Context, tenant_id string, limit mo
 	return nil
 }
 
+func (d *DevAuth) SetTenantTokenPolicy(ctx context.Context, tenant_id string, policy model.TokenPolicy) error {
+	l := log.FromContext(ctx)
+
+	ctx = identity.WithContext(ctx, &identity.Identity{
+		Tenant: tenant_id,
+	})
+
+	l.Infof("setting token policy %+v for tenant %v", policy, tenant_id)
+
+	if err := d.db.PutTokenPolicy(ctx, policy); err != nil {
+		return errors.Wrapf(err, "failed to save token policy for tenant %v to database",
+			tenant_id)
+	}
+
+	// cached verifications don't reflect the new audience
+	if d.cache != nil {
+		d.cache.InvalidateTenant(tenant_id)
+	}
+	return nil
+}
+
+func (d *DevAuth) GetTenantTokenPolicy(ctx context.Context, tenant_id string) (*model.TokenPolicy, error) {
+	ctx = identity.WithContext(ctx, &identity.Identity{
+		Tenant: tenant_id,
+	})
+
+	policy, err := d.db.GetTokenPolicy(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get token policy for tenant %v",
+			tenant_id)
+	}
+	return policy, nil
+}
+
 func (d *DevAuth) GetDevCountByStatus(ctx context.Context, status string) (int, error) {
 	return d.db.GetDevCountByStatus(ctx, status)
 }
@@ -969,8 +2007,12 @@ func (d *DevAuth) DeleteTokens(ctx context.Context, tenant_id, device_id string)
 
 	if device_id != "" {
 		err = d.db.DeleteTokenByDevId(ctx, device_id)
+		d.invalidateDevice(device_id)
 	} else {
 		err = d.db.DeleteTokens(ctx)
+		if d.cache != nil {
+			d.cache.InvalidateTenant(tenant_id)
+		}
 	}
 
 	if err != nil && err != store.ErrTokenNotFound {
@@ -987,7 +2029,15 @@ func (d *DevAuth) ProvisionTenant(ctx context.Context, tenant_id string) error {
 
 	dbname := mstore.DbFromContext(tenantCtx, mongo.DbName)
 
-	return d.db.WithAutomigrate().MigrateTenant(ctx, dbname, mongo.DbVersion)
+	err := d.db.WithAutomigrate().MigrateTenant(ctx, dbname, mongo.DbVersion)
+	if err != nil {
+		return err
+	}
+
+	if d.tenantKeys != nil {
+		return d.provisionTenantKey(tenantCtx, tenant_id)
+	}
+	return nil
 }
 
 func (d *DevAuth) GetTenantDeviceStatus(ctx context.Context, tenantId, deviceId string) (*model.Status, error) {
@@ -1006,3 +2056,60 @@ func (d *DevAuth) GetTenantDeviceStatus(ctx context.Context, tenantId, deviceId
 
 	}
 }
+
+// GetJWKS returns the public keys accepted for device token verification,
+// so that other services can validate device tokens offline.
+func (d *DevAuth) GetJWKS(ctx context.Context) (*jwt.JWKS, error) {
+	jwks, err := d.jwt.JWKS()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build JWKS")
+	}
+	return jwks, nil
+}
+
+func (d *DevAuth) AddTrustedCA(ctx context.Context, ca *model.TrustedCA) error {
+	err := d.db.AddTrustedCA(ctx, *ca)
+	switch err {
+	case nil:
+		return nil
+	case store.ErrObjectExists:
+		return ErrTrustedCAExists
+	default:
+		return errors.Wrap(err, "failed to add trusted CA")
+	}
+}
+
+func (d *DevAuth) GetTrustedCAs(ctx context.Context) ([]model.TrustedCA, error) {
+	cas, err := d.db.GetTrustedCAs(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list trusted CAs")
+	}
+	return cas, nil
+}
+
+func (d *DevAuth) DeleteTrustedCA(ctx context.Context, id string) error {
+	err := d.db.DeleteTrustedCA(ctx, id)
+	switch err {
+	case nil:
+		return nil
+	case store.ErrTrustedCANotFound:
+		return ErrTrustedCANotFound
+	default:
+		return errors.Wrap(err, "failed to delete trusted CA")
+	}
+}
+
+func (d *DevAuth) GetTokenScopes(ctx context.Context) (*model.TokenScopes, error) {
+	scopes, err := d.db.GetTokenScopes(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get token scopes")
+	}
+	return scopes, nil
+}
+
+func (d *DevAuth) SetTokenScopes(ctx context.Context, scopes model.TokenScopes) error {
+	if err := d.db.PutTokenScopes(ctx, scopes); err != nil {
+		return errors.Wrap(err, "failed to set token scopes")
+	}
+	return nil
+}
@@ -15,10 +15,12 @@ package devauth
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/globalsign/mgo/bson"
@@ -27,6 +29,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
+	"github.com/mendersoftware/deviceauth/cache"
 	"github.com/mendersoftware/deviceauth/client/orchestrator"
 	morchestrator "github.com/mendersoftware/deviceauth/client/orchestrator/mocks"
 	mtenant "github.com/mendersoftware/deviceauth/client/tenant/mocks"
@@ -318,6 +321,10 @@ func TestDevAuthSubmitAuthRequest(t *testing.T) {
 			}
 
 			db := mstore.DataStore{}
+			db.On("GetLimit", ctxMatcher, model.LimitStrictAuthReqs).
+				Return(nil, store.ErrLimitNotFound)
+			db.On("GetIdentitySchema", ctxMatcher).
+				Return(nil, store.ErrIdentitySchemaNotFound)
 			db.On("AddDevice",
 				ctxMatcher,
 				mock.MatchedBy(
@@ -339,6 +346,10 @@ func TestDevAuthSubmitAuthRequest(t *testing.T) {
 					return nil
 				},
 				tc.getDevByIdErr)
+			db.On("GetAdmissionRules", ctxMatcher).
+				Return([]model.AdmissionRule{}, nil)
+			db.On("GetLimit", ctxMatcher, model.LimitMaxAuthSetsPerDevice).
+				Return(nil, store.ErrLimitNotFound)
 			db.On("AddAuthSet",
 				ctxMatcher,
 				mock.MatchedBy(
@@ -366,6 +377,8 @@ func TestDevAuthSubmitAuthRequest(t *testing.T) {
 				},
 				tc.getAuthSetErr)
 
+			db.On("GetTokenPolicy", ctxMatcher).
+				Return(&model.TokenPolicy{}, nil)
 			db.On("AddToken",
 				ctxMatcher,
 				mock.AnythingOfType("model.Token")).Return(nil)
@@ -413,395 +426,1926 @@ func TestDevAuthSubmitAuthRequest(t *testing.T) {
 }
 
 // still a Submit... test, but focuses on preauth
-func TestDevAuthSubmitAuthRequestPreauth(t *testing.T) {
-	idData := "{\"mac\":\"00:00:00:01\"}"
-	_, idDataSha256, err := parseIdData(idData)
-	assert.NoError(t, err)
-
-	inReq := model.AuthReq{
-		IdData:      idData,
-		PubKey:      "foo-pubkey",
-		TenantToken: "foo-tenant",
-	}
-
-	dummyDevId := "dummydevid"
-	dummyToken := "dummytoken"
+func TestDevAuthSubmitAuthRequestTokenReuse(t *testing.T) {
+	t.Parallel()
 
-	testCases := []struct {
-		desc string
+	pubKey := "dummy_pubkey"
+	idData := "{\"mac\":\"00:00:00:01\"}"
+	devId := "dummy_devid"
+	authId := "dummy_aid"
 
-		dbGetAuthSetByDataKeyRes *model.AuthSet
-		dbGetAuthSetByDataKeyErr error
+	_, idDataHash, err := parseIdData(idData)
+	assert.NoError(t, err)
 
-		dbGetLimitRes *model.Limit
-		dbGetLimitErr error
+	now := time.Now()
+	validToken := model.NewToken("jti1", devId, "validtoken").
+		WithValidity(now.Unix()-600, now.Unix()+3600)
+	validToken.AuthSetId = authId
+	expiringToken := model.NewToken("jti1", devId, "expiringtoken").
+		WithValidity(now.Unix()-3000, now.Unix()+600)
+	expiringToken.AuthSetId = authId
 
-		dbGetDevCountByStatusRes int
-		dbGetDevCountByStatusErr error
+	testCases := map[string]struct {
+		config Config
 
-		dev                *model.Device
-		dbGetDeviceByIdErr error
+		dbReusable    []model.Token
+		dbReusableErr error
 
-		coSubmitProvisionDeviceJobErr error
+		dbExcess    []model.Token
+		dbExcessErr error
+		// the new token is listed after dbExcess, issued in the same
+		// second
+		sameSecond bool
 
-		res string
-		err error
+		res     string
+		err     error
+		deleted []string
 	}{
-		{
-			desc: "ok: preauthorized set is auto-accepted",
-			dbGetAuthSetByDataKeyRes: &model.AuthSet{
-				IdDataSha256: idDataSha256,
-				DeviceId:     dummyDevId,
-				PubKey:       inReq.PubKey,
-				Status:       model.DevStatusPreauth,
-			},
-			dbGetLimitRes: &model.Limit{
-				Value: 5,
-			},
-			dbGetDevCountByStatusRes: 0,
-			dev: &model.Device{
-				Id:     dummyDevId,
-				Status: model.DevStatusPending,
-			},
-			res: dummyToken,
+		"ok, reuse": {
+			config:     Config{TokenReuseMinLifetime: 1800},
+			dbReusable: []model.Token{*validToken},
+			res:        "validtoken",
 		},
-		{
-			desc: "error: can't get an existing authset",
-			dbGetAuthSetByDataKeyErr: errors.New("db error"),
-			dev: &model.Device{
-				Id:     dummyDevId,
-				Status: model.DevStatusPending,
-			},
-			err: errors.New("failed to fetch auth set: db error"),
+		"ok, reuse, not enough lifetime left": {
+			config:     Config{TokenReuseMinLifetime: 1800},
+			dbReusable: []model.Token{*expiringToken},
+			res:        "dummytoken",
+			deleted:    []string{"jti1"},
 		},
-		{
-			desc: "error: preauthorized set would exceed limit",
-			dbGetAuthSetByDataKeyRes: &model.AuthSet{
-				IdDataSha256: idDataSha256,
-				DeviceId:     dummyDevId,
-				PubKey:       inReq.PubKey,
-				Status:       model.DevStatusPreauth,
-			},
-			dbGetLimitRes: &model.Limit{
-				Value: 5,
-			},
-			dbGetDevCountByStatusRes: 5,
-			dev: &model.Device{
-				Id:     dummyDevId,
-				Status: model.DevStatusPending,
+		"ok, reuse, rotated with a token limit": {
+			config: Config{
+				TokenReuseMinLifetime: 1800,
+				MaxDeviceTokens:       1,
 			},
-			err: ErrMaxDeviceCountReached,
+			dbReusable: []model.Token{*expiringToken},
+			sameSecond: true,
+			res:        "dummytoken",
+			deleted:    []string{"jti1"},
 		},
-		{
-			desc: "error: can't get device limit",
-			dbGetAuthSetByDataKeyRes: &model.AuthSet{
-				IdDataSha256: idDataSha256,
-				DeviceId:     dummyDevId,
-				PubKey:       inReq.PubKey,
-				Status:       model.DevStatusPreauth,
-			},
-			dbGetLimitErr: errors.New("db error"),
-			dev: &model.Device{
-				Id:     dummyDevId,
-				Status: model.DevStatusPending,
-			},
-			err: errors.New("can't get current device limit: db error"),
+		"ok, reuse, no tokens": {
+			config:     Config{TokenReuseMinLifetime: 1800},
+			dbReusable: []model.Token{},
+			res:        "dummytoken",
 		},
-		{
-			desc: "error: failed to submit job to conductor",
-			dbGetAuthSetByDataKeyRes: &model.AuthSet{
-				IdDataSha256: idDataSha256,
-				DeviceId:     dummyDevId,
-				PubKey:       inReq.PubKey,
-				Status:       model.DevStatusPreauth,
+		"ok, rotate": {
+			config: Config{MaxDeviceTokens: 1},
+			dbExcess: []model.Token{
+				*model.NewToken("jti2", devId, ""),
+				*model.NewToken("jti3", devId, ""),
 			},
-			dbGetLimitRes: &model.Limit{
-				Value: 5,
-			},
-			dbGetDevCountByStatusRes: 0,
-			dev: &model.Device{
-				Id:     dummyDevId,
-				Status: model.DevStatusPending,
-			},
-			coSubmitProvisionDeviceJobErr: errors.New("conductor failed"),
-			err: errors.New("submit device provisioning job error: conductor failed"),
+			res:     "dummytoken",
+			deleted: []string{"jti2", "jti3"},
 		},
-		{
-			desc: "ok: preauthorized set is auto-accepted, device was already accepted",
-			dbGetAuthSetByDataKeyRes: &model.AuthSet{
-				IdDataSha256: idDataSha256,
-				DeviceId:     dummyDevId,
-				PubKey:       inReq.PubKey,
-				Status:       model.DevStatusPreauth,
-			},
-			dbGetLimitRes: &model.Limit{
-				Value: 5,
-			},
-			dbGetDevCountByStatusRes: 0,
-			dev: &model.Device{
-				Id:     dummyDevId,
-				Status: model.DevStatusAccepted,
+		"ok, rotate, same second": {
+			config: Config{MaxDeviceTokens: 2},
+			dbExcess: []model.Token{
+				*model.NewToken("jti2", devId, ""),
+				*model.NewToken("jti3", devId, ""),
 			},
-			coSubmitProvisionDeviceJobErr: errors.New("conductor shouldn't be called"),
-			res: dummyToken,
+			sameSecond: true,
+			res:        "dummytoken",
+			deleted:    []string{"jti3"},
 		},
-		{
-			desc: "error: cannot get device status",
-			dbGetAuthSetByDataKeyRes: &model.AuthSet{
-				IdDataSha256: idDataSha256,
-				DeviceId:     dummyDevId,
-				PubKey:       inReq.PubKey,
-				Status:       model.DevStatusPreauth,
-			},
-			dbGetLimitRes: &model.Limit{
-				Value: 5,
-			},
-			dbGetDevCountByStatusRes: 0,
-			dbGetDeviceByIdErr:       errors.New("Get device failed"),
-			err:                      errors.New("Get device failed"),
+		"ok, rotate, cleanup failure": {
+			config:      Config{MaxDeviceTokens: 1},
+			dbExcessErr: errors.New("db error"),
+			res:         "dummytoken",
+		},
+		"error, reuse": {
+			config:        Config{TokenReuseMinLifetime: 1800},
+			dbReusableErr: errors.New("db error"),
+			err:           errors.New("db get tokens error: db error"),
 		},
 	}
 
-	for tcidx := range testCases {
-		tc := testCases[tcidx]
-		t.Run(fmt.Sprintf("tc: %s", tc.desc), func(t *testing.T) {
+	for n := range testCases {
+		tc := testCases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
 			t.Parallel()
 
-			ctx := context.Background()
+			ctxMatcher := mtesting.ContextMatcher()
 
-			// setup mocks
 			db := mstore.DataStore{}
-
-			// get the auth set to check if preauthorized
+			db.On("GetLimit", ctxMatcher, model.LimitStrictAuthReqs).
+				Return(nil, store.ErrLimitNotFound)
+			db.On("GetIdentitySchema", ctxMatcher).
+				Return(nil, store.ErrIdentitySchemaNotFound)
+			db.On("AddDevice",
+				ctxMatcher,
+				mock.AnythingOfType("model.Device")).Return(store.ErrObjectExists)
+			db.On("GetDeviceByIdentityDataHash",
+				ctxMatcher,
+				idDataHash).Return(&model.Device{
+				PubKey:       pubKey,
+				IdDataSha256: idDataHash,
+				Id:           devId,
+			}, nil)
 			db.On("GetAuthSetByIdDataHashKey",
-				ctx,
-				idDataSha256,
-				inReq.PubKey,
-			).Return(
-				tc.dbGetAuthSetByDataKeyRes,
-				tc.dbGetAuthSetByDataKeyErr,
-			)
-
-			// for a preauthorized set - check if we're not over the limit
-			db.On("GetLimit",
-				ctx,
-				model.LimitMaxDeviceCount,
-			).Return(
-				tc.dbGetLimitRes,
-				tc.dbGetLimitErr,
-			)
-
-			// takes part in limit checking
-			db.On("GetDevCountByStatus",
-				ctx,
-				model.DevStatusAccepted,
-			).Return(
-				tc.dbGetDevCountByStatusRes,
-				tc.dbGetDevCountByStatusErr,
-			)
-
-			// at the end of processing, updates the preauthorized set to 'accepted'
-			// just happy path, errors tested elsewhere
-			db.On("UpdateAuthSetById",
-				ctx,
-				mock.AnythingOfType("string"),
-				mock.MatchedBy(
-					func(u model.AuthSetUpdate) bool {
-						return u.Status == model.DevStatusAccepted
-					}),
-			).Return(nil)
-
-			// at the end of processing, updates the device status to 'accepted'
-			db.On("UpdateDevice",
-				ctx,
-				mock.MatchedBy(
-					func(m model.Device) bool {
-						return m.Id == dummyDevId
-
-					}),
-				mock.MatchedBy(
-					func(u model.DeviceUpdate) bool {
-						return u.Status == model.DevStatusAccepted
-					}),
-			).Return(nil)
-
-			// at the end of processing, saves the issued token
-			// only happy path, errors tested elsewhere
+				ctxMatcher,
+				idDataHash, pubKey).Return(&model.AuthSet{
+				Id:           authId,
+				DeviceId:     devId,
+				IdDataSha256: idDataHash,
+				PubKey:       pubKey,
+				Status:       model.DevStatusAccepted,
+			}, nil)
+			db.On("GetAdmissionRules", ctxMatcher).
+				Return([]model.AdmissionRule{}, nil)
+			db.On("AddAuthSet",
+				ctxMatcher,
+				mock.AnythingOfType("model.AuthSet")).Return(store.ErrObjectExists)
+			db.On("GetDeviceStatus", ctxMatcher, devId).
+				Return(model.DevStatusAccepted, nil)
+			db.On("UpdateDevice", ctxMatcher,
+				mock.AnythingOfType("model.Device"),
+				mock.AnythingOfType("model.DeviceUpdate")).Return(nil)
+			db.On("GetTokens", ctxMatcher, uint(0), uint(1),
+				model.TokenFilter{DevId: devId, AuthSetId: authId},
+			).Return(tc.dbReusable, tc.dbReusableErr)
+			var newToken model.Token
+			db.On("GetTokens", ctxMatcher, uint(0), uint(0),
+				model.TokenFilter{DevId: devId},
+			).Return(func(context.Context, uint, uint, model.TokenFilter) []model.Token {
+				if tc.sameSecond {
+					return append(tc.dbExcess, newToken)
+				}
+				return tc.dbExcess
+			}, tc.dbExcessErr)
+			db.On("GetTokenPolicy", ctxMatcher).
+				Return(&model.TokenPolicy{}, nil)
 			db.On("AddToken",
-				ctx,
-				mock.AnythingOfType("model.Token"),
-			).Return(nil)
-
-			db.On("GetDeviceById",
-				context.Background(), dummyDevId).Return(tc.dev, tc.dbGetDeviceByIdErr)
+				ctxMatcher,
+				mock.AnythingOfType("model.Token")).
+				Run(func(args mock.Arguments) {
+					newToken = args.Get(1).(model.Token)
+				}).
+				Return(nil)
+			db.On("DeleteToken",
+				ctxMatcher,
+				mock.AnythingOfType("string")).Return(nil)
 
-			// token serialization - happy path only, errors tested elsewhere
 			jwth := mjwt.Handler{}
 			jwth.On("ToJWT",
 				mock.AnythingOfType("*jwt.Token"),
-			).Return(dummyToken, nil)
+			).Return("dummytoken", nil)
 
-			co := morchestrator.ClientRunner{}
-			co.On("SubmitProvisionDeviceJob", ctx,
-				mock.AnythingOfType("orchestrator.ProvisionDeviceReq")).
-				Return(tc.coSubmitProvisionDeviceJobErr)
+			devauth := NewDevAuth(&db, nil, &jwth, tc.config)
 
-			// setup devauth
-			devauth := NewDevAuth(&db, &co, &jwth, Config{})
-
-			// test
-			res, err := devauth.SubmitAuthRequest(ctx, &inReq)
+			res, err := devauth.SubmitAuthRequest(context.Background(),
+				&model.AuthReq{
+					IdData: idData,
+					PubKey: pubKey,
+				})
 
-			// verify
-			assert.Equal(t, tc.res, res)
 			if tc.err != nil {
 				assert.EqualError(t, err, tc.err.Error())
 			} else {
 				assert.NoError(t, err)
+				assert.Equal(t, tc.res, res)
+			}
+
+			if tc.res == "dummytoken" {
+				db.AssertCalled(t, "AddToken", ctxMatcher,
+					mock.AnythingOfType("model.Token"))
+			} else {
+				db.AssertNotCalled(t, "AddToken", ctxMatcher,
+					mock.AnythingOfType("model.Token"))
+			}
+
+			db.AssertNumberOfCalls(t, "DeleteToken", len(tc.deleted))
+			for _, jti := range tc.deleted {
+				db.AssertCalled(t, "DeleteToken", ctxMatcher, jti)
 			}
 		})
 	}
 }
 
-func TestDevAuthPreauthorizeDevice(t *testing.T) {
+func TestDevAuthSubmitAuthRequestLockout(t *testing.T) {
 	t.Parallel()
 
-	authsetId := "aid"
-	deviceId := "did"
 	idData := "{\"mac\":\"00:00:00:01\"}"
-	pubKey := "pubkey"
+	_, idDataHash, err := parseIdData(idData)
+	assert.NoError(t, err)
+	idLockoutId := "identity:" + hex.EncodeToString(idDataHash)
 
-	req := &model.PreAuthReq{
-		AuthSetId: authsetId,
-		DeviceId:  deviceId,
-		IdData:    idData,
-		PubKey:    pubKey,
-	}
+	testCases := map[string]struct {
+		threshold int
+		remoteIP  string
 
-	badReq := &model.PreAuthReq{
-		AuthSetId: authsetId,
-		DeviceId:  deviceId,
-		IdData:    "a",
-		PubKey:    pubKey,
+		dbLocked []model.AuthLockout
+		dbErr    error
+
+		ids []string
+		err error
+	}{
+		"ok, disabled": {
+			threshold: 0,
+		},
+		"ok, not locked": {
+			threshold: 5,
+			remoteIP:  "192.0.2.1",
+			dbLocked:  []model.AuthLockout{},
+			ids:       []string{idLockoutId, "ip:192.0.2.1"},
+		},
+		"error, locked": {
+			threshold: 5,
+			remoteIP:  "192.0.2.1",
+			dbLocked:  []model.AuthLockout{*model.NewIPAuthLockout("192.0.2.1")},
+			ids:       []string{idLockoutId, "ip:192.0.2.1"},
+			err:       ErrAuthLockedOut,
+		},
+		"error, locked, no IP": {
+			threshold: 5,
+			dbLocked:  []model.AuthLockout{*model.NewIPAuthLockout("192.0.2.1")},
+			ids:       []string{idLockoutId},
+			err:       ErrAuthLockedOut,
+		},
+		"error, db": {
+			threshold: 5,
+			dbErr:     errors.New("db error"),
+			ids:       []string{idLockoutId},
+			err:       errors.New("db get auth lockouts error: db error"),
+		},
 	}
 
-	testCases := []struct {
-		desc string
-		req  *model.PreAuthReq
+	for n := range testCases {
+		tc := testCases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
 
-		addDeviceErr  error
-		addAuthSetErr error
+			ctxMatcher := mtesting.ContextMatcher()
+
+			db := mstore.DataStore{}
+			db.On("GetLimit", ctxMatcher, model.LimitStrictAuthReqs).
+				Return(nil, store.ErrLimitNotFound)
+			db.On("GetIdentitySchema", ctxMatcher).
+				Return(nil, store.ErrIdentitySchemaNotFound)
+			db.On("GetAuthLockouts", ctxMatcher, uint(0), uint(1),
+				mock.MatchedBy(func(f model.AuthLockoutFilter) bool {
+					return assert.Equal(t, tc.ids, f.Ids) &&
+						f.LockedAt != nil
+				}),
+			).Return(tc.dbLocked, tc.dbErr)
+			// the request proceeds if not locked out
+			db.On("GetAuthSetByIdDataHashKey", ctxMatcher,
+				idDataHash, "dummy_pubkey",
+			).Return(nil, errors.New("get auth set error"))
+
+			devauth := NewDevAuth(&db, nil, nil, Config{
+				AuthLockoutThreshold: tc.threshold,
+			})
+
+			_, err := devauth.SubmitAuthRequest(context.Background(),
+				&model.AuthReq{
+					IdData:   idData,
+					PubKey:   "dummy_pubkey",
+					RemoteIP: tc.remoteIP,
+				})
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+				db.AssertNotCalled(t, "GetAuthSetByIdDataHashKey", ctxMatcher,
+					idDataHash, "dummy_pubkey")
+			} else {
+				assert.Contains(t, err.Error(), "get auth set error")
+			}
+			if tc.threshold == 0 {
+				db.AssertNotCalled(t, "GetAuthLockouts", ctxMatcher,
+					mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestDevAuthRecordAuthFailure(t *testing.T) {
+	t.Parallel()
+
+	idData := "{\"mac\":\"00:00:00:01\"}"
+	_, idDataHash, err := parseIdData(idData)
+	assert.NoError(t, err)
+	idLockout := model.NewIdentityAuthLockout(idDataHash, idData)
+	ipLockout := model.NewIPAuthLockout("192.0.2.1")
+
+	config := Config{
+		AuthLockoutThreshold: 3,
+		AuthLockoutWindow:    600,
+		AuthLockoutDuration:  900,
+	}
+
+	locked := time.Now().Add(time.Minute)
+	expired := time.Now().Add(-time.Minute)
+
+	testCases := map[string]struct {
+		config Config
+		idData string
+
+		dbId     *model.AuthLockout
+		dbIP     *model.AuthLockout
+		dbErr    error
+		dbLocked []string
 
 		err error
 	}{
-		{
-			desc: "ok",
-			req:  req,
+		"ok, disabled": {
+			idData: idData,
 		},
-		{
-			desc: "error: add device, exists",
-			req:  req,
+		"ok, below threshold": {
+			config: config,
+			idData: idData,
+			dbId:   &model.AuthLockout{Id: idLockout.Id, Failures: 2},
+			dbIP:   &model.AuthLockout{Id: ipLockout.Id, Failures: 1},
+		},
+		"ok, lockout expired": {
+			config: config,
+			idData: idData,
+			dbId:   &model.AuthLockout{Id: idLockout.Id, Failures: 1, LockedUntil: &expired},
+			dbIP:   &model.AuthLockout{Id: ipLockout.Id, Failures: 1},
+		},
+		"ok, malformed identity, IP only": {
+			config: config,
+			idData: "foo",
+			dbIP:   &model.AuthLockout{Id: ipLockout.Id, Failures: 1},
+		},
+		"locked, identity threshold": {
+			config:   config,
+			idData:   idData,
+			dbId:     &model.AuthLockout{Id: idLockout.Id, Failures: 3},
+			dbIP:     &model.AuthLockout{Id: ipLockout.Id, Failures: 1},
+			dbLocked: []string{idLockout.Id},
+			err:      ErrAuthLockedOut,
+		},
+		"locked, IP threshold": {
+			config:   config,
+			idData:   idData,
+			dbId:     &model.AuthLockout{Id: idLockout.Id, Failures: 1},
+			dbIP:     &model.AuthLockout{Id: ipLockout.Id, Failures: 3},
+			dbLocked: []string{ipLockout.Id},
+			err:      ErrAuthLockedOut,
+		},
+		"locked already": {
+			config: config,
+			idData: idData,
+			dbId:   &model.AuthLockout{Id: idLockout.Id, Failures: 5, LockedUntil: &locked},
+			dbIP:   &model.AuthLockout{Id: ipLockout.Id, Failures: 1},
+			err:    ErrAuthLockedOut,
+		},
+		"error, db": {
+			config: config,
+			idData: idData,
+			dbErr:  errors.New("db error"),
+			err:    errors.New("db add auth failure error: db error"),
+		},
+	}
 
-			addDeviceErr: store.ErrObjectExists,
+	for n := range testCases {
+		tc := testCases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
 
-			err: ErrDeviceExists,
-		},
-		{
-			desc: "error: add device, generic",
-			req:  req,
+			ctxMatcher := mtesting.ContextMatcher()
 
-			addDeviceErr: errors.New("generic error"),
+			lockoutMatcher := func(lockout *model.AuthLockout) interface{} {
+				return mock.MatchedBy(func(l model.AuthLockout) bool {
+					return l.Id == lockout.Id &&
+						l.IdData == lockout.IdData &&
+						!l.LastFailureTs.IsZero() &&
+						l.ExpiresAt.Sub(l.LastFailureTs) ==
+							1500*time.Second
+				})
+			}
 
-			err: errors.New("failed to add device: generic error"),
-		},
-		{
-			desc: "error: add auth set, exists",
-			req:  req,
+			db := mstore.DataStore{}
+			db.On("AddAuthFailure", ctxMatcher, lockoutMatcher(idLockout),
+				mock.AnythingOfType("time.Time"),
+			).Return(tc.dbId, tc.dbErr)
+			db.On("AddAuthFailure", ctxMatcher, lockoutMatcher(ipLockout),
+				mock.AnythingOfType("time.Time"),
+			).Return(tc.dbIP, tc.dbErr)
+			db.On("SetAuthLockedUntil", ctxMatcher,
+				mock.AnythingOfType("string"),
+				mock.AnythingOfType("time.Time"),
+			).Return(nil)
 
-			addAuthSetErr: store.ErrObjectExists,
+			devauth := NewDevAuth(&db, nil, nil, tc.config)
 
-			err: ErrDeviceExists,
-		},
-		{
-			desc: "error: add auth set, exists",
-			req:  req,
+			err := devauth.RecordAuthFailure(context.Background(),
+				&model.AuthReq{
+					IdData:   tc.idData,
+					PubKey:   "dummy_pubkey",
+					RemoteIP: "192.0.2.1",
+				})
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
 
-			addAuthSetErr: errors.New("generic error"),
+			db.AssertNumberOfCalls(t, "SetAuthLockedUntil", len(tc.dbLocked))
+			for _, id := range tc.dbLocked {
+				db.AssertCalled(t, "SetAuthLockedUntil", ctxMatcher, id,
+					mock.AnythingOfType("time.Time"))
+			}
+			if tc.config.AuthLockoutThreshold == 0 {
+				db.AssertNotCalled(t, "AddAuthFailure", ctxMatcher,
+					mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
 
-			err: errors.New("failed to add auth set: generic error"),
+func TestDevAuthDeleteAuthLockout(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		dbErr error
+		err   error
+	}{
+		"ok": {},
+		"not found": {
+			dbErr: store.ErrAuthLockoutNotFound,
+			err:   ErrAuthLockoutNotFound,
 		},
-		{
-			desc: "error: identity data malformed",
-			req:  badReq,
+		"error": {
+			dbErr: errors.New("db error"),
+			err:   errors.New("failed to delete auth lockout: db error"),
+		},
+	}
 
-			err: errors.New("dev auth: bad request: failed to parse identity data: a: invalid character 'a' looking for beginning of value"),
+	for n := range testCases {
+		tc := testCases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := mstore.DataStore{}
+			db.On("DeleteAuthLockout", ctx, "ip:192.0.2.1").Return(tc.dbErr)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+
+			err := devauth.DeleteAuthLockout(ctx, "ip:192.0.2.1")
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDevAuthSubmitAuthRequestTokenPolicy(t *testing.T) {
+	t.Parallel()
+
+	pubKey := "dummy_pubkey"
+	idData := "{\"mac\":\"00:00:00:01\"}"
+	devId := "dummy_devid"
+	authId := "dummy_aid"
+
+	_, idDataHash, err := parseIdData(idData)
+	assert.NoError(t, err)
+
+	config := Config{
+		Issuer:          "Mender",
+		Audience:        "mender",
+		ExpirationTime:  3600,
+		MaxDeviceTokens: 2,
+	}
+
+	testCases := map[string]struct {
+		dbPolicy    *model.TokenPolicy
+		dbPolicyErr error
+
+		audience  string
+		expiresIn int64
+		maxTokens uint
+		claims    map[string]interface{}
+
+		err error
+	}{
+		"ok, server config": {
+			dbPolicy:  &model.TokenPolicy{},
+			audience:  "mender",
+			expiresIn: 3600,
+			maxTokens: 2,
+		},
+		"ok, tenant policy": {
+			dbPolicy: &model.TokenPolicy{
+				Audience:        "acme",
+				ExpirationTime:  30 * 24 * 3600,
+				MaxDeviceTokens: 5,
+				Claims: map[string]interface{}{
+					"fleet": "trucks",
+				},
+			},
+			audience:  "acme",
+			expiresIn: 30 * 24 * 3600,
+			maxTokens: 5,
+			claims: map[string]interface{}{
+				"fleet": "trucks",
+			},
+		},
+		"error, policy": {
+			dbPolicyErr: errors.New("db error"),
+			err:         errors.New("db get token policy error: db error"),
 		},
 	}
 
-	for tcidx := range testCases {
-		tc := testCases[tcidx]
-		t.Run(fmt.Sprintf("tc: %s", tc.desc), func(t *testing.T) {
+	for n := range testCases {
+		tc := testCases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
 			t.Parallel()
 
 			ctxMatcher := mtesting.ContextMatcher()
 
-			ctxMatcher = mock.MatchedBy(func(c context.Context) bool {
-				return true
-			})
-
 			db := mstore.DataStore{}
+			db.On("GetLimit", ctxMatcher, model.LimitStrictAuthReqs).
+				Return(nil, store.ErrLimitNotFound)
+			db.On("GetIdentitySchema", ctxMatcher).
+				Return(nil, store.ErrIdentitySchemaNotFound)
 			db.On("AddDevice",
 				ctxMatcher,
-				mock.MatchedBy(
-					func(d model.Device) bool {
-						return (d.IdData == tc.req.IdData) &&
-							(d.Id == tc.req.DeviceId) &&
-							(d.PubKey == tc.req.PubKey)
-					})).Return(tc.addDeviceErr)
-
+				mock.AnythingOfType("model.Device")).Return(store.ErrObjectExists)
+			db.On("GetDeviceByIdentityDataHash",
+				ctxMatcher,
+				idDataHash).Return(&model.Device{
+				PubKey:       pubKey,
+				IdDataSha256: idDataHash,
+				Id:           devId,
+			}, nil)
+			db.On("GetAuthSetByIdDataHashKey",
+				ctxMatcher,
+				idDataHash, pubKey).Return(&model.AuthSet{
+				Id:           authId,
+				DeviceId:     devId,
+				IdDataSha256: idDataHash,
+				PubKey:       pubKey,
+				Status:       model.DevStatusAccepted,
+			}, nil)
+			db.On("GetAdmissionRules", ctxMatcher).
+				Return([]model.AdmissionRule{}, nil)
 			db.On("AddAuthSet",
 				ctxMatcher,
-				mock.MatchedBy(
-					func(m model.AuthSet) bool {
-						return (m.Id == tc.req.AuthSetId) &&
-							(m.DeviceId == tc.req.DeviceId) &&
-							(m.IdData == tc.req.IdData) &&
-							(m.PubKey == tc.req.PubKey)
-					})).Return(tc.addAuthSetErr)
+				mock.AnythingOfType("model.AuthSet")).Return(store.ErrObjectExists)
+			db.On("GetDeviceStatus", ctxMatcher, devId).
+				Return(model.DevStatusAccepted, nil)
+			db.On("UpdateDevice", ctxMatcher,
+				mock.AnythingOfType("model.Device"),
+				mock.AnythingOfType("model.DeviceUpdate")).Return(nil)
+			db.On("GetTokenPolicy", ctxMatcher).
+				Return(tc.dbPolicy, tc.dbPolicyErr)
+			db.On("GetTokens", ctxMatcher, uint(0), uint(0),
+				model.TokenFilter{DevId: devId},
+			).Return([]model.Token{}, nil)
+			db.On("AddToken",
+				ctxMatcher,
+				mock.AnythingOfType("model.Token")).Return(nil)
 
-			devauth := NewDevAuth(&db, nil, nil, Config{})
-			err := devauth.PreauthorizeDevice(context.Background(), tc.req)
+			jwth := mjwt.Handler{}
+			jwth.On("ToJWT",
+				mock.MatchedBy(func(tok *jwt.Token) bool {
+					return tok.Claims.Audience == tc.audience &&
+						tok.Claims.ExpiresAt-tok.Claims.IssuedAt == tc.expiresIn &&
+						assert.ObjectsAreEqual(tc.claims, tok.Claims.Extra)
+				}),
+			).Return("dummytoken", nil)
+
+			devauth := NewDevAuth(&db, nil, &jwth, config)
+
+			res, err := devauth.SubmitAuthRequest(context.Background(),
+				&model.AuthReq{
+					IdData: idData,
+					PubKey: pubKey,
+				})
 
 			if tc.err != nil {
 				assert.EqualError(t, err, tc.err.Error())
+				db.AssertNotCalled(t, "AddToken", ctxMatcher,
+					mock.AnythingOfType("model.Token"))
 			} else {
 				assert.NoError(t, err)
+				assert.Equal(t, "dummytoken", res)
+				db.AssertCalled(t, "GetTokens", ctxMatcher, uint(0),
+					uint(0), model.TokenFilter{DevId: devId})
 			}
 		})
 	}
 }
 
-func TestDevAuthAcceptDevice(t *testing.T) {
+func TestDevAuthSubmitAuthRequestScope(t *testing.T) {
 	t.Parallel()
 
-	testCases := []struct {
-		aset *model.AuthSet
+	pubKey := "dummy_pubkey"
+	idData := "{\"mac\":\"00:00:00:01\"}"
+	devId := "dummy_devid"
+	authId := "dummy_aid"
 
-		dbLimit    *model.Limit
-		dbLimitErr error
+	_, idDataHash, err := parseIdData(idData)
+	assert.NoError(t, err)
 
-		dbCount    int
-		dbCountErr error
+	now := time.Now()
+	unscopedToken := model.NewToken("jti1", devId, "unscopedtoken").
+		WithValidity(now.Unix()-600, now.Unix()+3600)
 
-		dev                *model.Device
-		dbGetDeviceByIdErr error
+	testCases := map[string]struct {
+		config Config
+		scope  string
 
-		dbUpdateErr               error
-		dbUpdateRevokeAuthSetsErr error
+		dbScopes    *model.TokenScopes
+		dbScopesErr error
+		dbReusable  []model.Token
+
+		tokenScope string
+		res        string
+		err        error
+	}{
+		"ok, no scope": {
+			res: "dummytoken",
+		},
+		"ok, scope": {
+			scope:      "deployments",
+			dbScopes:   &model.TokenScopes{Scopes: []string{"deployments", "inventory"}},
+			tokenScope: "deployments",
+			res:        "dummytoken",
+		},
+		"ok, scope list normalized": {
+			scope:      " inventory   deployments ",
+			dbScopes:   &model.TokenScopes{Scopes: []string{"deployments", "inventory"}},
+			tokenScope: "inventory deployments",
+			res:        "dummytoken",
+		},
+		"ok, unscoped token not reused": {
+			config:     Config{TokenReuseMinLifetime: 1800},
+			scope:      "deployments",
+			dbScopes:   &model.TokenScopes{Scopes: []string{"deployments"}},
+			dbReusable: []model.Token{*unscopedToken},
+			tokenScope: "deployments",
+			res:        "dummytoken",
+		},
+		"error, scope not allowed": {
+			scope:    "deployments inventory",
+			dbScopes: &model.TokenScopes{Scopes: []string{"deployments"}},
+			err:      errors.New("dev auth: bad request: token scope not allowed"),
+		},
+		"error, db": {
+			scope:       "deployments",
+			dbScopesErr: errors.New("db error"),
+			err:         errors.New("db get token scopes error: db error"),
+		},
+	}
+
+	for n := range testCases {
+		tc := testCases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			ctxMatcher := mtesting.ContextMatcher()
+
+			db := mstore.DataStore{}
+			db.On("GetLimit", ctxMatcher, model.LimitStrictAuthReqs).
+				Return(nil, store.ErrLimitNotFound)
+			db.On("GetIdentitySchema", ctxMatcher).
+				Return(nil, store.ErrIdentitySchemaNotFound)
+			db.On("GetTokenScopes", ctxMatcher).
+				Return(tc.dbScopes, tc.dbScopesErr)
+			db.On("AddDevice",
+				ctxMatcher,
+				mock.AnythingOfType("model.Device")).Return(store.ErrObjectExists)
+			db.On("GetDeviceByIdentityDataHash",
+				ctxMatcher,
+				idDataHash).Return(&model.Device{
+				PubKey:       pubKey,
+				IdDataSha256: idDataHash,
+				Id:           devId,
+			}, nil)
+			db.On("GetAuthSetByIdDataHashKey",
+				ctxMatcher,
+				idDataHash, pubKey).Return(&model.AuthSet{
+				Id:           authId,
+				DeviceId:     devId,
+				IdDataSha256: idDataHash,
+				PubKey:       pubKey,
+				Status:       model.DevStatusAccepted,
+			}, nil)
+			db.On("GetAdmissionRules", ctxMatcher).
+				Return([]model.AdmissionRule{}, nil)
+			db.On("AddAuthSet",
+				ctxMatcher,
+				mock.AnythingOfType("model.AuthSet")).Return(store.ErrObjectExists)
+			db.On("GetDeviceStatus", ctxMatcher, devId).
+				Return(model.DevStatusAccepted, nil)
+			db.On("UpdateDevice", ctxMatcher,
+				mock.AnythingOfType("model.Device"),
+				mock.AnythingOfType("model.DeviceUpdate")).Return(nil)
+			db.On("GetTokens", ctxMatcher, uint(0), uint(1),
+				model.TokenFilter{DevId: devId, AuthSetId: authId},
+			).Return(tc.dbReusable, nil)
+			db.On("GetTokenPolicy", ctxMatcher).
+				Return(&model.TokenPolicy{}, nil)
+			db.On("AddToken",
+				ctxMatcher,
+				mock.MatchedBy(func(tok model.Token) bool {
+					return tok.Scope == tc.tokenScope
+				})).Return(nil)
+
+			jwth := mjwt.Handler{}
+			jwth.On("ToJWT",
+				mock.MatchedBy(func(tok *jwt.Token) bool {
+					return tok.Claims.Scope == tc.tokenScope
+				}),
+			).Return("dummytoken", nil)
+
+			devauth := NewDevAuth(&db, nil, &jwth, tc.config)
+
+			res, err := devauth.SubmitAuthRequest(context.Background(),
+				&model.AuthReq{
+					IdData: idData,
+					PubKey: pubKey,
+					Scope:  tc.scope,
+				})
+
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+				db.AssertNotCalled(t, "AddToken", ctxMatcher,
+					mock.AnythingOfType("model.Token"))
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.res, res)
+			}
+		})
+	}
+}
+
+func TestDevAuthCheckAuthReqReplay(t *testing.T) {
+	t.Parallel()
+
+	idData := "{\"mac\":\"00:00:00:01\"}"
+	_, idDataHash, err := parseIdData(idData)
+	assert.NoError(t, err)
+
+	now := time.Now()
+	past := now.Add(-10 * time.Minute)
+
+	testCases := map[string]struct {
+		skew int64
+
+		timestamp *time.Time
+		nonce     string
+
+		strict    uint64
+		strictErr error
+
+		addNonceErr error
+
+		err error
+	}{
+		"ok, disabled": {
+			timestamp: &past,
+			nonce:     "foo",
+		},
+		"ok": {
+			skew:      300,
+			timestamp: &now,
+			nonce:     "foo",
+		},
+		"ok, legacy client": {
+			skew: 300,
+		},
+		"ok, legacy client, disabled": {},
+		"error, legacy client, strict": {
+			skew:   300,
+			strict: 1,
+			err:    MakeErrDevAuthUnauthorized(ErrAuthReqNoNonce),
+		},
+		"error, legacy client, strict, disabled": {
+			strict: 1,
+			err:    MakeErrDevAuthUnauthorized(ErrAuthReqNoNonce),
+		},
+		"error, getting the policy": {
+			skew:      300,
+			strictErr: errors.New("db error"),
+			err:       errors.New("can't get auth requests policy: db error"),
+		},
+		"error, timestamp too old": {
+			skew:      300,
+			timestamp: &past,
+			nonce:     "foo",
+			err:       MakeErrDevAuthUnauthorized(ErrAuthReqExpired),
+		},
+		"error, nonce used": {
+			skew:        300,
+			timestamp:   &now,
+			nonce:       "foo",
+			addNonceErr: store.ErrObjectExists,
+			err:         MakeErrDevAuthUnauthorized(ErrAuthReqReplayed),
+		},
+		"error, db": {
+			skew:        300,
+			timestamp:   &now,
+			nonce:       "foo",
+			addNonceErr: errors.New("db error"),
+			err:         errors.New("db add auth nonce error: db error"),
+		},
+	}
+
+	for n := range testCases {
+		tc := testCases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := mstore.DataStore{}
+			db.On("GetLimit", ctx, model.LimitStrictAuthReqs).
+				Return(&model.Limit{
+					Name:  model.LimitStrictAuthReqs,
+					Value: tc.strict,
+				}, tc.strictErr)
+			db.On("AddAuthNonce", ctx,
+				mock.MatchedBy(func(n model.AuthNonce) bool {
+					return n.Id == hex.EncodeToString(idDataHash)+"/"+tc.nonce &&
+						n.ExpiresAt.Equal(tc.timestamp.Add(
+							time.Duration(tc.skew)*time.Second).UTC())
+				}),
+			).Return(tc.addNonceErr)
+
+			devauth := NewDevAuth(&db, nil, nil,
+				Config{AuthReqMaxClockSkew: tc.skew})
+
+			err := devauth.checkAuthReqReplay(ctx, &model.AuthReq{
+				IdData:    idData,
+				Timestamp: tc.timestamp,
+				Nonce:     tc.nonce,
+			})
+
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDevAuthCreateAuthChallenge(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		dbErr error
+
+		err error
+	}{
+		"ok": {},
+		"error, db": {
+			dbErr: errors.New("db error"),
+			err:   errors.New("db add auth challenge error: db error"),
+		},
+	}
+
+	for n := range testCases {
+		tc := testCases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := mstore.DataStore{}
+			db.On("AddAuthChallenge", ctx,
+				mock.AnythingOfType("model.AuthChallenge"),
+			).Return(tc.dbErr)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{AuthChallengeTTL: 60})
+
+			before := time.Now()
+			ch, err := devauth.CreateAuthChallenge(ctx)
+
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Len(t, ch.Nonce, 43)
+				assert.WithinDuration(t, before.Add(time.Minute),
+					ch.ExpiresAt, time.Second)
+				db.AssertCalled(t, "AddAuthChallenge", ctx, *ch)
+			}
+		})
+	}
+}
+
+func TestDevAuthSubmitAuthRequestChallenge(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		challenge *model.AuthChallenge
+		dbErr     error
+
+		err error
+	}{
+		"error, unknown": {
+			dbErr: store.ErrAuthChallengeNotFound,
+			err:   MakeErrDevAuthUnauthorized(ErrAuthChallengeInvalid),
+		},
+		"error, expired": {
+			challenge: &model.AuthChallenge{
+				Nonce:     "foo",
+				ExpiresAt: time.Now().Add(-time.Second),
+			},
+			err: MakeErrDevAuthUnauthorized(ErrAuthChallengeInvalid),
+		},
+		"error, db": {
+			dbErr: errors.New("db error"),
+			err:   errors.New("db consume auth challenge error: db error"),
+		},
+	}
+
+	for n := range testCases {
+		tc := testCases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := mstore.DataStore{}
+			db.On("ConsumeAuthChallenge", ctx, "foo").
+				Return(tc.challenge, tc.dbErr)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+
+			_, err := devauth.SubmitAuthRequest(ctx, &model.AuthReq{
+				IdData:    "{\"mac\":\"00:00:00:01\"}",
+				PubKey:    "dummy_pubkey",
+				Challenge: "foo",
+			})
+
+			assert.EqualError(t, err, tc.err.Error())
+		})
+	}
+
+	// a valid challenge is consumed, the request is processed as usual
+	ctx := context.Background()
+
+	db := mstore.DataStore{}
+	db.On("GetIdentitySchema", ctx).
+		Return(nil, store.ErrIdentitySchemaNotFound)
+	db.On("ConsumeAuthChallenge", ctx, "foo").
+		Return(&model.AuthChallenge{
+			Nonce:     "foo",
+			ExpiresAt: time.Now().Add(time.Minute),
+		}, nil)
+	db.On("GetAuthSetByIdDataHashKey", ctx,
+		mock.AnythingOfType("[]uint8"), "dummy_pubkey",
+	).Return(nil, errors.New("db error"))
+
+	devauth := NewDevAuth(&db, nil, nil, Config{AuthReqMaxClockSkew: 300})
+	_, err := devauth.SubmitAuthRequest(ctx, &model.AuthReq{
+		IdData:    "{\"mac\":\"00:00:00:01\"}",
+		PubKey:    "dummy_pubkey",
+		Challenge: "foo",
+	})
+	assert.EqualError(t, err, "failed to fetch auth set: db error")
+	db.AssertExpectations(t)
+}
+
+func TestDevAuthSubmitAuthRequestPreauth(t *testing.T) {
+	idData := "{\"mac\":\"00:00:00:01\"}"
+	_, idDataSha256, err := parseIdData(idData)
+	assert.NoError(t, err)
+
+	inReq := model.AuthReq{
+		IdData:      idData,
+		PubKey:      "foo-pubkey",
+		TenantToken: "foo-tenant",
+	}
+
+	dummyDevId := "dummydevid"
+	dummyToken := "dummytoken"
+
+	testCases := []struct {
+		desc string
+
+		dbGetAuthSetByDataKeyRes *model.AuthSet
+		dbGetAuthSetByDataKeyErr error
+
+		dbGetLimitRes *model.Limit
+		dbGetLimitErr error
+
+		dbGetDevCountByStatusRes int
+		dbGetDevCountByStatusErr error
+
+		dev                *model.Device
+		dbGetDeviceByIdErr error
+
+		coSubmitProvisionDeviceJobErr error
+
+		res string
+		err error
+	}{
+		{
+			desc: "ok: preauthorized set is auto-accepted",
+			dbGetAuthSetByDataKeyRes: &model.AuthSet{
+				IdDataSha256: idDataSha256,
+				DeviceId:     dummyDevId,
+				PubKey:       inReq.PubKey,
+				Status:       model.DevStatusPreauth,
+			},
+			dbGetLimitRes: &model.Limit{
+				Value: 5,
+			},
+			dbGetDevCountByStatusRes: 0,
+			dev: &model.Device{
+				Id:     dummyDevId,
+				Status: model.DevStatusPending,
+			},
+			res: dummyToken,
+		},
+		{
+			desc: "error: can't get an existing authset",
+			dbGetAuthSetByDataKeyErr: errors.New("db error"),
+			dev: &model.Device{
+				Id:     dummyDevId,
+				Status: model.DevStatusPending,
+			},
+			err: errors.New("failed to fetch auth set: db error"),
+		},
+		{
+			desc: "error: preauthorized set would exceed limit",
+			dbGetAuthSetByDataKeyRes: &model.AuthSet{
+				IdDataSha256: idDataSha256,
+				DeviceId:     dummyDevId,
+				PubKey:       inReq.PubKey,
+				Status:       model.DevStatusPreauth,
+			},
+			dbGetLimitRes: &model.Limit{
+				Value: 5,
+			},
+			dbGetDevCountByStatusRes: 5,
+			dev: &model.Device{
+				Id:     dummyDevId,
+				Status: model.DevStatusPending,
+			},
+			err: ErrMaxDeviceCountReached,
+		},
+		{
+			desc: "error: can't get device limit",
+			dbGetAuthSetByDataKeyRes: &model.AuthSet{
+				IdDataSha256: idDataSha256,
+				DeviceId:     dummyDevId,
+				PubKey:       inReq.PubKey,
+				Status:       model.DevStatusPreauth,
+			},
+			dbGetLimitErr: errors.New("db error"),
+			dev: &model.Device{
+				Id:     dummyDevId,
+				Status: model.DevStatusPending,
+			},
+			err: errors.New("can't get current device limit: db error"),
+		},
+		{
+			desc: "error: failed to submit job to conductor",
+			dbGetAuthSetByDataKeyRes: &model.AuthSet{
+				IdDataSha256: idDataSha256,
+				DeviceId:     dummyDevId,
+				PubKey:       inReq.PubKey,
+				Status:       model.DevStatusPreauth,
+			},
+			dbGetLimitRes: &model.Limit{
+				Value: 5,
+			},
+			dbGetDevCountByStatusRes: 0,
+			dev: &model.Device{
+				Id:     dummyDevId,
+				Status: model.DevStatusPending,
+			},
+			coSubmitProvisionDeviceJobErr: errors.New("conductor failed"),
+			err: errors.New("submit device provisioning job error: conductor failed"),
+		},
+		{
+			desc: "ok: preauthorized set is auto-accepted, device was already accepted",
+			dbGetAuthSetByDataKeyRes: &model.AuthSet{
+				IdDataSha256: idDataSha256,
+				DeviceId:     dummyDevId,
+				PubKey:       inReq.PubKey,
+				Status:       model.DevStatusPreauth,
+			},
+			dbGetLimitRes: &model.Limit{
+				Value: 5,
+			},
+			dbGetDevCountByStatusRes: 0,
+			dev: &model.Device{
+				Id:     dummyDevId,
+				Status: model.DevStatusAccepted,
+			},
+			coSubmitProvisionDeviceJobErr: errors.New("conductor shouldn't be called"),
+			res: dummyToken,
+		},
+		{
+			desc: "error: cannot get device status",
+			dbGetAuthSetByDataKeyRes: &model.AuthSet{
+				IdDataSha256: idDataSha256,
+				DeviceId:     dummyDevId,
+				PubKey:       inReq.PubKey,
+				Status:       model.DevStatusPreauth,
+			},
+			dbGetLimitRes: &model.Limit{
+				Value: 5,
+			},
+			dbGetDevCountByStatusRes: 0,
+			dbGetDeviceByIdErr:       errors.New("Get device failed"),
+			err:                      errors.New("Get device failed"),
+		},
+	}
+
+	for tcidx := range testCases {
+		tc := testCases[tcidx]
+		t.Run(fmt.Sprintf("tc: %s", tc.desc), func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			// setup mocks
+			db := mstore.DataStore{}
+			db.On("GetIdentitySchema", mock.Anything).
+				Return(nil, store.ErrIdentitySchemaNotFound)
+
+			// get the auth set to check if preauthorized
+			db.On("GetAuthSetByIdDataHashKey",
+				ctx,
+				idDataSha256,
+				inReq.PubKey,
+			).Return(
+				tc.dbGetAuthSetByDataKeyRes,
+				tc.dbGetAuthSetByDataKeyErr,
+			)
+
+			// replay protection policy
+			db.On("GetLimit", ctx, model.LimitStrictAuthReqs).
+				Return(nil, store.ErrLimitNotFound)
+
+			// for a preauthorized set - check if we're not over the limit
+			db.On("GetLimit",
+				ctx,
+				model.LimitMaxDeviceCount,
+			).Return(
+				tc.dbGetLimitRes,
+				tc.dbGetLimitErr,
+			)
+
+			// takes part in limit checking
+			db.On("GetDevCountByStatus",
+				ctx,
+				model.DevStatusAccepted,
+			).Return(
+				tc.dbGetDevCountByStatusRes,
+				tc.dbGetDevCountByStatusErr,
+			)
+
+			// at the end of processing, updates the preauthorized set to 'accepted'
+			// just happy path, errors tested elsewhere
+			db.On("UpdateAuthSetById",
+				ctx,
+				mock.AnythingOfType("string"),
+				mock.MatchedBy(
+					func(u model.AuthSetUpdate) bool {
+						return u.Status == model.DevStatusAccepted
+					}),
+			).Return(nil)
+
+			// at the end of processing, updates the device status to 'accepted'
+			db.On("UpdateDevice",
+				ctx,
+				mock.MatchedBy(
+					func(m model.Device) bool {
+						return m.Id == dummyDevId
+
+					}),
+				mock.MatchedBy(
+					func(u model.DeviceUpdate) bool {
+						return u.Status == model.DevStatusAccepted
+					}),
+			).Return(nil)
+
+			// at the end of processing, saves the issued token
+			// only happy path, errors tested elsewhere
+			db.On("GetTokenPolicy", ctx).
+				Return(&model.TokenPolicy{}, nil)
+			db.On("AddToken",
+				ctx,
+				mock.AnythingOfType("model.Token"),
+			).Return(nil)
+
+			db.On("GetDeviceById",
+				context.Background(), dummyDevId).Return(tc.dev, tc.dbGetDeviceByIdErr)
+
+			// token serialization - happy path only, errors tested elsewhere
+			jwth := mjwt.Handler{}
+			jwth.On("ToJWT",
+				mock.AnythingOfType("*jwt.Token"),
+			).Return(dummyToken, nil)
+
+			co := morchestrator.ClientRunner{}
+			co.On("SubmitProvisionDeviceJob", ctx,
+				mock.AnythingOfType("orchestrator.ProvisionDeviceReq")).
+				Return(tc.coSubmitProvisionDeviceJobErr)
+
+			// setup devauth
+			devauth := NewDevAuth(&db, &co, &jwth, Config{})
+
+			// test
+			res, err := devauth.SubmitAuthRequest(ctx, &inReq)
+
+			// verify
+			assert.Equal(t, tc.res, res)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDevAuthSubmitAuthRequestCert(t *testing.T) {
+	t.Parallel()
+
+	dummyDevId := "devid"
+	dummyAuthId := "authid"
+	dummyToken := "token"
+
+	inReq := model.AuthReq{
+		Certificate: mtesting.LoadCertChainStr(t,
+			"testdata/cert_device.pem",
+			"testdata/cert_intermediate.pem"),
+	}
+	assert.NoError(t, inReq.Validate())
+
+	_, idDataSha256, err := parseIdData(inReq.IdData)
+	assert.NoError(t, err)
+
+	rootCA := model.TrustedCA{
+		Id:          "root",
+		Certificate: mtesting.LoadCertChainStr(t, "testdata/cert_ca.pem"),
+	}
+	otherCA := model.TrustedCA{
+		Id:          "other",
+		Certificate: mtesting.LoadCertChainStr(t, "testdata/cert_other_ca.pem"),
+	}
+
+	authSet := func(status string) *model.AuthSet {
+		return &model.AuthSet{
+			Id:           dummyAuthId,
+			DeviceId:     dummyDevId,
+			IdData:       inReq.IdData,
+			IdDataSha256: idDataSha256,
+			PubKey:       inReq.PubKey,
+			Status:       status,
+		}
+	}
+
+	testCases := map[string]struct {
+		dbTrustedCAs    []model.TrustedCA
+		dbTrustedCAsErr error
+
+		// auth set recorded by the regular auth request processing
+		authSet *model.AuthSet
+
+		dbGetLimitRes *model.Limit
+		dbDevCount    int
+
+		coSubmitProvisionDeviceJobErr error
+
+		res      string
+		err      string
+		accepted bool
+	}{
+		"ok, pending auth set accepted": {
+			dbTrustedCAs:  []model.TrustedCA{otherCA, rootCA},
+			authSet:       authSet(model.DevStatusPending),
+			dbGetLimitRes: &model.Limit{Value: 0},
+			res:           dummyToken,
+			accepted:      true,
+		},
+		"ok, already accepted": {
+			dbTrustedCAs: []model.TrustedCA{rootCA},
+			authSet:      authSet(model.DevStatusAccepted),
+			res:          dummyToken,
+		},
+		"error, rejected auth set stays rejected": {
+			dbTrustedCAs: []model.TrustedCA{rootCA},
+			authSet:      authSet(model.DevStatusRejected),
+			err:          ErrDevAuthUnauthorized.Error(),
+		},
+		"error, untrusted CA": {
+			dbTrustedCAs: []model.TrustedCA{otherCA},
+			err:          "dev auth: unauthorized: certificate verification failed: x509: certificate signed by unknown authority",
+		},
+		"error, no trusted CAs": {
+			dbTrustedCAs: []model.TrustedCA{},
+			err:          "dev auth: unauthorized: certificate verification failed: no trusted CA certificates",
+		},
+		"error, db": {
+			dbTrustedCAsErr: errors.New("db error"),
+			err:             "failed to fetch trusted CAs: db error",
+		},
+		"error, device limit reached": {
+			dbTrustedCAs:  []model.TrustedCA{rootCA},
+			authSet:       authSet(model.DevStatusPending),
+			dbGetLimitRes: &model.Limit{Value: 1},
+			dbDevCount:    1,
+			err:           ErrMaxDeviceCountReached.Error(),
+		},
+		"error, provisioning": {
+			dbTrustedCAs:                  []model.TrustedCA{rootCA},
+			authSet:                       authSet(model.DevStatusPending),
+			dbGetLimitRes:                 &model.Limit{Value: 0},
+			coSubmitProvisionDeviceJobErr: errors.New("conductor failed"),
+			err:                           "submit device provisioning job error: conductor failed",
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(fmt.Sprintf("tc: %s", name), func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := mstore.DataStore{}
+			db.On("GetIdentitySchema", ctx).
+				Return(nil, store.ErrIdentitySchemaNotFound)
+			db.On("GetTrustedCAs", ctx).Return(tc.dbTrustedCAs, tc.dbTrustedCAsErr)
+
+			// not preauthorized
+			db.On("GetAuthSetByIdDataHashKey",
+				ctx, idDataSha256, inReq.PubKey,
+			).Return(nil, store.ErrDevNotFound).Once()
+
+			// regular auth request processing
+			db.On("AddDevice", ctx,
+				mock.AnythingOfType("model.Device"),
+			).Return(nil)
+			db.On("GetDeviceByIdentityDataHash", ctx, idDataSha256).
+				Return(&model.Device{Id: dummyDevId}, nil)
+			db.On("GetAdmissionRules", ctx).
+				Return([]model.AdmissionRule{}, nil)
+			db.On("GetLimit", ctx, model.LimitMaxAuthSetsPerDevice).
+				Return(nil, store.ErrLimitNotFound)
+			db.On("GetLimit", ctx, model.LimitStrictAuthReqs).
+				Return(nil, store.ErrLimitNotFound)
+			db.On("AddAuthSet", ctx,
+				mock.MatchedBy(func(m model.AuthSet) bool {
+					return m.DeviceId == dummyDevId &&
+						m.PubKey == inReq.PubKey &&
+						m.IdData == inReq.IdData
+				}),
+			).Return(nil)
+			db.On("GetDeviceStatus", ctx, dummyDevId).
+				Return(model.DevStatusPending, nil)
+			db.On("UpdateDevice", ctx,
+				mock.AnythingOfType("model.Device"),
+				mock.AnythingOfType("model.DeviceUpdate"),
+			).Return(nil)
+			db.On("GetAuthSetByIdDataHashKey",
+				ctx, idDataSha256, inReq.PubKey,
+			).Return(tc.authSet, nil)
+
+			// auto-accept
+			db.On("UpdateAuthSet", ctx,
+				bson.M{
+					model.AuthSetKeyDeviceId: dummyDevId,
+					"$or": []bson.M{
+						bson.M{model.AuthSetKeyStatus: model.DevStatusAccepted},
+						bson.M{model.AuthSetKeyStatus: model.DevStatusPreauth},
+					},
+				},
+				model.AuthSetUpdate{Status: model.DevStatusRejected},
+			).Return(store.ErrAuthSetNotFound)
+			db.On("GetDeviceById", ctx, dummyDevId).
+				Return(&model.Device{Id: dummyDevId, Status: model.DevStatusPending}, nil)
+			db.On("GetLimit", ctx, model.LimitMaxDeviceCount).
+				Return(tc.dbGetLimitRes, nil)
+			db.On("GetDevCountByStatus", ctx, model.DevStatusAccepted).
+				Return(tc.dbDevCount, nil)
+			db.On("UpdateAuthSetById", ctx, dummyAuthId,
+				model.AuthSetUpdate{Status: model.DevStatusAccepted},
+			).Return(nil)
+
+			db.On("GetTokenPolicy", ctx).
+				Return(&model.TokenPolicy{}, nil)
+			db.On("AddToken", ctx,
+				mock.MatchedBy(func(tok model.Token) bool {
+					return tok.DevId == dummyDevId &&
+						tok.AuthSetId == dummyAuthId &&
+						tok.IssuedAt != nil &&
+						tok.ExpiresAt != nil &&
+						tok.ExpiresAt.Sub(*tok.IssuedAt) == time.Hour
+				}),
+			).Return(nil)
+
+			jwth := mjwt.Handler{}
+			jwth.On("ToJWT",
+				mock.AnythingOfType("*jwt.Token"),
+			).Return(dummyToken, nil)
+
+			co := morchestrator.ClientRunner{}
+			co.On("SubmitProvisionDeviceJob", ctx,
+				orchestrator.ProvisionDeviceReq{
+					Device: model.Device{Id: dummyDevId},
+				}).
+				Return(tc.coSubmitProvisionDeviceJobErr)
+
+			devauth := NewDevAuth(&db, &co, &jwth, Config{ExpirationTime: 3600})
+
+			req := inReq
+			res, err := devauth.SubmitAuthRequest(ctx, &req)
+
+			assert.Equal(t, tc.res, res)
+			if tc.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.err)
+				}
+			} else {
+				assert.NoError(t, err)
+				if tc.accepted {
+					db.AssertCalled(t, "UpdateAuthSetById", ctx, dummyAuthId,
+						model.AuthSetUpdate{Status: model.DevStatusAccepted})
+				} else {
+					db.AssertNotCalled(t, "UpdateAuthSetById", ctx, dummyAuthId,
+						model.AuthSetUpdate{Status: model.DevStatusAccepted})
+				}
+			}
+		})
+	}
+}
+
+func TestDevAuthPreauthorizeDevice(t *testing.T) {
+	t.Parallel()
+
+	authsetId := "aid"
+	deviceId := "did"
+	idData := "{\"mac\":\"00:00:00:01\"}"
+	pubKey := "pubkey"
+
+	req := &model.PreAuthReq{
+		AuthSetId: authsetId,
+		DeviceId:  deviceId,
+		IdData:    idData,
+		PubKey:    pubKey,
+	}
+
+	badReq := &model.PreAuthReq{
+		AuthSetId: authsetId,
+		DeviceId:  deviceId,
+		IdData:    "a",
+		PubKey:    pubKey,
+	}
+
+	testCases := []struct {
+		desc string
+		req  *model.PreAuthReq
+
+		addDeviceErr  error
+		addAuthSetErr error
+		schema        *model.IdentitySchema
+
+		err error
+	}{
+		{
+			desc: "ok",
+			req:  req,
+		},
+		{
+			desc: "error: add device, exists",
+			req:  req,
+
+			addDeviceErr: store.ErrObjectExists,
+
+			err: ErrDeviceExists,
+		},
+		{
+			desc: "error: add device, generic",
+			req:  req,
+
+			addDeviceErr: errors.New("generic error"),
+
+			err: errors.New("failed to add device: generic error"),
+		},
+		{
+			desc: "error: add auth set, exists",
+			req:  req,
+
+			addAuthSetErr: store.ErrObjectExists,
+
+			err: ErrDeviceExists,
+		},
+		{
+			desc: "error: add auth set, exists",
+			req:  req,
+
+			addAuthSetErr: errors.New("generic error"),
+
+			err: errors.New("failed to add auth set: generic error"),
+		},
+		{
+			desc: "error: identity data malformed",
+			req:  badReq,
+
+			err: errors.New("dev auth: bad request: failed to parse identity data: a: invalid character 'a' looking for beginning of value"),
+		},
+		{
+			desc: "error: identity data doesn't match the schema",
+			req:  req,
+
+			schema: &model.IdentitySchema{
+				Required: []string{"mac", "sn"},
+			},
+
+			err: errors.New("dev auth: bad request: identity data does not match the schema: sn: required attribute missing"),
+		},
+	}
+
+	for tcidx := range testCases {
+		tc := testCases[tcidx]
+		t.Run(fmt.Sprintf("tc: %s", tc.desc), func(t *testing.T) {
+			t.Parallel()
+
+			ctxMatcher := mtesting.ContextMatcher()
+
+			ctxMatcher = mock.MatchedBy(func(c context.Context) bool {
+				return true
+			})
+
+			db := mstore.DataStore{}
+			if tc.schema != nil {
+				db.On("GetIdentitySchema", ctxMatcher).
+					Return(tc.schema, nil)
+			} else {
+				db.On("GetIdentitySchema", ctxMatcher).
+					Return(nil, store.ErrIdentitySchemaNotFound)
+			}
+			db.On("AddDevice",
+				ctxMatcher,
+				mock.MatchedBy(
+					func(d model.Device) bool {
+						return (d.IdData == tc.req.IdData) &&
+							(d.Id == tc.req.DeviceId) &&
+							(d.PubKey == tc.req.PubKey)
+					})).Return(tc.addDeviceErr)
+
+			db.On("GetAdmissionRules", ctxMatcher).
+				Return([]model.AdmissionRule{}, nil)
+			db.On("AddAuthSet",
+				ctxMatcher,
+				mock.MatchedBy(
+					func(m model.AuthSet) bool {
+						return (m.Id == tc.req.AuthSetId) &&
+							(m.DeviceId == tc.req.DeviceId) &&
+							(m.IdData == tc.req.IdData) &&
+							(m.PubKey == tc.req.PubKey)
+					})).Return(tc.addAuthSetErr)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+			err := devauth.PreauthorizeDevice(context.Background(), tc.req)
+
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDevAuthAcceptDevice(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		aset *model.AuthSet
+
+		dbLimit    *model.Limit
+		dbLimitErr error
+
+		dbCount    int
+		dbCountErr error
+
+		dev                *model.Device
+		dbGetDeviceByIdErr error
+
+		dbUpdateErr               error
+		dbUpdateRevokeAuthSetsErr error
+
+		dbGetErr error
+
+		coSubmitProvisionDeviceJobErr error
+
+		outErr string
+	}{
+		{
+			aset: &model.AuthSet{
+				Id:       "dummy_aid",
+				DeviceId: "dummy_devid",
+			},
+			dev: &model.Device{
+				Id:     "dummy_devid",
+				Status: model.DevStatusPending,
+			},
+			dbLimit: &model.Limit{Value: 0},
+		},
+		{
+			aset: &model.AuthSet{
+				Id:       "dummy_aid",
+				DeviceId: "dummy_devid",
+			},
+			dev: &model.Device{
+				Id:     "dummy_devid",
+				Status: model.DevStatusPending,
+			},
+			dbLimit: &model.Limit{Value: 5},
+			dbCount: 4,
+		},
+		{
+			aset: &model.AuthSet{
+				Id:       "dummy_aid",
+				DeviceId: "dummy_devid",
+				Status:   model.DevStatusAccepted,
+			},
+			dev: &model.Device{
+				Id:     "dummy_devid",
+				Status: model.DevStatusPending,
+			},
+			coSubmitProvisionDeviceJobErr: errors.New("conductor shouldn't be called"),
+			dbLimit: &model.Limit{Value: 5},
+			dbCount: 4,
+		},
+		{
+			aset: &model.AuthSet{
+				Id:       "dummy_aid",
+				DeviceId: "dummy_devid",
+				Status:   model.DevStatusPending,
+			},
+			dev: &model.Device{
+				Id:     "dummy_devid",
+				Status: model.DevStatusAccepted,
+			},
+			coSubmitProvisionDeviceJobErr: errors.New("conductor shouldn't be called"),
+			dbLimit: &model.Limit{Value: 5},
+			dbCount: 4,
+		},
+		{
+			aset: &model.AuthSet{
+				Id:       "dummy_aid",
+				DeviceId: "dummy_devid",
+			},
+			dev: &model.Device{
+				Id:     "dummy_devid",
+				Status: model.DevStatusPending,
+			},
+			dbLimit: &model.Limit{Value: 5},
+			dbCount: 5,
+			outErr:  "maximum number of accepted devices reached",
+		},
+		{
+			aset: &model.AuthSet{
+				Id:       "dummy_aid",
+				DeviceId: "dummy_devid",
+			},
+			dev: &model.Device{
+				Id:     "dummy_devid",
+				Status: model.DevStatusPending,
+			},
+			dbLimit: &model.Limit{Value: 5},
+			dbCount: 6,
+			outErr:  "maximum number of accepted devices reached",
+		},
+		{
+			aset: &model.AuthSet{
+				Id:       "dummy_aid",
+				DeviceId: "dummy_devid",
+			},
+			dev: &model.Device{
+				Id:     "dummy_devid",
+				Status: model.DevStatusPending,
+			},
+			dbLimit:    &model.Limit{Value: 5},
+			dbLimitErr: errors.New("error"),
+			outErr:     "can't get current device limit: error",
+		},
+		{
+			aset: &model.AuthSet{
+				Id:       "dummy_aid",
+				DeviceId: "dummy_devid",
+			},
+			dev: &model.Device{
+				Id:     "dummy_devid",
+				Status: model.DevStatusPending,
+			},
+			dbLimit:    &model.Limit{Value: 5},
+			dbCountErr: errors.New("error"),
+			outErr:     "can't get current device count: error",
+		},
+		{
+			dbLimit:  &model.Limit{Value: 0},
+			dbGetErr: store.ErrDevNotFound,
+			outErr:   store.ErrDevNotFound.Error(),
+		},
+		{
+			dbLimit: &model.Limit{Value: 0},
+			aset: &model.AuthSet{
+				Id:       "dummy_aid",
+				DeviceId: "dummy_devid",
+			},
+			dev: &model.Device{
+				Id:     "dummy_devid",
+				Status: model.DevStatusPending,
+			},
+			dbUpdateErr: errors.New("failed to update device"),
+			outErr:      "db update device auth set error: failed to update device",
+		},
+		{
+			dbLimit: &model.Limit{Value: 0},
+			aset: &model.AuthSet{
+				Id:       "dummy_aid",
+				DeviceId: "dummy_devid",
+			},
+			dev: &model.Device{
+				Id:     "dummy_devid",
+				Status: model.DevStatusPending,
+			},
+			coSubmitProvisionDeviceJobErr: errors.New("conductor failed"),
+			outErr: "submit device provisioning job error: conductor failed",
+		},
+		{
+			dbLimit: &model.Limit{Value: 0},
+			aset: &model.AuthSet{
+				Id:       "dummy_aid",
+				DeviceId: "dummy_devid",
+			},
+			dev: &model.Device{
+				Id:     "dummy_devid",
+				Status: model.DevStatusPending,
+			},
+			dbUpdateRevokeAuthSetsErr: store.ErrAuthSetNotFound,
+		},
+		{
+			dbLimit: &model.Limit{Value: 0},
+			aset: &model.AuthSet{
+				Id:       "dummy_aid",
+				DeviceId: "dummy_devid",
+			},
+			dev: &model.Device{
+				Id:     "dummy_devid",
+				Status: model.DevStatusPending,
+			},
+			dbUpdateRevokeAuthSetsErr: errors.New("foobar"),
+			outErr: "failed to reject auth sets: foobar",
+		},
+		{
+			aset: &model.AuthSet{
+				Id:       "dummy_aid",
+				DeviceId: "dummy_devid",
+			},
+			dbLimit:            &model.Limit{Value: 5},
+			dbCount:            4,
+			dbGetDeviceByIdErr: errors.New("Get device failed"),
+			outErr:             "Get device failed",
+		},
+	}
+
+	for idx := range testCases {
+		tc := testCases[idx]
+		t.Run(fmt.Sprintf("tc %v", idx), func(t *testing.T) {
+			t.Parallel()
+
+			db := mstore.DataStore{}
+			db.On("GetAuthSetById",
+				context.Background(), "dummy_aid").Return(tc.aset, tc.dbGetErr)
+			db.On("GetLimit",
+				context.Background(), model.LimitMaxDeviceCount).Return(tc.dbLimit, tc.dbLimitErr)
+			db.On("GetDevCountByStatus",
+				context.Background(), model.DevStatusAccepted).Return(tc.dbCount, tc.dbCountErr)
+			db.On("GetDeviceById",
+				context.Background(), "dummy_devid").Return(tc.dev, tc.dbGetDeviceByIdErr)
+			db.On("UpdateDevice", context.Background(),
+				mock.AnythingOfType("model.Device"),
+				mock.AnythingOfType("model.DeviceUpdate")).Return(nil)
+
+			if tc.aset != nil {
+				// for rejecting all auth sets
+				db.On("UpdateAuthSet",
+					context.Background(),
+					mock.MatchedBy(
+						func(m bson.M) bool {
+							return m[model.AuthSetKeyDeviceId] == tc.aset.DeviceId
+						}),
+					model.AuthSetUpdate{
+						Status: model.DevStatusRejected,
+					}).Return(tc.dbUpdateRevokeAuthSetsErr)
+				// for accepting a single one
+				db.On("UpdateAuthSetById", context.Background(),
+					tc.aset.Id,
+					model.AuthSetUpdate{
+						Status: model.DevStatusAccepted,
+					}).Return(tc.dbUpdateErr)
+			}
+
+			ctx := context.Background()
+
+			co := morchestrator.ClientRunner{}
+			co.On("SubmitProvisionDeviceJob", ctx,
+				mock.AnythingOfType("orchestrator.ProvisionDeviceReq")).
+				Return(tc.coSubmitProvisionDeviceJobErr)
+
+			devauth := NewDevAuth(&db, &co, nil, Config{})
+			err := devauth.AcceptDeviceAuth(context.Background(), "dummy_devid", "dummy_aid")
+
+			if tc.outErr != "" {
+				assert.EqualError(t, err, tc.outErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDevAuthRejectDevice(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		aset             *model.AuthSet
+		dbErr            error
+		dbDelDevTokenErr error
+
+		outErr string
+	}{
+		{
+			aset: &model.AuthSet{
+				Id:       "dummy_aid",
+				DeviceId: "dummy_devid",
+			},
+			dbDelDevTokenErr: nil,
+		},
+		{
+			dbErr:            errors.New("failed"),
+			dbDelDevTokenErr: nil,
+			outErr:           "db get auth set error: failed",
+		},
+		{
+			aset: &model.AuthSet{
+				Id:       "dummy_aid",
+				DeviceId: "dummy_devid",
+			},
+			dbDelDevTokenErr: store.ErrTokenNotFound,
+			outErr:           "db delete device token error: token not found",
+		},
+		{
+			aset: &model.AuthSet{
+				Id:       "dummy_aid",
+				DeviceId: "dummy_devid",
+				Status:   "accepted",
+			},
+			dbDelDevTokenErr: errors.New("some error"),
+			outErr:           "db delete device token error: some error",
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(fmt.Sprintf("tc %d", i), func(t *testing.T) {
+			t.Parallel()
+
+			db := mstore.DataStore{}
+			db.On("GetAuthSetById", context.Background(), "dummy_aid").Return(tc.aset, tc.dbErr)
+			if tc.aset != nil {
+				db.On("UpdateAuthSetById", context.Background(), tc.aset.Id,
+					model.AuthSetUpdate{Status: model.DevStatusRejected}).Return(nil)
+			}
+			db.On("DeleteTokenByDevId", context.Background(), "dummy_devid").Return(
+				tc.dbDelDevTokenErr)
+			db.On("GetDeviceStatus", context.Background(),
+				"dummy_devid").Return(
+				"accpted", nil)
+			db.On("UpdateDevice", context.Background(),
+				mock.AnythingOfType("model.Device"),
+				mock.AnythingOfType("model.DeviceUpdate")).Return(nil)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+			err := devauth.RejectDeviceAuth(context.Background(), "dummy_devid", "dummy_aid")
+
+			if tc.dbErr != nil || (tc.dbDelDevTokenErr != nil &&
+				tc.dbDelDevTokenErr != store.ErrTokenNotFound) {
+
+				assert.EqualError(t, err, tc.outErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
 
-		dbGetErr error
+func TestDevAuthResetDevice(t *testing.T) {
+	t.Parallel()
 
-		coSubmitProvisionDeviceJobErr error
+	testCases := []struct {
+		aset             *model.AuthSet
+		dbErr            error
+		dbDelDevTokenErr error
 
 		outErr string
 	}{
@@ -810,296 +2354,540 @@ func TestDevAuthAcceptDevice(t *testing.T) {
 				Id:       "dummy_aid",
 				DeviceId: "dummy_devid",
 			},
-			dev: &model.Device{
-				Id:     "dummy_devid",
-				Status: model.DevStatusPending,
-			},
-			dbLimit: &model.Limit{Value: 0},
+			dbDelDevTokenErr: nil,
 		},
 		{
-			aset: &model.AuthSet{
-				Id:       "dummy_aid",
-				DeviceId: "dummy_devid",
-			},
-			dev: &model.Device{
-				Id:     "dummy_devid",
-				Status: model.DevStatusPending,
-			},
-			dbLimit: &model.Limit{Value: 5},
-			dbCount: 4,
+			dbErr:  errors.New("failed"),
+			outErr: "db get auth set error: failed",
 		},
 		{
 			aset: &model.AuthSet{
 				Id:       "dummy_aid",
 				DeviceId: "dummy_devid",
-				Status:   model.DevStatusAccepted,
-			},
-			dev: &model.Device{
-				Id:     "dummy_devid",
-				Status: model.DevStatusPending,
+				Status:   "accepted",
 			},
-			coSubmitProvisionDeviceJobErr: errors.New("conductor shouldn't be called"),
-			dbLimit: &model.Limit{Value: 5},
-			dbCount: 4,
+			dbDelDevTokenErr: store.ErrTokenNotFound,
+			outErr:           "db delete device token error: token not found",
 		},
 		{
 			aset: &model.AuthSet{
 				Id:       "dummy_aid",
 				DeviceId: "dummy_devid",
-				Status:   model.DevStatusPending,
-			},
-			dev: &model.Device{
-				Id:     "dummy_devid",
-				Status: model.DevStatusAccepted,
+				Status:   "accepted",
 			},
-			coSubmitProvisionDeviceJobErr: errors.New("conductor shouldn't be called"),
-			dbLimit: &model.Limit{Value: 5},
-			dbCount: 4,
+			dbDelDevTokenErr: errors.New("some error"),
+			outErr:           "db delete device token error: some error",
 		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(fmt.Sprintf("tc %d", i), func(t *testing.T) {
+			t.Parallel()
+
+			db := mstore.DataStore{}
+			db.On("GetAuthSetById", context.Background(), "dummy_aid").Return(tc.aset, tc.dbErr)
+			if tc.aset != nil {
+				db.On("UpdateAuthSetById", context.Background(), tc.aset.Id,
+					model.AuthSetUpdate{Status: model.DevStatusPending}).Return(nil)
+			}
+			db.On("DeleteTokenByDevId", context.Background(), "dummy_devid").Return(
+				tc.dbDelDevTokenErr)
+			db.On("GetDeviceStatus", context.Background(),
+				"dummy_devid").Return(
+				"accpted", nil)
+			db.On("UpdateDevice", context.Background(),
+				mock.AnythingOfType("model.Device"),
+				mock.AnythingOfType("model.DeviceUpdate")).Return(nil)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+			err := devauth.ResetDeviceAuth(context.Background(), "dummy_devid", "dummy_aid")
+
+			if tc.dbErr != nil ||
+				(tc.dbDelDevTokenErr != nil &&
+					tc.dbDelDevTokenErr != store.ErrTokenNotFound) {
+
+				assert.EqualError(t, err, tc.outErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDevAuthVerifyToken(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		tokenString      string
+		tokenValidateErr error
+
+		jwToken     *jwt.Token
+		validateErr error
+
+		token       *model.Token
+		getTokenErr error
+
+		auth       *model.AuthSet
+		getAuthErr error
+
+		dev          *model.Device
+		getDeviceErr error
+
+		tenantVerify bool
+	}{
 		{
-			aset: &model.AuthSet{
-				Id:       "dummy_aid",
-				DeviceId: "dummy_devid",
-			},
-			dev: &model.Device{
-				Id:     "dummy_devid",
-				Status: model.DevStatusPending,
+			tokenString:      "expired",
+			tokenValidateErr: jwt.ErrTokenExpired,
+
+			jwToken: &jwt.Token{
+				Claims: jwt.Claims{
+					ID: "expired",
+				},
 			},
-			dbLimit: &model.Limit{Value: 5},
-			dbCount: 5,
-			outErr:  "maximum number of accepted devices reached",
+			validateErr: jwt.ErrTokenExpired,
 		},
 		{
-			aset: &model.AuthSet{
-				Id:       "dummy_aid",
-				DeviceId: "dummy_devid",
-			},
-			dev: &model.Device{
-				Id:     "dummy_devid",
-				Status: model.DevStatusPending,
-			},
-			dbLimit: &model.Limit{Value: 5},
-			dbCount: 6,
-			outErr:  "maximum number of accepted devices reached",
+			tokenString:      "bad",
+			tokenValidateErr: jwt.ErrTokenInvalid,
+
+			jwToken:     nil,
+			validateErr: jwt.ErrTokenInvalid,
 		},
 		{
-			aset: &model.AuthSet{
-				Id:       "dummy_aid",
-				DeviceId: "dummy_devid",
+			tokenString:      "good-no-auth",
+			tokenValidateErr: store.ErrDevNotFound,
+
+			jwToken: &jwt.Token{
+				Claims: jwt.Claims{
+					ID:     "good-no-auth",
+					Device: true,
+				},
 			},
-			dev: &model.Device{
-				Id:     "dummy_devid",
-				Status: model.DevStatusPending,
+			token: &model.Token{
+				Id:        "good-no-auth",
+				AuthSetId: "not-found",
 			},
-			dbLimit:    &model.Limit{Value: 5},
-			dbLimitErr: errors.New("error"),
-			outErr:     "can't get current device limit: error",
+			getAuthErr: store.ErrDevNotFound,
 		},
 		{
-			aset: &model.AuthSet{
-				Id:       "dummy_aid",
-				DeviceId: "dummy_devid",
+			tokenString: "good-accepted",
+			jwToken: &jwt.Token{
+				Claims: jwt.Claims{
+					ID:     "good-accepted",
+					Device: true,
+				},
 			},
-			dev: &model.Device{
-				Id:     "dummy_devid",
-				Status: model.DevStatusPending,
+			token: &model.Token{
+				Id:        "good-accepted",
+				AuthSetId: "foo",
 			},
-			dbLimit:    &model.Limit{Value: 5},
-			dbCountErr: errors.New("error"),
-			outErr:     "can't get current device count: error",
-		},
-		{
-			dbLimit:  &model.Limit{Value: 0},
-			dbGetErr: store.ErrDevNotFound,
-			outErr:   store.ErrDevNotFound.Error(),
-		},
-		{
-			dbLimit: &model.Limit{Value: 0},
-			aset: &model.AuthSet{
-				Id:       "dummy_aid",
-				DeviceId: "dummy_devid",
+			auth: &model.AuthSet{
+				Id:       "foo",
+				Status:   model.DevStatusAccepted,
+				DeviceId: "foodev",
 			},
 			dev: &model.Device{
-				Id:     "dummy_devid",
-				Status: model.DevStatusPending,
+				Id:              "foodev",
+				Decommissioning: false,
 			},
-			dbUpdateErr: errors.New("failed to update device"),
-			outErr:      "db update device auth set error: failed to update device",
 		},
 		{
-			dbLimit: &model.Limit{Value: 0},
-			aset: &model.AuthSet{
-				Id:       "dummy_aid",
-				DeviceId: "dummy_devid",
+			tokenString:      "good-rejected",
+			tokenValidateErr: jwt.ErrTokenInvalid,
+
+			jwToken: &jwt.Token{
+				Claims: jwt.Claims{
+					ID:     "good-rejected",
+					Device: true,
+				},
 			},
-			dev: &model.Device{
-				Id:     "dummy_devid",
-				Status: model.DevStatusPending,
+			token: &model.Token{
+				Id:        "good-rejected",
+				AuthSetId: "foo",
+			},
+			auth: &model.AuthSet{
+				Id:     "foo",
+				Status: model.DevStatusRejected,
 			},
-			coSubmitProvisionDeviceJobErr: errors.New("conductor failed"),
-			outErr: "submit device provisioning job error: conductor failed",
 		},
 		{
-			dbLimit: &model.Limit{Value: 0},
-			aset: &model.AuthSet{
-				Id:       "dummy_aid",
-				DeviceId: "dummy_devid",
+			tokenString:      "good-accepted-decommissioning",
+			tokenValidateErr: jwt.ErrTokenInvalid,
+
+			jwToken: &jwt.Token{
+				Claims: jwt.Claims{
+					ID:     "good-accepted-decommissioning",
+					Device: true,
+				},
 			},
-			dev: &model.Device{
-				Id:     "dummy_devid",
-				Status: model.DevStatusPending,
+			token: &model.Token{
+				Id:        "good-accepted-decommissioning",
+				AuthSetId: "foo",
 			},
-			dbUpdateRevokeAuthSetsErr: store.ErrAuthSetNotFound,
-		},
-		{
-			dbLimit: &model.Limit{Value: 0},
-			aset: &model.AuthSet{
-				Id:       "dummy_aid",
-				DeviceId: "dummy_devid",
+			auth: &model.AuthSet{
+				Id:       "foo",
+				Status:   model.DevStatusAccepted,
+				DeviceId: "foodev",
 			},
 			dev: &model.Device{
-				Id:     "dummy_devid",
-				Status: model.DevStatusPending,
+				Id:              "foodev",
+				Decommissioning: true,
 			},
-			dbUpdateRevokeAuthSetsErr: errors.New("foobar"),
-			outErr: "failed to reject auth sets: foobar",
 		},
 		{
-			aset: &model.AuthSet{
-				Id:       "dummy_aid",
-				DeviceId: "dummy_devid",
+			tokenString:      "missing-tenant-claim",
+			tokenValidateErr: jwt.ErrTokenInvalid,
+
+			jwToken: &jwt.Token{
+				Claims: jwt.Claims{
+					ID: "missing-tenant-claim",
+				},
 			},
-			dbLimit:            &model.Limit{Value: 5},
-			dbCount:            4,
-			dbGetDeviceByIdErr: errors.New("Get device failed"),
-			outErr:             "Get device failed",
+
+			tenantVerify: true,
 		},
 	}
 
-	for idx := range testCases {
-		tc := testCases[idx]
-		t.Run(fmt.Sprintf("tc %v", idx), func(t *testing.T) {
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(fmt.Sprintf("tc %s", tc.tokenString), func(t *testing.T) {
 			t.Parallel()
 
-			db := mstore.DataStore{}
-			db.On("GetAuthSetById",
-				context.Background(), "dummy_aid").Return(tc.aset, tc.dbGetErr)
-			db.On("GetLimit",
-				context.Background(), model.LimitMaxDeviceCount).Return(tc.dbLimit, tc.dbLimitErr)
-			db.On("GetDevCountByStatus",
-				context.Background(), model.DevStatusAccepted).Return(tc.dbCount, tc.dbCountErr)
-			db.On("GetDeviceById",
-				context.Background(), "dummy_devid").Return(tc.dev, tc.dbGetDeviceByIdErr)
-			db.On("UpdateDevice", context.Background(),
-				mock.AnythingOfType("model.Device"),
-				mock.AnythingOfType("model.DeviceUpdate")).Return(nil)
+			db := &mstore.DataStore{}
+			ja := &mjwt.Handler{}
 
-			if tc.aset != nil {
-				// for rejecting all auth sets
-				db.On("UpdateAuthSet",
-					context.Background(),
-					mock.MatchedBy(
-						func(m bson.M) bool {
-							return m[model.AuthSetKeyDeviceId] == tc.aset.DeviceId
-						}),
-					model.AuthSetUpdate{
-						Status: model.DevStatusRejected,
-					}).Return(tc.dbUpdateRevokeAuthSetsErr)
-				// for accepting a single one
-				db.On("UpdateAuthSetById", context.Background(),
-					tc.aset.Id,
-					model.AuthSetUpdate{
-						Status: model.DevStatusAccepted,
-					}).Return(tc.dbUpdateErr)
+			devauth := NewDevAuth(db, nil, ja, Config{})
+			if tc.tenantVerify {
+				// ok to pass nil tenantadm client here
+				devauth = devauth.WithTenantVerification(nil)
 			}
 
-			ctx := context.Background()
+			// ja.On("FromJWT", tc.tokenString).Return(tc.jwToken, tc.validateErr)
+			ja.On("FromJWT", tc.tokenString).Return(
+				func(s string) *jwt.Token {
+					t.Logf("string: %v return %+v", s, tc.jwToken)
+					return tc.jwToken
+				}, tc.validateErr)
 
-			co := morchestrator.ClientRunner{}
-			co.On("SubmitProvisionDeviceJob", ctx,
-				mock.AnythingOfType("orchestrator.ProvisionDeviceReq")).
-				Return(tc.coSubmitProvisionDeviceJobErr)
+			if tc.validateErr == jwt.ErrTokenExpired {
+				db.On("DeleteToken",
+					context.Background(),
+					tc.jwToken.Claims.ID).Return(nil)
+			}
 
-			devauth := NewDevAuth(&db, &co, nil, Config{})
-			err := devauth.AcceptDeviceAuth(context.Background(), "dummy_devid", "dummy_aid")
+			if tc.token != nil {
+				db.On("GetToken", context.Background(),
+					tc.jwToken.Claims.ID).
+					Return(tc.token, tc.getTokenErr)
+			}
 
-			if tc.outErr != "" {
-				assert.EqualError(t, err, tc.outErr)
+			if tc.token != nil {
+				db.On("GetAuthSetById", context.Background(),
+					tc.token.AuthSetId).Return(tc.auth, tc.getAuthErr)
+				// devauth will ask for a device if auth set is
+				// found and accepted
+				if tc.dev != nil {
+					db.On("GetDeviceById", context.Background(),
+						tc.auth.DeviceId).Return(tc.dev, tc.getDeviceErr)
+				}
+			}
+
+			err := devauth.VerifyToken(context.Background(), tc.tokenString, "", nil)
+			if tc.tokenValidateErr != nil {
+				assert.EqualError(t, err, tc.tokenValidateErr.Error())
 			} else {
 				assert.NoError(t, err)
 			}
+			ja.AssertExpectations(t)
+			db.AssertExpectations(t)
+
 		})
 	}
 }
 
-func TestDevAuthRejectDevice(t *testing.T) {
+func TestDevAuthVerifyTokenCache(t *testing.T) {
 	t.Parallel()
 
-	testCases := []struct {
-		aset             *model.AuthSet
-		dbErr            error
-		dbDelDevTokenErr error
+	ctx := context.Background()
 
-		outErr string
+	jwToken := &jwt.Token{
+		Claims: jwt.Claims{
+			ID:        "jti1",
+			ExpiresAt: time.Now().Unix() + 3600,
+			Device:    true,
+		},
+	}
+
+	db := &mstore.DataStore{}
+	ja := &mjwt.Handler{}
+
+	ja.On("FromJWT", "dummytoken").Return(jwToken, nil)
+	db.On("GetToken", ctx, "jti1").
+		Return(&model.Token{Id: "jti1", AuthSetId: "foo"}, nil)
+	db.On("GetAuthSetById", ctx, "foo").
+		Return(&model.AuthSet{
+			Id:       "foo",
+			Status:   model.DevStatusAccepted,
+			DeviceId: "foodev",
+		}, nil)
+	db.On("GetDeviceById", ctx, "foodev").
+		Return(&model.Device{Id: "foodev"}, nil)
+	db.On("DeleteToken", ctx, "jti1").Return(nil)
+
+	vc := cache.NewVerificationCache(10, time.Minute)
+	devauth := NewDevAuth(db, nil, ja, Config{}).WithVerificationCache(vc)
+
+	// miss, then hit
+	assert.NoError(t, devauth.VerifyToken(ctx, "dummytoken", "", nil))
+	assert.NoError(t, devauth.VerifyToken(ctx, "dummytoken", "", nil))
+	db.AssertNumberOfCalls(t, "GetToken", 1)
+	assert.Equal(t, cache.Stats{Hits: 1, Misses: 1, Size: 1}, vc.Stats())
+
+	stats, err := devauth.GetVerificationCacheStats(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &cache.Stats{Hits: 1, Misses: 1, Size: 1}, stats)
+
+	// revoking the token drops the cached result
+	assert.NoError(t, devauth.RevokeToken(ctx, "jti1"))
+	assert.Equal(t, 0, vc.Stats().Size)
+
+	assert.NoError(t, devauth.VerifyToken(ctx, "dummytoken", "", nil))
+	db.AssertNumberOfCalls(t, "GetToken", 2)
+}
+
+func TestDevAuthGetVerificationCacheStatsDisabled(t *testing.T) {
+	t.Parallel()
+
+	devauth := NewDevAuth(&mstore.DataStore{}, nil, nil, Config{})
+
+	stats, err := devauth.GetVerificationCacheStats(context.Background())
+	assert.Nil(t, stats)
+	assert.Equal(t, ErrVerificationCacheDisabled, err)
+}
+
+func TestDevAuthVerifyTokenCacheReaccept(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	jwToken := &jwt.Token{
+		Claims: jwt.Claims{
+			ID:        "jti1",
+			ExpiresAt: time.Now().Unix() + 3600,
+			Device:    true,
+		},
+	}
+
+	db := &mstore.DataStore{}
+	ja := &mjwt.Handler{}
+	co := &morchestrator.ClientRunner{}
+
+	ja.On("FromJWT", "dummytoken").Return(jwToken, nil)
+	db.On("GetToken", ctx, "jti1").
+		Return(&model.Token{Id: "jti1", AuthSetId: "foo"}, nil)
+	db.On("GetAuthSetById", ctx, "foo").
+		Return(&model.AuthSet{
+			Id:       "foo",
+			Status:   model.DevStatusAccepted,
+			DeviceId: "foodev",
+		}, nil).Once()
+	db.On("GetDeviceById", ctx, "foodev").
+		Return(&model.Device{Id: "foodev", Status: model.DevStatusAccepted}, nil)
+
+	// another key of the device is accepted, as with a trusted certificate
+	db.On("GetLimit", ctx, model.LimitMaxDeviceCount).
+		Return(&model.Limit{Value: 0}, nil)
+	db.On("UpdateAuthSet", ctx,
+		mock.AnythingOfType("bson.M"),
+		model.AuthSetUpdate{Status: model.DevStatusRejected}).
+		Return(nil)
+	db.On("UpdateAuthSetById", ctx, "bar",
+		model.AuthSetUpdate{Status: model.DevStatusAccepted}).
+		Return(nil)
+	db.On("UpdateDevice", ctx,
+		model.Device{Id: "foodev"},
+		mock.AnythingOfType("model.DeviceUpdate")).
+		Return(nil)
+
+	vc := cache.NewVerificationCache(10, time.Minute)
+	devauth := NewDevAuth(db, co, ja, Config{}).WithVerificationCache(vc)
+
+	assert.NoError(t, devauth.VerifyToken(ctx, "dummytoken", "", nil))
+	assert.Equal(t, 1, vc.Stats().Size)
+
+	err := devauth.rejectAcceptedAuthSets(ctx, "foodev")
+	assert.NoError(t, err)
+	err = devauth.autoAcceptAuthSet(ctx,
+		&model.AuthSet{Id: "bar", DeviceId: "foodev", Status: model.DevStatusPending})
+	assert.NoError(t, err)
+
+	// the token of the rejected auth set is verified again
+	db.On("GetAuthSetById", ctx, "foo").
+		Return(&model.AuthSet{
+			Id:       "foo",
+			Status:   model.DevStatusRejected,
+			DeviceId: "foodev",
+		}, nil)
+	db.On("DeleteToken", ctx, "jti1").Return(nil)
+
+	err = devauth.VerifyToken(ctx, "dummytoken", "", nil)
+	assert.EqualError(t, err, jwt.ErrTokenInvalid.Error())
+	db.AssertNumberOfCalls(t, "GetToken", 2)
+}
+
+func TestDevAuthVerifyTokenScope(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		tokenScope string
+		scope      string
+
+		err error
 	}{
-		{
-			aset: &model.AuthSet{
-				Id:       "dummy_aid",
-				DeviceId: "dummy_devid",
-			},
-			dbDelDevTokenErr: nil,
+		"ok, no scope required": {
+			tokenScope: "inventory",
 		},
-		{
-			dbErr:            errors.New("failed"),
-			dbDelDevTokenErr: nil,
-			outErr:           "db get auth set error: failed",
+		"ok, unrestricted token": {
+			scope: "deployments",
 		},
-		{
-			aset: &model.AuthSet{
-				Id:       "dummy_aid",
-				DeviceId: "dummy_devid",
-			},
-			dbDelDevTokenErr: store.ErrTokenNotFound,
-			outErr:           "db delete device token error: token not found",
+		"ok, scope": {
+			tokenScope: "inventory deployments",
+			scope:      "deployments",
 		},
-		{
-			aset: &model.AuthSet{
-				Id:       "dummy_aid",
-				DeviceId: "dummy_devid",
-				Status:   "accepted",
-			},
-			dbDelDevTokenErr: errors.New("some error"),
-			outErr:           "db delete device token error: some error",
+		"error, scope missing": {
+			tokenScope: "inventory",
+			scope:      "deployments",
+			err:        ErrTokenScopeMissing,
 		},
 	}
 
-	for i := range testCases {
-		tc := testCases[i]
-		t.Run(fmt.Sprintf("tc %d", i), func(t *testing.T) {
+	for n := range testCases {
+		tc := testCases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
 			t.Parallel()
 
-			db := mstore.DataStore{}
-			db.On("GetAuthSetById", context.Background(), "dummy_aid").Return(tc.aset, tc.dbErr)
-			if tc.aset != nil {
-				db.On("UpdateAuthSetById", context.Background(), tc.aset.Id,
-					model.AuthSetUpdate{Status: model.DevStatusRejected}).Return(nil)
+			ctx := context.Background()
+
+			db := &mstore.DataStore{}
+			ja := &mjwt.Handler{}
+
+			ja.On("FromJWT", "dummytoken").Return(&jwt.Token{
+				Claims: jwt.Claims{
+					ID:        "jti1",
+					ExpiresAt: time.Now().Unix() + 3600,
+					Scope:     tc.tokenScope,
+					Device:    true,
+				},
+			}, nil)
+			db.On("GetToken", ctx, "jti1").
+				Return(&model.Token{Id: "jti1", AuthSetId: "foo"}, nil)
+			db.On("GetAuthSetById", ctx, "foo").
+				Return(&model.AuthSet{
+					Id:       "foo",
+					Status:   model.DevStatusAccepted,
+					DeviceId: "foodev",
+				}, nil)
+			db.On("GetDeviceById", ctx, "foodev").
+				Return(&model.Device{Id: "foodev"}, nil)
+
+			devauth := NewDevAuth(db, nil, ja, Config{})
+			err := devauth.VerifyToken(ctx, "dummytoken", tc.scope, nil)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
 			}
-			db.On("DeleteTokenByDevId", context.Background(), "dummy_devid").Return(
-				tc.dbDelDevTokenErr)
-			db.On("GetDeviceStatus", context.Background(),
-				"dummy_devid").Return(
-				"accpted", nil)
-			db.On("UpdateDevice", context.Background(),
-				mock.AnythingOfType("model.Device"),
-				mock.AnythingOfType("model.DeviceUpdate")).Return(nil)
+		})
+	}
+}
 
-			devauth := NewDevAuth(&db, nil, nil, Config{})
-			err := devauth.RejectDeviceAuth(context.Background(), "dummy_devid", "dummy_aid")
+func TestDevAuthVerifyTokenAudience(t *testing.T) {
+	t.Parallel()
 
-			if tc.dbErr != nil || (tc.dbDelDevTokenErr != nil &&
-				tc.dbDelDevTokenErr != store.ErrTokenNotFound) {
+	testCases := map[string]struct {
+		config    Config
+		dbPolicy  *model.TokenPolicy
+		dbErr     error
+		audience  string
+		audiences []string
 
-				assert.EqualError(t, err, tc.outErr)
+		err error
+	}{
+		"ok, audience checks disabled": {
+			audience: "other",
+		},
+		"ok, server audience": {
+			config:   Config{Audience: "srv"},
+			dbPolicy: &model.TokenPolicy{},
+			audience: "srv",
+		},
+		"ok, tenant audience": {
+			config:   Config{Audience: "srv"},
+			dbPolicy: &model.TokenPolicy{Audience: "tenant"},
+			audience: "tenant",
+		},
+		"ok, accepted audiences": {
+			audience:  "aud2",
+			audiences: []string{"aud1", "aud2"},
+		},
+		"error, no audience": {
+			config:   Config{Audience: "srv"},
+			dbPolicy: &model.TokenPolicy{},
+			err:      jwt.ErrTokenInvalid,
+		},
+		"error, server audience overridden by the tenant": {
+			config:   Config{Audience: "srv"},
+			dbPolicy: &model.TokenPolicy{Audience: "tenant"},
+			audience: "srv",
+			err:      jwt.ErrTokenInvalid,
+		},
+		"error, audience not accepted": {
+			audience:  "aud3",
+			audiences: []string{"aud1", "aud2"},
+			err:       ErrTokenAudienceMismatch,
+		},
+		"error, db": {
+			config: Config{Audience: "srv"},
+			dbErr:  errors.New("db error"),
+			err:    errors.New("db get token policy error: db error"),
+		},
+	}
+
+	for n := range testCases {
+		tc := testCases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := &mstore.DataStore{}
+			ja := &mjwt.Handler{}
+
+			ja.On("FromJWT", "dummytoken").Return(&jwt.Token{
+				Claims: jwt.Claims{
+					ID:        "jti1",
+					ExpiresAt: time.Now().Unix() + 3600,
+					Audience:  tc.audience,
+					Device:    true,
+				},
+			}, nil)
+			db.On("GetTokenPolicy", ctx).Return(tc.dbPolicy, tc.dbErr)
+			db.On("GetToken", ctx, "jti1").
+				Return(&model.Token{Id: "jti1", AuthSetId: "foo"}, nil)
+			db.On("GetAuthSetById", ctx, "foo").
+				Return(&model.AuthSet{
+					Id:       "foo",
+					Status:   model.DevStatusAccepted,
+					DeviceId: "foodev",
+				}, nil)
+			db.On("GetDeviceById", ctx, "foodev").
+				Return(&model.Device{Id: "foodev"}, nil)
+
+			devauth := NewDevAuth(db, nil, ja, tc.config)
+			err := devauth.VerifyToken(ctx, "dummytoken", "", tc.audiences)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
 			} else {
 				assert.NoError(t, err)
 			}
@@ -1107,93 +2895,224 @@ func TestDevAuthRejectDevice(t *testing.T) {
 	}
 }
 
-func TestDevAuthResetDevice(t *testing.T) {
+func TestDevAuthRenewToken(t *testing.T) {
 	t.Parallel()
 
-	testCases := []struct {
-		aset             *model.AuthSet
-		dbErr            error
-		dbDelDevTokenErr error
+	privKey := mtesting.LoadPrivKeyPKCS8("testdata/private_ed25519.pem", t)
+	pubKey := mtesting.LoadPubKeyStr("testdata/public_ed25519.pem", t)
+	body := []byte(`{"token":"oldtoken"}`)
+	signature := string(mtesting.AuthReqSign(body, privKey, t))
 
-		outErr string
+	now := time.Now().Unix()
+
+	testCases := map[string]struct {
+		config       Config
+		tenantVerify bool
+
+		claims   jwt.Claims
+		parseErr error
+
+		signature string
+
+		dbGetTokenErr error
+		dbAuthSet     *model.AuthSet
+		dbDeleteErr   error
+
+		audience string
+		res      string
+		err      error
 	}{
-		{
-			aset: &model.AuthSet{
-				Id:       "dummy_aid",
-				DeviceId: "dummy_devid",
-			},
-			dbDelDevTokenErr: nil,
+		"ok": {
+			claims: jwt.Claims{ID: "jti1", ExpiresAt: now + 600, Device: true},
+			res:    "newtoken",
+		},
+		"ok, tenant": {
+			tenantVerify: true,
+			claims: jwt.Claims{ID: "jti1", ExpiresAt: now + 600,
+				Device: true, Tenant: "tenant1"},
+			res: "newtoken",
+		},
+		"ok, audience": {
+			config: Config{Audience: "srv"},
+			claims: jwt.Claims{ID: "jti1", ExpiresAt: now + 600,
+				Audience: "srv", Device: true},
+			audience: "srv",
+			res:      "newtoken",
+		},
+		"ok, expired in grace period": {
+			config:   Config{TokenRenewalGracePeriod: 3600},
+			claims:   jwt.Claims{ID: "jti1", ExpiresAt: now - 600, Device: true},
+			parseErr: jwt.ErrTokenExpired,
+			res:      "newtoken",
+		},
+		"error, expired": {
+			config:   Config{TokenRenewalGracePeriod: 300},
+			claims:   jwt.Claims{ID: "jti1", ExpiresAt: now - 600, Device: true},
+			parseErr: jwt.ErrTokenExpired,
+			err:      errors.New("dev auth: unauthorized: jwt: token expired"),
+		},
+		"error, invalid": {
+			parseErr: jwt.ErrTokenInvalid,
+			err:      errors.New("dev auth: unauthorized: jwt: token invalid"),
 		},
-		{
-			dbErr:  errors.New("failed"),
-			outErr: "db get auth set error: failed",
+		"error, not a device token": {
+			claims: jwt.Claims{ID: "jti1", ExpiresAt: now + 600},
+			err:    errors.New("dev auth: unauthorized: jwt: token invalid"),
 		},
-		{
-			aset: &model.AuthSet{
-				Id:       "dummy_aid",
-				DeviceId: "dummy_devid",
-				Status:   "accepted",
+		"error, audience": {
+			config: Config{Audience: "srv"},
+			claims: jwt.Claims{ID: "jti1", ExpiresAt: now + 600,
+				Audience: "other", Device: true},
+			err: errors.New("dev auth: unauthorized: jwt: token invalid"),
+		},
+		"error, revoked": {
+			claims:        jwt.Claims{ID: "jti1", ExpiresAt: now + 600, Device: true},
+			dbGetTokenErr: store.ErrTokenNotFound,
+			err:           errors.New("dev auth: unauthorized: token not found"),
+		},
+		"error, auth set rejected": {
+			claims: jwt.Claims{ID: "jti1", ExpiresAt: now + 600, Device: true},
+			dbAuthSet: &model.AuthSet{
+				Id:       "aid1",
+				DeviceId: "dev1",
+				PubKey:   pubKey,
+				Status:   model.DevStatusRejected,
 			},
-			dbDelDevTokenErr: store.ErrTokenNotFound,
-			outErr:           "db delete device token error: token not found",
+			err: errors.New("dev auth: unauthorized: jwt: token invalid"),
 		},
-		{
-			aset: &model.AuthSet{
-				Id:       "dummy_aid",
-				DeviceId: "dummy_devid",
-				Status:   "accepted",
+		"error, signature": {
+			claims:    jwt.Claims{ID: "jti1", ExpiresAt: now + 600, Device: true},
+			signature: "Zm9vYmFy",
+			err:       errors.New("dev auth: unauthorized: verification failed: invalid signature"),
+		},
+		"error, psk not supported": {
+			claims: jwt.Claims{ID: "jti1", ExpiresAt: now + 600, Device: true},
+			dbAuthSet: &model.AuthSet{
+				Id:             "aid1",
+				DeviceId:       "dev1",
+				Status:         model.DevStatusAccepted,
+				CredentialType: model.CredentialTypePSK,
+				PSK:            []byte("encrypted"),
 			},
-			dbDelDevTokenErr: errors.New("some error"),
-			outErr:           "db delete device token error: some error",
+			err: MakeErrDevAuthBadRequest(ErrPSKNotSupported),
+		},
+		"error, already renewed": {
+			claims:      jwt.Claims{ID: "jti1", ExpiresAt: now + 600, Device: true},
+			dbDeleteErr: store.ErrTokenNotFound,
+			err:         errors.New("dev auth: unauthorized: token not found"),
+		},
+		"error, db delete": {
+			claims:      jwt.Claims{ID: "jti1", ExpiresAt: now + 600, Device: true},
+			dbDeleteErr: errors.New("db error"),
+			err:         errors.New("db delete token error: db error"),
 		},
 	}
 
-	for i := range testCases {
-		tc := testCases[i]
-		t.Run(fmt.Sprintf("tc %d", i), func(t *testing.T) {
+	for n := range testCases {
+		tc := testCases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
 			t.Parallel()
 
+			ctxMatcher := mtesting.ContextMatcher()
+
+			authSet := tc.dbAuthSet
+			if authSet == nil {
+				authSet = &model.AuthSet{
+					Id:       "aid1",
+					DeviceId: "dev1",
+					PubKey:   pubKey,
+					Status:   model.DevStatusAccepted,
+				}
+			}
+
 			db := mstore.DataStore{}
-			db.On("GetAuthSetById", context.Background(), "dummy_aid").Return(tc.aset, tc.dbErr)
-			if tc.aset != nil {
-				db.On("UpdateAuthSetById", context.Background(), tc.aset.Id,
-					model.AuthSetUpdate{Status: model.DevStatusPending}).Return(nil)
+			db.On("GetTokenPolicy", ctxMatcher).
+				Return(&model.TokenPolicy{}, nil)
+			db.On("GetToken", ctxMatcher, "jti1").
+				Return(&model.Token{Id: "jti1", AuthSetId: "aid1"},
+					tc.dbGetTokenErr)
+			db.On("GetAuthSetById", ctxMatcher, "aid1").
+				Return(authSet, nil)
+			db.On("GetDeviceById", ctxMatcher, "dev1").
+				Return(&model.Device{Id: "dev1"}, nil)
+			db.On("DeleteToken", ctxMatcher, "jti1").
+				Return(tc.dbDeleteErr)
+			db.On("AddToken", ctxMatcher,
+				mock.MatchedBy(func(tok model.Token) bool {
+					return tok.AuthSetId == "aid1" &&
+						tok.DevId == "dev1"
+				})).Return(nil)
+
+			var token *jwt.Token
+			if tc.parseErr != jwt.ErrTokenInvalid {
+				token = &jwt.Token{Claims: tc.claims}
 			}
-			db.On("DeleteTokenByDevId", context.Background(), "dummy_devid").Return(
-				tc.dbDelDevTokenErr)
-			db.On("GetDeviceStatus", context.Background(),
-				"dummy_devid").Return(
-				"accpted", nil)
-			db.On("UpdateDevice", context.Background(),
-				mock.AnythingOfType("model.Device"),
-				mock.AnythingOfType("model.DeviceUpdate")).Return(nil)
 
-			devauth := NewDevAuth(&db, nil, nil, Config{})
-			err := devauth.ResetDeviceAuth(context.Background(), "dummy_devid", "dummy_aid")
+			jwth := mjwt.Handler{}
+			jwth.On("FromJWT", "oldtoken").Return(token, tc.parseErr)
+			jwth.On("ToJWT",
+				mock.MatchedBy(func(tok *jwt.Token) bool {
+					return tok.Claims.Subject == "dev1" &&
+						tok.Claims.Tenant == tc.claims.Tenant &&
+						tok.Claims.Audience == tc.audience
+				}),
+			).Return("newtoken", nil)
 
-			if tc.dbErr != nil ||
-				(tc.dbDelDevTokenErr != nil &&
-					tc.dbDelDevTokenErr != store.ErrTokenNotFound) {
+			devauth := NewDevAuth(&db, nil, &jwth, tc.config)
+			if tc.tenantVerify {
+				devauth = devauth.WithTenantVerification(nil)
+			}
 
-				assert.EqualError(t, err, tc.outErr)
+			sig := signature
+			if tc.signature != "" {
+				sig = tc.signature
+			}
+
+			res, err := devauth.RenewToken(context.Background(),
+				&model.TokenRenewReq{
+					Token:     "oldtoken",
+					Body:      body,
+					Signature: sig,
+				})
+
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+				db.AssertNotCalled(t, "AddToken", ctxMatcher,
+					mock.AnythingOfType("model.Token"))
 			} else {
 				assert.NoError(t, err)
+				assert.Equal(t, tc.res, res)
+				db.AssertCalled(t, "DeleteToken", ctxMatcher, "jti1")
 			}
 		})
 	}
 }
 
-func TestDevAuthVerifyToken(t *testing.T) {
+func TestDevAuthIntrospectToken(t *testing.T) {
 	t.Parallel()
 
-	testCases := []struct {
-		tokenString      string
-		tokenValidateErr error
+	jwToken := &jwt.Token{
+		Claims: jwt.Claims{
+			ID:        "jti1",
+			Subject:   "foodev",
+			IssuedAt:  1599996400,
+			ExpiresAt: 1600000000,
+			Device:    true,
+		},
+	}
+	token := &model.Token{
+		Id:        "jti1",
+		AuthSetId: "foo",
+	}
+	accepted := &model.AuthSet{
+		Id:       "foo",
+		Status:   model.DevStatusAccepted,
+		DeviceId: "foodev",
+	}
 
-		jwToken     *jwt.Token
+	testCases := map[string]struct {
 		validateErr error
 
-		token       *model.Token
 		getTokenErr error
 
 		auth       *model.AuthSet
@@ -1202,174 +3121,84 @@ func TestDevAuthVerifyToken(t *testing.T) {
 		dev          *model.Device
 		getDeviceErr error
 
-		tenantVerify bool
+		out    *model.TokenIntrospection
+		outErr error
 	}{
-		{
-			tokenString:      "expired",
-			tokenValidateErr: jwt.ErrTokenExpired,
-
-			jwToken: &jwt.Token{
-				Claims: jwt.Claims{
-					ID: "expired",
-				},
+		"ok, active": {
+			auth: accepted,
+			dev: &model.Device{
+				Id:     "foodev",
+				Status: model.DevStatusAccepted,
+			},
+			out: &model.TokenIntrospection{
+				Active:       true,
+				Subject:      "foodev",
+				ExpiresAt:    1600000000,
+				IssuedAt:     1599996400,
+				ID:           "jti1",
+				AuthSetId:    "foo",
+				DeviceStatus: model.DevStatusAccepted,
 			},
-			validateErr: jwt.ErrTokenExpired,
 		},
-		{
-			tokenString:      "bad",
-			tokenValidateErr: jwt.ErrTokenInvalid,
-
-			jwToken:     nil,
+		"ok, invalid": {
 			validateErr: jwt.ErrTokenInvalid,
+			out:         &model.TokenIntrospection{Active: false},
 		},
-		{
-			tokenString:      "good-no-auth",
-			tokenValidateErr: store.ErrDevNotFound,
-
-			jwToken: &jwt.Token{
-				Claims: jwt.Claims{
-					ID:     "good-no-auth",
-					Device: true,
-				},
-			},
-			token: &model.Token{
-				Id:        "good-no-auth",
-				AuthSetId: "not-found",
-			},
-			getAuthErr: store.ErrDevNotFound,
-		},
-		{
-			tokenString: "good-accepted",
-			jwToken: &jwt.Token{
-				Claims: jwt.Claims{
-					ID:     "good-accepted",
-					Device: true,
-				},
-			},
-			token: &model.Token{
-				Id:        "good-accepted",
-				AuthSetId: "foo",
-			},
-			auth: &model.AuthSet{
-				Id:       "foo",
-				Status:   model.DevStatusAccepted,
-				DeviceId: "foodev",
-			},
-			dev: &model.Device{
-				Id:              "foodev",
-				Decommissioning: false,
-			},
+		"ok, revoked": {
+			getTokenErr: store.ErrTokenNotFound,
+			out:         &model.TokenIntrospection{Active: false},
 		},
-		{
-			tokenString:      "good-rejected",
-			tokenValidateErr: jwt.ErrTokenInvalid,
-
-			jwToken: &jwt.Token{
-				Claims: jwt.Claims{
-					ID:     "good-rejected",
-					Device: true,
-				},
-			},
-			token: &model.Token{
-				Id:        "good-rejected",
-				AuthSetId: "foo",
-			},
-			auth: &model.AuthSet{
-				Id:     "foo",
-				Status: model.DevStatusRejected,
-			},
+		"ok, auth set gone": {
+			getAuthErr: store.ErrAuthSetNotFound,
+			out:        &model.TokenIntrospection{Active: false},
 		},
-		{
-			tokenString:      "good-accepted-decommissioning",
-			tokenValidateErr: jwt.ErrTokenInvalid,
-
-			jwToken: &jwt.Token{
-				Claims: jwt.Claims{
-					ID:     "good-accepted-decommissioning",
-					Device: true,
-				},
-			},
-			token: &model.Token{
-				Id:        "good-accepted-decommissioning",
-				AuthSetId: "foo",
-			},
+		"ok, rejected": {
 			auth: &model.AuthSet{
 				Id:       "foo",
-				Status:   model.DevStatusAccepted,
+				Status:   model.DevStatusRejected,
 				DeviceId: "foodev",
 			},
+			out: &model.TokenIntrospection{Active: false},
+		},
+		"ok, decommissioning": {
+			auth: accepted,
 			dev: &model.Device{
 				Id:              "foodev",
 				Decommissioning: true,
 			},
+			out: &model.TokenIntrospection{Active: false},
 		},
-		{
-			tokenString:      "missing-tenant-claim",
-			tokenValidateErr: jwt.ErrTokenInvalid,
-
-			jwToken: &jwt.Token{
-				Claims: jwt.Claims{
-					ID: "missing-tenant-claim",
-				},
-			},
-
-			tenantVerify: true,
+		"error, db": {
+			auth:         accepted,
+			getDeviceErr: errors.New("db error"),
+			outErr:       errors.New("db error"),
 		},
 	}
 
-	for i := range testCases {
-		tc := testCases[i]
-		t.Run(fmt.Sprintf("tc %s", tc.tokenString), func(t *testing.T) {
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(fmt.Sprintf("tc %s", name), func(t *testing.T) {
 			t.Parallel()
 
+			ctx := context.Background()
+
 			db := &mstore.DataStore{}
 			ja := &mjwt.Handler{}
 
-			devauth := NewDevAuth(db, nil, ja, Config{})
-			if tc.tenantVerify {
-				// ok to pass nil tenantadm client here
-				devauth = devauth.WithTenantVerification(nil)
-			}
-
-			// ja.On("FromJWT", tc.tokenString).Return(tc.jwToken, tc.validateErr)
-			ja.On("FromJWT", tc.tokenString).Return(
-				func(s string) *jwt.Token {
-					t.Logf("string: %v return %+v", s, tc.jwToken)
-					return tc.jwToken
-				}, tc.validateErr)
-
-			if tc.validateErr == jwt.ErrTokenExpired {
-				db.On("DeleteToken",
-					context.Background(),
-					tc.jwToken.Claims.ID).Return(nil)
-			}
-
-			if tc.token != nil {
-				db.On("GetToken", context.Background(),
-					tc.jwToken.Claims.ID).
-					Return(tc.token, tc.getTokenErr)
-			}
+			ja.On("FromJWT", "dummytoken").Return(jwToken, tc.validateErr)
+			db.On("GetToken", ctx, "jti1").Return(token, tc.getTokenErr)
+			db.On("GetAuthSetById", ctx, "foo").Return(tc.auth, tc.getAuthErr)
+			db.On("GetDeviceById", ctx, "foodev").Return(tc.dev, tc.getDeviceErr)
 
-			if tc.token != nil {
-				db.On("GetAuthSetById", context.Background(),
-					tc.token.AuthSetId).Return(tc.auth, tc.getAuthErr)
-				// devauth will ask for a device if auth set is
-				// found and accepted
-				if tc.dev != nil {
-					db.On("GetDeviceById", context.Background(),
-						tc.auth.DeviceId).Return(tc.dev, tc.getDeviceErr)
-				}
-			}
+			devauth := NewDevAuth(db, nil, ja, Config{})
+			out, err := devauth.IntrospectToken(ctx, "dummytoken")
 
-			err := devauth.VerifyToken(context.Background(), tc.tokenString)
-			if tc.tokenValidateErr != nil {
-				assert.EqualError(t, err, tc.tokenValidateErr.Error())
+			if tc.outErr != nil {
+				assert.EqualError(t, err, tc.outErr.Error())
 			} else {
 				assert.NoError(t, err)
+				assert.Equal(t, tc.out, out)
 			}
-			ja.AssertExpectations(t)
-			db.AssertExpectations(t)
-
 		})
 	}
 }
@@ -1703,7 +3532,62 @@ func TestDevAuthGetTenantLimit(t *testing.T) {
 				assert.EqualError(t, err, tc.outErr.Error())
 			} else {
 				assert.NoError(t, err)
-				assert.Equal(t, *tc.outLimit, *limit)
+				assert.Equal(t, *tc.outLimit, *limit)
+			}
+		})
+	}
+}
+
+func TestDevAuthTenantTokenPolicy(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		dbErr error
+
+		outSetErr error
+		outGetErr error
+	}{
+		"ok": {},
+		"error": {
+			dbErr:     errors.New("db error"),
+			outSetErr: errors.New("failed to save token policy for tenant tenant-foo to database: db error"),
+			outGetErr: errors.New("failed to get token policy for tenant tenant-foo: db error"),
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(fmt.Sprintf("tc %s", i), func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			policy := model.TokenPolicy{Audience: "aud"}
+
+			// verify the tenant db is used
+			ctxMatcher := mock.MatchedBy(func(c context.Context) bool {
+				id := identity.FromContext(c)
+				return id != nil && id.Tenant == "tenant-foo"
+			})
+
+			db := mstore.DataStore{}
+			db.On("PutTokenPolicy", ctxMatcher, policy).Return(tc.dbErr)
+			db.On("GetTokenPolicy", ctxMatcher).Return(&policy, tc.dbErr)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+
+			err := devauth.SetTenantTokenPolicy(ctx, "tenant-foo", policy)
+			if tc.outSetErr != nil {
+				assert.EqualError(t, err, tc.outSetErr.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+
+			out, err := devauth.GetTenantTokenPolicy(ctx, "tenant-foo")
+			if tc.outGetErr != nil {
+				assert.EqualError(t, err, tc.outGetErr.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, &policy, out)
 			}
 		})
 	}
@@ -1796,7 +3680,7 @@ func TestDevAuthProvisionTenant(t *testing.T) {
 			db := mstore.DataStore{}
 			db.On("MigrateTenant", ctx,
 				mock.AnythingOfType("string"),
-				"1.5.0",
+				"1.7.0",
 			).Return(tc.datastoreError)
 			db.On("WithAutomigrate").Return(&db)
 			devauth := NewDevAuth(&db, nil, nil, Config{})
@@ -2083,3 +3967,392 @@ func TestGetTenantDeviceStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestGetJWKS(t *testing.T) {
+	t.Parallel()
+
+	jwks := &jwt.JWKS{
+		Keys: []jwt.JWK{
+			{KeyID: "foo", KeyType: "RSA", Algorithm: jwt.AlgRS256, Use: jwt.JWKUseSignature},
+		},
+	}
+
+	testCases := map[string]struct {
+		jwks   *jwt.JWKS
+		jwtErr error
+
+		outErr error
+	}{
+		"ok": {
+			jwks: jwks,
+		},
+		"error": {
+			jwtErr: errors.New("unsupported public key type"),
+			outErr: errors.New("failed to build JWKS: unsupported public key type"),
+		},
+	}
+
+	for n := range testCases {
+		tc := testCases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			ja := &mjwt.Handler{}
+			ja.On("JWKS").Return(tc.jwks, tc.jwtErr)
+
+			devauth := NewDevAuth(nil, nil, ja, Config{})
+			out, err := devauth.GetJWKS(context.Background())
+
+			if tc.outErr != nil {
+				assert.EqualError(t, err, tc.outErr.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.jwks, out)
+			}
+		})
+	}
+}
+
+func TestDevAuthAddTrustedCA(t *testing.T) {
+	t.Parallel()
+
+	ca := &model.TrustedCA{Id: "foo", Certificate: "cert"}
+
+	testCases := map[string]struct {
+		dbErr  error
+		outErr error
+	}{
+		"ok": {},
+		"error, exists": {
+			dbErr:  store.ErrObjectExists,
+			outErr: ErrTrustedCAExists,
+		},
+		"error, generic": {
+			dbErr:  errors.New("db error"),
+			outErr: errors.New("failed to add trusted CA: db error"),
+		},
+	}
+
+	for n := range testCases {
+		tc := testCases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := mstore.DataStore{}
+			db.On("AddTrustedCA", ctx, *ca).Return(tc.dbErr)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+			err := devauth.AddTrustedCA(ctx, ca)
+
+			if tc.outErr != nil {
+				assert.EqualError(t, err, tc.outErr.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDevAuthGetTrustedCAs(t *testing.T) {
+	t.Parallel()
+
+	cas := []model.TrustedCA{{Id: "foo"}, {Id: "bar"}}
+
+	testCases := map[string]struct {
+		dbCAs  []model.TrustedCA
+		dbErr  error
+		outErr error
+	}{
+		"ok": {
+			dbCAs: cas,
+		},
+		"error, generic": {
+			dbErr:  errors.New("db error"),
+			outErr: errors.New("failed to list trusted CAs: db error"),
+		},
+	}
+
+	for n := range testCases {
+		tc := testCases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := mstore.DataStore{}
+			db.On("GetTrustedCAs", ctx).Return(tc.dbCAs, tc.dbErr)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+			out, err := devauth.GetTrustedCAs(ctx)
+
+			if tc.outErr != nil {
+				assert.EqualError(t, err, tc.outErr.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.dbCAs, out)
+			}
+		})
+	}
+}
+
+func TestDevAuthDeleteTrustedCA(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		dbErr  error
+		outErr error
+	}{
+		"ok": {},
+		"error, not found": {
+			dbErr:  store.ErrTrustedCANotFound,
+			outErr: ErrTrustedCANotFound,
+		},
+		"error, generic": {
+			dbErr:  errors.New("db error"),
+			outErr: errors.New("failed to delete trusted CA: db error"),
+		},
+	}
+
+	for n := range testCases {
+		tc := testCases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := mstore.DataStore{}
+			db.On("DeleteTrustedCA", ctx, "foo").Return(tc.dbErr)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+			err := devauth.DeleteTrustedCA(ctx, "foo")
+
+			if tc.outErr != nil {
+				assert.EqualError(t, err, tc.outErr.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDevAuthGetDeviceToken(t *testing.T) {
+	t.Parallel()
+
+	tok := model.Token{Id: "jti", DevId: "foo"}
+
+	testCases := map[string]struct {
+		dbTokens []model.Token
+		dbErr    error
+
+		outToken *model.Token
+		outErr   error
+	}{
+		"ok": {
+			dbTokens: []model.Token{tok},
+			outToken: &tok,
+		},
+		"error, no tokens": {
+			dbTokens: []model.Token{},
+			outErr:   store.ErrTokenNotFound,
+		},
+		"error, db": {
+			dbErr:  errors.New("db error"),
+			outErr: errors.New("db get tokens error: db error"),
+		},
+	}
+
+	for n := range testCases {
+		tc := testCases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := mstore.DataStore{}
+			db.On("GetTokens", ctx, uint(0), uint(1),
+				model.TokenFilter{DevId: "foo"},
+			).Return(tc.dbTokens, tc.dbErr)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+			out, err := devauth.GetDeviceToken(ctx, "foo")
+
+			if tc.outErr != nil {
+				assert.EqualError(t, err, tc.outErr.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.outToken, out)
+			}
+		})
+	}
+}
+
+func TestDevAuthGetDeviceTokens(t *testing.T) {
+	t.Parallel()
+
+	toks := []model.Token{{Id: "jti1", DevId: "foo"}, {Id: "jti2", DevId: "foo"}}
+
+	testCases := map[string]struct {
+		filter model.TokenFilter
+
+		dbGetDeviceErr error
+		dbTokens       []model.Token
+		dbTokensErr    error
+
+		outErr error
+	}{
+		"ok": {
+			dbTokens: toks,
+		},
+		"ok, filter": {
+			filter:   model.TokenFilter{AuthSetId: "aid"},
+			dbTokens: toks,
+		},
+		"error, device not found": {
+			dbGetDeviceErr: store.ErrDevNotFound,
+			outErr:         store.ErrDevNotFound,
+		},
+		"error, get device": {
+			dbGetDeviceErr: errors.New("db error"),
+			outErr:         errors.New("db get device by id error: db error"),
+		},
+		"error, get tokens": {
+			dbTokensErr: errors.New("db error"),
+			outErr:      errors.New("db get tokens error: db error"),
+		},
+	}
+
+	for n := range testCases {
+		tc := testCases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := mstore.DataStore{}
+			db.On("GetDeviceById", ctx, "foo").
+				Return(&model.Device{Id: "foo"}, tc.dbGetDeviceErr)
+
+			filter := tc.filter
+			filter.DevId = "foo"
+			db.On("GetTokens", ctx, uint(10), uint(20), filter).
+				Return(tc.dbTokens, tc.dbTokensErr)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+			out, err := devauth.GetDeviceTokens(ctx, "foo", 10, 20, tc.filter)
+
+			if tc.outErr != nil {
+				assert.EqualError(t, err, tc.outErr.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.dbTokens, out)
+			}
+		})
+	}
+}
+
+func TestDevAuthRevokeDeviceTokens(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		dbGetDeviceErr error
+		dbDeleteErr    error
+
+		outErr error
+	}{
+		"ok": {},
+		"ok, no tokens": {
+			dbDeleteErr: store.ErrTokenNotFound,
+		},
+		"error, device not found": {
+			dbGetDeviceErr: store.ErrDevNotFound,
+			outErr:         store.ErrDevNotFound,
+		},
+		"error, delete": {
+			dbDeleteErr: errors.New("db error"),
+			outErr:      errors.New("db delete device tokens error: db error"),
+		},
+	}
+
+	for n := range testCases {
+		tc := testCases[n]
+		t.Run(fmt.Sprintf("tc %s", n), func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := mstore.DataStore{}
+			db.On("GetDeviceById", ctx, "foo").
+				Return(&model.Device{Id: "foo"}, tc.dbGetDeviceErr)
+			db.On("DeleteTokenByDevId", ctx, "foo").
+				Return(tc.dbDeleteErr)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+			err := devauth.RevokeDeviceTokens(ctx, "foo")
+
+			if tc.outErr != nil {
+				assert.EqualError(t, err, tc.outErr.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDevAuthCanonicalizeIdData(t *testing.T) {
+	t.Parallel()
+
+	normalizers := model.IdDataNormalizers{
+		"mac": {model.IdDataNormalizerTrim, model.IdDataNormalizerMAC},
+		"sn":  {model.IdDataNormalizerTrim, model.IdDataNormalizerLowercase},
+		"ifs": {model.IdDataNormalizerMAC},
+	}
+
+	testCases := map[string]struct {
+		idData      string
+		normalizers model.IdDataNormalizers
+
+		out string
+		err error
+	}{
+		"ok": {
+			idData: `{"sn": "ABC-1", "mac": "00:01:02:03:04:0A", "n": 1.0}`,
+			out:    `{"mac":"00:01:02:03:04:0A","n":1,"sn":"ABC-1"}`,
+		},
+		"ok, normalized": {
+			idData:      `{"sn": " ABC-1 ", "mac": "00-01-02-03-04-0A ", "ifs": ["0001.0203.040a", "foo"]}`,
+			normalizers: normalizers,
+			out:         `{"ifs":["00:01:02:03:04:0a","foo"],"mac":"00:01:02:03:04:0a","sn":"abc-1"}`,
+		},
+		"ok, not a mac": {
+			idData:      `{"mac": "00:01:02:03:04", "sn": 1}`,
+			normalizers: normalizers,
+			out:         `{"mac":"00:01:02:03:04","sn":1}`,
+		},
+		"error": {
+			idData: `{"mac": }`,
+			err:    errors.New("dev auth: bad request: failed to parse identity data: {\"mac\": }: invalid character '}' looking for beginning of value"),
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			devauth := NewDevAuth(&mstore.DataStore{}, nil, nil, Config{
+				IdDataNormalizers: tc.normalizers,
+			})
+
+			idData := tc.idData
+			err := devauth.canonicalizeIdData(&idData)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.out, idData)
+			}
+		})
+	}
+}
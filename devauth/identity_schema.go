@@ -0,0 +1,171 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devauth
+
+import (
+	"context"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceauth/model"
+	"github.com/mendersoftware/deviceauth/store"
+)
+
+const (
+	// devices checked at once when looking for schema violations
+	identitySchemaBatchSize = 500
+)
+
+func (d *DevAuth) GetIdentitySchema(ctx context.Context) (*model.IdentitySchema, error) {
+	schema, err := d.db.GetIdentitySchema(ctx)
+	switch err {
+	case nil:
+		return schema, nil
+	case store.ErrIdentitySchemaNotFound:
+		return nil, ErrIdSchemaNotFound
+	default:
+		return nil, errors.Wrap(err, "failed to get identity schema")
+	}
+}
+
+func (d *DevAuth) SetIdentitySchema(ctx context.Context, schema model.IdentitySchema) error {
+	now := time.Now().UTC()
+	schema.UpdatedTs = &now
+
+	if err := d.db.PutIdentitySchema(ctx, schema); err != nil {
+		return errors.Wrap(err, "failed to set identity schema")
+	}
+	return nil
+}
+
+func (d *DevAuth) DeleteIdentitySchema(ctx context.Context) error {
+	err := d.db.DeleteIdentitySchema(ctx)
+	switch err {
+	case nil:
+		return nil
+	case store.ErrIdentitySchemaNotFound:
+		return ErrIdSchemaNotFound
+	default:
+		return errors.Wrap(err, "failed to delete identity schema")
+	}
+}
+
+// identitySchema fetches the (tenant's) identity schema; nil if not set.
+func (d *DevAuth) identitySchema(ctx context.Context) (*model.IdentitySchema, error) {
+	schema, err := d.db.GetIdentitySchema(ctx)
+	switch err {
+	case nil:
+		return schema, nil
+	case store.ErrIdentitySchemaNotFound:
+		return nil, nil
+	default:
+		return nil, errors.Wrap(err, "failed to get identity schema")
+	}
+}
+
+// validateIdData checks the identity data of a new device against the
+// (tenant's) identity schema.
+func (d *DevAuth) validateIdData(ctx context.Context, idData map[string]interface{}) error {
+	schema, err := d.identitySchema(ctx)
+	if err != nil || schema == nil {
+		return err
+	}
+
+	if err := schema.ValidateIdData(idData); err != nil {
+		return MakeErrDevAuthBadRequest(err)
+	}
+
+	return nil
+}
+
+// checkAuthReqIdData checks the identity data of the auth request against
+// the (tenant's) identity schema. Devices known before the schema was set
+// are let in; they are reported by GetIdentitySchemaViolations instead.
+func (d *DevAuth) checkAuthReqIdData(ctx context.Context, r *model.AuthReq) error {
+	schema, err := d.identitySchema(ctx)
+	if err != nil || schema == nil {
+		return err
+	}
+
+	idDataStruct, idDataSha256, err := parseIdData(r.IdData)
+	if err != nil {
+		return MakeErrDevAuthBadRequest(err)
+	}
+
+	verr := schema.ValidateIdData(idDataStruct)
+	if verr == nil {
+		return nil
+	}
+
+	dev, err := d.db.GetDeviceByIdentityDataHash(ctx, idDataSha256)
+	switch err {
+	case nil:
+		log.FromContext(ctx).Warnf("device %s: %v", dev.Id, verr)
+		return nil
+	case store.ErrDevNotFound:
+		return MakeErrDevAuthBadRequest(verr)
+	default:
+		return errors.Wrap(err, "failed to fetch device")
+	}
+}
+
+// GetIdentitySchemaViolations lists the devices whose identity data doesn't
+// match the (tenant's) identity schema; empty if the schema is not set.
+func (d *DevAuth) GetIdentitySchemaViolations(ctx context.Context, skip, limit uint) ([]model.IdentitySchemaViolation, error) {
+	res := []model.IdentitySchemaViolation{}
+
+	schema, err := d.identitySchema(ctx)
+	if err != nil || schema == nil {
+		return res, err
+	}
+
+	for offset := uint(0); ; offset += identitySchemaBatchSize {
+		devs, err := d.db.GetDevices(ctx, offset, identitySchemaBatchSize, store.DeviceFilter{})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list devices")
+		}
+
+		for _, dev := range devs {
+			idData, _, err := parseIdData(dev.IdData)
+			if err != nil {
+				return nil, errors.Wrapf(err, "device %s", dev.Id)
+			}
+
+			verr := schema.ValidateIdData(idData)
+			if verr == nil {
+				continue
+			}
+
+			if skip > 0 {
+				skip--
+				continue
+			}
+
+			res = append(res, model.IdentitySchemaViolation{
+				DeviceId: dev.Id,
+				IdData:   dev.IdData,
+				Errors:   verr.(model.IdentityDataErrors),
+			})
+			if uint(len(res)) == limit {
+				return res, nil
+			}
+		}
+
+		if len(devs) < identitySchemaBatchSize {
+			return res, nil
+		}
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceauth/model"
+	"github.com/mendersoftware/deviceauth/store"
+	mstore "github.com/mendersoftware/deviceauth/store/mocks"
+)
+
+var testIdentitySchema = &model.IdentitySchema{
+	Required: []string{"mac"},
+	Properties: map[string]model.IdentityProperty{
+		"mac": {
+			Type:    model.IdentityTypeString,
+			Pattern: "^([0-9a-f]{2}:){5}[0-9a-f]{2}$",
+		},
+	},
+}
+
+func TestDevAuthGetIdentitySchema(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		dbSchema *model.IdentitySchema
+		dbErr    error
+
+		schema *model.IdentitySchema
+		err    error
+	}{
+		"ok": {
+			dbSchema: testIdentitySchema,
+			schema:   testIdentitySchema,
+		},
+		"not set": {
+			dbErr: store.ErrIdentitySchemaNotFound,
+			err:   ErrIdSchemaNotFound,
+		},
+		"error": {
+			dbErr: errors.New("db error"),
+			err:   errors.New("failed to get identity schema: db error"),
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := mstore.DataStore{}
+			db.On("GetIdentitySchema", ctx).Return(tc.dbSchema, tc.dbErr)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+
+			schema, err := devauth.GetIdentitySchema(ctx)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.schema, schema)
+		})
+	}
+}
+
+func TestDevAuthCheckAuthReqIdData(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		idData    string
+		schema    *model.IdentitySchema
+		schemaErr error
+		dev       *model.Device
+		devErr    error
+
+		err error
+	}{
+		"ok, schema not set": {
+			idData:    `{"sn":"0001"}`,
+			schemaErr: store.ErrIdentitySchemaNotFound,
+		},
+		"ok": {
+			idData: `{"mac":"00:01:02:03:04:05"}`,
+			schema: testIdentitySchema,
+		},
+		"ok, known device": {
+			idData: `{"sn":"0001"}`,
+			schema: testIdentitySchema,
+			dev:    &model.Device{Id: "dev-1"},
+		},
+		"error, new device": {
+			idData: `{"mac":"00-01-02-03-04-05","sn":"0001"}`,
+			schema: testIdentitySchema,
+			devErr: store.ErrDevNotFound,
+			err: MakeErrDevAuthBadRequest(errors.New(
+				"identity data does not match the schema: " +
+					"mac: must match pattern ^([0-9a-f]{2}:){5}[0-9a-f]{2}$")),
+		},
+		"error, required attribute": {
+			idData: `{"sn":"0001"}`,
+			schema: testIdentitySchema,
+			devErr: store.ErrDevNotFound,
+			err: MakeErrDevAuthBadRequest(errors.New(
+				"identity data does not match the schema: " +
+					"mac: required attribute missing")),
+		},
+		"error, schema": {
+			idData:    `{"sn":"0001"}`,
+			schemaErr: errors.New("db error"),
+			err:       errors.New("failed to get identity schema: db error"),
+		},
+		"error, device": {
+			idData: `{"sn":"0001"}`,
+			schema: testIdentitySchema,
+			devErr: errors.New("db error"),
+			err:    errors.New("failed to fetch device: db error"),
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := mstore.DataStore{}
+			db.On("GetIdentitySchema", ctx).Return(tc.schema, tc.schemaErr)
+			db.On("GetDeviceByIdentityDataHash", ctx,
+				mock.AnythingOfType("[]uint8")).
+				Return(tc.dev, tc.devErr)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+
+			err := devauth.checkAuthReqIdData(ctx, &model.AuthReq{
+				IdData: tc.idData,
+			})
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDevAuthGetIdentitySchemaViolations(t *testing.T) {
+	t.Parallel()
+
+	// every third device has no mac
+	devs := make([]model.Device, identitySchemaBatchSize+100)
+	for i := range devs {
+		devs[i].Id = fmt.Sprintf("dev-%d", i)
+		if i%3 == 0 {
+			devs[i].IdData = fmt.Sprintf(`{"sn":"%04d"}`, i)
+		} else {
+			devs[i].IdData = `{"mac":"00:01:02:03:04:05"}`
+		}
+	}
+
+	violation := func(i int) model.IdentitySchemaViolation {
+		return model.IdentitySchemaViolation{
+			DeviceId: devs[i].Id,
+			IdData:   devs[i].IdData,
+			Errors: model.IdentityDataErrors{
+				{Attribute: "mac", Message: "required attribute missing"},
+			},
+		}
+	}
+
+	testCases := map[string]struct {
+		skip  uint
+		limit uint
+
+		schema    *model.IdentitySchema
+		schemaErr error
+		devsErr   error
+
+		violations []model.IdentitySchemaViolation
+		err        error
+	}{
+		"ok": {
+			limit:      2,
+			schema:     testIdentitySchema,
+			violations: []model.IdentitySchemaViolation{violation(0), violation(3)},
+		},
+		"ok, skip": {
+			skip:       2,
+			limit:      1,
+			schema:     testIdentitySchema,
+			violations: []model.IdentitySchemaViolation{violation(6)},
+		},
+		"ok, next batch": {
+			skip:   167,
+			limit:  3,
+			schema: testIdentitySchema,
+			violations: []model.IdentitySchemaViolation{
+				violation(501), violation(504), violation(507),
+			},
+		},
+		"ok, last": {
+			skip:       199,
+			limit:      10,
+			schema:     testIdentitySchema,
+			violations: []model.IdentitySchemaViolation{violation(597)},
+		},
+		"ok, schema not set": {
+			limit:      10,
+			schemaErr:  store.ErrIdentitySchemaNotFound,
+			violations: []model.IdentitySchemaViolation{},
+		},
+		"error, schema": {
+			limit:      10,
+			schemaErr:  errors.New("db error"),
+			violations: []model.IdentitySchemaViolation{},
+			err:        errors.New("failed to get identity schema: db error"),
+		},
+		"error, devices": {
+			limit:   10,
+			schema:  testIdentitySchema,
+			devsErr: errors.New("db error"),
+			err:     errors.New("failed to list devices: db error"),
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := mstore.DataStore{}
+			db.On("GetIdentitySchema", ctx).Return(tc.schema, tc.schemaErr)
+			db.On("GetDevices", ctx, uint(0), uint(identitySchemaBatchSize),
+				store.DeviceFilter{}).
+				Return(devs[:identitySchemaBatchSize], tc.devsErr)
+			db.On("GetDevices", ctx, uint(identitySchemaBatchSize),
+				uint(identitySchemaBatchSize), store.DeviceFilter{}).
+				Return(devs[identitySchemaBatchSize:], nil)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+
+			violations, err := devauth.GetIdentitySchemaViolations(ctx,
+				tc.skip, tc.limit)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.violations, violations)
+		})
+	}
+}
@@ -13,11 +13,14 @@
 //    limitations under the License.
 package mocks
 
+import cache "github.com/mendersoftware/deviceauth/cache"
 import context "context"
 
 import mock "github.com/stretchr/testify/mock"
+import jwt "github.com/mendersoftware/deviceauth/jwt"
 import model "github.com/mendersoftware/deviceauth/model"
 import store "github.com/mendersoftware/deviceauth/store"
+import time "time"
 
 // App is an autogenerated mock type for the App type
 type App struct {
@@ -38,6 +41,80 @@ func (_m *App) AcceptDeviceAuth(ctx context.Context, dev_id string, auth_id stri
 	return r0
 }
 
+// AddAdmissionRule provides a mock function with given fields: ctx, rule
+func (_m *App) AddAdmissionRule(ctx context.Context, rule *model.AdmissionRule) error {
+	ret := _m.Called(ctx, rule)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.AdmissionRule) error); ok {
+		r0 = rf(ctx, rule)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// AddTrustedCA provides a mock function with given fields: ctx, ca
+func (_m *App) AddTrustedCA(ctx context.Context, ca *model.TrustedCA) error {
+	ret := _m.Called(ctx, ca)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.TrustedCA) error); ok {
+		r0 = rf(ctx, ca)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreateAuthChallenge provides a mock function with given fields: ctx
+func (_m *App) CreateAuthChallenge(ctx context.Context) (*model.AuthChallenge, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *model.AuthChallenge
+	if rf, ok := ret.Get(0).(func(context.Context) *model.AuthChallenge); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.AuthChallenge)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateClaimCode provides a mock function with given fields: ctx, expiresAt
+func (_m *App) CreateClaimCode(ctx context.Context, expiresAt *time.Time) (*model.ClaimCode, error) {
+	ret := _m.Called(ctx, expiresAt)
+
+	var r0 *model.ClaimCode
+	if rf, ok := ret.Get(0).(func(context.Context, *time.Time) *model.ClaimCode); ok {
+		r0 = rf(ctx, expiresAt)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.ClaimCode)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *time.Time) error); ok {
+		r1 = rf(ctx, expiresAt)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // DecommissionDevice provides a mock function with given fields: ctx, dev_id
 func (_m *App) DecommissionDevice(ctx context.Context, dev_id string) error {
 	ret := _m.Called(ctx, dev_id)
@@ -52,6 +129,48 @@ func (_m *App) DecommissionDevice(ctx context.Context, dev_id string) error {
 	return r0
 }
 
+// DeleteAdmissionRule provides a mock function with given fields: ctx, id
+func (_m *App) DeleteAdmissionRule(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteAdmissionWebhook provides a mock function with given fields: ctx
+func (_m *App) DeleteAdmissionWebhook(ctx context.Context) error {
+	ret := _m.Called(ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteAuthLockout provides a mock function with given fields: ctx, id
+func (_m *App) DeleteAuthLockout(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteAuthSet provides a mock function with given fields: ctx, dev_id, auth_id
 func (_m *App) DeleteAuthSet(ctx context.Context, dev_id string, auth_id string) error {
 	ret := _m.Called(ctx, dev_id, auth_id)
@@ -66,6 +185,34 @@ func (_m *App) DeleteAuthSet(ctx context.Context, dev_id string, auth_id string)
 	return r0
 }
 
+// DeleteClaimCode provides a mock function with given fields: ctx, id
+func (_m *App) DeleteClaimCode(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteIdentitySchema provides a mock function with given fields: ctx
+func (_m *App) DeleteIdentitySchema(ctx context.Context) error {
+	ret := _m.Called(ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteTokens provides a mock function with given fields: ctx, tenant_id, device_id
 func (_m *App) DeleteTokens(ctx context.Context, tenant_id string, device_id string) error {
 	ret := _m.Called(ctx, tenant_id, device_id)
@@ -80,6 +227,135 @@ func (_m *App) DeleteTokens(ctx context.Context, tenant_id string, device_id str
 	return r0
 }
 
+// DeleteTrustedCA provides a mock function with given fields: ctx, id
+func (_m *App) DeleteTrustedCA(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetAdmissionRule provides a mock function with given fields: ctx, id
+func (_m *App) GetAdmissionRule(ctx context.Context, id string) (*model.AdmissionRule, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *model.AdmissionRule
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.AdmissionRule); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.AdmissionRule)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAdmissionRules provides a mock function with given fields: ctx
+func (_m *App) GetAdmissionRules(ctx context.Context) ([]model.AdmissionRule, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.AdmissionRule
+	if rf, ok := ret.Get(0).(func(context.Context) []model.AdmissionRule); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.AdmissionRule)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAdmissionWebhook provides a mock function with given fields: ctx
+func (_m *App) GetAdmissionWebhook(ctx context.Context) (*model.AdmissionWebhook, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *model.AdmissionWebhook
+	if rf, ok := ret.Get(0).(func(context.Context) *model.AdmissionWebhook); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.AdmissionWebhook)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAuthLockouts provides a mock function with given fields: ctx, skip, limit, filter
+func (_m *App) GetAuthLockouts(ctx context.Context, skip uint, limit uint, filter model.AuthLockoutFilter) ([]model.AuthLockout, error) {
+	ret := _m.Called(ctx, skip, limit, filter)
+
+	var r0 []model.AuthLockout
+	if rf, ok := ret.Get(0).(func(context.Context, uint, uint, model.AuthLockoutFilter) []model.AuthLockout); ok {
+		r0 = rf(ctx, skip, limit, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.AuthLockout)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uint, uint, model.AuthLockoutFilter) error); ok {
+		r1 = rf(ctx, skip, limit, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetClaimCodes provides a mock function with given fields: ctx
+func (_m *App) GetClaimCodes(ctx context.Context) ([]model.ClaimCode, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.ClaimCode
+	if rf, ok := ret.Get(0).(func(context.Context) []model.ClaimCode); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.ClaimCode)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetDevCountByStatus provides a mock function with given fields: ctx, status
 func (_m *App) GetDevCountByStatus(ctx context.Context, status string) (int, error) {
 	ret := _m.Called(ctx, status)
@@ -147,6 +423,29 @@ func (_m *App) GetDeviceToken(ctx context.Context, dev_id string) (*model.Token,
 	return r0, r1
 }
 
+// GetDeviceTokens provides a mock function with given fields: ctx, dev_id, skip, limit, filter
+func (_m *App) GetDeviceTokens(ctx context.Context, dev_id string, skip uint, limit uint, filter model.TokenFilter) ([]model.Token, error) {
+	ret := _m.Called(ctx, dev_id, skip, limit, filter)
+
+	var r0 []model.Token
+	if rf, ok := ret.Get(0).(func(context.Context, string, uint, uint, model.TokenFilter) []model.Token); ok {
+		r0 = rf(ctx, dev_id, skip, limit, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Token)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, uint, uint, model.TokenFilter) error); ok {
+		r1 = rf(ctx, dev_id, skip, limit, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetDevices provides a mock function with given fields: ctx, skip, limit, filter
 func (_m *App) GetDevices(ctx context.Context, skip uint, limit uint, filter store.DeviceFilter) ([]model.Device, error) {
 	ret := _m.Called(ctx, skip, limit, filter)
@@ -170,6 +469,75 @@ func (_m *App) GetDevices(ctx context.Context, skip uint, limit uint, filter sto
 	return r0, r1
 }
 
+// GetIdentitySchema provides a mock function with given fields: ctx
+func (_m *App) GetIdentitySchema(ctx context.Context) (*model.IdentitySchema, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *model.IdentitySchema
+	if rf, ok := ret.Get(0).(func(context.Context) *model.IdentitySchema); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.IdentitySchema)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetIdentitySchemaViolations provides a mock function with given fields: ctx, skip, limit
+func (_m *App) GetIdentitySchemaViolations(ctx context.Context, skip uint, limit uint) ([]model.IdentitySchemaViolation, error) {
+	ret := _m.Called(ctx, skip, limit)
+
+	var r0 []model.IdentitySchemaViolation
+	if rf, ok := ret.Get(0).(func(context.Context, uint, uint) []model.IdentitySchemaViolation); ok {
+		r0 = rf(ctx, skip, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.IdentitySchemaViolation)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uint, uint) error); ok {
+		r1 = rf(ctx, skip, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetJWKS provides a mock function with given fields: ctx
+func (_m *App) GetJWKS(ctx context.Context) (*jwt.JWKS, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *jwt.JWKS
+	if rf, ok := ret.Get(0).(func(context.Context) *jwt.JWKS); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*jwt.JWKS)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetLimit provides a mock function with given fields: ctx, name
 func (_m *App) GetLimit(ctx context.Context, name string) (*model.Limit, error) {
 	ret := _m.Called(ctx, name)
@@ -216,6 +584,29 @@ func (_m *App) GetTenantDeviceStatus(ctx context.Context, tenantId string, devic
 	return r0, r1
 }
 
+// GetTenantJWKS provides a mock function with given fields: ctx, tenantId
+func (_m *App) GetTenantJWKS(ctx context.Context, tenantId string) (*jwt.JWKS, error) {
+	ret := _m.Called(ctx, tenantId)
+
+	var r0 *jwt.JWKS
+	if rf, ok := ret.Get(0).(func(context.Context, string) *jwt.JWKS); ok {
+		r0 = rf(ctx, tenantId)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*jwt.JWKS)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, tenantId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetTenantLimit provides a mock function with given fields: ctx, name, tenant_id
 func (_m *App) GetTenantLimit(ctx context.Context, name string, tenant_id string) (*model.Limit, error) {
 	ret := _m.Called(ctx, name, tenant_id)
@@ -239,6 +630,144 @@ func (_m *App) GetTenantLimit(ctx context.Context, name string, tenant_id string
 	return r0, r1
 }
 
+// GetTenantTokenPolicy provides a mock function with given fields: ctx, tenant_id
+func (_m *App) GetTenantTokenPolicy(ctx context.Context, tenant_id string) (*model.TokenPolicy, error) {
+	ret := _m.Called(ctx, tenant_id)
+
+	var r0 *model.TokenPolicy
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.TokenPolicy); ok {
+		r0 = rf(ctx, tenant_id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.TokenPolicy)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, tenant_id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTokenScopes provides a mock function with given fields: ctx
+func (_m *App) GetTokenScopes(ctx context.Context) (*model.TokenScopes, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *model.TokenScopes
+	if rf, ok := ret.Get(0).(func(context.Context) *model.TokenScopes); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.TokenScopes)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTrustedCAs provides a mock function with given fields: ctx
+func (_m *App) GetTrustedCAs(ctx context.Context) ([]model.TrustedCA, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.TrustedCA
+	if rf, ok := ret.Get(0).(func(context.Context) []model.TrustedCA); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.TrustedCA)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetVerificationCacheStats provides a mock function with given fields: ctx
+func (_m *App) GetVerificationCacheStats(ctx context.Context) (*cache.Stats, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *cache.Stats
+	if rf, ok := ret.Get(0).(func(context.Context) *cache.Stats); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*cache.Stats)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// IntrospectToken provides a mock function with given fields: ctx, token
+func (_m *App) IntrospectToken(ctx context.Context, token string) (*model.TokenIntrospection, error) {
+	ret := _m.Called(ctx, token)
+
+	var r0 *model.TokenIntrospection
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.TokenIntrospection); ok {
+		r0 = rf(ctx, token)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.TokenIntrospection)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, token)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MatchAdmissionRule provides a mock function with given fields: ctx, idData, keyType
+func (_m *App) MatchAdmissionRule(ctx context.Context, idData map[string]interface{}, keyType string) (*model.AdmissionRule, error) {
+	ret := _m.Called(ctx, idData, keyType)
+
+	var r0 *model.AdmissionRule
+	if rf, ok := ret.Get(0).(func(context.Context, map[string]interface{}, string) *model.AdmissionRule); ok {
+		r0 = rf(ctx, idData, keyType)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.AdmissionRule)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, map[string]interface{}, string) error); ok {
+		r1 = rf(ctx, idData, keyType)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // PreauthorizeDevice provides a mock function with given fields: ctx, req
 func (_m *App) PreauthorizeDevice(ctx context.Context, req *model.PreAuthReq) error {
 	ret := _m.Called(ctx, req)
@@ -267,6 +796,20 @@ func (_m *App) ProvisionTenant(ctx context.Context, tenant_id string) error {
 	return r0
 }
 
+// RecordAuthFailure provides a mock function with given fields: ctx, r
+func (_m *App) RecordAuthFailure(ctx context.Context, r *model.AuthReq) error {
+	ret := _m.Called(ctx, r)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.AuthReq) error); ok {
+		r0 = rf(ctx, r)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // RejectDeviceAuth provides a mock function with given fields: ctx, dev_id, auth_id
 func (_m *App) RejectDeviceAuth(ctx context.Context, dev_id string, auth_id string) error {
 	ret := _m.Called(ctx, dev_id, auth_id)
@@ -281,6 +824,27 @@ func (_m *App) RejectDeviceAuth(ctx context.Context, dev_id string, auth_id stri
 	return r0
 }
 
+// RenewToken provides a mock function with given fields: ctx, r
+func (_m *App) RenewToken(ctx context.Context, r *model.TokenRenewReq) (string, error) {
+	ret := _m.Called(ctx, r)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, *model.TokenRenewReq) string); ok {
+		r0 = rf(ctx, r)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.TokenRenewReq) error); ok {
+		r1 = rf(ctx, r)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ResetDeviceAuth provides a mock function with given fields: ctx, dev_id, auth_id
 func (_m *App) ResetDeviceAuth(ctx context.Context, dev_id string, auth_id string) error {
 	ret := _m.Called(ctx, dev_id, auth_id)
@@ -295,6 +859,20 @@ func (_m *App) ResetDeviceAuth(ctx context.Context, dev_id string, auth_id strin
 	return r0
 }
 
+// RevokeDeviceTokens provides a mock function with given fields: ctx, dev_id
+func (_m *App) RevokeDeviceTokens(ctx context.Context, dev_id string) error {
+	ret := _m.Called(ctx, dev_id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, dev_id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // RevokeToken provides a mock function with given fields: ctx, token_id
 func (_m *App) RevokeToken(ctx context.Context, token_id string) error {
 	ret := _m.Called(ctx, token_id)
@@ -309,6 +887,34 @@ func (_m *App) RevokeToken(ctx context.Context, token_id string) error {
 	return r0
 }
 
+// SetAdmissionWebhook provides a mock function with given fields: ctx, hook
+func (_m *App) SetAdmissionWebhook(ctx context.Context, hook model.AdmissionWebhook) error {
+	ret := _m.Called(ctx, hook)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.AdmissionWebhook) error); ok {
+		r0 = rf(ctx, hook)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetIdentitySchema provides a mock function with given fields: ctx, schema
+func (_m *App) SetIdentitySchema(ctx context.Context, schema model.IdentitySchema) error {
+	ret := _m.Called(ctx, schema)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.IdentitySchema) error); ok {
+		r0 = rf(ctx, schema)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // SetTenantLimit provides a mock function with given fields: ctx, tenant_id, limit
 func (_m *App) SetTenantLimit(ctx context.Context, tenant_id string, limit model.Limit) error {
 	ret := _m.Called(ctx, tenant_id, limit)
@@ -323,6 +929,34 @@ func (_m *App) SetTenantLimit(ctx context.Context, tenant_id string, limit model
 	return r0
 }
 
+// SetTenantTokenPolicy provides a mock function with given fields: ctx, tenant_id, policy
+func (_m *App) SetTenantTokenPolicy(ctx context.Context, tenant_id string, policy model.TokenPolicy) error {
+	ret := _m.Called(ctx, tenant_id, policy)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.TokenPolicy) error); ok {
+		r0 = rf(ctx, tenant_id, policy)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetTokenScopes provides a mock function with given fields: ctx, scopes
+func (_m *App) SetTokenScopes(ctx context.Context, scopes model.TokenScopes) error {
+	ret := _m.Called(ctx, scopes)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.TokenScopes) error); ok {
+		r0 = rf(ctx, scopes)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // SubmitAuthRequest provides a mock function with given fields: ctx, r
 func (_m *App) SubmitAuthRequest(ctx context.Context, r *model.AuthReq) (string, error) {
 	ret := _m.Called(ctx, r)
@@ -344,13 +978,27 @@ func (_m *App) SubmitAuthRequest(ctx context.Context, r *model.AuthReq) (string,
 	return r0, r1
 }
 
-// VerifyToken provides a mock function with given fields: ctx, token
-func (_m *App) VerifyToken(ctx context.Context, token string) error {
-	ret := _m.Called(ctx, token)
+// UpdateAdmissionRule provides a mock function with given fields: ctx, rule
+func (_m *App) UpdateAdmissionRule(ctx context.Context, rule *model.AdmissionRule) error {
+	ret := _m.Called(ctx, rule)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
-		r0 = rf(ctx, token)
+	if rf, ok := ret.Get(0).(func(context.Context, *model.AdmissionRule) error); ok {
+		r0 = rf(ctx, rule)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// VerifyToken provides a mock function with given fields: ctx, token, scope, audiences
+func (_m *App) VerifyToken(ctx context.Context, token string, scope string, audiences []string) error {
+	ret := _m.Called(ctx, token, scope, audiences)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, []string) error); ok {
+		r0 = rf(ctx, token, scope, audiences)
 	} else {
 		r0 = ret.Error(0)
 	}
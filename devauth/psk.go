@@ -0,0 +1,148 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devauth
+
+import (
+	"context"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceauth/keys"
+	"github.com/mendersoftware/deviceauth/model"
+	"github.com/mendersoftware/deviceauth/store"
+	"github.com/mendersoftware/deviceauth/utils"
+)
+
+// WithPSKEncryptionKey enables pre-shared key (HMAC) credentials, for devices
+// that can't do public key signatures; the keys are stored encrypted with
+// `encKey`.
+func (d *DevAuth) WithPSKEncryptionKey(encKey []byte) *DevAuth {
+	d.pskEncKey = encKey
+	return d
+}
+
+// encryptPSK turns the pre-shared key into the credential of the auth set.
+func (d *DevAuth) encryptPSK(aset *model.AuthSet, psk []byte) error {
+	if d.pskEncKey == nil {
+		return MakeErrDevAuthBadRequest(ErrPSKNotSupported)
+	}
+
+	encrypted, err := keys.Encrypt(psk, d.pskEncKey)
+	if err != nil {
+		return errors.Wrap(err, "failed to encrypt pre-shared key")
+	}
+
+	aset.CredentialType = model.CredentialTypePSK
+	aset.PSK = encrypted
+
+	return nil
+}
+
+// verifyPSKSignature verifies the request signed with the pre-shared key of
+// the auth set.
+func (d *DevAuth) verifyPSKSignature(aset *model.AuthSet, signature string, body []byte) error {
+	if d.pskEncKey == nil {
+		return MakeErrDevAuthBadRequest(ErrPSKNotSupported)
+	}
+
+	psk, err := keys.Decrypt(aset.PSK, d.pskEncKey)
+	if err != nil {
+		return errors.Wrap(err, "failed to decrypt pre-shared key")
+	}
+
+	if err := utils.VerifyAuthReqHMAC(signature, psk, body); err != nil {
+		return MakeErrDevAuthUnauthorized(ErrAuthReqSignature)
+	}
+
+	return nil
+}
+
+// verifyPSKAuthReq verifies the signature of the auth request with the
+// pre-shared key of the device, and returns its auth set. As the key is
+// only known once preauthorized, unknown devices fail the verification.
+func (d *DevAuth) verifyPSKAuthReq(ctx context.Context, r *model.AuthReq) (*model.AuthSet, error) {
+	if d.pskEncKey == nil {
+		return nil, MakeErrDevAuthBadRequest(ErrPSKNotSupported)
+	}
+
+	_, idDataSha256, err := parseIdData(r.IdData)
+	if err != nil {
+		return nil, MakeErrDevAuthBadRequest(err)
+	}
+
+	aset, err := d.pskAuthSet(ctx, idDataSha256)
+	if err != nil {
+		return nil, err
+	}
+
+	if aset != nil {
+		err = d.verifyPSKSignature(aset, r.Signature, r.Body)
+		if err == nil {
+			return aset, nil
+		} else if !IsErrDevAuthUnauthorized(err) {
+			return nil, err
+		}
+	}
+
+	switch lerr := d.recordAuthFailure(ctx, r); lerr {
+	case nil:
+	case ErrAuthLockedOut:
+		return nil, lerr
+	default:
+		log.FromContext(ctx).Errorf("failed to record auth failure: %v", lerr)
+	}
+
+	return nil, MakeErrDevAuthUnauthorized(ErrAuthReqSignature)
+}
+
+// pskAuthSet fetches the pre-shared key auth set of the device identity, if
+// any.
+func (d *DevAuth) pskAuthSet(ctx context.Context, idDataSha256 []byte) (*model.AuthSet, error) {
+	dev, err := d.db.GetDeviceByIdentityDataHash(ctx, idDataSha256)
+	switch err {
+	case nil:
+	case store.ErrDevNotFound:
+		return nil, nil
+	default:
+		return nil, errors.Wrap(err, "failed to fetch device")
+	}
+
+	asets, err := d.db.GetAuthSetsForDevice(ctx, dev.Id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch auth sets")
+	}
+
+	for i := range asets {
+		if asets[i].IsPSK() {
+			return &asets[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// processPSKAuthRequest accepts the preauthorized pre-shared key auth set,
+// once the device proved the possession of the key.
+func (d *DevAuth) processPSKAuthRequest(ctx context.Context, aset *model.AuthSet) (*model.AuthSet, error) {
+	if aset.Status != model.DevStatusPreauth {
+		return aset, nil
+	}
+
+	if err := d.autoAcceptAuthSet(ctx, aset); err != nil {
+		return nil, err
+	}
+
+	return aset, nil
+}
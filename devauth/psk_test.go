@@ -0,0 +1,203 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceauth/keys"
+	"github.com/mendersoftware/deviceauth/model"
+	"github.com/mendersoftware/deviceauth/store"
+	mstore "github.com/mendersoftware/deviceauth/store/mocks"
+	mtesting "github.com/mendersoftware/deviceauth/utils/testing"
+)
+
+func TestDevAuthPreauthorizeDevicePSK(t *testing.T) {
+	t.Parallel()
+
+	encKey := make([]byte, 32)
+	psk := []byte("0123456789abcdef")
+
+	testCases := map[string]struct {
+		encKey []byte
+		err    error
+	}{
+		"ok": {
+			encKey: encKey,
+		},
+		"error, not supported": {
+			err: MakeErrDevAuthBadRequest(ErrPSKNotSupported),
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := mstore.DataStore{}
+			db.On("GetIdentitySchema", ctx).
+				Return(nil, store.ErrIdentitySchemaNotFound)
+			db.On("AddDevice", ctx,
+				mock.AnythingOfType("model.Device")).
+				Return(nil)
+			db.On("AddAuthSet", ctx,
+				mock.MatchedBy(func(aset model.AuthSet) bool {
+					if !aset.IsPSK() || aset.PubKey != "" {
+						return false
+					}
+					dec, err := keys.Decrypt(aset.PSK, encKey)
+					return err == nil && string(dec) == string(psk)
+				})).
+				Return(nil)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+			if tc.encKey != nil {
+				devauth = devauth.WithPSKEncryptionKey(tc.encKey)
+			}
+
+			err := devauth.PreauthorizeDevice(ctx, &model.PreAuthReq{
+				DeviceId:  "dev-1",
+				AuthSetId: "aset-1",
+				IdData:    `{"sn":"0001"}`,
+				PSK:       psk,
+			})
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+				db.AssertNotCalled(t, "AddDevice", ctx, mock.Anything)
+			} else {
+				assert.NoError(t, err)
+				db.AssertExpectations(t)
+			}
+		})
+	}
+}
+
+func TestDevAuthVerifyPSKAuthReq(t *testing.T) {
+	t.Parallel()
+
+	encKey := make([]byte, 32)
+	psk := []byte("0123456789abcdef")
+	encPSK, err := keys.Encrypt(psk, encKey)
+	assert.NoError(t, err)
+
+	idData := `{"sn":"0001"}`
+	idDataSha256 := sha256.Sum256([]byte(idData))
+	body := []byte(`{"id_data":"{\"sn\":\"0001\"}","credential_type":"psk"}`)
+
+	pskAuthSet := model.AuthSet{
+		Id:             "aset-2",
+		DeviceId:       "dev-1",
+		Status:         model.DevStatusPreauth,
+		CredentialType: model.CredentialTypePSK,
+		PSK:            encPSK,
+	}
+
+	testCases := map[string]struct {
+		encKey    []byte
+		signature string
+
+		dev    *model.Device
+		devErr error
+		asets  []model.AuthSet
+
+		aset *model.AuthSet
+		err  error
+	}{
+		"ok": {
+			encKey:    encKey,
+			signature: string(mtesting.AuthReqHMAC(body, psk)),
+			dev:       &model.Device{Id: "dev-1"},
+			asets: []model.AuthSet{
+				{Id: "aset-1", DeviceId: "dev-1", PubKey: "key"},
+				pskAuthSet,
+			},
+			aset: &pskAuthSet,
+		},
+		"error, bad signature": {
+			encKey:    encKey,
+			signature: string(mtesting.AuthReqHMAC(body, []byte("fedcba9876543210"))),
+			dev:       &model.Device{Id: "dev-1"},
+			asets:     []model.AuthSet{pskAuthSet},
+			err:       MakeErrDevAuthUnauthorized(ErrAuthReqSignature),
+		},
+		"error, no psk auth set": {
+			encKey:    encKey,
+			signature: string(mtesting.AuthReqHMAC(body, psk)),
+			dev:       &model.Device{Id: "dev-1"},
+			asets: []model.AuthSet{
+				{Id: "aset-1", DeviceId: "dev-1", PubKey: "key"},
+			},
+			err: MakeErrDevAuthUnauthorized(ErrAuthReqSignature),
+		},
+		"error, unknown device": {
+			encKey:    encKey,
+			signature: string(mtesting.AuthReqHMAC(body, psk)),
+			devErr:    store.ErrDevNotFound,
+			err:       MakeErrDevAuthUnauthorized(ErrAuthReqSignature),
+		},
+		"error, not supported": {
+			signature: string(mtesting.AuthReqHMAC(body, psk)),
+			err:       MakeErrDevAuthBadRequest(ErrPSKNotSupported),
+		},
+		"error, db": {
+			encKey:    encKey,
+			signature: string(mtesting.AuthReqHMAC(body, psk)),
+			devErr:    errors.New("db error"),
+			err:       errors.New("failed to fetch device: db error"),
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			db := mstore.DataStore{}
+			db.On("GetDeviceByIdentityDataHash", ctx, idDataSha256[:]).
+				Return(tc.dev, tc.devErr)
+			db.On("GetAuthSetsForDevice", ctx, "dev-1").
+				Return(tc.asets, nil)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{})
+			if tc.encKey != nil {
+				devauth = devauth.WithPSKEncryptionKey(tc.encKey)
+			}
+
+			aset, err := devauth.verifyPSKAuthReq(ctx, &model.AuthReq{
+				IdData:         idData,
+				CredentialType: model.CredentialTypePSK,
+				Signature:      tc.signature,
+				Body:           body,
+			})
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+				assert.Nil(t, aset)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.aset, aset)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"sync"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceauth/jwt"
+	"github.com/mendersoftware/deviceauth/keys"
+	"github.com/mendersoftware/deviceauth/model"
+	"github.com/mendersoftware/deviceauth/store"
+)
+
+const (
+	// signing algorithm of the generated tenant keys
+	tenantKeyAlg = jwt.AlgES256
+	// how long a missing tenant key is remembered, so that verifying tokens
+	// of tenants without their own key doesn't cost a DB lookup each;
+	// signing always looks the key up
+	tenantKeyMissTTL = time.Minute
+)
+
+// tenantKeys caches the token handlers of the tenants' own signing keys.
+type tenantKeys struct {
+	// key encrypting the tenant keys in the DB
+	encKey []byte
+
+	mu       sync.Mutex
+	handlers map[string]*tenantKeyEntry
+}
+
+type tenantKeyEntry struct {
+	// nil if the tenant has no key
+	handler jwt.Handler
+	// only applies to missing keys
+	expiresAt time.Time
+}
+
+func newTenantKeys(encKey []byte) *tenantKeys {
+	return &tenantKeys{
+		encKey:   encKey,
+		handlers: map[string]*tenantKeyEntry{},
+	}
+}
+
+func (k *tenantKeys) get(tenantId string) (jwt.Handler, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	e, ok := k.handlers[tenantId]
+	if !ok {
+		return nil, false
+	}
+	if e.handler == nil && !time.Now().Before(e.expiresAt) {
+		delete(k.handlers, tenantId)
+		return nil, false
+	}
+	return e.handler, true
+}
+
+func (k *tenantKeys) invalidate(tenantId string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	delete(k.handlers, tenantId)
+}
+
+func (k *tenantKeys) put(tenantId string, h jwt.Handler) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.handlers[tenantId] = &tenantKeyEntry{
+		handler:   h,
+		expiresAt: time.Now().Add(tenantKeyMissTTL),
+	}
+}
+
+// WithTenantKeys enables per-tenant token signing keys, generated when
+// provisioning tenants and stored in the tenant DB encrypted with `encKey`.
+// Tokens of tenants with their own key are signed and verified only with
+// that key; tokens of other tenants with the server key.
+func (d *DevAuth) WithTenantKeys(encKey []byte) *DevAuth {
+	d.tenantKeys = newTenantKeys(encKey)
+	return d
+}
+
+// provisionTenantKey generates the signing key of the tenant in the
+// context, unless it has one already.
+func (d *DevAuth) provisionTenantKey(ctx context.Context, tenantId string) error {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return errors.Wrap(err, "failed to generate tenant key")
+	}
+
+	handler, err := jwt.NewKeyring(privKey, tenantKeyAlg)
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode tenant key")
+	}
+
+	encrypted, err := keys.Encrypt(der, d.tenantKeys.encKey)
+	if err != nil {
+		return errors.Wrap(err, "failed to encrypt tenant key")
+	}
+
+	err = d.db.AddTenantKey(ctx, model.TenantKey{
+		KeyID:     handler.ActiveKeyID(),
+		Alg:       tenantKeyAlg,
+		PrivKey:   encrypted,
+		CreatedTs: time.Now().UTC(),
+	})
+	switch err {
+	case nil:
+		d.tenantKeys.put(tenantId, handler)
+	case store.ErrObjectExists:
+		// tenant provisioned before, possibly cached as missing
+		d.tenantKeys.invalidate(tenantId)
+	default:
+		return errors.Wrap(err, "db add tenant key error")
+	}
+
+	return nil
+}
+
+// EnsureTenantKey generates the signing key of the tenant in the context,
+// unless it has one already, e.g. of tenants provisioned before tenant keys
+// were enabled. Returns whether the key was generated.
+func (d *DevAuth) EnsureTenantKey(ctx context.Context) (bool, error) {
+	id := identity.FromContext(ctx)
+	if d.tenantKeys == nil || id == nil || id.Tenant == "" {
+		return false, nil
+	}
+
+	_, err := d.db.GetTenantKey(ctx)
+	switch err {
+	case nil:
+		return false, nil
+	case store.ErrTenantKeyNotFound:
+	default:
+		return false, errors.Wrap(err, "db get tenant key error")
+	}
+
+	if err := d.provisionTenantKey(ctx, id.Tenant); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetTenantJWKS returns the public keys accepted for the verification of the
+// tenant's device tokens: the tenant's own key, if it has one, or the server
+// keys.
+func (d *DevAuth) GetTenantJWKS(ctx context.Context, tenantId string) (*jwt.JWKS, error) {
+	h, err := d.tokenHandler(ctx, tenantId, true)
+	if err != nil {
+		return nil, err
+	}
+
+	jwks, err := h.JWKS()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build JWKS")
+	}
+	return jwks, nil
+}
+
+// tokenHandler returns the handler signing and verifying the tokens of the
+// tenant: the handler of the tenant's own key, if it has one, or the server
+// one. A cached missing key is looked up again when signing, so that a key
+// created meanwhile (e.g. by another instance) is used right away.
+func (d *DevAuth) tokenHandler(ctx context.Context, tenantId string, signing bool) (jwt.Handler, error) {
+	if d.tenantKeys == nil || tenantId == "" {
+		return d.jwt, nil
+	}
+
+	if h, ok := d.tenantKeys.get(tenantId); ok {
+		if h != nil {
+			return h, nil
+		}
+		if !signing {
+			return d.jwt, nil
+		}
+	}
+
+	ctx = identity.WithContext(ctx, &identity.Identity{
+		Tenant: tenantId,
+	})
+
+	key, err := d.db.GetTenantKey(ctx)
+	switch err {
+	case nil:
+	case store.ErrTenantKeyNotFound:
+		d.tenantKeys.put(tenantId, nil)
+		return d.jwt, nil
+	default:
+		return nil, errors.Wrap(err, "db get tenant key error")
+	}
+
+	der, err := keys.Decrypt(key.PrivKey, d.tenantKeys.encKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decrypt key of tenant %s", tenantId)
+	}
+
+	privKey, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse key of tenant %s", tenantId)
+	}
+
+	h, err := jwt.NewKeyring(privKey, key.Alg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to set up key of tenant %s", tenantId)
+	}
+
+	d.tenantKeys.put(tenantId, h)
+	return h, nil
+}
+
+// verificationHandler returns the handler verifying the token, as selected by
+// its (unverified) 'mender.tenant' claim.
+func (d *DevAuth) verificationHandler(ctx context.Context, raw string) (jwt.Handler, error) {
+	if d.tenantKeys == nil {
+		return d.jwt, nil
+	}
+
+	claims, err := jwt.UnverifiedClaims(raw)
+	if err != nil {
+		// malformed, rejected when parsing
+		return d.jwt, nil
+	}
+
+	return d.tokenHandler(ctx, claims.Tenant, false)
+}
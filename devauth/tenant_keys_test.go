@@ -0,0 +1,359 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceauth/jwt"
+	"github.com/mendersoftware/deviceauth/model"
+	"github.com/mendersoftware/deviceauth/store"
+	mstore "github.com/mendersoftware/deviceauth/store/mocks"
+	mtesting "github.com/mendersoftware/deviceauth/utils/testing"
+)
+
+func makeTestKeyring(t *testing.T) *jwt.Keyring {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	k, err := jwt.NewKeyring(privKey, "")
+	assert.NoError(t, err)
+	return k
+}
+
+func TestDevAuthProvisionTenantKey(t *testing.T) {
+	t.Parallel()
+
+	encKey := make([]byte, 32)
+
+	testCases := map[string]struct {
+		dbErr error
+		err   error
+	}{
+		"ok": {},
+		"ok, key exists": {
+			dbErr: store.ErrObjectExists,
+		},
+		"error": {
+			dbErr: errors.New("db error"),
+			err:   errors.New("db add tenant key error: db error"),
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			tenantCtx := identity.WithContext(ctx, &identity.Identity{
+				Tenant: "foo",
+			})
+
+			db := mstore.DataStore{}
+			db.On("MigrateTenant", ctx,
+				mock.AnythingOfType("string"),
+				mock.AnythingOfType("string"),
+			).Return(nil)
+			db.On("WithAutomigrate").Return(&db)
+			db.On("AddTenantKey", tenantCtx,
+				mock.MatchedBy(func(key model.TenantKey) bool {
+					return key.KeyID != "" &&
+						key.Alg == jwt.AlgES256 &&
+						len(key.PrivKey) > 0
+				}),
+			).Return(tc.dbErr)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{}).
+				WithTenantKeys(encKey)
+			// looked up before provisioning
+			devauth.tenantKeys.put("foo", nil)
+
+			err := devauth.ProvisionTenant(ctx, "foo")
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+
+			h, cached := devauth.tenantKeys.get("foo")
+			assert.Equal(t, tc.dbErr == nil, h != nil)
+			if tc.dbErr == store.ErrObjectExists {
+				assert.False(t, cached)
+			}
+		})
+	}
+}
+
+func TestDevAuthTenantKeysSignVerify(t *testing.T) {
+	t.Parallel()
+
+	encKey := make([]byte, 32)
+	serverKeys := makeTestKeyring(t)
+	ctxMatcher := mtesting.ContextMatcher()
+
+	// provision the key of tenant "foo"
+	var tenantKey model.TenantKey
+	db := &mstore.DataStore{}
+	db.On("AddTenantKey", ctxMatcher,
+		mock.AnythingOfType("model.TenantKey"),
+	).Run(func(args mock.Arguments) {
+		tenantKey = args.Get(1).(model.TenantKey)
+	}).Return(nil)
+
+	devauth := NewDevAuth(db, nil, serverKeys, Config{}).
+		WithTenantKeys(encKey)
+	err := devauth.provisionTenantKey(context.Background(), "foo")
+	assert.NoError(t, err)
+
+	tenantMatcher := func(tenantId string) interface{} {
+		return mock.MatchedBy(func(ctx context.Context) bool {
+			id := identity.FromContext(ctx)
+			return id != nil && id.Tenant == tenantId
+		})
+	}
+
+	// fresh instance, keys fetched from the DB
+	db = &mstore.DataStore{}
+	db.On("GetTenantKey", tenantMatcher("foo")).Return(&tenantKey, nil)
+	db.On("GetTenantKey", tenantMatcher("bar")).
+		Return(nil, store.ErrTenantKeyNotFound)
+	db.On("GetTenantKey", tenantMatcher("new")).
+		Return(nil, store.ErrTenantKeyNotFound).Once()
+	db.On("GetTenantKey", tenantMatcher("new")).Return(&tenantKey, nil)
+	db.On("GetTenantKey", tenantMatcher("baz")).
+		Return(nil, errors.New("db error"))
+	db.On("GetTenantKey", tenantMatcher("qux")).
+		Return(&model.TenantKey{
+			KeyID:   "kid",
+			Alg:     jwt.AlgES256,
+			PrivKey: []byte("corrupted"),
+		}, nil)
+
+	devauth = NewDevAuth(db, nil, serverKeys, Config{}).
+		WithTenantKeys(encKey)
+
+	sign := func(tenantId string) string {
+		raw, err := (&jwt.Token{Claims: jwt.Claims{
+			ID:        "jti",
+			Subject:   "dev",
+			Issuer:    "Mender",
+			ExpiresAt: 4000000000,
+			Tenant:    tenantId,
+		}}).MarshalJWT(devauth.signToken(context.Background()))
+		assert.NoError(t, err)
+		return string(raw)
+	}
+
+	verify := func(raw string) error {
+		h, err := devauth.verificationHandler(context.Background(), raw)
+		if err != nil {
+			return err
+		}
+		_, err = h.FromJWT(raw)
+		return err
+	}
+
+	// signed with the tenant key
+	tenantToken := sign("foo")
+	assert.NoError(t, verify(tenantToken))
+	_, err = serverKeys.FromJWT(tenantToken)
+	assert.Equal(t, jwt.ErrTokenInvalid, err)
+
+	// the server key can't be used for the tenant's tokens
+	forged, err := (&jwt.Token{Claims: jwt.Claims{
+		ID:        "jti",
+		Subject:   "dev",
+		Issuer:    "Mender",
+		ExpiresAt: 4000000000,
+		Tenant:    "foo",
+	}}).MarshalJWT(serverKeys.ToJWT)
+	assert.NoError(t, err)
+	assert.Equal(t, jwt.ErrTokenInvalid, verify(string(forged)))
+
+	// tenants without their own key, and tokens without a tenant, use the
+	// server key
+	for _, tenantId := range []string{"bar", ""} {
+		raw := sign(tenantId)
+		assert.NoError(t, verify(raw))
+		_, err = serverKeys.FromJWT(raw)
+		assert.NoError(t, err)
+	}
+
+	// keys are fetched once
+	db.AssertNumberOfCalls(t, "GetTenantKey", 2)
+
+	// the key created after the miss was cached is used for signing right
+	// away, and then for verifying
+	_, err = devauth.tokenHandler(context.Background(), "new", false)
+	assert.NoError(t, err)
+	newToken := sign("new")
+	assert.NoError(t, verify(newToken))
+	_, err = serverKeys.FromJWT(newToken)
+	assert.Equal(t, jwt.ErrTokenInvalid, err)
+	db.AssertNumberOfCalls(t, "GetTenantKey", 4)
+
+	// errors
+	_, err = devauth.tokenHandler(context.Background(), "baz", true)
+	assert.EqualError(t, err, "db get tenant key error: db error")
+	_, err = devauth.tokenHandler(context.Background(), "qux", true)
+	assert.EqualError(t, err, "failed to decrypt key of tenant qux: "+
+		"ciphertext too short")
+}
+
+func TestDevAuthEnsureTenantKey(t *testing.T) {
+	t.Parallel()
+
+	encKey := make([]byte, 32)
+
+	testCases := map[string]struct {
+		tenant string
+		getErr error
+
+		generated bool
+		err       error
+	}{
+		"ok, generated": {
+			tenant:    "foo",
+			getErr:    store.ErrTenantKeyNotFound,
+			generated: true,
+		},
+		"ok, key exists": {
+			tenant: "foo",
+		},
+		"ok, no tenant": {},
+		"error": {
+			tenant: "foo",
+			getErr: errors.New("db error"),
+			err:    errors.New("db get tenant key error: db error"),
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			if tc.tenant != "" {
+				ctx = identity.WithContext(ctx, &identity.Identity{
+					Tenant: tc.tenant,
+				})
+			}
+
+			db := mstore.DataStore{}
+			db.On("GetTenantKey", ctx).
+				Return(&model.TenantKey{}, tc.getErr)
+			db.On("AddTenantKey", ctx,
+				mock.AnythingOfType("model.TenantKey"),
+			).Return(nil)
+
+			devauth := NewDevAuth(&db, nil, nil, Config{}).
+				WithTenantKeys(encKey)
+
+			generated, err := devauth.EnsureTenantKey(ctx)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.generated, generated)
+			if tc.generated {
+				db.AssertCalled(t, "AddTenantKey", ctx,
+					mock.AnythingOfType("model.TenantKey"))
+			} else {
+				db.AssertNotCalled(t, "AddTenantKey", ctx, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestDevAuthGetTenantJWKS(t *testing.T) {
+	t.Parallel()
+
+	encKey := make([]byte, 32)
+	serverKeys := makeTestKeyring(t)
+
+	var tenantKey model.TenantKey
+	db := &mstore.DataStore{}
+	db.On("AddTenantKey", mock.Anything,
+		mock.AnythingOfType("model.TenantKey"),
+	).Run(func(args mock.Arguments) {
+		tenantKey = args.Get(1).(model.TenantKey)
+	}).Return(nil)
+
+	devauth := NewDevAuth(db, nil, serverKeys, Config{}).
+		WithTenantKeys(encKey)
+	err := devauth.provisionTenantKey(context.Background(), "foo")
+	assert.NoError(t, err)
+
+	// a service verifying the tenant's token offline
+	raw, err := (&jwt.Token{Claims: jwt.Claims{
+		ID:        "jti",
+		Subject:   "dev",
+		Issuer:    "Mender",
+		ExpiresAt: 4000000000,
+		Tenant:    "foo",
+	}}).MarshalJWT(devauth.signToken(context.Background()))
+	assert.NoError(t, err)
+
+	jwks, err := devauth.GetTenantJWKS(context.Background(), "foo")
+	assert.NoError(t, err)
+	if assert.Len(t, jwks.Keys, 1) {
+		jwk := jwks.Keys[0]
+		assert.Equal(t, tenantKey.KeyID, jwk.KeyID)
+		assert.Equal(t, jwt.AlgES256, jwk.Algorithm)
+
+		verifier := makeTestKeyring(t)
+		kid, err := verifier.AddVerificationKey(&ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(b64Decode(t, jwk.X)),
+			Y:     new(big.Int).SetBytes(b64Decode(t, jwk.Y)),
+		}, jwk.Algorithm)
+		assert.NoError(t, err)
+		assert.Equal(t, jwk.KeyID, kid)
+
+		token, err := verifier.FromJWT(string(raw))
+		assert.NoError(t, err)
+		if assert.NotNil(t, token) {
+			assert.Equal(t, "foo", token.Claims.Tenant)
+		}
+	}
+
+	// the server keys are published for tenants without their own key
+	db.On("GetTenantKey", mock.Anything).
+		Return(nil, store.ErrTenantKeyNotFound)
+	jwks, err = devauth.GetTenantJWKS(context.Background(), "bar")
+	assert.NoError(t, err)
+	serverJWKS, err := serverKeys.JWKS()
+	assert.NoError(t, err)
+	assert.Equal(t, serverJWKS, jwks)
+}
+
+func b64Decode(t *testing.T, s string) []byte {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	assert.NoError(t, err)
+	return data
+}
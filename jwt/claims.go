@@ -14,6 +14,8 @@
 package jwt
 
 import (
+	"encoding/json"
+	"strings"
 	"time"
 )
 
@@ -28,6 +30,39 @@ type Claims struct {
 	Scope     string `json:"scp,omitempty"`
 	Tenant    string `json:"mender.tenant,omitempty"`
 	Device    bool   `json:"mender.device,omitempty"`
+
+	// additional claims, included when marshaling; the claims above take
+	// precedence, and additional claims are not unmarshaled
+	Extra map[string]interface{} `json:"-"`
+}
+
+// MarshalJSON encodes the claims along with the additional ones.
+func (c *Claims) MarshalJSON() ([]byte, error) {
+	// alias without methods, to avoid recursion
+	type claims Claims
+
+	data, err := json.Marshal((*claims)(c))
+	if err != nil || len(c.Extra) == 0 {
+		return data, err
+	}
+
+	merged := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return nil, err
+	}
+
+	for name, value := range c.Extra {
+		if _, ok := merged[name]; ok {
+			continue
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		merged[name] = raw
+	}
+
+	return json.Marshal(merged)
 }
 
 // Valid checks if claims are valid. Returns error if validation fails.
@@ -48,6 +83,31 @@ func (c *Claims) Valid() error {
 	return nil
 }
 
+// HasScope checks if the token is valid for the scope; the 'scp' claim is a
+// space separated list of scopes, tokens without it are not restricted.
+func (c *Claims) HasScope(scope string) bool {
+	if c.Scope == "" {
+		return true
+	}
+
+	for _, s := range strings.Fields(c.Scope) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAudience checks if the token was issued for one of the audiences.
+func (c *Claims) HasAudience(audiences ...string) bool {
+	for _, aud := range audiences {
+		if c.Audience == aud {
+			return true
+		}
+	}
+	return false
+}
+
 func verifyExp(exp int64) bool {
 	now := time.Now().Unix()
 	return now <= exp
@@ -0,0 +1,106 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package jwt
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaimsHasScope(t *testing.T) {
+	testCases := map[string]struct {
+		scope    string
+		required string
+		res      bool
+	}{
+		"no scope": {
+			required: "deployments",
+			res:      true,
+		},
+		"single scope": {
+			scope:    "deployments",
+			required: "deployments",
+			res:      true,
+		},
+		"scope list": {
+			scope:    "inventory deployments",
+			required: "deployments",
+			res:      true,
+		},
+		"scope missing": {
+			scope:    "inventory",
+			required: "deployments",
+			res:      false,
+		},
+		"no partial match": {
+			scope:    "deployments.read",
+			required: "deployments",
+			res:      false,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			c := Claims{Scope: tc.scope}
+			assert.Equal(t, tc.res, c.HasScope(tc.required))
+		})
+	}
+}
+
+func TestClaimsMarshalJSON(t *testing.T) {
+	testCases := map[string]struct {
+		claims Claims
+		res    string
+	}{
+		"no extra claims": {
+			claims: Claims{Subject: "foo", ExpiresAt: 123},
+			res:    `{"exp":123,"sub":"foo"}`,
+		},
+		"extra claims": {
+			claims: Claims{
+				Subject:   "foo",
+				ExpiresAt: 123,
+				Extra: map[string]interface{}{
+					"fleet": "bar",
+					"level": 2,
+				},
+			},
+			res: `{"exp":123,"fleet":"bar","level":2,"sub":"foo"}`,
+		},
+		"extra claims don't override": {
+			claims: Claims{
+				Subject: "foo",
+				Extra: map[string]interface{}{
+					"sub": "bar",
+				},
+			},
+			res: `{"sub":"foo"}`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			data, err := json.Marshal(&tc.claims)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.res, string(data))
+
+			var out Claims
+			assert.NoError(t, json.Unmarshal(data, &out))
+			assert.Equal(t, tc.claims.Subject, out.Subject)
+			assert.Nil(t, out.Extra)
+		})
+	}
+}
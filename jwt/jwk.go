@@ -0,0 +1,148 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// JWK is a JSON Web Key (RFC 7517) holding a token verification key
+type JWK struct {
+	KeyID     string `json:"kid"`
+	KeyType   string `json:"kty"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC, OKP
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+const (
+	JWKUseSignature = "sig"
+)
+
+// NewJWK encodes the public key as a JWK, with key ID and algorithm set.
+func NewJWK(pubKey crypto.PublicKey, alg string) (*JWK, error) {
+	members, err := jwkMembers(pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	kid, err := KeyID(pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JWK{
+		KeyID:     kid,
+		KeyType:   members["kty"],
+		Algorithm: alg,
+		Use:       JWKUseSignature,
+		N:         members["n"],
+		E:         members["e"],
+		Curve:     members["crv"],
+		X:         members["x"],
+		Y:         members["y"],
+	}, nil
+}
+
+// singleKeyJWKS is a helper for single-key handlers
+func singleKeyJWKS(pubKey crypto.PublicKey, alg string) (*JWKS, error) {
+	jwk, err := NewJWK(pubKey, alg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JWKS{Keys: []JWK{*jwk}}, nil
+}
+
+// jwkMembers returns the required JWK members of a public key (RFC 7517,
+// RFC 8037), as used for the JWK thumbprint
+func jwkMembers(pubKey crypto.PublicKey) (map[string]string, error) {
+	switch key := pubKey.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"n":   b64(key.N.Bytes()),
+			"e":   b64(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		return map[string]string{
+			"kty": "EC",
+			"crv": key.Curve.Params().Name,
+			"x":   b64(padBytes(key.X.Bytes(), size)),
+			"y":   b64(padBytes(key.Y.Bytes(), size)),
+		}, nil
+	case ed25519.PublicKey:
+		return map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   b64(key),
+		}, nil
+	default:
+		return nil, errors.Errorf("unsupported public key type: %T", pubKey)
+	}
+}
+
+// KeyID computes the key ID of a public key as its JWK thumbprint
+// (RFC 7638), so that all instances sharing a key agree on its ID.
+func KeyID(pubKey crypto.PublicKey) (string, error) {
+	members, err := jwkMembers(pubKey)
+	if err != nil {
+		return "", err
+	}
+
+	// encoding/json sorts map keys, which is what RFC 7638 requires
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return b64(sum[:]), nil
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func padBytes(data []byte, size int) []byte {
+	if len(data) >= size {
+		return data
+	}
+	out := make([]byte, size)
+	copy(out[size-len(data):], data)
+	return out
+}
@@ -14,12 +14,23 @@
 package jwt
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rsa"
 
 	jwtgo "github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
 )
 
+const (
+	AlgRS256 = "RS256"
+	AlgPS256 = "PS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
 var (
 	ErrTokenExpired = errors.New("jwt: token expired")
 	ErrTokenInvalid = errors.New("jwt: token invalid")
@@ -33,6 +44,91 @@ type Handler interface {
 	// ErrTokenExpired when the token is valid but expired
 	// ErrTokenInvalid when the token is invalid (malformed, missing required claims, etc.)
 	FromJWT(string) (*Token, error)
+	// JWKS returns the public keys accepted for token verification
+	JWKS() (*JWKS, error)
+}
+
+// NewJWTHandler creates a Handler signing tokens with `privKey`. If `alg` is
+// empty, the algorithm is picked based on the key type: RS256 for RSA keys,
+// ES256 for P-256 ECDSA keys and EdDSA for Ed25519 keys.
+func NewJWTHandler(privKey crypto.PrivateKey, alg string) (Handler, error) {
+	alg, err := checkKeyAlg(privKey, alg)
+	if err != nil {
+		return nil, err
+	}
+
+	switch alg {
+	case AlgPS256:
+		return NewJWTHandlerPS256(privKey.(*rsa.PrivateKey)), nil
+	case AlgES256:
+		return NewJWTHandlerES256(privKey.(*ecdsa.PrivateKey)), nil
+	case AlgEdDSA:
+		return NewJWTHandlerEdDSA(privKey.(ed25519.PrivateKey)), nil
+	default:
+		return NewJWTHandlerRS256(privKey.(*rsa.PrivateKey)), nil
+	}
+}
+
+// checkKeyAlg verifies that the private key can be used with the signing
+// algorithm, or picks the algorithm based on the key type if `alg` is empty.
+func checkKeyAlg(privKey crypto.PrivateKey, alg string) (string, error) {
+	if alg == "" {
+		switch key := privKey.(type) {
+		case *rsa.PrivateKey:
+			alg = AlgRS256
+		case *ecdsa.PrivateKey:
+			if key.Curve != elliptic.P256() {
+				return "", errors.Errorf("unsupported elliptic curve: %s",
+					key.Curve.Params().Name)
+			}
+			alg = AlgES256
+		case ed25519.PrivateKey:
+			alg = AlgEdDSA
+		default:
+			return "", errors.Errorf("unsupported private key type: %T", privKey)
+		}
+	}
+
+	if signingMethod(alg) == nil {
+		return "", errors.Errorf("unsupported signing algorithm: %s", alg)
+	}
+
+	signer, ok := privKey.(crypto.Signer)
+	if !ok || !keyAllowsAlg(signer.Public(), alg) {
+		return "", errors.Errorf("private key type %T can't be used with %s", privKey, alg)
+	}
+
+	return alg, nil
+}
+
+// keyAllowsAlg checks if tokens signed with `alg` can be verified with
+// the public key
+func keyAllowsAlg(pubKey crypto.PublicKey, alg string) bool {
+	switch key := pubKey.(type) {
+	case *rsa.PublicKey:
+		return alg == AlgRS256 || alg == AlgPS256
+	case *ecdsa.PublicKey:
+		return alg == AlgES256 && key.Curve == elliptic.P256()
+	case ed25519.PublicKey:
+		return alg == AlgEdDSA
+	default:
+		return false
+	}
+}
+
+func signingMethod(alg string) jwtgo.SigningMethod {
+	switch alg {
+	case AlgRS256:
+		return jwtgo.SigningMethodRS256
+	case AlgPS256:
+		return jwtgo.SigningMethodPS256
+	case AlgES256:
+		return jwtgo.SigningMethodES256
+	case AlgEdDSA:
+		return SigningMethodEdDSA
+	default:
+		return nil
+	}
 }
 
 // JWTHandlerRS256 is an RS256-specific JWTHandler
@@ -56,12 +152,22 @@ func (j *JWTHandlerRS256) ToJWT(token *Token) (string, error) {
 }
 
 func (j *JWTHandlerRS256) FromJWT(tokstr string) (*Token, error) {
+	return parseJWT(tokstr, jwtgo.SigningMethodRS256, &j.privKey.PublicKey)
+}
+
+func (j *JWTHandlerRS256) JWKS() (*JWKS, error) {
+	return singleKeyJWKS(&j.privKey.PublicKey, AlgRS256)
+}
+
+// parseJWT parses and verifies the token, accepting only the given signing
+// method; shared by all algorithm-specific handlers
+func parseJWT(tokstr string, method jwtgo.SigningMethod, pubKey interface{}) (*Token, error) {
 	jwttoken, err := jwtgo.ParseWithClaims(tokstr, &Claims{},
 		func(token *jwtgo.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwtgo.SigningMethodRSA); !ok {
+			if token.Method.Alg() != method.Alg() {
 				return nil, errors.New("unexpected signing method: " + token.Method.Alg())
 			}
-			return &j.privKey.PublicKey, nil
+			return pubKey, nil
 		},
 	)
 
@@ -70,7 +176,7 @@ func (j *JWTHandlerRS256) FromJWT(tokstr string) (*Token, error) {
 	if err != nil {
 		err, ok := err.(*jwtgo.ValidationError)
 		if ok && err.Inner != nil {
-			return nil, err.Inner
+			return expiredToken(jwttoken, err), err.Inner
 		} else {
 			return nil, err
 		}
@@ -85,3 +191,16 @@ func (j *JWTHandlerRS256) FromJWT(tokstr string) (*Token, error) {
 		return nil, ErrTokenInvalid
 	}
 }
+
+// expiredToken returns the token if its only validation error is expiration,
+// i.e. its signature and other claims are valid; nil otherwise.
+func expiredToken(jwttoken *jwtgo.Token, err *jwtgo.ValidationError) *Token {
+	if jwttoken == nil || err.Errors != jwtgo.ValidationErrorClaimsInvalid ||
+		err.Inner != ErrTokenExpired {
+		return nil
+	}
+	if claims, ok := jwttoken.Claims.(*Claims); ok {
+		return &Token{Claims: *claims}
+	}
+	return nil
+}
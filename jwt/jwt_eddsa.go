@@ -0,0 +1,96 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package jwt
+
+import (
+	"crypto/ed25519"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrEdDSAVerification = errors.New("jwt: EdDSA verification failed")
+
+	// SigningMethodEdDSA implements the EdDSA (Ed25519) signing method,
+	// which is not provided by jwt-go
+	SigningMethodEdDSA = &SigningMethodEd25519{}
+)
+
+func init() {
+	jwtgo.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwtgo.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+type SigningMethodEd25519 struct{}
+
+func (m *SigningMethodEd25519) Alg() string {
+	return "EdDSA"
+}
+
+func (m *SigningMethodEd25519) Verify(signingString, signature string, key interface{}) error {
+	pubKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwtgo.ErrInvalidKeyType
+	}
+
+	sig, err := jwtgo.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(pubKey, []byte(signingString), sig) {
+		return ErrEdDSAVerification
+	}
+
+	return nil
+}
+
+func (m *SigningMethodEd25519) Sign(signingString string, key interface{}) (string, error) {
+	privKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwtgo.ErrInvalidKey
+	}
+
+	return jwtgo.EncodeSegment(ed25519.Sign(privKey, []byte(signingString))), nil
+}
+
+// JWTHandlerEdDSA is an EdDSA-specific (Ed25519) JWTHandler
+type JWTHandlerEdDSA struct {
+	privKey ed25519.PrivateKey
+}
+
+func NewJWTHandlerEdDSA(privKey ed25519.PrivateKey) *JWTHandlerEdDSA {
+	return &JWTHandlerEdDSA{
+		privKey: privKey,
+	}
+}
+
+func (j *JWTHandlerEdDSA) ToJWT(token *Token) (string, error) {
+	//generate
+	jt := jwtgo.NewWithClaims(SigningMethodEdDSA, &token.Claims)
+
+	//sign
+	data, err := jt.SignedString(j.privKey)
+	return data, err
+}
+
+func (j *JWTHandlerEdDSA) FromJWT(tokstr string) (*Token, error) {
+	return parseJWT(tokstr, SigningMethodEdDSA, j.privKey.Public())
+}
+
+func (j *JWTHandlerEdDSA) JWKS() (*JWKS, error) {
+	return singleKeyJWKS(j.privKey.Public(), AlgEdDSA)
+}
@@ -0,0 +1,48 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package jwt
+
+import (
+	"crypto/ecdsa"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+)
+
+// JWTHandlerES256 is an ES256-specific JWTHandler
+type JWTHandlerES256 struct {
+	privKey *ecdsa.PrivateKey
+}
+
+func NewJWTHandlerES256(privKey *ecdsa.PrivateKey) *JWTHandlerES256 {
+	return &JWTHandlerES256{
+		privKey: privKey,
+	}
+}
+
+func (j *JWTHandlerES256) ToJWT(token *Token) (string, error) {
+	//generate
+	jt := jwtgo.NewWithClaims(jwtgo.SigningMethodES256, &token.Claims)
+
+	//sign
+	data, err := jt.SignedString(j.privKey)
+	return data, err
+}
+
+func (j *JWTHandlerES256) FromJWT(tokstr string) (*Token, error) {
+	return parseJWT(tokstr, jwtgo.SigningMethodES256, &j.privKey.PublicKey)
+}
+
+func (j *JWTHandlerES256) JWKS() (*JWKS, error) {
+	return singleKeyJWKS(&j.privKey.PublicKey, AlgES256)
+}
@@ -0,0 +1,48 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package jwt
+
+import (
+	"crypto/rsa"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+)
+
+// JWTHandlerPS256 is a PS256-specific (RSASSA-PSS) JWTHandler
+type JWTHandlerPS256 struct {
+	privKey *rsa.PrivateKey
+}
+
+func NewJWTHandlerPS256(privKey *rsa.PrivateKey) *JWTHandlerPS256 {
+	return &JWTHandlerPS256{
+		privKey: privKey,
+	}
+}
+
+func (j *JWTHandlerPS256) ToJWT(token *Token) (string, error) {
+	//generate
+	jt := jwtgo.NewWithClaims(jwtgo.SigningMethodPS256, &token.Claims)
+
+	//sign
+	data, err := jt.SignedString(j.privKey)
+	return data, err
+}
+
+func (j *JWTHandlerPS256) FromJWT(tokstr string) (*Token, error) {
+	return parseJWT(tokstr, jwtgo.SigningMethodPS256, &j.privKey.PublicKey)
+}
+
+func (j *JWTHandlerPS256) JWKS() (*JWKS, error) {
+	return singleKeyJWKS(&j.privKey.PublicKey, AlgPS256)
+}
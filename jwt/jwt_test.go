@@ -14,8 +14,11 @@
 package jwt
 
 import (
+	"crypto"
 	"crypto/rsa"
+	"fmt"
 	"testing"
+	"time"
 
 	jwtgo "github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
@@ -194,3 +197,169 @@ func parseGeneratedTokenRS256(t *testing.T, token string, key *rsa.PrivateKey) *
 
 	return tokenParsed
 }
+
+func TestNewJWTHandler(t *testing.T) {
+	rsaKey := loadPrivKey("./testdata/private.pem", t)
+	ecKey := loadAnyPrivKey("./testdata/private_ec.pem", t)
+	edKey := loadAnyPrivKey("./testdata/private_ed25519.pem", t)
+
+	testCases := map[string]struct {
+		privKey crypto.PrivateKey
+		alg     string
+
+		handlerType string
+		err         string
+	}{
+		"ok, rsa, detected": {
+			privKey:     rsaKey,
+			handlerType: "*jwt.JWTHandlerRS256",
+		},
+		"ok, rsa, PS256": {
+			privKey:     rsaKey,
+			alg:         AlgPS256,
+			handlerType: "*jwt.JWTHandlerPS256",
+		},
+		"ok, ec, detected": {
+			privKey:     ecKey,
+			handlerType: "*jwt.JWTHandlerES256",
+		},
+		"ok, ed25519, explicit": {
+			privKey:     edKey,
+			alg:         AlgEdDSA,
+			handlerType: "*jwt.JWTHandlerEdDSA",
+		},
+		"error, key/alg mismatch": {
+			privKey: ecKey,
+			alg:     AlgRS256,
+			err:     "private key type *ecdsa.PrivateKey can't be used with RS256",
+		},
+		"error, unknown alg": {
+			privKey: rsaKey,
+			alg:     "HS256",
+			err:     "unsupported signing algorithm: HS256",
+		},
+		"error, unknown key": {
+			privKey: "foo",
+			err:     "unsupported private key type: string",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			h, err := NewJWTHandler(tc.privKey, tc.alg)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.handlerType, fmt.Sprintf("%T", h))
+			}
+		})
+	}
+}
+
+func TestJWTHandlerRoundTrip(t *testing.T) {
+	rsaKey := loadPrivKey("./testdata/private.pem", t)
+	ecKey := loadAnyPrivKey("./testdata/private_ec.pem", t)
+	edKey := loadAnyPrivKey("./testdata/private_ed25519.pem", t)
+
+	testCases := map[string]struct {
+		privKey crypto.PrivateKey
+		alg     string
+	}{
+		"RS256": {privKey: rsaKey, alg: AlgRS256},
+		"PS256": {privKey: rsaKey, alg: AlgPS256},
+		"ES256": {privKey: ecKey, alg: AlgES256},
+		"EdDSA": {privKey: edKey, alg: AlgEdDSA},
+	}
+
+	claims := Claims{
+		ID:        "someid",
+		Issuer:    "Mender",
+		Subject:   "foo",
+		ExpiresAt: time.Now().Unix() + 3600,
+		Device:    true,
+	}
+
+	handlers := map[string]Handler{}
+	for name, tc := range testCases {
+		h, err := NewJWTHandler(tc.privKey, tc.alg)
+		assert.NoError(t, err)
+		handlers[name] = h
+	}
+
+	for name := range testCases {
+		t.Run(name, func(t *testing.T) {
+			raw, err := handlers[name].ToJWT(&Token{Claims: claims})
+			assert.NoError(t, err)
+
+			token, err := handlers[name].FromJWT(raw)
+			assert.NoError(t, err)
+			if assert.NotNil(t, token) {
+				assert.Equal(t, claims, token.Claims)
+			}
+
+			jwks, err := handlers[name].JWKS()
+			assert.NoError(t, err)
+			if assert.Len(t, jwks.Keys, 1) {
+				assert.Equal(t, testCases[name].alg, jwks.Keys[0].Algorithm)
+				assert.Equal(t, JWKUseSignature, jwks.Keys[0].Use)
+			}
+
+			// handlers of other algorithms must reject the token
+			for other, h := range handlers {
+				if other == name {
+					continue
+				}
+				_, err := h.FromJWT(raw)
+				assert.Error(t, err, "%s accepted by %s", name, other)
+			}
+		})
+	}
+}
+
+func TestJWTHandlerFromJWTExpired(t *testing.T) {
+	key := loadPrivKey("./testdata/private.pem", t)
+	otherKey := loadAnyPrivKey("./testdata/private_ec.pem", t)
+
+	claims := Claims{
+		ID:        "someid",
+		Issuer:    "Mender",
+		Subject:   "foo",
+		ExpiresAt: time.Now().Unix() - 10,
+		Device:    true,
+	}
+
+	h := NewJWTHandlerRS256(key)
+	raw, err := h.ToJWT(&Token{Claims: claims})
+	assert.NoError(t, err)
+
+	// claims of authentic expired tokens are returned
+	token, err := h.FromJWT(raw)
+	assert.EqualError(t, err, ErrTokenExpired.Error())
+	if assert.NotNil(t, token) {
+		assert.Equal(t, claims, token.Claims)
+	}
+
+	// but not of expired tokens signed with another key
+	other, err := NewJWTHandler(otherKey, "")
+	assert.NoError(t, err)
+	raw, err = other.ToJWT(&Token{Claims: claims})
+	assert.NoError(t, err)
+
+	token, err = other.FromJWT(raw)
+	assert.EqualError(t, err, ErrTokenExpired.Error())
+	assert.NotNil(t, token)
+
+	token, err = h.FromJWT(raw)
+	assert.Error(t, err)
+	assert.Nil(t, token)
+}
+
+func loadAnyPrivKey(path string, t *testing.T) crypto.PrivateKey {
+	key, err := keys.LoadPrivate(path)
+	if err != nil {
+		t.Fatalf("failed to load key: %v", err)
+	}
+
+	return key
+}
@@ -0,0 +1,246 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package jwt
+
+import (
+	"crypto"
+	"encoding/json"
+	"strings"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+const (
+	HeaderKeyID = "kid"
+)
+
+var (
+	ErrKeyExists = errors.New("jwt: key already in keyring")
+)
+
+// Keyring is a Handler supporting signing key rotation. Tokens are signed
+// with the active key and stamped with its ID in the 'kid' header. Tokens
+// are verified with the key matching their 'kid': the active one, or one of
+// the verification-only keys, i.e. keys staged for activation on other
+// instances, or retired keys whose tokens did not expire yet.
+//
+// Rotation without downtime:
+// - stage: add the new key as a verification key on all instances
+// - activate: make the new key active, keep the old one for verification
+// - retire: drop the old key once all tokens signed with it have expired
+type Keyring struct {
+	activeKid string
+	alg       string
+	privKey   crypto.PrivateKey
+
+	// verification keys by key ID, including the active one
+	pubKeys map[string]*verificationKey
+	// key IDs in order of preference, for tokens without 'kid'
+	kids []string
+}
+
+type verificationKey struct {
+	pubKey crypto.PublicKey
+	alg    string
+}
+
+func NewKeyring(privKey crypto.PrivateKey, alg string) (*Keyring, error) {
+	alg, err := checkKeyAlg(privKey, alg)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKey := privKey.(crypto.Signer).Public()
+
+	kid, err := KeyID(pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Keyring{
+		activeKid: kid,
+		alg:       alg,
+		privKey:   privKey,
+		pubKeys: map[string]*verificationKey{
+			kid: &verificationKey{pubKey: pubKey, alg: alg},
+		},
+		kids: []string{kid},
+	}, nil
+}
+
+// AddVerificationKey adds a verification-only key, accepting tokens signed
+// with `alg`, and returns its key ID. If `alg` is empty, the algorithm of the
+// active key is used if compatible with the key type, otherwise the default
+// one for the key type (RS256, ES256, EdDSA).
+func (k *Keyring) AddVerificationKey(pubKey crypto.PublicKey, alg string) (string, error) {
+	kid, err := KeyID(pubKey)
+	if err != nil {
+		return "", err
+	}
+
+	if _, ok := k.pubKeys[kid]; ok {
+		return "", ErrKeyExists
+	}
+
+	if alg == "" {
+		for _, a := range []string{k.alg, AlgRS256, AlgES256, AlgEdDSA} {
+			if keyAllowsAlg(pubKey, a) {
+				alg = a
+				break
+			}
+		}
+	}
+
+	if !keyAllowsAlg(pubKey, alg) {
+		return "", errors.Errorf("public key type %T can't be used with %s", pubKey, alg)
+	}
+
+	k.pubKeys[kid] = &verificationKey{pubKey: pubKey, alg: alg}
+	k.kids = append(k.kids, kid)
+
+	return kid, nil
+}
+
+// SplitKeyAlg splits a key reference of the form path[:alg] into the key
+// path and the signing algorithm of the tokens verified with the key; the
+// algorithm is empty if the reference doesn't end with a supported one.
+func SplitKeyAlg(ref string) (string, string) {
+	i := strings.LastIndexByte(ref, ':')
+	if i < 0 || signingMethod(ref[i+1:]) == nil {
+		return ref, ""
+	}
+	return ref[:i], ref[i+1:]
+}
+
+// ActiveKeyID returns the ID of the key used for signing.
+func (k *Keyring) ActiveKeyID() string {
+	return k.activeKid
+}
+
+// KeyIDs returns the IDs of all keys accepted for verification, the active
+// one first.
+func (k *Keyring) KeyIDs() []string {
+	return append([]string{}, k.kids...)
+}
+
+func (k *Keyring) ToJWT(token *Token) (string, error) {
+	//generate
+	jt := jwtgo.NewWithClaims(signingMethod(k.alg), &token.Claims)
+	jt.Header[HeaderKeyID] = k.activeKid
+
+	//sign
+	return jt.SignedString(k.privKey)
+}
+
+func (k *Keyring) FromJWT(tokstr string) (*Token, error) {
+	kid, err := parseKeyID(tokstr)
+	if err != nil {
+		return nil, err
+	}
+
+	if kid != "" {
+		key, ok := k.pubKeys[kid]
+		if !ok {
+			return nil, ErrTokenInvalid
+		}
+		token, err := parseJWTWithKey(tokstr, key)
+		if err == errSignatureInvalid {
+			return nil, ErrTokenInvalid
+		}
+		return token, err
+	}
+
+	// tokens issued before key IDs were introduced; try all keys, the
+	// active one first
+	for _, kid := range k.kids {
+		token, err := parseJWTWithKey(tokstr, k.pubKeys[kid])
+		if err != errSignatureInvalid {
+			return token, err
+		}
+	}
+
+	return nil, ErrTokenInvalid
+}
+
+// JWKS returns all keys accepted for verification, the active one first.
+func (k *Keyring) JWKS() (*JWKS, error) {
+	jwks := &JWKS{Keys: make([]JWK, 0, len(k.kids))}
+
+	for _, kid := range k.kids {
+		key := k.pubKeys[kid]
+		jwk, err := NewJWK(key.pubKey, key.alg)
+		if err != nil {
+			return nil, err
+		}
+		jwks.Keys = append(jwks.Keys, *jwk)
+	}
+
+	return jwks, nil
+}
+
+// errSignatureInvalid is only used internally, to tell signature
+// verification failures from other errors
+var errSignatureInvalid = errors.New("jwt: signature invalid")
+
+// parseKeyID extracts the 'kid' header without verifying the token
+func parseKeyID(tokstr string) (string, error) {
+	parts := strings.Split(tokstr, ".")
+	if len(parts) != 3 {
+		return "", errors.New("token contains an invalid number of segments")
+	}
+
+	data, err := jwtgo.DecodeSegment(parts[0])
+	if err != nil {
+		return "", ErrTokenInvalid
+	}
+
+	var header map[string]interface{}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return "", ErrTokenInvalid
+	}
+
+	kid, _ := header[HeaderKeyID].(string)
+	return kid, nil
+}
+
+// parseJWTWithKey parses and verifies the token with the verification key
+func parseJWTWithKey(tokstr string, key *verificationKey) (*Token, error) {
+	jwttoken, err := jwtgo.ParseWithClaims(tokstr, &Claims{},
+		func(token *jwtgo.Token) (interface{}, error) {
+			if token.Method.Alg() != key.alg {
+				return nil, errors.New("unexpected signing method: " + token.Method.Alg())
+			}
+			return key.pubKey, nil
+		},
+	)
+
+	if err != nil {
+		err, ok := err.(*jwtgo.ValidationError)
+		if ok && err.Errors&(jwtgo.ValidationErrorSignatureInvalid|
+			jwtgo.ValidationErrorUnverifiable) != 0 {
+			return nil, errSignatureInvalid
+		}
+		if ok && err.Inner != nil {
+			return expiredToken(jwttoken, err), err.Inner
+		}
+		return nil, err
+	}
+
+	if claims, ok := jwttoken.Claims.(*Claims); ok && jwttoken.Valid {
+		return &Token{Claims: *claims}, nil
+	}
+
+	return nil, ErrTokenInvalid
+}
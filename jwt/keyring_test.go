@@ -0,0 +1,294 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package jwt
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyID(t *testing.T) {
+	// example from RFC 7638, section 3.1
+	n, err := base64.RawURLEncoding.DecodeString(
+		"0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtV" +
+			"T86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn6" +
+			"4tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_F" +
+			"DW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1" +
+			"n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPks" +
+			"INHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	assert.NoError(t, err)
+
+	kid, err := KeyID(&rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: 65537,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", kid)
+
+	_, err = KeyID("foo")
+	assert.EqualError(t, err, "unsupported public key type: string")
+}
+
+func TestKeyringRotation(t *testing.T) {
+	oldKey := loadPrivKey("./testdata/private.pem", t)
+	newKey := loadPrivKey("./testdata/private_2.pem", t)
+	otherKey := loadAnyPrivKey("./testdata/private_ec.pem", t)
+
+	claims := Claims{
+		ID:        "someid",
+		Issuer:    "Mender",
+		Subject:   "foo",
+		ExpiresAt: time.Now().Unix() + 3600,
+	}
+
+	// stage: old key active, new key accepted for verification
+	staged, err := NewKeyring(oldKey, "")
+	assert.NoError(t, err)
+	newKid, err := staged.AddVerificationKey(&newKey.PublicKey, "")
+	assert.NoError(t, err)
+
+	_, err = staged.AddVerificationKey(&newKey.PublicKey, "")
+	assert.EqualError(t, err, ErrKeyExists.Error())
+
+	// activate: new key active, old key accepted for verification
+	active, err := NewKeyring(newKey, "")
+	assert.NoError(t, err)
+	oldKid, err := active.AddVerificationKey(&oldKey.PublicKey, "")
+	assert.NoError(t, err)
+
+	assert.Equal(t, newKid, active.ActiveKeyID())
+	assert.Equal(t, oldKid, staged.ActiveKeyID())
+	assert.Equal(t, []string{newKid, oldKid}, active.KeyIDs())
+
+	// retired: old key gone
+	retired, err := NewKeyring(newKey, "")
+	assert.NoError(t, err)
+
+	// unrelated keyring
+	other, err := NewKeyring(otherKey, "")
+	assert.NoError(t, err)
+
+	oldToken, err := staged.ToJWT(&Token{Claims: claims})
+	assert.NoError(t, err)
+	newToken, err := active.ToJWT(&Token{Claims: claims})
+	assert.NoError(t, err)
+	// token issued before key IDs were introduced
+	legacyToken, err := NewJWTHandlerRS256(oldKey).ToJWT(&Token{Claims: claims})
+	assert.NoError(t, err)
+
+	testCases := map[string]struct {
+		keyring *Keyring
+		token   string
+		err     error
+	}{
+		"old token, staged":        {keyring: staged, token: oldToken},
+		"new token, staged":        {keyring: staged, token: newToken},
+		"old token, active":        {keyring: active, token: oldToken},
+		"new token, active":        {keyring: active, token: newToken},
+		"legacy token, staged":     {keyring: staged, token: legacyToken},
+		"legacy token, active":     {keyring: active, token: legacyToken},
+		"old token, retired":       {keyring: retired, token: oldToken, err: ErrTokenInvalid},
+		"legacy token, retired":    {keyring: retired, token: legacyToken, err: ErrTokenInvalid},
+		"new token, unrelated key": {keyring: other, token: newToken, err: ErrTokenInvalid},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			token, err := tc.keyring.FromJWT(tc.token)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+				assert.Nil(t, token)
+			} else {
+				assert.NoError(t, err)
+				if assert.NotNil(t, token) {
+					assert.Equal(t, claims, token.Claims)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyringRotationAlg(t *testing.T) {
+	oldKey := loadPrivKey("./testdata/private.pem", t)
+	newKey := loadAnyPrivKey("./testdata/private_ec.pem", t)
+
+	claims := Claims{
+		ID:        "someid",
+		Issuer:    "Mender",
+		Subject:   "foo",
+		ExpiresAt: time.Now().Unix() + 3600,
+	}
+
+	// PS256 key retired in favor of an ES256 one
+	old, err := NewKeyring(oldKey, AlgPS256)
+	assert.NoError(t, err)
+	oldToken, err := old.ToJWT(&Token{Claims: claims})
+	assert.NoError(t, err)
+
+	testCases := map[string]struct {
+		ref string
+		err error
+	}{
+		"algorithm set": {
+			ref: "./testdata/private.pem:PS256",
+		},
+		"default algorithm": {
+			ref: "./testdata/private.pem",
+			err: ErrTokenInvalid,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			active, err := NewKeyring(newKey, "")
+			assert.NoError(t, err)
+
+			path, alg := SplitKeyAlg(tc.ref)
+			_, err = active.AddVerificationKey(
+				&loadPrivKey(path, t).PublicKey, alg)
+			assert.NoError(t, err)
+
+			token, err := active.FromJWT(oldToken)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+				if assert.NotNil(t, token) {
+					assert.Equal(t, claims, token.Claims)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitKeyAlg(t *testing.T) {
+	testCases := map[string]struct {
+		ref string
+
+		path string
+		alg  string
+	}{
+		"path": {
+			ref:  "/etc/deviceauth/old.pem",
+			path: "/etc/deviceauth/old.pem",
+		},
+		"path and algorithm": {
+			ref:  "/etc/deviceauth/old.pem:PS256",
+			path: "/etc/deviceauth/old.pem",
+			alg:  AlgPS256,
+		},
+		"path with colon": {
+			ref:  "C:/deviceauth/old.pem",
+			path: "C:/deviceauth/old.pem",
+		},
+		"path with colon and algorithm": {
+			ref:  "C:/deviceauth/old.pem:EdDSA",
+			path: "C:/deviceauth/old.pem",
+			alg:  AlgEdDSA,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			path, alg := SplitKeyAlg(tc.ref)
+			assert.Equal(t, tc.path, path)
+			assert.Equal(t, tc.alg, alg)
+		})
+	}
+}
+
+func TestKeyringFromJWTExpired(t *testing.T) {
+	k, err := NewKeyring(loadAnyPrivKey("./testdata/private_ed25519.pem", t), "")
+	assert.NoError(t, err)
+
+	raw, err := k.ToJWT(&Token{Claims: Claims{
+		ID:        "someid",
+		Issuer:    "Mender",
+		Subject:   "foo",
+		ExpiresAt: time.Now().Unix() - 10,
+	}})
+	assert.NoError(t, err)
+
+	token, err := k.FromJWT(raw)
+	assert.EqualError(t, err, ErrTokenExpired.Error())
+	if assert.NotNil(t, token) {
+		assert.Equal(t, "someid", token.Claims.ID)
+	}
+
+	_, err = k.FromJWT("1234123412341234")
+	assert.EqualError(t, err, "token contains an invalid number of segments")
+}
+
+func TestKeyringJWKS(t *testing.T) {
+	rsaKey := loadPrivKey("./testdata/private.pem", t)
+	ecKey := loadAnyPrivKey("./testdata/private_ec.pem", t)
+	edKey := loadAnyPrivKey("./testdata/private_ed25519.pem", t)
+
+	k, err := NewKeyring(rsaKey, "")
+	assert.NoError(t, err)
+	ecKid, err := k.AddVerificationKey(ecKey.(crypto.Signer).Public(), "")
+	assert.NoError(t, err)
+	edKid, err := k.AddVerificationKey(edKey.(crypto.Signer).Public(), "")
+	assert.NoError(t, err)
+
+	_, err = k.AddVerificationKey(&loadPrivKey("./testdata/private_2.pem", t).PublicKey, AlgES256)
+	assert.EqualError(t, err, "public key type *rsa.PublicKey can't be used with ES256")
+
+	jwks, err := k.JWKS()
+	assert.NoError(t, err)
+	if !assert.Len(t, jwks.Keys, 3) {
+		return
+	}
+
+	rsaJWK := jwks.Keys[0]
+	assert.Equal(t, k.ActiveKeyID(), rsaJWK.KeyID)
+	assert.Equal(t, "RSA", rsaJWK.KeyType)
+	assert.Equal(t, AlgRS256, rsaJWK.Algorithm)
+	assert.Equal(t, JWKUseSignature, rsaJWK.Use)
+	assert.Equal(t, "AQAB", rsaJWK.E)
+	assert.NotEmpty(t, rsaJWK.N)
+
+	ecJWK := jwks.Keys[1]
+	assert.Equal(t, ecKid, ecJWK.KeyID)
+	assert.Equal(t, "EC", ecJWK.KeyType)
+	assert.Equal(t, AlgES256, ecJWK.Algorithm)
+	assert.Equal(t, "P-256", ecJWK.Curve)
+	assert.NotEmpty(t, ecJWK.X)
+	assert.NotEmpty(t, ecJWK.Y)
+
+	edJWK := jwks.Keys[2]
+	assert.Equal(t, edKid, edJWK.KeyID)
+	assert.Equal(t, "OKP", edJWK.KeyType)
+	assert.Equal(t, AlgEdDSA, edJWK.Algorithm)
+	assert.Equal(t, "Ed25519", edJWK.Curve)
+	assert.NotEmpty(t, edJWK.X)
+
+	// the published key ID matches the one stamped into tokens
+	raw, err := k.ToJWT(&Token{Claims: Claims{
+		ID:        "someid",
+		Issuer:    "Mender",
+		Subject:   "foo",
+		ExpiresAt: time.Now().Unix() + 3600,
+	}})
+	assert.NoError(t, err)
+	kid, err := parseKeyID(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, rsaJWK.KeyID, kid)
+}
@@ -44,6 +44,29 @@ func (_m *Handler) FromJWT(_a0 string) (*jwt.Token, error) {
 	return r0, r1
 }
 
+// JWKS provides a mock function with given fields:
+func (_m *Handler) JWKS() (*jwt.JWKS, error) {
+	ret := _m.Called()
+
+	var r0 *jwt.JWKS
+	if rf, ok := ret.Get(0).(func() *jwt.JWKS); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*jwt.JWKS)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ToJWT provides a mock function with given fields: t
 func (_m *Handler) ToJWT(t *jwt.Token) (string, error) {
 	ret := _m.Called(t)
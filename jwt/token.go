@@ -14,6 +14,10 @@
 package jwt
 
 import (
+	"encoding/json"
+	"strings"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
 )
 
@@ -57,3 +61,24 @@ func (t *Token) UnmarshalJWT(raw []byte, uv UnpackVerifyFunc) error {
 	}
 	return nil
 }
+
+// UnverifiedClaims decodes the token claims without verifying the token, e.g.
+// to pick the verification key. The claims must not be trusted.
+func UnverifiedClaims(tokstr string) (*Claims, error) {
+	parts := strings.Split(tokstr, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenInvalid
+	}
+
+	data, err := jwtgo.DecodeSegment(parts[1])
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, ErrTokenInvalid
+	}
+
+	return &claims, nil
+}
@@ -79,3 +79,39 @@ func TestTokenUnmarshal(t *testing.T) {
 	assert.Equal(t, unTok, tok)
 	assert.Error(t, err)
 }
+
+func TestUnverifiedClaims(t *testing.T) {
+	testCases := map[string]struct {
+		token  string
+		claims *Claims
+		err    error
+	}{
+		"ok": {
+			// {"sub":"foo","mender.tenant":"bar"}, signature not verified
+			token: "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9." +
+				"eyJzdWIiOiJmb28iLCJtZW5kZXIudGVuYW50IjoiYmFyIn0.invalid",
+			claims: &Claims{Subject: "foo", Tenant: "bar"},
+		},
+		"error, segments": {
+			token: "foo.bar",
+			err:   ErrTokenInvalid,
+		},
+		"error, encoding": {
+			token: "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.!!!.invalid",
+			err:   ErrTokenInvalid,
+		},
+		"error, json": {
+			// "foo"
+			token: "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.ImZvbyI.invalid",
+			err:   ErrTokenInvalid,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			claims, err := UnverifiedClaims(tc.token)
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.claims, claims)
+		})
+	}
+}
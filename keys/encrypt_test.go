@@ -0,0 +1,97 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package keys
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadEncryptionKey(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		keyPath string
+		err     string
+	}{
+		"ok": {
+			keyPath: "testdata/encryption.key",
+		},
+		"error, no file": {
+			keyPath: "wrong_path",
+			err:     ErrMsgEncKeyReadFailed + ": open wrong_path: no such file or directory",
+		},
+		"error, not base64": {
+			keyPath: "testdata/encryption_broken.key",
+			err:     "encryption key not base64 encoded: illegal base64 data at input byte 3",
+		},
+		"error, wrong size": {
+			keyPath: "testdata/encryption_short.key",
+			err:     "invalid encryption key size: 16 bytes, expected 32",
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			key, err := LoadEncryptionKey(tc.keyPath)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+				assert.Len(t, key, EncryptionKeySize)
+			}
+		})
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	t.Parallel()
+
+	key, err := LoadEncryptionKey("testdata/encryption.key")
+	assert.NoError(t, err)
+
+	data := []byte("secret data")
+
+	ciphertext, err := Encrypt(data, key)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(ciphertext), string(data))
+
+	// random nonce
+	other, err := Encrypt(data, key)
+	assert.NoError(t, err)
+	assert.NotEqual(t, ciphertext, other)
+
+	plaintext, err := Decrypt(ciphertext, key)
+	assert.NoError(t, err)
+	assert.Equal(t, data, plaintext)
+
+	// tampered
+	ciphertext[len(ciphertext)-1] ^= 0xff
+	_, err = Decrypt(ciphertext, key)
+	assert.EqualError(t, err, "failed to decrypt: cipher: message authentication failed")
+
+	_, err = Decrypt(ciphertext[:4], key)
+	assert.Equal(t, ErrCiphertextTooShort, err)
+
+	wrongKey := make([]byte, EncryptionKeySize)
+	_, err = Decrypt(other, wrongKey)
+	assert.EqualError(t, err, "failed to decrypt: cipher: message authentication failed")
+
+	_, err = Encrypt(data, key[:5])
+	assert.EqualError(t, err, "invalid encryption key: crypto/aes: invalid key size 5")
+}
@@ -14,6 +14,7 @@
 package keys
 
 import (
+	"crypto"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
@@ -25,6 +26,13 @@ import (
 const (
 	ErrMsgPrivKeyReadFailed    = "failed to read server private key file"
 	ErrMsgPrivKeyNotPEMEncoded = "server private key not PEM-encoded"
+	ErrMsgPubKeyReadFailed     = "failed to read public key file"
+	ErrMsgPubKeyNotPEMEncoded  = "public key not PEM-encoded"
+
+	PrivKeyBlockTypeRSA   = "RSA PRIVATE KEY"
+	PrivKeyBlockTypeEC    = "EC PRIVATE KEY"
+	PrivKeyBlockTypePKCS8 = "PRIVATE KEY"
+	PubKeyBlockType       = "PUBLIC KEY"
 )
 
 func LoadRSAPrivate(privKeyPath string) (*rsa.PrivateKey, error) {
@@ -39,10 +47,68 @@ func LoadRSAPrivate(privKeyPath string) (*rsa.PrivateKey, error) {
 		return nil, errors.New(ErrMsgPrivKeyNotPEMEncoded)
 	}
 	// check if it is a RSA PRIVATE KEY
-	if got, want := block.Type, "RSA PRIVATE KEY"; got != want {
+	if got, want := block.Type, PrivKeyBlockTypeRSA; got != want {
 		return nil, errors.Errorf(
 			"unknown server private key type; got: %s, want: %s", got, want)
 	}
 	// return parsed key
 	return x509.ParsePKCS1PrivateKey(block.Bytes)
 }
+
+// LoadPrivate loads a private key of any supported type: PKCS#1 RSA
+// ("RSA PRIVATE KEY"), SEC 1 EC ("EC PRIVATE KEY") or PKCS#8 encoded RSA,
+// ECDSA and Ed25519 keys ("PRIVATE KEY").
+func LoadPrivate(privKeyPath string) (crypto.PrivateKey, error) {
+	// read key from file
+	pemData, err := ioutil.ReadFile(privKeyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, ErrMsgPrivKeyReadFailed)
+	}
+	// decode pem key
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.New(ErrMsgPrivKeyNotPEMEncoded)
+	}
+
+	switch block.Type {
+	case PrivKeyBlockTypeRSA:
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case PrivKeyBlockTypeEC:
+		return x509.ParseECPrivateKey(block.Bytes)
+	case PrivKeyBlockTypePKCS8:
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, errors.Errorf(
+			"unknown server private key type: %s", block.Type)
+	}
+}
+
+// LoadPublic loads a public key from a PEM encoded PKIX public key
+// ("PUBLIC KEY"), or from any private key supported by LoadPrivate.
+func LoadPublic(keyPath string) (crypto.PublicKey, error) {
+	pemData, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, ErrMsgPubKeyReadFailed)
+	}
+
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.New(ErrMsgPubKeyNotPEMEncoded)
+	}
+
+	if block.Type == PubKeyBlockType {
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+
+	privKey, err := LoadPrivate(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := privKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("unsupported private key type: %T", privKey)
+	}
+
+	return signer.Public(), nil
+}
@@ -67,3 +67,103 @@ func TestLoadRsaPrivateKey(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadPrivateKey(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		privKeyPath string
+		keyType     string
+		err         string
+	}{
+		"ok, rsa": {
+			privKeyPath: "testdata/private.pem",
+			keyType:     "*rsa.PrivateKey",
+		},
+		"ok, ec": {
+			privKeyPath: "testdata/private_ec.pem",
+			keyType:     "*ecdsa.PrivateKey",
+		},
+		"ok, pkcs8 rsa": {
+			privKeyPath: "testdata/private_pkcs8.pem",
+			keyType:     "*rsa.PrivateKey",
+		},
+		"ok, pkcs8 ed25519": {
+			privKeyPath: "testdata/private_ed25519.pem",
+			keyType:     "ed25519.PrivateKey",
+		},
+		"error, wrong path": {
+			privKeyPath: "wrong_path",
+			err:         ErrMsgPrivKeyReadFailed + ": open wrong_path: no such file or directory",
+		},
+		"error, not pem": {
+			privKeyPath: "testdata/private_broken.pem",
+			err:         ErrMsgPrivKeyNotPEMEncoded,
+		},
+		"error, public key": {
+			privKeyPath: "testdata/public.pem",
+			err:         "unknown server private key type: PUBLIC KEY",
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			key, err := LoadPrivate(tc.privKeyPath)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.keyType, fmt.Sprintf("%T", key))
+			}
+		})
+	}
+}
+
+func TestLoadPublicKey(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		keyPath string
+		keyType string
+		err     string
+	}{
+		"ok, public": {
+			keyPath: "testdata/public.pem",
+			keyType: "*rsa.PublicKey",
+		},
+		"ok, from private ec": {
+			keyPath: "testdata/private_ec.pem",
+			keyType: "*ecdsa.PublicKey",
+		},
+		"ok, from private ed25519": {
+			keyPath: "testdata/private_ed25519.pem",
+			keyType: "ed25519.PublicKey",
+		},
+		"error, wrong path": {
+			keyPath: "wrong_path",
+			err:     ErrMsgPubKeyReadFailed + ": open wrong_path: no such file or directory",
+		},
+		"error, not pem": {
+			keyPath: "testdata/private_broken.pem",
+			err:     ErrMsgPubKeyNotPEMEncoded,
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			key, err := LoadPublic(tc.keyPath)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.keyType, fmt.Sprintf("%T", key))
+			}
+		})
+	}
+}
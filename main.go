@@ -25,6 +25,7 @@ import (
 	cinv "github.com/mendersoftware/deviceauth/client/inventory"
 	"github.com/mendersoftware/deviceauth/cmd"
 	dconfig "github.com/mendersoftware/deviceauth/config"
+	"github.com/mendersoftware/deviceauth/model"
 	"github.com/mendersoftware/deviceauth/store/mongo"
 )
 
@@ -110,6 +111,10 @@ func doMain(args []string) {
 					Name:  "decommissioning-cleanup",
 					Usage: "Cleanup devauth database from leftovers after failed decommissioning",
 				},
+				cli.BoolFlag{
+					Name:  "rehash-identity-data",
+					Usage: "Recompute the identity data hashes, after changing the identity normalizers, and report the devices to be merged",
+				},
 				cli.StringFlag{
 					Name:  "tenant",
 					Usage: "Tenant ID (optional).",
@@ -166,6 +171,15 @@ func cmdServer(args *cli.Context) error {
 		db = db.WithAutomigrate().(*mongo.DataStoreMongo)
 	}
 
+	idDataNormalizers, err := model.ParseIdDataNormalizers(
+		config.Config.GetStringSlice(dconfig.SettingIdDataNormalizers))
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("invalid identity normalizers: %v", err),
+			1)
+	}
+	db = db.WithIdDataNormalizers(idDataNormalizers)
+
 	if config.Config.Get(dconfig.SettingTenantAdmAddr) != "" {
 		db = db.WithMultitenant()
 	}
@@ -198,7 +212,8 @@ func cmdMigrate(args *cli.Context) error {
 }
 
 func cmdMaintenance(args *cli.Context) error {
-	err := cmd.Maintenance(args.Bool("decommissioning-cleanup"), args.String("tenant"), args.Bool("dry-run"))
+	err := cmd.Maintenance(args.Bool("decommissioning-cleanup"), args.Bool("rehash-identity-data"),
+		args.String("tenant"), args.Bool("dry-run"))
 	if err != nil {
 		return cli.NewExitError(err, 6)
 	}
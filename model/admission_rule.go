@@ -0,0 +1,201 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceauth/utils"
+)
+
+const (
+	// admission rule actions
+	AdmissionActionAccept  = "accept"
+	AdmissionActionReject  = "reject"
+	AdmissionActionPending = "pending"
+
+	// condition match types
+	AdmissionMatchExact  = "exact"
+	AdmissionMatchPrefix = "prefix"
+	AdmissionMatchRegex  = "regex"
+	AdmissionMatchCIDR   = "cidr"
+
+	AdmissionRuleKeyPriority  = "priority"
+	AdmissionRuleKeyCreatedTs = "created_ts"
+)
+
+var (
+	AdmissionActions = []string{
+		AdmissionActionAccept,
+		AdmissionActionReject,
+		AdmissionActionPending,
+	}
+	AdmissionMatchTypes = []string{
+		AdmissionMatchExact,
+		AdmissionMatchPrefix,
+		AdmissionMatchRegex,
+		AdmissionMatchCIDR,
+	}
+	AdmissionKeyTypes = []string{
+		utils.KeyTypeRSA,
+		utils.KeyTypeECDSA,
+		utils.KeyTypeEd25519,
+	}
+)
+
+// AdmissionRule decides the admission of new devices, instead of leaving
+// them pending. The rules of a tenant are evaluated in the order of their
+// priority (lowest first); the first rule whose conditions and key types all
+// match the auth request applies.
+type AdmissionRule struct {
+	Id         string               `json:"id" bson:"_id"`
+	Name       string               `json:"name" bson:"name"`
+	Priority   int                  `json:"priority" bson:"priority"`
+	Conditions []AdmissionCondition `json:"conditions" bson:"conditions"`
+	// key types the rule applies to; any if empty
+	KeyTypes  []string   `json:"key_types,omitempty" bson:"key_types,omitempty"`
+	Action    string     `json:"action" bson:"action"`
+	CreatedTs *time.Time `json:"created_ts" bson:"created_ts,omitempty"`
+	UpdatedTs *time.Time `json:"updated_ts" bson:"updated_ts,omitempty"`
+}
+
+// AdmissionCondition matches an identity data attribute; attributes with
+// multiple values match if any of the values matches.
+type AdmissionCondition struct {
+	Attribute string `json:"attribute" bson:"attribute"`
+	Match     string `json:"match" bson:"match"`
+	Value     string `json:"value" bson:"value"`
+}
+
+func (r *AdmissionRule) Validate() error {
+	if r.Name == "" {
+		return errors.New("name: non zero value required")
+	}
+
+	if !inSet(r.Action, AdmissionActions) {
+		return errors.Errorf("action: must be one of %v", AdmissionActions)
+	}
+
+	if len(r.Conditions) == 0 && len(r.KeyTypes) == 0 {
+		return errors.New("rule must have conditions or key types")
+	}
+
+	for i := range r.Conditions {
+		if err := r.Conditions[i].Validate(); err != nil {
+			return errors.Wrapf(err, "conditions[%d]", i)
+		}
+	}
+
+	for _, t := range r.KeyTypes {
+		if !inSet(t, AdmissionKeyTypes) {
+			return errors.Errorf("key_types: must be one of %v", AdmissionKeyTypes)
+		}
+	}
+
+	return nil
+}
+
+func (c *AdmissionCondition) Validate() error {
+	if c.Attribute == "" {
+		return errors.New("attribute: non zero value required")
+	}
+
+	switch c.Match {
+	case AdmissionMatchExact, AdmissionMatchPrefix:
+	case AdmissionMatchRegex:
+		if _, err := regexp.Compile(c.Value); err != nil {
+			return errors.Wrap(err, "value: invalid regular expression")
+		}
+	case AdmissionMatchCIDR:
+		if _, _, err := net.ParseCIDR(c.Value); err != nil {
+			return errors.Wrap(err, "value: invalid CIDR")
+		}
+	default:
+		return errors.Errorf("match: must be one of %v", AdmissionMatchTypes)
+	}
+
+	return nil
+}
+
+// Matches checks if the rule applies to the identity data and the key type
+// of an auth request.
+func (r *AdmissionRule) Matches(idData map[string]interface{}, keyType string) bool {
+	if len(r.KeyTypes) > 0 && !inSet(keyType, r.KeyTypes) {
+		return false
+	}
+
+	for i := range r.Conditions {
+		if !r.Conditions[i].Matches(idData) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c *AdmissionCondition) Matches(idData map[string]interface{}) bool {
+	attr, ok := idData[c.Attribute]
+	if !ok {
+		return false
+	}
+
+	values, ok := attr.([]interface{})
+	if !ok {
+		values = []interface{}{attr}
+	}
+
+	for _, v := range values {
+		if c.matchValue(fmt.Sprint(v)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *AdmissionCondition) matchValue(v string) bool {
+	switch c.Match {
+	case AdmissionMatchExact:
+		return v == c.Value
+	case AdmissionMatchPrefix:
+		return strings.HasPrefix(v, c.Value)
+	case AdmissionMatchRegex:
+		re, err := regexp.Compile(c.Value)
+		return err == nil && re.MatchString(v)
+	case AdmissionMatchCIDR:
+		_, ipnet, err := net.ParseCIDR(c.Value)
+		if err != nil {
+			return false
+		}
+		ip := net.ParseIP(v)
+		return ip != nil && ipnet.Contains(ip)
+	default:
+		return false
+	}
+}
+
+func inSet(v string, set []string) bool {
+	for _, s := range set {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"net"
+	"net/url"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceauth/utils"
+)
+
+const (
+	minAdmissionWebhookSecretLength = 16
+)
+
+// AdmissionWebhook is the (tenant's) external service deciding the admission
+// of new devices not decided by the admission rules.
+type AdmissionWebhook struct {
+	URL string `json:"url" bson:"url"`
+	// shared secret the responses are signed with; never returned
+	Secret string `json:"secret,omitempty" bson:"secret"`
+}
+
+func (w AdmissionWebhook) Validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return errors.Wrap(err, "url: invalid URL")
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return errors.New("url: must be an absolute https URL")
+	}
+	// host names are checked when resolved, see the admission client
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !utils.IsPublicIP(ip) {
+		return errors.New("url: must not point to a loopback, link-local or private address")
+	}
+
+	if len(w.Secret) < minAdmissionWebhookSecretLength {
+		return errors.Errorf("secret: must be at least %d characters long",
+			minAdmissionWebhookSecretLength)
+	}
+
+	return nil
+}
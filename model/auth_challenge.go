@@ -0,0 +1,47 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	AuthChallengeKeyExpiresAt = "expires_at"
+
+	authChallengeNonceLen = 32
+)
+
+// AuthChallenge is a single use server nonce, to be included in the signed
+// auth request as proof of the device key possession and liveness.
+type AuthChallenge struct {
+	Nonce     string    `json:"nonce" bson:"_id"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+}
+
+func NewAuthChallenge(ttl time.Duration) (*AuthChallenge, error) {
+	buf := make([]byte, authChallengeNonceLen)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, errors.Wrap(err, "failed to generate nonce")
+	}
+
+	return &AuthChallenge{
+		Nonce:     base64.RawURLEncoding.EncodeToString(buf),
+		ExpiresAt: time.Now().Add(ttl).UTC(),
+	}, nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"encoding/hex"
+	"time"
+)
+
+const (
+	// failed auth requests of a device identity
+	AuthLockoutTypeIdentity = "identity"
+	// failed auth requests from a source IP
+	AuthLockoutTypeIP = "ip"
+
+	AuthLockoutKeyType          = "type"
+	AuthLockoutKeyIdData        = "id_data"
+	AuthLockoutKeyFailures      = "failures"
+	AuthLockoutKeyLastFailureTs = "last_failure_ts"
+	AuthLockoutKeyLockedUntil   = "locked_until"
+	AuthLockoutKeyExpiresAt     = "expires_at"
+)
+
+// AuthLockout tracks the failed signature verifications of auth requests of
+// a device identity, or from a source IP; auth requests are rejected while
+// locked out.
+type AuthLockout struct {
+	// type and value, e.g. 'ip:192.0.2.1'
+	Id   string `json:"id" bson:"_id"`
+	Type string `json:"type" bson:"type"`
+	// hex encoded identity data SHA256 hash, or the IP address
+	Value string `json:"value" bson:"value"`
+	// identity data of the last failed request, for identity lockouts
+	IdData string `json:"id_data,omitempty" bson:"id_data,omitempty"`
+	// number of failures since the counting (re)started
+	Failures      int        `json:"failures" bson:"failures"`
+	LastFailureTs time.Time  `json:"last_failure_ts" bson:"last_failure_ts"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty" bson:"locked_until,omitempty"`
+	// the record is dropped afterwards
+	ExpiresAt time.Time `json:"-" bson:"expires_at"`
+}
+
+func NewIdentityAuthLockout(idDataSha256 []byte, idData string) *AuthLockout {
+	value := hex.EncodeToString(idDataSha256)
+	return &AuthLockout{
+		Id:     AuthLockoutTypeIdentity + ":" + value,
+		Type:   AuthLockoutTypeIdentity,
+		Value:  value,
+		IdData: idData,
+	}
+}
+
+func NewIPAuthLockout(ip string) *AuthLockout {
+	return &AuthLockout{
+		Id:    AuthLockoutTypeIP + ":" + ip,
+		Type:  AuthLockoutTypeIP,
+		Value: ip,
+	}
+}
+
+// IsLocked checks if auth requests are rejected at the given time.
+func (l *AuthLockout) IsLocked(now time.Time) bool {
+	return l.LockedUntil != nil && now.Before(*l.LockedUntil)
+}
+
+type AuthLockoutFilter struct {
+	Ids  []string
+	Type string
+	// only lockouts in effect at the given time
+	LockedAt *time.Time
+}
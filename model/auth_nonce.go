@@ -0,0 +1,38 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"encoding/hex"
+	"time"
+)
+
+const (
+	AuthNonceKeyExpiresAt = "expires_at"
+)
+
+// AuthNonce is a nonce seen in an auth request, remembered until the
+// request timestamp leaves the allowed clock skew window.
+type AuthNonce struct {
+	// identity data hash and the nonce
+	Id        string    `bson:"_id"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+func NewAuthNonce(idDataSha256 []byte, nonce string, expiresAt time.Time) *AuthNonce {
+	return &AuthNonce{
+		Id:        hex.EncodeToString(idDataSha256) + "/" + nonce,
+		ExpiresAt: expiresAt.UTC(),
+	}
+}
@@ -14,58 +14,176 @@
 package model
 
 import (
-	"crypto/rsa"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
+	"time"
 
 	"github.com/mendersoftware/deviceauth/utils"
 )
 
+const (
+	AuthReqNonceMaxLen = 128
+)
+
 // note: fields with underscores need the 'bson' decorator
 // otherwise the underscore will be removed upon write to mongo
 type AuthReq struct {
 	IdData      string `json:"id_data" bson:"id_data"`
 	TenantToken string `json:"tenant_token" bson:"tenant_token"`
 	PubKey      string `json:"pubkey"`
+	// PEM encoded certificate chain, leaf first, followed by the
+	// intermediate CAs; optional
+	Certificate string `json:"certificate,omitempty" bson:"-"`
+	// replay protection; the request is rejected if issued outside of the
+	// allowed clock skew, or if the nonce was already used by the device
+	Timestamp *time.Time `json:"timestamp,omitempty" bson:"-"`
+	Nonce     string     `json:"nonce,omitempty" bson:"-"`
+	// server nonce obtained beforehand, alternative to timestamp and
+	// nonce (see AuthChallenge)
+	Challenge string `json:"challenge,omitempty" bson:"-"`
+	// space separated list of scopes the token is restricted to; optional
+	Scope string `json:"scope,omitempty" bson:"-"`
+	// 'psk' for devices signing the request with a pre-shared key,
+	// instead of the private key of `PubKey`
+	CredentialType string `json:"credential_type,omitempty" bson:"-"`
+	// one-time code accepting the auth set (see ClaimCode); optional
+	ClaimCode string `json:"claim_code,omitempty" bson:"-"`
 
 	//helpers, not serialized
-	PubKeyStruct *rsa.PublicKey `json:"-" bson:"-"`
+	PubKeyStruct crypto.PublicKey `json:"-" bson:"-"`
+	// parsed certificate chain, either from `Certificate` or the client
+	// certificates presented in the TLS handshake
+	CertChain []*x509.Certificate `json:"-" bson:"-"`
+	// address of the device
+	RemoteIP string `json:"-" bson:"-"`
+	// signature and the signed request body, verified with the
+	// pre-shared key once the auth set is known
+	Signature string `json:"-" bson:"-"`
+	Body      []byte `json:"-" bson:"-"`
+}
+
+// IsPSK checks if the device authenticates with a pre-shared key.
+func (r *AuthReq) IsPSK() bool {
+	return r.CredentialType == CredentialTypePSK
 }
 
 func (r *AuthReq) Validate() error {
+	switch r.CredentialType {
+	case "", CredentialTypePubKey:
+	case CredentialTypePSK:
+		if r.PubKey != "" || r.Certificate != "" || len(r.CertChain) > 0 {
+			return errors.New("pubkey and certificate can't be used with psk credentials")
+		}
+	default:
+		return errors.New("unsupported credential_type")
+	}
+
+	if r.Certificate != "" {
+		chain, err := utils.ParseCertChain(r.Certificate)
+		if err != nil {
+			return err
+		}
+		r.CertChain = chain
+	}
+
+	if len(r.CertChain) > 0 {
+		if err := r.applyCertificate(); err != nil {
+			return err
+		}
+	}
+
 	if r.IdData == "" {
 		return errors.New("id_data must be provided")
 	}
 
-	if r.PubKey == "" {
+	if r.PubKey == "" && !r.IsPSK() {
 		return errors.New("pubkey must be provided")
 	}
 
-	// normalize pubkey by parsing+serializing the key string
-	//in between, save it in a temp field because it will be useful outside of Validate()
-	key, err := utils.ParsePubKey(r.PubKey)
-	if err != nil {
-		return err
+	if (r.Timestamp == nil) != (r.Nonce == "") {
+		return errors.New("timestamp and nonce must be provided together")
 	}
 
-	keyStruct, ok := key.(*rsa.PublicKey)
-	if !ok {
-		return errors.New("cannot decode public key")
+	if len(r.Nonce) > AuthReqNonceMaxLen || len(r.Challenge) > AuthReqNonceMaxLen {
+		return errors.New("nonce too long")
 	}
-	r.PubKeyStruct = keyStruct
 
-	serialized, err := utils.SerializePubKey(keyStruct)
+	if !r.IsPSK() {
+		// normalize pubkey by parsing+serializing the key string
+		//in between, save it in a temp field because it will be useful outside of Validate()
+		key, err := utils.ParsePubKey(r.PubKey)
+		if err != nil {
+			return err
+		}
+
+		serialized, err := utils.SerializePubKey(key)
+		if err != nil {
+			return err
+		}
+
+		r.PubKey = serialized
+		r.PubKeyStruct = key
+	}
+
+	if canonical, err := CanonicalIdData(r.IdData, nil); err != nil {
+		return err
+	} else {
+		r.IdData = canonical
+	}
+
+	// not checking tenant token for now - TODO
+	return nil
+}
+
+// applyCertificate fills in the public key and identity data derived from
+// the leaf certificate; if provided by the device, they must match the
+// certificate
+func (r *AuthReq) applyCertificate() error {
+	leaf := r.CertChain[0]
+
+	pubkey, err := utils.SerializePubKey(leaf.PublicKey)
 	if err != nil {
 		return err
 	}
 
-	r.PubKey = serialized
+	if r.PubKey == "" {
+		r.PubKey = pubkey
+	} else {
+		key, err := utils.ParsePubKey(r.PubKey)
+		if err != nil {
+			return err
+		}
+		serialized, err := utils.SerializePubKey(key)
+		if err != nil {
+			return err
+		}
+		if serialized != pubkey {
+			return errors.New("pubkey doesn't match the certificate")
+		}
+	}
 
-	if sorted, err := utils.JsonSort(r.IdData); err != nil {
+	idData, err := json.Marshal(utils.CertIdentity(leaf))
+	if err != nil {
+		return err
+	}
+	canonical, err := CanonicalIdData(string(idData), nil)
+	if err != nil {
 		return err
+	}
+
+	if r.IdData == "" {
+		r.IdData = canonical
 	} else {
-		r.IdData = sorted
+		provided, err := CanonicalIdData(r.IdData, nil)
+		if err != nil {
+			return err
+		}
+		if provided != canonical {
+			return errors.New("id_data doesn't match the certificate")
+		}
 	}
 
-	// not checking tenant token for now - TODO
 	return nil
 }
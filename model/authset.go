@@ -26,6 +26,13 @@ const (
 	AuthSetKeyDeviceId     = "device_id"
 	AuthSetKeyStatus       = "status"
 	AuthSetKeyIdDataSha256 = "id_data_sha256"
+	AuthSetKeyTimestamp    = "ts"
+
+	// the device signs auth requests with the private key of the auth
+	// set's public key; the default
+	CredentialTypePubKey = "pubkey"
+	// the device signs auth requests with a pre-shared secret (HMAC)
+	CredentialTypePSK = "psk"
 )
 
 type AuthSet struct {
@@ -37,6 +44,15 @@ type AuthSet struct {
 	DeviceId     string                 `json:"-" bson:"device_id,omitempty"`
 	Timestamp    *time.Time             `json:"ts" bson:"ts,omitempty"`
 	Status       string                 `json:"status" bson:"status,omitempty"`
+	// empty for public key auth sets
+	CredentialType string `json:"credential_type,omitempty" bson:"credential_type,omitempty"`
+	// pre-shared secret, encrypted; never exposed
+	PSK []byte `json:"-" bson:"psk,omitempty"`
+}
+
+// IsPSK checks if the device authenticates with a pre-shared key.
+func (a *AuthSet) IsPSK() bool {
+	return a.CredentialType == CredentialTypePSK
 }
 
 type AuthSetUpdate struct {
@@ -57,6 +73,7 @@ type DevAdmAuthSet struct {
 	RequestTime    *time.Time             `json:"request_time" bson:"request_time"`
 	Status         string                 `json:"status" bson:"status"`
 	Attributes     map[string]interface{} `json:"attributes" bson:"attributes"`
+	CredentialType string                 `json:"credential_type,omitempty" bson:"credential_type,omitempty"`
 }
 
 func NewDevAdmAuthSet(a AuthSet) (*DevAdmAuthSet, error) {
@@ -67,6 +84,7 @@ func NewDevAdmAuthSet(a AuthSet) (*DevAdmAuthSet, error) {
 		DeviceId:       a.DeviceId,
 		RequestTime:    a.Timestamp,
 		Status:         a.Status,
+		CredentialType: a.CredentialType,
 	}
 
 	// we don't store decoded attributes, but we will
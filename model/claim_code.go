@@ -0,0 +1,87 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ClaimCodeKeyExpiresAt = "expires_at"
+
+	// 80 bits, 16 base32 characters
+	claimCodeLen = 10
+	// characters per dash separated group of the code
+	claimCodeGroupLen = 4
+)
+
+// ClaimCode is a one-time code, scoped to a tenant, with which a device's
+// auth set is accepted without knowing its key in advance. Only the hash of
+// the code is stored; the code is returned once, when created.
+type ClaimCode struct {
+	// hash of the code, see ClaimCodeId
+	Id        string     `json:"id" bson:"_id"`
+	Code      string     `json:"code,omitempty" bson:"-"`
+	CreatedTs time.Time  `json:"created_ts" bson:"created_ts"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+}
+
+func NewClaimCode(expiresAt *time.Time) (*ClaimCode, error) {
+	buf := make([]byte, claimCodeLen)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, errors.Wrap(err, "failed to generate claim code")
+	}
+
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf)
+
+	groups := make([]string, 0, len(enc)/claimCodeGroupLen)
+	for i := 0; i < len(enc); i += claimCodeGroupLen {
+		groups = append(groups, enc[i:i+claimCodeGroupLen])
+	}
+	code := strings.Join(groups, "-")
+
+	if expiresAt != nil {
+		t := expiresAt.UTC()
+		expiresAt = &t
+	}
+
+	return &ClaimCode{
+		Id:        ClaimCodeId(code),
+		Code:      code,
+		CreatedTs: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// ClaimCodeId hashes the code, ignoring case and the separators, as the codes
+// are typed in by hand.
+func ClaimCodeId(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// Expired checks if the code expired at the given time.
+func (c *ClaimCode) Expired(now time.Time) bool {
+	return c.ExpiresAt != nil && !now.Before(*c.ExpiresAt)
+}
@@ -14,7 +14,6 @@
 package model
 
 import (
-	"crypto/rsa"
 	"encoding/json"
 	"io"
 
@@ -33,7 +32,9 @@ type DevAdmAuthSetReq struct {
 	Attributes DeviceAuthAttributes `json:"-"`
 }
 
-func ParseDevAdmAuthSetReq(source io.Reader) (*DevAdmAuthSetReq, error) {
+// ParseDevAdmAuthSetReq parses and normalizes the request; the attributes
+// are checked against the identity schema, unless nil.
+func ParseDevAdmAuthSetReq(source io.Reader, schema *IdentitySchema) (*DevAdmAuthSetReq, error) {
 	jd := json.NewDecoder(source)
 
 	var req DevAdmAuthSetReq
@@ -52,12 +53,7 @@ func ParseDevAdmAuthSetReq(source io.Reader) (*DevAdmAuthSetReq, error) {
 		return nil, err
 	}
 
-	keyStruct, ok := key.(*rsa.PublicKey)
-	if !ok {
-		return nil, errors.New("cannot decode public key")
-	}
-
-	serialized, err := utils.SerializePubKey(keyStruct)
+	serialized, err := utils.SerializePubKey(key)
 	if err != nil {
 		return nil, err
 	}
@@ -73,12 +69,22 @@ func ParseDevAdmAuthSetReq(source io.Reader) (*DevAdmAuthSetReq, error) {
 		return nil, errors.New("no attributes provided")
 	}
 
+	idData := make(map[string]interface{}, len(req.Attributes))
+	for k, v := range req.Attributes {
+		idData[k] = v
+	}
+
+	if schema != nil {
+		if err := schema.ValidateIdData(idData); err != nil {
+			return nil, err
+		}
+	}
+
 	// validate/normalize id data
-	// enough to re-encode via stdlib to get alphabetical key sort
-	if sorted, err := json.Marshal(req.Attributes); err != nil {
+	if canonical, err := utils.JsonMarshalCanonical(idData); err != nil {
 		return nil, err
 	} else {
-		req.DeviceId = string(sorted)
+		req.DeviceId = string(canonical)
 	}
 
 	return &req, nil
@@ -23,8 +23,9 @@ const (
 	DevStatusPending  = "pending"
 	DevStatusPreauth  = "preauthorized"
 
-	DevKeyIdData = "id_data"
-	DevKeyStatus = "status"
+	DevKeyIdData       = "id_data"
+	DevKeyIdDataSha256 = "id_data_sha256"
+	DevKeyStatus       = "status"
 )
 
 // note: fields with underscores need the 'bson' decorator
@@ -0,0 +1,133 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceauth/utils"
+)
+
+const (
+	// identity attribute normalizers
+	IdDataNormalizerLowercase = "lowercase"
+	IdDataNormalizerTrim      = "trim"
+	// MAC addresses in any of the net.ParseMAC formats are converted to
+	// lowercase, colon separated hex; other values are left as they are
+	IdDataNormalizerMAC = "mac"
+)
+
+var (
+	IdDataNormalizerTypes = []string{
+		IdDataNormalizerLowercase,
+		IdDataNormalizerTrim,
+		IdDataNormalizerMAC,
+	}
+)
+
+// IdDataNormalizers lists the normalizers applied, in order, to the values of
+// the identity attributes, by attribute name. They apply to string values,
+// and to the strings of array values.
+type IdDataNormalizers map[string][]string
+
+// ParseIdDataNormalizers parses the normalizers from specs of the form
+// attribute:normalizer[,normalizer...].
+func ParseIdDataNormalizers(specs []string) (IdDataNormalizers, error) {
+	n := IdDataNormalizers{}
+
+	for _, spec := range specs {
+		i := strings.LastIndexByte(spec, ':')
+		if i <= 0 {
+			return nil, errors.Errorf(
+				"invalid normalizers %s, expected attribute:normalizer[,normalizer...]", spec)
+		}
+
+		attr := spec[:i]
+		for _, normalizer := range strings.Split(spec[i+1:], ",") {
+			n[attr] = append(n[attr], strings.TrimSpace(normalizer))
+		}
+	}
+
+	if err := n.Validate(); err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+func (n IdDataNormalizers) Validate() error {
+	for attr, normalizers := range n {
+		for _, normalizer := range normalizers {
+			if !inSet(normalizer, IdDataNormalizerTypes) {
+				return errors.Errorf("%s: unknown normalizer %s, must be one of %v",
+					attr, normalizer, IdDataNormalizerTypes)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (n IdDataNormalizers) apply(idData map[string]interface{}) {
+	for attr, normalizers := range n {
+		switch value := idData[attr].(type) {
+		case string:
+			idData[attr] = normalize(value, normalizers)
+		case []interface{}:
+			for i := range value {
+				if s, ok := value[i].(string); ok {
+					value[i] = normalize(s, normalizers)
+				}
+			}
+		}
+	}
+}
+
+func normalize(value string, normalizers []string) string {
+	for _, normalizer := range normalizers {
+		switch normalizer {
+		case IdDataNormalizerLowercase:
+			value = strings.ToLower(value)
+		case IdDataNormalizerTrim:
+			value = strings.TrimSpace(value)
+		case IdDataNormalizerMAC:
+			if mac, err := net.ParseMAC(value); err == nil {
+				value = mac.String()
+			}
+		}
+	}
+
+	return value
+}
+
+// CanonicalIdData normalizes the identity data and encodes it in the
+// canonical form of RFC 8785, which is hashed to identify the device.
+func CanonicalIdData(idData string, normalizers IdDataNormalizers) (string, error) {
+	var dec map[string]interface{}
+	if err := utils.JsonDecode(idData, &dec); err != nil {
+		return "", err
+	}
+
+	normalizers.apply(dec)
+
+	enc, err := utils.JsonMarshalCanonical(dec)
+	if err != nil {
+		return "", err
+	}
+
+	return string(enc), nil
+}
@@ -0,0 +1,245 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// identity attribute types
+	IdentityTypeString  = "string"
+	IdentityTypeNumber  = "number"
+	IdentityTypeInteger = "integer"
+	IdentityTypeBoolean = "boolean"
+	// array of strings, the pattern and enum apply to each of the values
+	IdentityTypeArray = "array"
+)
+
+var (
+	IdentityTypes = []string{
+		IdentityTypeString,
+		IdentityTypeNumber,
+		IdentityTypeInteger,
+		IdentityTypeBoolean,
+		IdentityTypeArray,
+	}
+)
+
+// IdentitySchema specifies the identity data of the (tenant's) devices; it
+// follows a subset of JSON Schema, for an object of attributes.
+type IdentitySchema struct {
+	// attributes every device must have
+	Required []string `json:"required" bson:"required"`
+	// attribute specifications
+	Properties map[string]IdentityProperty `json:"properties" bson:"properties"`
+	// whether attributes not in properties are allowed; defaults to true
+	AdditionalProperties *bool      `json:"additionalProperties,omitempty" bson:"additional_properties,omitempty"`
+	UpdatedTs            *time.Time `json:"updated_ts,omitempty" bson:"updated_ts,omitempty"`
+}
+
+type IdentityProperty struct {
+	// one of IdentityType*; any if empty
+	Type string `json:"type,omitempty" bson:"type,omitempty"`
+	// regular expression string values must match
+	Pattern string `json:"pattern,omitempty" bson:"pattern,omitempty"`
+	// allowed string values
+	Enum []string `json:"enum,omitempty" bson:"enum,omitempty"`
+}
+
+// IdentityDataError describes a violation of the identity schema by an
+// attribute.
+type IdentityDataError struct {
+	Attribute string `json:"attribute"`
+	Message   string `json:"message"`
+}
+
+// IdentityDataErrors lists all the violations of the identity schema.
+type IdentityDataErrors []IdentityDataError
+
+func (e IdentityDataErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = e[i].Attribute + ": " + e[i].Message
+	}
+	return "identity data does not match the schema: " + strings.Join(msgs, "; ")
+}
+
+func (s *IdentitySchema) Validate() error {
+	for _, attr := range s.Required {
+		if attr == "" {
+			return errors.New("required: attribute names can't be empty")
+		}
+	}
+
+	for attr, prop := range s.Properties {
+		if attr == "" {
+			return errors.New("properties: attribute names can't be empty")
+		}
+		// stored as document keys
+		if strings.ContainsAny(attr, ".$") {
+			return errors.Errorf("properties: %s: attribute names can't contain '.' or '$'", attr)
+		}
+		if err := prop.validate(); err != nil {
+			return errors.Wrapf(err, "properties: %s", attr)
+		}
+	}
+
+	return nil
+}
+
+func (p *IdentityProperty) validate() error {
+	if p.Type != "" && !inSet(p.Type, IdentityTypes) {
+		return errors.Errorf("type: must be one of %v", IdentityTypes)
+	}
+
+	if p.Pattern != "" || len(p.Enum) > 0 {
+		if p.Type != IdentityTypeString && p.Type != IdentityTypeArray {
+			return errors.New("pattern and enum apply to string and array types only")
+		}
+	}
+
+	if p.Pattern != "" {
+		if _, err := regexp.Compile(p.Pattern); err != nil {
+			return errors.Wrap(err, "pattern: invalid regular expression")
+		}
+	}
+
+	return nil
+}
+
+// ValidateIdData checks the identity data against the schema; returns
+// IdentityDataErrors listing all the violations, in the order of the
+// attribute names.
+func (s *IdentitySchema) ValidateIdData(idData map[string]interface{}) error {
+	var errs IdentityDataErrors
+
+	for _, attr := range s.Required {
+		if _, ok := idData[attr]; !ok {
+			errs = append(errs, IdentityDataError{attr, "required attribute missing"})
+		}
+	}
+
+	for attr, value := range idData {
+		prop, ok := s.Properties[attr]
+		if !ok {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				errs = append(errs, IdentityDataError{attr, "attribute not allowed"})
+			}
+			continue
+		}
+
+		if msg := prop.check(value); msg != "" {
+			errs = append(errs, IdentityDataError{attr, msg})
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Attribute < errs[j].Attribute
+	})
+
+	return errs
+}
+
+// check returns the description of the violation, if any.
+func (p *IdentityProperty) check(value interface{}) string {
+	switch p.Type {
+	case "":
+		return ""
+	case IdentityTypeString:
+		s, ok := value.(string)
+		if !ok {
+			return "must be a string"
+		}
+		return p.checkString(s)
+	case IdentityTypeNumber:
+		if _, ok := toFloat(value); !ok {
+			return "must be a number"
+		}
+	case IdentityTypeInteger:
+		if f, ok := toFloat(value); !ok || f != math.Trunc(f) {
+			return "must be an integer"
+		}
+	case IdentityTypeBoolean:
+		if _, ok := value.(bool); !ok {
+			return "must be a boolean"
+		}
+	case IdentityTypeArray:
+		values, ok := value.([]interface{})
+		if !ok {
+			return "must be an array"
+		}
+		for i, v := range values {
+			s, ok := v.(string)
+			if !ok {
+				return fmt.Sprintf("[%d]: must be a string", i)
+			}
+			if msg := p.checkString(s); msg != "" {
+				return fmt.Sprintf("[%d]: %s", i, msg)
+			}
+		}
+	}
+
+	return ""
+}
+
+func (p *IdentityProperty) checkString(s string) string {
+	if p.Pattern != "" {
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil || !re.MatchString(s) {
+			return "must match pattern " + p.Pattern
+		}
+	}
+
+	if len(p.Enum) > 0 && !inSet(s, p.Enum) {
+		return fmt.Sprintf("must be one of %v", p.Enum)
+	}
+
+	return ""
+}
+
+// toFloat converts the numbers decoded from JSON or BSON.
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// IdentitySchemaViolation is an existing device whose identity data doesn't
+// match the schema.
+type IdentitySchemaViolation struct {
+	DeviceId string             `json:"device_id"`
+	IdData   string             `json:"id_data"`
+	Errors   IdentityDataErrors `json:"errors"`
+}
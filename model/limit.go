@@ -15,10 +15,23 @@ package model
 
 const (
 	LimitMaxDeviceCount = "max_devices"
+	// if non-zero, auth requests without timestamp and nonce are rejected
+	LimitStrictAuthReqs = "strict_auth_requests"
+	// max auth sets per device; the oldest pending or rejected auth sets
+	// are evicted when a device submits a new one (0 - no limit)
+	LimitMaxAuthSetsPerDevice = "max_auth_sets_per_device"
+	// seconds after which pending and rejected auth sets are purged
+	// (0 - never)
+	LimitAuthSetTTL = "pending_auth_set_ttl"
 )
 
 var (
-	ValidLimits = []string{LimitMaxDeviceCount}
+	ValidLimits = []string{
+		LimitMaxDeviceCount,
+		LimitStrictAuthReqs,
+		LimitMaxAuthSetsPerDevice,
+		LimitAuthSetTTL,
+	}
 )
 
 type Limit struct {
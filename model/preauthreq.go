@@ -14,12 +14,10 @@
 package model
 
 import (
-	"crypto/rsa"
 	"encoding/json"
 	"io"
 
 	"github.com/asaskevich/govalidator"
-	"github.com/pkg/errors"
 
 	"github.com/mendersoftware/deviceauth/utils"
 )
@@ -29,6 +27,8 @@ type PreAuthReq struct {
 	AuthSetId string `json:"auth_set_id" valid:"required" bson:"auth_set_id"`
 	IdData    string `json:"id_data" valid:"required" bson:"id_data"`
 	PubKey    string `json:"pubkey" valid:"required" bson:"pubkey"`
+	// pre-shared secret, instead of the public key
+	PSK []byte `json:"-" bson:"-"`
 }
 
 func ParsePreAuthReq(source io.Reader) (*PreAuthReq, error) {
@@ -52,10 +52,10 @@ func (r *PreAuthReq) Validate() error {
 		return err
 	}
 
-	if sorted, err := utils.JsonSort(r.IdData); err != nil {
+	if canonical, err := CanonicalIdData(r.IdData, nil); err != nil {
 		return err
 	} else {
-		r.IdData = sorted
+		r.IdData = canonical
 	}
 
 	//normalize key
@@ -64,12 +64,7 @@ func (r *PreAuthReq) Validate() error {
 		return err
 	}
 
-	keyStruct, ok := key.(*rsa.PublicKey)
-	if !ok {
-		return errors.New("cannot decode key as RSA public key")
-	}
-
-	serialized, err := utils.SerializePubKey(keyStruct)
+	serialized, err := utils.SerializePubKey(key)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,37 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"time"
+)
+
+const (
+	RateLimitBucketKeyRev       = "rev"
+	RateLimitBucketKeyExpiresAt = "expires_at"
+)
+
+// RateLimitBucket is the state of a rate limiting token bucket, shared by
+// the service instances.
+type RateLimitBucket struct {
+	// group and subject of the limit, e.g. 'devices:ip:192.0.2.1'
+	Key string `bson:"_id"`
+	// tokens left at the time of the last update
+	Tokens    float64   `bson:"tokens"`
+	UpdatedTs time.Time `bson:"updated_ts"`
+	// incremented on every update, for detecting concurrent updates
+	Rev int64 `bson:"rev"`
+	// the bucket is full by then, and is dropped
+	ExpiresAt time.Time `bson:"expires_at"`
+}
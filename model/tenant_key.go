@@ -0,0 +1,30 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"time"
+)
+
+// TenantKey is the tenant's own token signing key, used instead of the
+// server key.
+type TenantKey struct {
+	// key ID, as in the 'kid' token header
+	KeyID string `bson:"kid"`
+	// signing algorithm
+	Alg string `bson:"alg"`
+	// encrypted PKCS#8 encoded private key
+	PrivKey   []byte    `bson:"priv_key"`
+	CreatedTs time.Time `bson:"created_ts"`
+}
@@ -13,11 +13,20 @@
 //    limitations under the License.
 package model
 
+import (
+	"time"
+)
+
 type Token struct {
 	Id        string `json:"id" bson:"_id"`
 	DevId     string `json:"dev_id" bson:"dev_id,omitempty"`
 	AuthSetId string `json:"auth_id" bson:"auth_id,omitempty"`
-	Token     string `json:"token" bson:"token,omitempty"`
+	// the raw token is not exposed via the API
+	Token     string     `json:"-" bson:"token,omitempty"`
+	IssuedAt  *time.Time `json:"issued_at,omitempty" bson:"issued_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	// scopes the token is restricted to, as in the 'scp' claim
+	Scope string `json:"scope,omitempty" bson:"scope,omitempty"`
 }
 
 type TokenFilter struct {
@@ -39,3 +48,29 @@ func (t *Token) WithAuthSet(set *AuthSet) *Token {
 	t.AuthSetId = set.Id
 	return t
 }
+
+// WithValidity sets the issue and expiration time, as in the 'iat' and 'exp'
+// token claims (Unix time)
+func (t *Token) WithValidity(iat, exp int64) *Token {
+	issuedAt := time.Unix(iat, 0).UTC()
+	expiresAt := time.Unix(exp, 0).UTC()
+
+	t.IssuedAt = &issuedAt
+	t.ExpiresAt = &expiresAt
+	return t
+}
+
+// TokenIntrospection is the token introspection response, as in RFC 7662.
+// Only 'active' is set for tokens that are not active.
+type TokenIntrospection struct {
+	Active       bool   `json:"active"`
+	Subject      string `json:"sub,omitempty"`
+	Tenant       string `json:"mender.tenant,omitempty"`
+	ExpiresAt    int64  `json:"exp,omitempty"`
+	IssuedAt     int64  `json:"iat,omitempty"`
+	Audience     string `json:"aud,omitempty"`
+	ID           string `json:"jti,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	AuthSetId    string `json:"mender.auth_id,omitempty"`
+	DeviceStatus string `json:"mender.device_status,omitempty"`
+}
@@ -0,0 +1,60 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"errors"
+	"strings"
+)
+
+// claims set by the service, which can't be overridden by the policy
+var reservedClaims = []string{
+	"aud", "exp", "jti", "iat", "iss", "nbf", "sub", "scp",
+}
+
+// TokenPolicy is the tenant's device token policy, overriding the server
+// configuration; unset fields fall back to the server configuration.
+type TokenPolicy struct {
+	// audience ('aud' claim) of the device tokens; only used if the server
+	// audience is configured
+	Audience string `json:"audience,omitempty" bson:"audience,omitempty"`
+	// token expiration time, in seconds
+	ExpirationTime int64 `json:"expiration_time,omitempty" bson:"expiration_time,omitempty"`
+	// max number of unexpired tokens per device
+	MaxDeviceTokens uint `json:"max_device_tokens,omitempty" bson:"max_device_tokens,omitempty"`
+	// additional claims included in the device tokens
+	Claims map[string]interface{} `json:"claims,omitempty" bson:"claims,omitempty"`
+}
+
+func (p TokenPolicy) Validate() error {
+	if p.ExpirationTime < 0 {
+		return errors.New("expiration time can't be negative")
+	}
+
+	for name := range p.Claims {
+		if name == "" {
+			return errors.New("claim names must be non-empty")
+		}
+		if strings.HasPrefix(name, "mender.") {
+			return errors.New("reserved claim: " + name)
+		}
+		for _, reserved := range reservedClaims {
+			if name == reserved {
+				return errors.New("reserved claim: " + name)
+			}
+		}
+	}
+
+	return nil
+}
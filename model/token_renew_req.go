@@ -0,0 +1,37 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"errors"
+)
+
+// TokenRenewReq is a device request for a new token, in exchange for its
+// current one. The request is signed with the key of the token's auth set.
+type TokenRenewReq struct {
+	Token string `json:"token"`
+
+	//helpers, not serialized
+	// raw request body, covered by the signature
+	Body      []byte `json:"-"`
+	Signature string `json:"-"`
+}
+
+func (r *TokenRenewReq) Validate() error {
+	if r.Token == "" {
+		return errors.New("token must be provided")
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"errors"
+	"strings"
+)
+
+// TokenScopes lists the scopes devices can restrict their tokens to.
+type TokenScopes struct {
+	Scopes []string `json:"scopes" bson:"scopes"`
+}
+
+func (s TokenScopes) Validate() error {
+	seen := map[string]bool{}
+	for _, scope := range s.Scopes {
+		if scope == "" || strings.ContainsAny(scope, " \t\r\n") {
+			return errors.New("scopes must be non-empty and can't contain whitespace")
+		}
+		if seen[scope] {
+			return errors.New("duplicate scope: " + scope)
+		}
+		seen[scope] = true
+	}
+
+	return nil
+}
+
+// Allows checks if the scope is on the list.
+func (s TokenScopes) Allows(scope string) bool {
+	for _, allowed := range s.Scopes {
+		if scope == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"crypto/x509"
+	"time"
+
+	"github.com/mendersoftware/deviceauth/utils"
+)
+
+// TrustedCA is a CA certificate trusted to issue device certificates,
+// scoped to a tenant
+type TrustedCA struct {
+	// SHA256 fingerprint of the certificate
+	Id          string     `json:"id" bson:"_id"`
+	Subject     string     `json:"subject" bson:"subject"`
+	NotAfter    time.Time  `json:"not_after" bson:"not_after"`
+	Certificate string     `json:"certificate" bson:"certificate"`
+	CreatedTs   *time.Time `json:"created_ts" bson:"created_ts,omitempty"`
+}
+
+func NewTrustedCA(cert *x509.Certificate) *TrustedCA {
+	now := time.Now().UTC()
+
+	return &TrustedCA{
+		Id:          utils.CertFingerprint(cert),
+		Subject:     cert.Subject.String(),
+		NotAfter:    cert.NotAfter.UTC(),
+		Certificate: utils.SerializeCert(cert),
+		CreatedTs:   &now,
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit is a token bucket configuration: requests take a token from the
+// bucket, which is refilled at a constant rate, up to its capacity.
+type Limit struct {
+	// tokens added per second; no limit if 0
+	Rate float64
+	// bucket capacity, i.e. the number of requests allowed at once
+	Burst int
+}
+
+// Store keeps the token buckets.
+type Store interface {
+	// Take takes a token from the bucket with the given key. If the
+	// bucket is empty, it returns the time until a token is available.
+	Take(ctx context.Context, key string, limit Limit) (time.Duration, error)
+}
+
+func (l Limit) capacity() float64 {
+	if l.Burst < 1 {
+		return 1
+	}
+	return float64(l.Burst)
+}
+
+// refill returns the tokens in the bucket at `now`, given the tokens at the
+// time of the last update.
+func (l Limit) refill(tokens float64, last, now time.Time) float64 {
+	// the clocks of the instances sharing the buckets may differ
+	if elapsed := now.Sub(last); elapsed > 0 {
+		tokens += elapsed.Seconds() * l.Rate
+	}
+	return math.Min(tokens, l.capacity())
+}
+
+// take takes a token from the bucket holding `tokens`; it returns the tokens
+// left, or the time until a token is available if the bucket is empty.
+func (l Limit) take(tokens float64) (float64, time.Duration) {
+	if tokens < 1 {
+		wait := time.Duration((1 - tokens) / l.Rate * float64(time.Second))
+		return tokens, wait
+	}
+	return tokens - 1, 0
+}
+
+// fillTime is the time an empty bucket takes to fill up.
+func (l Limit) fillTime() time.Duration {
+	return time.Duration(l.capacity() / l.Rate * float64(time.Second))
+}
@@ -0,0 +1,179 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package ratelimit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/rest_utils"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceauth/model"
+	"github.com/mendersoftware/deviceauth/utils"
+)
+
+const (
+	GroupDevices    = "devices"
+	GroupManagement = "management"
+	GroupInternal   = "internal"
+
+	keyTypeIP          = "ip"
+	keyTypeIdentity    = "identity"
+	keyTypeTenantToken = "tenant_token"
+
+	// the only route whose body is inspected
+	uriAuthReqs = "/api/devices/v1/authentication/auth_requests"
+)
+
+var (
+	ErrTooManyRequests = errors.New("too many requests, try again later")
+)
+
+// Group is a set of routes with its own request budget.
+type Group struct {
+	Name string
+	// URL path prefix of the routes
+	Prefix string
+	Limit  Limit
+}
+
+// Groups returns the route groups of the device, management and internal
+// APIs, with the given limits.
+func Groups(devices, management, internal Limit) []Group {
+	return []Group{
+		{Name: GroupDevices, Prefix: "/api/devices/", Limit: devices},
+		{Name: GroupManagement, Prefix: "/api/management/", Limit: management},
+		{Name: GroupInternal, Prefix: "/api/internal/", Limit: internal},
+	}
+}
+
+// Middleware rejects the requests exceeding the budget of their route group
+// with 429 Too Many Requests. Budgets are kept separately for each client IP
+// address, and - for auth requests - for each device identity and tenant
+// token.
+//
+// If the token buckets can't be accessed, the requests are let through.
+type Middleware struct {
+	Store  Store
+	Groups []Group
+	// applied to the identity data, as when authenticating the device
+	IdDataNormalizers model.IdDataNormalizers
+}
+
+func (mw *Middleware) MiddlewareFunc(h rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		group := mw.group(r.URL.Path)
+		if group == nil {
+			h(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		l := log.FromContext(ctx)
+
+		for _, key := range mw.requestKeys(r) {
+			wait, err := mw.Store.Take(ctx, group.Name+":"+key, group.Limit)
+			if err != nil {
+				l.Errorf("failed to apply rate limit %s: %v", key, err)
+				continue
+			}
+
+			if wait > 0 {
+				w.Header().Set("Retry-After", retryAfter(wait))
+				rest_utils.RestErrWithWarningMsg(w, r, l,
+					errors.Wrapf(ErrTooManyRequests, "rate limit %s:%s exceeded",
+						group.Name, key),
+					http.StatusTooManyRequests, ErrTooManyRequests.Error())
+				return
+			}
+		}
+
+		h(w, r)
+	}
+}
+
+func (mw *Middleware) group(path string) *Group {
+	for i, g := range mw.Groups {
+		if strings.HasPrefix(path, g.Prefix) {
+			if g.Limit.Rate <= 0 {
+				return nil
+			}
+			return &mw.Groups[i]
+		}
+	}
+	return nil
+}
+
+// requestKeys returns the keys of the buckets the request takes tokens from:
+// of the client IP address, and of the identity data and the tenant token of
+// auth requests. The identity data is canonicalized, so that all
+// representations of the identity share the bucket.
+func (mw *Middleware) requestKeys(r *rest.Request) []string {
+	keys := []string{keyTypeIP + ":" + utils.RemoteIP(r.Request)}
+
+	if r.Method != http.MethodPost || r.URL.Path != uriAuthReqs ||
+		r.Body == nil {
+		return keys
+	}
+
+	body, err := utils.ReadBodyRaw(r)
+	// the body is read again by the handler
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return keys
+	}
+
+	var authReq struct {
+		IdData      string `json:"id_data"`
+		TenantToken string `json:"tenant_token"`
+	}
+	if err := json.Unmarshal(body, &authReq); err != nil {
+		return keys
+	}
+
+	if authReq.IdData != "" {
+		idData, err := model.CanonicalIdData(authReq.IdData,
+			mw.IdDataNormalizers)
+		if err == nil {
+			keys = append(keys, keyTypeIdentity+":"+sha256Hex(idData))
+		}
+	}
+	if authReq.TenantToken != "" {
+		keys = append(keys, keyTypeTenantToken+":"+sha256Hex(authReq.TenantToken))
+	}
+
+	return keys
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// retryAfter formats the wait time as the Retry-After header value, in
+// (whole) seconds.
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
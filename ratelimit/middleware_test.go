@@ -0,0 +1,174 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deviceauth/model"
+)
+
+// fakeStore records the keys taken from, and reports the configured keys
+// as empty.
+type fakeStore struct {
+	taken []string
+	empty map[string]time.Duration
+	err   error
+}
+
+func (s *fakeStore) Take(ctx context.Context, key string, limit Limit) (time.Duration, error) {
+	s.taken = append(s.taken, key)
+	return s.empty[key], s.err
+}
+
+func TestMiddleware(t *testing.T) {
+	rest.ErrorFieldName = "error"
+
+	const authReq = `{"id_data":"{\"mac\":\"00:01\"}","tenant_token":"tok"}`
+
+	idKey := "devices:identity:" + sha256Hex(`{"mac":"00:01"}`)
+	tokKey := "devices:tenant_token:" + sha256Hex("tok")
+
+	limit := Limit{Rate: 1, Burst: 10}
+
+	testCases := map[string]struct {
+		method string
+		url    string
+		body   string
+
+		empty map[string]time.Duration
+		err   error
+
+		taken      []string
+		code       int
+		retryAfter string
+	}{
+		"ok, auth request": {
+			method: http.MethodPost,
+			url:    "/api/devices/v1/authentication/auth_requests",
+			body:   authReq,
+			taken:  []string{"devices:ip:192.0.2.1", idKey, tokKey},
+			code:   http.StatusOK,
+		},
+		"ok, auth request, identity not canonical": {
+			method: http.MethodPost,
+			url:    "/api/devices/v1/authentication/auth_requests",
+			body:   `{"id_data":"{ \"mac\": \" 00:01 \" }","tenant_token":"tok"}`,
+			taken:  []string{"devices:ip:192.0.2.1", idKey, tokKey},
+			code:   http.StatusOK,
+		},
+		"ok, not the auth requests route": {
+			method: http.MethodPost,
+			url:    "/api/devices/v1/authentication/foo",
+			body:   authReq,
+			taken:  []string{"devices:ip:192.0.2.1"},
+			code:   http.StatusOK,
+		},
+		"ok, management request": {
+			method: http.MethodGet,
+			url:    "/api/management/v2/devauth/devices",
+			taken:  []string{"management:ip:192.0.2.1"},
+			code:   http.StatusOK,
+		},
+		"ok, group not limited": {
+			method: http.MethodGet,
+			url:    "/api/internal/v1/devauth/tokens/verify",
+			code:   http.StatusOK,
+		},
+		"ok, not in a group": {
+			method: http.MethodGet,
+			url:    "/foo",
+			code:   http.StatusOK,
+		},
+		"ok, not an auth request": {
+			method: http.MethodPost,
+			url:    "/api/devices/v1/authentication/auth_requests",
+			body:   "foo",
+			taken:  []string{"devices:ip:192.0.2.1"},
+			code:   http.StatusOK,
+		},
+		"ok, store error": {
+			method: http.MethodPost,
+			url:    "/api/devices/v1/authentication/auth_requests",
+			body:   authReq,
+			err:    errors.New("db error"),
+			taken:  []string{"devices:ip:192.0.2.1", idKey, tokKey},
+			code:   http.StatusOK,
+		},
+		"limited, IP": {
+			method:     http.MethodGet,
+			url:        "/api/management/v2/devauth/devices",
+			empty:      map[string]time.Duration{"management:ip:192.0.2.1": 1500 * time.Millisecond},
+			taken:      []string{"management:ip:192.0.2.1"},
+			code:       http.StatusTooManyRequests,
+			retryAfter: "2",
+		},
+		"limited, identity": {
+			method:     http.MethodPost,
+			url:        "/api/devices/v1/authentication/auth_requests",
+			body:       authReq,
+			empty:      map[string]time.Duration{idKey: 10 * time.Second},
+			taken:      []string{"devices:ip:192.0.2.1", idKey},
+			code:       http.StatusTooManyRequests,
+			retryAfter: "10",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := &fakeStore{empty: tc.empty, err: tc.err}
+
+			api := rest.NewApi()
+			api.Use(&Middleware{
+				Store:  s,
+				Groups: Groups(limit, limit, Limit{}),
+				IdDataNormalizers: model.IdDataNormalizers{
+					"mac": {model.IdDataNormalizerTrim},
+				},
+			})
+			api.SetApp(rest.AppSimple(func(w rest.ResponseWriter, r *rest.Request) {
+				// the body is still available
+				body, err := ioutil.ReadAll(r.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.body, string(body))
+
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := test.MakeSimpleRequest(tc.method,
+				"http://localhost"+tc.url, nil)
+			req.Body = ioutil.NopCloser(strings.NewReader(tc.body))
+			req.RemoteAddr = "192.0.2.1:1234"
+
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+			recorded.CodeIs(tc.code)
+			assert.Equal(t, tc.taken, s.taken)
+			assert.Equal(t, tc.retryAfter,
+				recorded.Recorder.HeaderMap.Get("Retry-After"))
+			if tc.code == http.StatusTooManyRequests {
+				recorded.BodyIs(`{"error":"too many requests, try again later","request_id":""}`)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceauth/model"
+	"github.com/mendersoftware/deviceauth/store"
+)
+
+const (
+	// full buckets are dropped from the memory store this often
+	memoryStoreSweepInterval = time.Minute
+
+	// attempts to update a shared bucket modified concurrently
+	maxBucketUpdateAttempts = 5
+)
+
+var (
+	ErrBucketUpdateConflict = errors.New("too many concurrent rate limit bucket updates")
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// MemoryStore keeps the token buckets in memory, so each instance limits
+// the requests it handles on its own.
+type MemoryStore struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		now:     time.Now,
+		buckets: map[string]*bucket{},
+	}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: limit.capacity(), last: now}
+		s.buckets[key] = b
+	}
+	b.limit = limit
+
+	tokens, wait := limit.take(limit.refill(b.tokens, b.last, now))
+	b.tokens, b.last = tokens, now
+
+	return wait, nil
+}
+
+// sweep drops the buckets that filled up, as they're no different from new
+// ones.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < memoryStoreSweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, b := range s.buckets {
+		if b.limit.refill(b.tokens, b.last, now) >= b.limit.capacity() {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// DataStore keeps the token buckets in the database, so the instances
+// sharing it share the limits.
+type DataStore struct {
+	db  store.DataStore
+	now func() time.Time
+}
+
+func NewDataStore(db store.DataStore) *DataStore {
+	return &DataStore{
+		db:  db,
+		now: time.Now,
+	}
+}
+
+func (s *DataStore) Take(ctx context.Context, key string, limit Limit) (time.Duration, error) {
+	for i := 0; i < maxBucketUpdateAttempts; i++ {
+		now := s.now()
+
+		tokens, last, rev := limit.capacity(), now, int64(0)
+
+		b, err := s.db.GetRateLimitBucket(ctx, key)
+		switch err {
+		case nil:
+			tokens, last, rev = b.Tokens, b.UpdatedTs, b.Rev
+		case store.ErrRateLimitBucketNotFound:
+		default:
+			return 0, errors.Wrap(err, "db get rate limit bucket error")
+		}
+
+		tokens, wait := limit.take(limit.refill(tokens, last, now))
+		if wait > 0 {
+			// nothing taken, no need to update
+			return wait, nil
+		}
+
+		err = s.db.PutRateLimitBucket(ctx, model.RateLimitBucket{
+			Key:       key,
+			Tokens:    tokens,
+			UpdatedTs: now,
+			ExpiresAt: now.Add(limit.fillTime()),
+		}, rev)
+		switch err {
+		case nil:
+			return 0, nil
+		case store.ErrRateLimitBucketConflict:
+			continue
+		default:
+			return 0, errors.Wrap(err, "db put rate limit bucket error")
+		}
+	}
+
+	return 0, ErrBucketUpdateConflict
+}
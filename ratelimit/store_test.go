@@ -0,0 +1,176 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceauth/model"
+	"github.com/mendersoftware/deviceauth/store"
+	mstore "github.com/mendersoftware/deviceauth/store/mocks"
+)
+
+var testNow = time.Unix(1600000000, 0)
+
+func TestMemoryStoreTake(t *testing.T) {
+	s := NewMemoryStore()
+	now := testNow
+	s.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	limit := Limit{Rate: 2, Burst: 3}
+
+	// full bucket
+	for i := 0; i < 3; i++ {
+		wait, err := s.Take(ctx, "foo", limit)
+		assert.NoError(t, err)
+		assert.Equal(t, time.Duration(0), wait)
+	}
+
+	wait, err := s.Take(ctx, "foo", limit)
+	assert.NoError(t, err)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// other buckets are separate
+	wait, err = s.Take(ctx, "bar", limit)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+
+	// refilled
+	now = now.Add(500 * time.Millisecond)
+	wait, err = s.Take(ctx, "foo", limit)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+
+	wait, err = s.Take(ctx, "foo", limit)
+	assert.NoError(t, err)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// full buckets are dropped
+	now = now.Add(time.Hour)
+	_, err = s.Take(ctx, "foo", limit)
+	assert.NoError(t, err)
+	assert.Len(t, s.buckets, 1)
+}
+
+func TestDataStoreTake(t *testing.T) {
+	limit := Limit{Rate: 2, Burst: 3}
+
+	testCases := map[string]struct {
+		bucket *model.RateLimitBucket
+		getErr error
+
+		putRev  int64
+		putErrs []error
+
+		wait time.Duration
+		err  string
+	}{
+		"ok, new bucket": {
+			getErr:  store.ErrRateLimitBucketNotFound,
+			putErrs: []error{nil},
+		},
+		"ok, existing bucket": {
+			bucket: &model.RateLimitBucket{
+				Tokens:    1,
+				UpdatedTs: testNow.Add(-time.Second),
+				Rev:       4,
+			},
+			putRev:  4,
+			putErrs: []error{nil},
+		},
+		"ok, updated concurrently": {
+			bucket: &model.RateLimitBucket{
+				Tokens:    3,
+				UpdatedTs: testNow,
+				Rev:       4,
+			},
+			putRev:  4,
+			putErrs: []error{store.ErrRateLimitBucketConflict, nil},
+		},
+		"empty bucket": {
+			bucket: &model.RateLimitBucket{
+				Tokens:    0.5,
+				UpdatedTs: testNow,
+				Rev:       4,
+			},
+			wait: 250 * time.Millisecond,
+		},
+		"error, too many conflicts": {
+			bucket: &model.RateLimitBucket{
+				Tokens:    3,
+				UpdatedTs: testNow,
+				Rev:       4,
+			},
+			putRev: 4,
+			putErrs: []error{
+				store.ErrRateLimitBucketConflict,
+				store.ErrRateLimitBucketConflict,
+				store.ErrRateLimitBucketConflict,
+				store.ErrRateLimitBucketConflict,
+				store.ErrRateLimitBucketConflict,
+			},
+			err: ErrBucketUpdateConflict.Error(),
+		},
+		"error, get": {
+			getErr: errors.New("db error"),
+			err:    "db get rate limit bucket error: db error",
+		},
+		"error, put": {
+			getErr:  store.ErrRateLimitBucketNotFound,
+			putErrs: []error{errors.New("db error")},
+			err:     "db put rate limit bucket error: db error",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			db := &mstore.DataStore{}
+			db.On("GetRateLimitBucket", ctx, "foo").
+				Return(tc.bucket, tc.getErr)
+			for _, err := range tc.putErrs {
+				db.On("PutRateLimitBucket", ctx,
+					mock.MatchedBy(func(b model.RateLimitBucket) bool {
+						return b.Key == "foo" &&
+							b.Tokens == 2 &&
+							b.UpdatedTs.Equal(testNow) &&
+							b.ExpiresAt.Equal(testNow.Add(1500*time.Millisecond))
+					}),
+					tc.putRev).
+					Return(err).Once()
+			}
+
+			s := NewDataStore(db)
+			s.now = func() time.Time { return testNow }
+
+			wait, err := s.Take(ctx, "foo", limit)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.wait, wait)
+			}
+
+			db.AssertExpectations(t)
+		})
+	}
+}
@@ -14,21 +14,30 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"net/http"
 	"time"
 
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/mendersoftware/go-lib-micro/config"
+	"github.com/mendersoftware/go-lib-micro/identity"
 	"github.com/mendersoftware/go-lib-micro/log"
+	mstore "github.com/mendersoftware/go-lib-micro/store"
 	"github.com/pkg/errors"
 
 	api_http "github.com/mendersoftware/deviceauth/api/http"
+	"github.com/mendersoftware/deviceauth/cache"
+	"github.com/mendersoftware/deviceauth/client/admission"
 	"github.com/mendersoftware/deviceauth/client/orchestrator"
 	"github.com/mendersoftware/deviceauth/client/tenant"
 	dconfig "github.com/mendersoftware/deviceauth/config"
 	"github.com/mendersoftware/deviceauth/devauth"
 	"github.com/mendersoftware/deviceauth/jwt"
 	"github.com/mendersoftware/deviceauth/keys"
+	"github.com/mendersoftware/deviceauth/model"
+	"github.com/mendersoftware/deviceauth/ratelimit"
+	"github.com/mendersoftware/deviceauth/store"
 	"github.com/mendersoftware/deviceauth/store/mongo"
 )
 
@@ -51,9 +60,15 @@ func RunServer(c config.Reader) error {
 
 	l := log.New(log.Ctx{})
 
-	privKey, err := keys.LoadRSAPrivate(c.GetString(dconfig.SettingServerPrivKeyPath))
+	privKey, err := keys.LoadPrivate(c.GetString(dconfig.SettingServerPrivKeyPath))
 	if err != nil {
-		return errors.Wrap(err, "failed to read rsa private key")
+		return errors.Wrap(err, "failed to read private key")
+	}
+
+	idDataNormalizers, err := model.ParseIdDataNormalizers(
+		c.GetStringSlice(dconfig.SettingIdDataNormalizers))
+	if err != nil {
+		return errors.Wrap(err, "invalid identity normalizers")
 	}
 
 	db, err := mongo.NewDataStoreMongo(
@@ -70,7 +85,23 @@ func RunServer(c config.Reader) error {
 		return errors.Wrap(err, "database connection failed")
 	}
 
-	jwtHandler := jwt.NewJWTHandlerRS256(privKey)
+	jwtHandler, err := jwt.NewKeyring(privKey,
+		c.GetString(dconfig.SettingServerPrivKeyAlg))
+	if err != nil {
+		return errors.Wrap(err, "failed to setup JWT handler")
+	}
+	l.Infof("signing tokens with key %s", jwtHandler.ActiveKeyID())
+
+	err = addVerificationKeys(l, jwtHandler,
+		c.GetStringSlice(dconfig.SettingServerVerifyKeyPaths))
+	if err != nil {
+		return err
+	}
+
+	if c.GetInt(dconfig.SettingAuthReqMaxClockSkew) <= 0 {
+		l.Warnf("%s is 0, auth request replays are not checked",
+			dconfig.SettingAuthReqMaxClockSkew)
+	}
 
 	orchClientConf := orchestrator.Config{
 		OrchestratorAddr: c.GetString(dconfig.SettingOrchestratorAddr),
@@ -81,9 +112,19 @@ func RunServer(c config.Reader) error {
 		orchestrator.NewClient(orchClientConf),
 		jwtHandler,
 		devauth.Config{
-			Issuer:                 c.GetString(dconfig.SettingJWTIssuer),
-			ExpirationTime:         int64(c.GetInt(dconfig.SettingJWTExpirationTimeout)),
-			MaxDevicesLimitDefault: uint64(c.GetInt(dconfig.SettingMaxDevicesLimitDefault)),
+			Issuer:                  c.GetString(dconfig.SettingJWTIssuer),
+			Audience:                c.GetString(dconfig.SettingJWTAudience),
+			ExpirationTime:          int64(c.GetInt(dconfig.SettingJWTExpirationTimeout)),
+			MaxDevicesLimitDefault:  uint64(c.GetInt(dconfig.SettingMaxDevicesLimitDefault)),
+			TokenReuseMinLifetime:   int64(c.GetInt(dconfig.SettingJWTReuseMinLifetime)),
+			MaxDeviceTokens:         uint(c.GetInt(dconfig.SettingMaxDeviceTokens)),
+			TokenRenewalGracePeriod: int64(c.GetInt(dconfig.SettingJWTRenewalGracePeriod)),
+			AuthReqMaxClockSkew:     int64(c.GetInt(dconfig.SettingAuthReqMaxClockSkew)),
+			AuthChallengeTTL:        int64(c.GetInt(dconfig.SettingAuthChallengeTTL)),
+			AuthLockoutThreshold:    c.GetInt(dconfig.SettingAuthLockoutThreshold),
+			AuthLockoutWindow:       int64(c.GetInt(dconfig.SettingAuthLockoutWindow)),
+			AuthLockoutDuration:     int64(c.GetInt(dconfig.SettingAuthLockoutDuration)),
+			IdDataNormalizers:       idDataNormalizers,
 		})
 
 	if tadmAddr := c.GetString(dconfig.SettingTenantAdmAddr); tadmAddr != "" {
@@ -96,21 +137,250 @@ func RunServer(c config.Reader) error {
 		devauth = devauth.WithTenantVerification(tc)
 	}
 
-	api, err := SetupAPI(c.GetString(dconfig.SettingMiddleware))
-	if err != nil {
-		return errors.Wrap(err, "API setup failed")
+	if path := c.GetString(dconfig.SettingTenantKeysEncryptionKeyPath); path != "" {
+		encKey, err := keys.LoadEncryptionKey(path)
+		if err != nil {
+			return errors.Wrap(err, "failed to read tenant keys encryption key")
+		}
+
+		l.Infof("signing tokens with tenant keys")
+		devauth = devauth.WithTenantKeys(encKey)
+
+		go backfillTenantKeys(l, devauth, db)
+	}
+
+	if path := c.GetString(dconfig.SettingPSKEncryptionKeyPath); path != "" {
+		encKey, err := keys.LoadEncryptionKey(path)
+		if err != nil {
+			return errors.Wrap(err, "failed to read pre-shared keys encryption key")
+		}
+
+		l.Infof("pre-shared key credentials enabled")
+		devauth = devauth.WithPSKEncryptionKey(encKey)
+	}
+
+	if c.GetBool(dconfig.SettingAdmissionWebhooks) {
+		l.Infof("admission webhooks enabled")
+
+		ac := admission.NewClient(admission.Config{
+			Timeout:      time.Duration(c.GetInt(dconfig.SettingAdmissionWebhookTimeout)) * time.Second,
+			Retries:      c.GetInt(dconfig.SettingAdmissionWebhookRetries),
+			MaxDuration:  time.Duration(c.GetInt(dconfig.SettingAdmissionWebhookMaxDuration)) * time.Second,
+			PrivateHosts: c.GetStringSlice(dconfig.SettingAdmissionWebhookPrivateHosts),
+		})
+		devauth = devauth.WithAdmissionWebhooks(ac)
 	}
 
+	if size := c.GetInt(dconfig.SettingVerifyCacheSize); size > 0 {
+		ttl := time.Duration(c.GetInt(dconfig.SettingVerifyCacheTTL)) * time.Second
+		l.Infof("caching up to %d token verification results for %v", size, ttl)
+
+		vc := cache.NewVerificationCache(size, ttl)
+		devauth = devauth.WithVerificationCache(vc)
+
+		go logVerificationCacheStats(l, vc)
+	}
+
+	if interval := c.GetInt(dconfig.SettingAuthSetPurgeInterval); interval > 0 {
+		go purgeAuthSets(l, devauth, db, time.Duration(interval)*time.Second)
+	}
+
+	stacktype := c.GetString(dconfig.SettingMiddleware)
+	limiter := setupRateLimit(c, db, idDataNormalizers)
+
 	devauthapi := api_http.NewDevAuthApiHandlers(devauth, db)
 
 	apph, err := devauthapi.GetApp()
 	if err != nil {
 		return errors.Wrap(err, "device authentication API handlers setup failed")
 	}
-	api.SetApp(apph)
+
+	handler, err := makeHandler(stacktype, apph, limiter)
+	if err != nil {
+		return err
+	}
+
+	errs := make(chan error, 2)
+
+	if tlsAddr := c.GetString(dconfig.SettingListenTLS); tlsAddr != "" {
+		// only devices are served on the TLS listener
+		devapph, err := devauthapi.GetDevicesApp()
+		if err != nil {
+			return errors.Wrap(err, "devices API handlers setup failed")
+		}
+
+		devhandler, err := makeHandler(stacktype, devapph, limiter)
+		if err != nil {
+			return err
+		}
+
+		srv := &http.Server{
+			Addr:    tlsAddr,
+			Handler: devhandler,
+			TLSConfig: &tls.Config{
+				// the chain is verified against tenant's trusted CAs
+				// when processing the auth request
+				ClientAuth: tls.RequestClientCert,
+			},
+		}
+
+		l.Printf("listening on %s (TLS)", tlsAddr)
+		go func() {
+			errs <- srv.ListenAndServeTLS(
+				c.GetString(dconfig.SettingServerTLSCertPath),
+				c.GetString(dconfig.SettingServerTLSKeyPath))
+		}()
+	}
 
 	addr := c.GetString(dconfig.SettingListen)
 	l.Printf("listening on %s", addr)
+	go func() {
+		errs <- http.ListenAndServe(addr, handler)
+	}()
+
+	return <-errs
+}
 
-	return http.ListenAndServe(addr, api.MakeHandler())
+// makeHandler sets up the API middleware around the app
+func makeHandler(stacktype string, app rest.App, limiter rest.Middleware) (http.Handler, error) {
+	api, err := SetupAPI(stacktype)
+	if err != nil {
+		return nil, errors.Wrap(err, "API setup failed")
+	}
+
+	if limiter != nil {
+		api.Use(limiter)
+	}
+	api.SetApp(app)
+
+	return api.MakeHandler(), nil
+}
+
+// setupRateLimit returns the rate limiting middleware, or nil if no limits
+// are configured
+func setupRateLimit(c config.Reader, db store.DataStore,
+	idDataNormalizers model.IdDataNormalizers) rest.Middleware {
+	l := log.New(log.Ctx{})
+
+	groups := ratelimit.Groups(
+		ratelimit.Limit{
+			Rate:  c.GetFloat64(dconfig.SettingRateLimitDevicesRate),
+			Burst: c.GetInt(dconfig.SettingRateLimitDevicesBurst),
+		},
+		ratelimit.Limit{
+			Rate:  c.GetFloat64(dconfig.SettingRateLimitManagementRate),
+			Burst: c.GetInt(dconfig.SettingRateLimitManagementBurst),
+		},
+		ratelimit.Limit{
+			Rate:  c.GetFloat64(dconfig.SettingRateLimitInternalRate),
+			Burst: c.GetInt(dconfig.SettingRateLimitInternalBurst),
+		})
+
+	enabled := false
+	for _, g := range groups {
+		if g.Limit.Rate > 0 {
+			l.Infof("limiting %s API requests to %v/s, bursts of %d",
+				g.Name, g.Limit.Rate, g.Limit.Burst)
+			enabled = true
+		}
+	}
+	if !enabled {
+		return nil
+	}
+
+	var rs ratelimit.Store = ratelimit.NewMemoryStore()
+	if c.GetBool(dconfig.SettingRateLimitShared) {
+		rs = ratelimit.NewDataStore(db)
+	}
+
+	return &ratelimit.Middleware{
+		Store:             rs,
+		Groups:            groups,
+		IdDataNormalizers: idDataNormalizers,
+	}
+}
+
+// addVerificationKeys loads the verification-only keys into the keyring;
+// keys already in the keyring, like the active key during a rotation, are
+// skipped
+func addVerificationKeys(l *log.Logger, k *jwt.Keyring, refs []string) error {
+	for _, ref := range refs {
+		path, alg := jwt.SplitKeyAlg(ref)
+
+		pubKey, err := keys.LoadPublic(path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read verification key %s", path)
+		}
+
+		kid, err := k.AddVerificationKey(pubKey, alg)
+		if err == jwt.ErrKeyExists {
+			l.Warnf("verification key %s already loaded, skipping", path)
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to add verification key %s", path)
+		}
+		l.Infof("accepting tokens signed with key %s", kid)
+	}
+
+	return nil
+}
+
+// logVerificationCacheStats periodically reports the cache counters
+func logVerificationCacheStats(l *log.Logger, vc *cache.VerificationCache) {
+	for range time.Tick(time.Minute) {
+		stats := vc.Stats()
+		l.Infof("token verification cache: %d hits, %d misses, %d entries",
+			stats.Hits, stats.Misses, stats.Size)
+	}
+}
+
+// backfillTenantKeys generates the signing keys of the tenants provisioned
+// before tenant keys were enabled
+func backfillTenantKeys(l *log.Logger, da *devauth.DevAuth, db store.DataStore) {
+	tdbs, err := db.GetTenantDbs()
+	if err != nil {
+		l.Errorf("failed to retrieve tenant DBs: %v", err)
+		return
+	}
+
+	for _, dbName := range tdbs {
+		tenantId := mstore.TenantFromDbName(dbName, mongo.DbName)
+		ctx := identity.WithContext(context.Background(), &identity.Identity{
+			Tenant: tenantId,
+		})
+
+		generated, err := da.EnsureTenantKey(ctx)
+		if err != nil {
+			l.Errorf("failed to generate the key of tenant %s: %v", tenantId, err)
+		}
+		if generated {
+			l.Infof("generated the key of tenant %s", tenantId)
+		}
+	}
+}
+
+// purgeAuthSets periodically purges the expired auth sets of all the tenants
+func purgeAuthSets(l *log.Logger, da *devauth.DevAuth, db store.DataStore, interval time.Duration) {
+	for range time.Tick(interval) {
+		tdbs, err := db.GetTenantDbs()
+		if err != nil {
+			l.Errorf("failed to retrieve tenant DBs: %v", err)
+			continue
+		}
+
+		for _, dbName := range append(tdbs, mongo.DbName) {
+			ctx := identity.WithContext(context.Background(), &identity.Identity{
+				Tenant: mstore.TenantFromDbName(dbName, mongo.DbName),
+			})
+
+			purged, err := da.PurgeAuthSets(ctx)
+			if err != nil {
+				l.Errorf("failed to purge expired auth sets of %s: %v", dbName, err)
+			}
+			if purged > 0 {
+				l.Infof("purged %d expired auth sets of %s", purged, dbName)
+			}
+		}
+	}
 }
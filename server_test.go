@@ -16,7 +16,11 @@ package main
 import (
 	"testing"
 
+	"github.com/mendersoftware/go-lib-micro/log"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deviceauth/jwt"
+	"github.com/mendersoftware/deviceauth/keys"
 )
 
 func TestSetupApi(t *testing.T) {
@@ -29,3 +33,24 @@ func TestSetupApi(t *testing.T) {
 	assert.NotNil(t, api)
 	assert.Nil(t, err)
 }
+
+func TestAddVerificationKeys(t *testing.T) {
+	privKey, err := keys.LoadPrivate("jwt/testdata/private.pem")
+	assert.NoError(t, err)
+
+	k, err := jwt.NewKeyring(privKey, "")
+	assert.NoError(t, err)
+
+	// the active key is also configured as a verification key
+	err = addVerificationKeys(log.NewEmpty(), k, []string{
+		"jwt/testdata/private.pem",
+		"jwt/testdata/private_2.pem",
+	})
+	assert.NoError(t, err)
+	assert.Len(t, k.KeyIDs(), 2)
+
+	err = addVerificationKeys(log.NewEmpty(), k, []string{
+		"jwt/testdata/missing.pem",
+	})
+	assert.Error(t, err)
+}
@@ -17,6 +17,7 @@ package store
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/mendersoftware/deviceauth/model"
 )
@@ -28,12 +29,32 @@ var (
 	ErrTokenNotFound = errors.New("token not found")
 	// authorization set not found
 	ErrAuthSetNotFound = errors.New("authorization set not found")
+	// auth challenge not found
+	ErrAuthChallengeNotFound = errors.New("auth challenge not found")
 	// limit  set not found
 	ErrLimitNotFound = errors.New("limit not found")
 	// device already exists
 	ErrObjectExists = errors.New("object exists")
 	// device status unknown
 	ErrDevStatusBroken = errors.New("cannot qualify device status")
+	// trusted CA certificate not found
+	ErrTrustedCANotFound = errors.New("trusted CA not found")
+	// tenant signing key not found
+	ErrTenantKeyNotFound = errors.New("tenant key not found")
+	// auth lockout not found
+	ErrAuthLockoutNotFound = errors.New("auth lockout not found")
+	// rate limit bucket not found
+	ErrRateLimitBucketNotFound = errors.New("rate limit bucket not found")
+	// rate limit bucket updated concurrently
+	ErrRateLimitBucketConflict = errors.New("rate limit bucket modified")
+	// claim code not found
+	ErrClaimCodeNotFound = errors.New("claim code not found")
+	// admission rule not found
+	ErrAdmissionRuleNotFound = errors.New("admission rule not found")
+	// admission webhook not set
+	ErrAdmissionWebhookNotFound = errors.New("admission webhook not found")
+	// identity schema not set
+	ErrIdentitySchemaNotFound = errors.New("identity schema not found")
 )
 
 const (
@@ -88,6 +109,10 @@ type DataStore interface {
 	// deletes authentication set for device
 	DeleteAuthSetForDevice(ctx context.Context, devId string, authId string) error
 
+	// lists auth sets in one of the statuses submitted before the given
+	// time, oldest first; no limit if limit is 0
+	GetAuthSetsBefore(ctx context.Context, before time.Time, statuses []string, limit int) ([]model.AuthSet, error)
+
 	// adds JWT to database
 	AddToken(ctx context.Context, t model.Token) error
 
@@ -95,6 +120,10 @@ type DataStore interface {
 	// returns ErrTokenNotFound if token not found
 	GetToken(ctx context.Context, jti string) (*model.Token, error)
 
+	// lists unexpired tokens matching the filter, newest first; no limit
+	// if limit is 0
+	GetTokens(ctx context.Context, skip, limit uint, filter model.TokenFilter) ([]model.Token, error)
+
 	// deletes token
 	DeleteToken(ctx context.Context, jti string) error
 
@@ -119,6 +148,132 @@ type DataStore interface {
 
 	GetAuthSets(ctx context.Context, skip, limit int, filter AuthSetFilter) ([]model.DevAdmAuthSet, error)
 
+	// adds a CA certificate trusted to issue device certificates
+	// returns ErrObjectExists if the certificate is already trusted
+	AddTrustedCA(ctx context.Context, ca model.TrustedCA) error
+
+	// lists (tenant's) trusted CA certificates
+	GetTrustedCAs(ctx context.Context) ([]model.TrustedCA, error)
+
+	// deletes trusted CA certificate by its fingerprint
+	// returns ErrTrustedCANotFound if not found
+	DeleteTrustedCA(ctx context.Context, id string) error
+
+	// records a nonce used in an auth request
+	// returns ErrObjectExists if the nonce was already used
+	AddAuthNonce(ctx context.Context, n model.AuthNonce) error
+
+	// stores an auth challenge; challenges are not scoped to tenants
+	AddAuthChallenge(ctx context.Context, c model.AuthChallenge) error
+
+	// removes and returns the auth challenge
+	// returns ErrAuthChallengeNotFound if not found
+	ConsumeAuthChallenge(ctx context.Context, nonce string) (*model.AuthChallenge, error)
+
+	// sets the scopes devices can restrict their tokens to
+	PutTokenScopes(ctx context.Context, scopes model.TokenScopes) error
+
+	// lists the scopes devices can restrict their tokens to; empty if
+	// not set
+	GetTokenScopes(ctx context.Context) (*model.TokenScopes, error)
+
+	// sets the (tenant's) token policy
+	PutTokenPolicy(ctx context.Context, policy model.TokenPolicy) error
+
+	// fetches the (tenant's) token policy; empty if not set
+	GetTokenPolicy(ctx context.Context) (*model.TokenPolicy, error)
+
+	// stores the (tenant's) token signing key
+	// returns ErrObjectExists if the tenant has a key already
+	AddTenantKey(ctx context.Context, key model.TenantKey) error
+
+	// fetches the (tenant's) token signing key
+	// returns ErrTenantKeyNotFound if not found
+	GetTenantKey(ctx context.Context) (*model.TenantKey, error)
+
+	// counts a failed auth request of the lockout's subject, and returns
+	// the updated lockout; counting restarts if the previous failure was
+	// before `since`
+	AddAuthFailure(ctx context.Context, lockout model.AuthLockout, since time.Time) (*model.AuthLockout, error)
+
+	// locks the subject out until the given time
+	// returns ErrAuthLockoutNotFound if not found
+	SetAuthLockedUntil(ctx context.Context, id string, until time.Time) error
+
+	// lists auth lockouts matching the filter, most recently failing first;
+	// no limit if limit is 0
+	GetAuthLockouts(ctx context.Context, skip, limit uint, filter model.AuthLockoutFilter) ([]model.AuthLockout, error)
+
+	// deletes auth lockout, resetting the failure count
+	// returns ErrAuthLockoutNotFound if not found
+	DeleteAuthLockout(ctx context.Context, id string) error
+
+	// fetches the rate limiting token bucket; buckets are not scoped to
+	// tenants
+	// returns ErrRateLimitBucketNotFound if not found
+	GetRateLimitBucket(ctx context.Context, key string) (*model.RateLimitBucket, error)
+
+	// stores the rate limiting token bucket, if its revision is still
+	// `rev` (0 for new buckets), incrementing it
+	// returns ErrRateLimitBucketConflict if the bucket was modified
+	PutRateLimitBucket(ctx context.Context, bucket model.RateLimitBucket, rev int64) error
+
+	// stores a (tenant's) claim code
+	// returns ErrObjectExists if the code already exists
+	AddClaimCode(ctx context.Context, code model.ClaimCode) error
+
+	// lists (tenant's) unused claim codes
+	GetClaimCodes(ctx context.Context) ([]model.ClaimCode, error)
+
+	// removes and returns the claim code, so it can be used only once
+	// returns ErrClaimCodeNotFound if not found
+	ConsumeClaimCode(ctx context.Context, id string) (*model.ClaimCode, error)
+
+	// deletes the claim code
+	// returns ErrClaimCodeNotFound if not found
+	DeleteClaimCode(ctx context.Context, id string) error
+
+	// stores a (tenant's) admission rule
+	// returns ErrObjectExists if the rule already exists
+	AddAdmissionRule(ctx context.Context, rule model.AdmissionRule) error
+
+	// lists (tenant's) admission rules, in the order of evaluation
+	GetAdmissionRules(ctx context.Context) ([]model.AdmissionRule, error)
+
+	// fetches admission rule by id
+	// returns ErrAdmissionRuleNotFound if not found
+	GetAdmissionRule(ctx context.Context, id string) (*model.AdmissionRule, error)
+
+	// replaces the admission rule, except for its creation time
+	// returns ErrAdmissionRuleNotFound if not found
+	UpdateAdmissionRule(ctx context.Context, rule model.AdmissionRule) error
+
+	// deletes admission rule by id
+	// returns ErrAdmissionRuleNotFound if not found
+	DeleteAdmissionRule(ctx context.Context, id string) error
+
+	// sets the (tenant's) admission webhook
+	PutAdmissionWebhook(ctx context.Context, hook model.AdmissionWebhook) error
+
+	// fetches the (tenant's) admission webhook
+	// returns ErrAdmissionWebhookNotFound if not set
+	GetAdmissionWebhook(ctx context.Context) (*model.AdmissionWebhook, error)
+
+	// removes the (tenant's) admission webhook
+	// returns ErrAdmissionWebhookNotFound if not set
+	DeleteAdmissionWebhook(ctx context.Context) error
+
+	// sets the (tenant's) identity data schema
+	PutIdentitySchema(ctx context.Context, schema model.IdentitySchema) error
+
+	// fetches the (tenant's) identity data schema
+	// returns ErrIdentitySchemaNotFound if not set
+	GetIdentitySchema(ctx context.Context) (*model.IdentitySchema, error)
+
+	// removes the (tenant's) identity data schema
+	// returns ErrIdentitySchemaNotFound if not set
+	DeleteIdentitySchema(ctx context.Context) error
+
 	GetTenantDbs() ([]string, error)
 
 	MigrateTenant(ctx context.Context, version string, tenant string) error
@@ -18,12 +18,78 @@ import context "context"
 import mock "github.com/stretchr/testify/mock"
 import model "github.com/mendersoftware/deviceauth/model"
 import store "github.com/mendersoftware/deviceauth/store"
+import time "time"
 
 // DataStore is an autogenerated mock type for the DataStore type
 type DataStore struct {
 	mock.Mock
 }
 
+// AddAdmissionRule provides a mock function with given fields: ctx, rule
+func (_m *DataStore) AddAdmissionRule(ctx context.Context, rule model.AdmissionRule) error {
+	ret := _m.Called(ctx, rule)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.AdmissionRule) error); ok {
+		r0 = rf(ctx, rule)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// AddAuthChallenge provides a mock function with given fields: ctx, c
+func (_m *DataStore) AddAuthChallenge(ctx context.Context, c model.AuthChallenge) error {
+	ret := _m.Called(ctx, c)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuthChallenge) error); ok {
+		r0 = rf(ctx, c)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// AddAuthFailure provides a mock function with given fields: ctx, lockout, since
+func (_m *DataStore) AddAuthFailure(ctx context.Context, lockout model.AuthLockout, since time.Time) (*model.AuthLockout, error) {
+	ret := _m.Called(ctx, lockout, since)
+
+	var r0 *model.AuthLockout
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuthLockout, time.Time) *model.AuthLockout); ok {
+		r0 = rf(ctx, lockout, since)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.AuthLockout)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.AuthLockout, time.Time) error); ok {
+		r1 = rf(ctx, lockout, since)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AddAuthNonce provides a mock function with given fields: ctx, n
+func (_m *DataStore) AddAuthNonce(ctx context.Context, n model.AuthNonce) error {
+	ret := _m.Called(ctx, n)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuthNonce) error); ok {
+		r0 = rf(ctx, n)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // AddAuthSet provides a mock function with given fields: ctx, set
 func (_m *DataStore) AddAuthSet(ctx context.Context, set model.AuthSet) error {
 	ret := _m.Called(ctx, set)
@@ -38,6 +104,20 @@ func (_m *DataStore) AddAuthSet(ctx context.Context, set model.AuthSet) error {
 	return r0
 }
 
+// AddClaimCode provides a mock function with given fields: ctx, code
+func (_m *DataStore) AddClaimCode(ctx context.Context, code model.ClaimCode) error {
+	ret := _m.Called(ctx, code)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.ClaimCode) error); ok {
+		r0 = rf(ctx, code)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // AddDevice provides a mock function with given fields: ctx, d
 func (_m *DataStore) AddDevice(ctx context.Context, d model.Device) error {
 	ret := _m.Called(ctx, d)
@@ -52,6 +132,20 @@ func (_m *DataStore) AddDevice(ctx context.Context, d model.Device) error {
 	return r0
 }
 
+// AddTenantKey provides a mock function with given fields: ctx, key
+func (_m *DataStore) AddTenantKey(ctx context.Context, key model.TenantKey) error {
+	ret := _m.Called(ctx, key)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.TenantKey) error); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // AddToken provides a mock function with given fields: ctx, t
 func (_m *DataStore) AddToken(ctx context.Context, t model.Token) error {
 	ret := _m.Called(ctx, t)
@@ -66,6 +160,108 @@ func (_m *DataStore) AddToken(ctx context.Context, t model.Token) error {
 	return r0
 }
 
+// AddTrustedCA provides a mock function with given fields: ctx, ca
+func (_m *DataStore) AddTrustedCA(ctx context.Context, ca model.TrustedCA) error {
+	ret := _m.Called(ctx, ca)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.TrustedCA) error); ok {
+		r0 = rf(ctx, ca)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ConsumeAuthChallenge provides a mock function with given fields: ctx, nonce
+func (_m *DataStore) ConsumeAuthChallenge(ctx context.Context, nonce string) (*model.AuthChallenge, error) {
+	ret := _m.Called(ctx, nonce)
+
+	var r0 *model.AuthChallenge
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.AuthChallenge); ok {
+		r0 = rf(ctx, nonce)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.AuthChallenge)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, nonce)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ConsumeClaimCode provides a mock function with given fields: ctx, id
+func (_m *DataStore) ConsumeClaimCode(ctx context.Context, id string) (*model.ClaimCode, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *model.ClaimCode
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.ClaimCode); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.ClaimCode)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteAdmissionRule provides a mock function with given fields: ctx, id
+func (_m *DataStore) DeleteAdmissionRule(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteAdmissionWebhook provides a mock function with given fields: ctx
+func (_m *DataStore) DeleteAdmissionWebhook(ctx context.Context) error {
+	ret := _m.Called(ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteAuthLockout provides a mock function with given fields: ctx, id
+func (_m *DataStore) DeleteAuthLockout(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteAuthSetForDevice provides a mock function with given fields: ctx, devId, authId
 func (_m *DataStore) DeleteAuthSetForDevice(ctx context.Context, devId string, authId string) error {
 	ret := _m.Called(ctx, devId, authId)
@@ -94,6 +290,20 @@ func (_m *DataStore) DeleteAuthSetsForDevice(ctx context.Context, devid string)
 	return r0
 }
 
+// DeleteClaimCode provides a mock function with given fields: ctx, id
+func (_m *DataStore) DeleteClaimCode(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteDevice provides a mock function with given fields: ctx, id
 func (_m *DataStore) DeleteDevice(ctx context.Context, id string) error {
 	ret := _m.Called(ctx, id)
@@ -108,6 +318,20 @@ func (_m *DataStore) DeleteDevice(ctx context.Context, id string) error {
 	return r0
 }
 
+// DeleteIdentitySchema provides a mock function with given fields: ctx
+func (_m *DataStore) DeleteIdentitySchema(ctx context.Context) error {
+	ret := _m.Called(ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteToken provides a mock function with given fields: ctx, jti
 func (_m *DataStore) DeleteToken(ctx context.Context, jti string) error {
 	ret := _m.Called(ctx, jti)
@@ -150,6 +374,112 @@ func (_m *DataStore) DeleteTokens(ctx context.Context) error {
 	return r0
 }
 
+// DeleteTrustedCA provides a mock function with given fields: ctx, id
+func (_m *DataStore) DeleteTrustedCA(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetAdmissionRule provides a mock function with given fields: ctx, id
+func (_m *DataStore) GetAdmissionRule(ctx context.Context, id string) (*model.AdmissionRule, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *model.AdmissionRule
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.AdmissionRule); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.AdmissionRule)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAdmissionRules provides a mock function with given fields: ctx
+func (_m *DataStore) GetAdmissionRules(ctx context.Context) ([]model.AdmissionRule, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.AdmissionRule
+	if rf, ok := ret.Get(0).(func(context.Context) []model.AdmissionRule); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.AdmissionRule)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAdmissionWebhook provides a mock function with given fields: ctx
+func (_m *DataStore) GetAdmissionWebhook(ctx context.Context) (*model.AdmissionWebhook, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *model.AdmissionWebhook
+	if rf, ok := ret.Get(0).(func(context.Context) *model.AdmissionWebhook); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.AdmissionWebhook)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAuthLockouts provides a mock function with given fields: ctx, skip, limit, filter
+func (_m *DataStore) GetAuthLockouts(ctx context.Context, skip uint, limit uint, filter model.AuthLockoutFilter) ([]model.AuthLockout, error) {
+	ret := _m.Called(ctx, skip, limit, filter)
+
+	var r0 []model.AuthLockout
+	if rf, ok := ret.Get(0).(func(context.Context, uint, uint, model.AuthLockoutFilter) []model.AuthLockout); ok {
+		r0 = rf(ctx, skip, limit, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.AuthLockout)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uint, uint, model.AuthLockoutFilter) error); ok {
+		r1 = rf(ctx, skip, limit, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetAuthSetById provides a mock function with given fields: ctx, id
 func (_m *DataStore) GetAuthSetById(ctx context.Context, id string) (*model.AuthSet, error) {
 	ret := _m.Called(ctx, id)
@@ -219,6 +549,29 @@ func (_m *DataStore) GetAuthSets(ctx context.Context, skip int, limit int, filte
 	return r0, r1
 }
 
+// GetAuthSetsBefore provides a mock function with given fields: ctx, before, statuses, limit
+func (_m *DataStore) GetAuthSetsBefore(ctx context.Context, before time.Time, statuses []string, limit int) ([]model.AuthSet, error) {
+	ret := _m.Called(ctx, before, statuses, limit)
+
+	var r0 []model.AuthSet
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, []string, int) []model.AuthSet); ok {
+		r0 = rf(ctx, before, statuses, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.AuthSet)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time, []string, int) error); ok {
+		r1 = rf(ctx, before, statuses, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetAuthSetsForDevice provides a mock function with given fields: ctx, devid
 func (_m *DataStore) GetAuthSetsForDevice(ctx context.Context, devid string) ([]model.AuthSet, error) {
 	ret := _m.Called(ctx, devid)
@@ -242,6 +595,29 @@ func (_m *DataStore) GetAuthSetsForDevice(ctx context.Context, devid string) ([]
 	return r0, r1
 }
 
+// GetClaimCodes provides a mock function with given fields: ctx
+func (_m *DataStore) GetClaimCodes(ctx context.Context) ([]model.ClaimCode, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.ClaimCode
+	if rf, ok := ret.Get(0).(func(context.Context) []model.ClaimCode); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.ClaimCode)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetDevCountByStatus provides a mock function with given fields: ctx, status
 func (_m *DataStore) GetDevCountByStatus(ctx context.Context, status string) (int, error) {
 	ret := _m.Called(ctx, status)
@@ -353,6 +729,29 @@ func (_m *DataStore) GetDevices(ctx context.Context, skip uint, limit uint, filt
 	return r0, r1
 }
 
+// GetIdentitySchema provides a mock function with given fields: ctx
+func (_m *DataStore) GetIdentitySchema(ctx context.Context) (*model.IdentitySchema, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *model.IdentitySchema
+	if rf, ok := ret.Get(0).(func(context.Context) *model.IdentitySchema); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.IdentitySchema)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetLimit provides a mock function with given fields: ctx, name
 func (_m *DataStore) GetLimit(ctx context.Context, name string) (*model.Limit, error) {
 	ret := _m.Called(ctx, name)
@@ -376,6 +775,29 @@ func (_m *DataStore) GetLimit(ctx context.Context, name string) (*model.Limit, e
 	return r0, r1
 }
 
+// GetRateLimitBucket provides a mock function with given fields: ctx, key
+func (_m *DataStore) GetRateLimitBucket(ctx context.Context, key string) (*model.RateLimitBucket, error) {
+	ret := _m.Called(ctx, key)
+
+	var r0 *model.RateLimitBucket
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.RateLimitBucket); ok {
+		r0 = rf(ctx, key)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.RateLimitBucket)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetTenantDbs provides a mock function with given fields:
 func (_m *DataStore) GetTenantDbs() ([]string, error) {
 	ret := _m.Called()
@@ -399,6 +821,29 @@ func (_m *DataStore) GetTenantDbs() ([]string, error) {
 	return r0, r1
 }
 
+// GetTenantKey provides a mock function with given fields: ctx
+func (_m *DataStore) GetTenantKey(ctx context.Context) (*model.TenantKey, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *model.TenantKey
+	if rf, ok := ret.Get(0).(func(context.Context) *model.TenantKey); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.TenantKey)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetToken provides a mock function with given fields: ctx, jti
 func (_m *DataStore) GetToken(ctx context.Context, jti string) (*model.Token, error) {
 	ret := _m.Called(ctx, jti)
@@ -422,6 +867,98 @@ func (_m *DataStore) GetToken(ctx context.Context, jti string) (*model.Token, er
 	return r0, r1
 }
 
+// GetTokenPolicy provides a mock function with given fields: ctx
+func (_m *DataStore) GetTokenPolicy(ctx context.Context) (*model.TokenPolicy, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *model.TokenPolicy
+	if rf, ok := ret.Get(0).(func(context.Context) *model.TokenPolicy); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.TokenPolicy)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTokenScopes provides a mock function with given fields: ctx
+func (_m *DataStore) GetTokenScopes(ctx context.Context) (*model.TokenScopes, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *model.TokenScopes
+	if rf, ok := ret.Get(0).(func(context.Context) *model.TokenScopes); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.TokenScopes)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTokens provides a mock function with given fields: ctx, skip, limit, filter
+func (_m *DataStore) GetTokens(ctx context.Context, skip uint, limit uint, filter model.TokenFilter) ([]model.Token, error) {
+	ret := _m.Called(ctx, skip, limit, filter)
+
+	var r0 []model.Token
+	if rf, ok := ret.Get(0).(func(context.Context, uint, uint, model.TokenFilter) []model.Token); ok {
+		r0 = rf(ctx, skip, limit, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Token)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uint, uint, model.TokenFilter) error); ok {
+		r1 = rf(ctx, skip, limit, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTrustedCAs provides a mock function with given fields: ctx
+func (_m *DataStore) GetTrustedCAs(ctx context.Context) ([]model.TrustedCA, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.TrustedCA
+	if rf, ok := ret.Get(0).(func(context.Context) []model.TrustedCA); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.TrustedCA)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // MigrateTenant provides a mock function with given fields: ctx, version, tenant
 func (_m *DataStore) MigrateTenant(ctx context.Context, version string, tenant string) error {
 	ret := _m.Called(ctx, version, tenant)
@@ -436,6 +973,34 @@ func (_m *DataStore) MigrateTenant(ctx context.Context, version string, tenant s
 	return r0
 }
 
+// PutAdmissionWebhook provides a mock function with given fields: ctx, hook
+func (_m *DataStore) PutAdmissionWebhook(ctx context.Context, hook model.AdmissionWebhook) error {
+	ret := _m.Called(ctx, hook)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.AdmissionWebhook) error); ok {
+		r0 = rf(ctx, hook)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// PutIdentitySchema provides a mock function with given fields: ctx, schema
+func (_m *DataStore) PutIdentitySchema(ctx context.Context, schema model.IdentitySchema) error {
+	ret := _m.Called(ctx, schema)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.IdentitySchema) error); ok {
+		r0 = rf(ctx, schema)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // PutLimit provides a mock function with given fields: ctx, lim
 func (_m *DataStore) PutLimit(ctx context.Context, lim model.Limit) error {
 	ret := _m.Called(ctx, lim)
@@ -450,6 +1015,76 @@ func (_m *DataStore) PutLimit(ctx context.Context, lim model.Limit) error {
 	return r0
 }
 
+// PutRateLimitBucket provides a mock function with given fields: ctx, bucket, rev
+func (_m *DataStore) PutRateLimitBucket(ctx context.Context, bucket model.RateLimitBucket, rev int64) error {
+	ret := _m.Called(ctx, bucket, rev)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.RateLimitBucket, int64) error); ok {
+		r0 = rf(ctx, bucket, rev)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// PutTokenPolicy provides a mock function with given fields: ctx, policy
+func (_m *DataStore) PutTokenPolicy(ctx context.Context, policy model.TokenPolicy) error {
+	ret := _m.Called(ctx, policy)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.TokenPolicy) error); ok {
+		r0 = rf(ctx, policy)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// PutTokenScopes provides a mock function with given fields: ctx, scopes
+func (_m *DataStore) PutTokenScopes(ctx context.Context, scopes model.TokenScopes) error {
+	ret := _m.Called(ctx, scopes)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.TokenScopes) error); ok {
+		r0 = rf(ctx, scopes)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetAuthLockedUntil provides a mock function with given fields: ctx, id, until
+func (_m *DataStore) SetAuthLockedUntil(ctx context.Context, id string, until time.Time) error {
+	ret := _m.Called(ctx, id, until)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) error); ok {
+		r0 = rf(ctx, id, until)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateAdmissionRule provides a mock function with given fields: ctx, rule
+func (_m *DataStore) UpdateAdmissionRule(ctx context.Context, rule model.AdmissionRule) error {
+	ret := _m.Called(ctx, rule)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.AdmissionRule) error); ok {
+		r0 = rf(ctx, rule)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateAuthSet provides a mock function with given fields: ctx, filter, mod
 func (_m *DataStore) UpdateAuthSet(ctx context.Context, filter interface{}, mod model.AuthSetUpdate) error {
 	ret := _m.Called(ctx, filter, mod)
@@ -35,16 +35,41 @@ import (
 )
 
 const (
-	DbVersion     = "1.5.0"
-	DbName        = "deviceauth"
-	DbDevicesColl = "devices"
-	DbAuthSetColl = "auth_sets"
-	DbTokensColl  = "tokens"
-	DbLimitsColl  = "limits"
+	DbVersion       = "1.7.0"
+	DbName          = "deviceauth"
+	DbDevicesColl   = "devices"
+	DbAuthSetColl   = "auth_sets"
+	DbTokensColl    = "tokens"
+	DbLimitsColl    = "limits"
+	DbTrustedCAColl = "trusted_cas"
+	DbAuthNonceColl = "auth_nonces"
+	DbChallengeColl = "auth_challenges"
+	DbScopesColl    = "token_scopes"
+	DbPolicyColl    = "token_policy"
+	DbKeysColl      = "tenant_keys"
+	DbLockoutColl   = "auth_lockouts"
+	DbRateLimitColl = "rate_limits"
+	DbClaimCodeColl = "claim_codes"
+	DbAdmissionColl = "admission_rules"
+	DbWebhookColl   = "admission_webhook"
+	DbSchemaColl    = "identity_schema"
+
+	// ids of the (only) documents of the token scopes, policy, tenant
+	// keys, admission webhook and identity schema collections
+	tokenScopesId      = "token_scopes"
+	tokenPolicyId      = "token_policy"
+	tenantKeyId        = "tenant_key"
+	admissionWebhookId = "admission_webhook"
+	identitySchemaId   = "identity_schema"
 
 	indexDevices_IdentityData                       = "devices:IdentityData"
 	indexAuthSet_DeviceId_IdentityData_PubKey       = "auth_sets:DeviceId:IdData:PubKey"
 	indexAuthSet_DeviceId_IdentityDataSha256_PubKey = "auth_sets:IdDataSha256:PubKey"
+	indexAuthNonce_ExpiresAt                        = "auth_nonces:ExpiresAt"
+	indexChallenge_ExpiresAt                        = "auth_challenges:ExpiresAt"
+	indexAuthLockout_ExpiresAt                      = "auth_lockouts:ExpiresAt"
+	indexRateLimit_ExpiresAt                        = "rate_limits:ExpiresAt"
+	indexClaimCode_ExpiresAt                        = "claim_codes:ExpiresAt"
 )
 
 var (
@@ -73,6 +98,8 @@ type DataStoreMongo struct {
 	session     *mgo.Session
 	automigrate bool
 	multitenant bool
+	// applied when recomputing the identity data hashes
+	idDataNormalizers model.IdDataNormalizers
 }
 
 func NewDataStoreMongoWithSession(session *mgo.Session) *DataStoreMongo {
@@ -295,6 +322,34 @@ func (db *DataStoreMongo) GetToken(ctx context.Context, jti string) (*model.Toke
 	return &res, nil
 }
 
+func (db *DataStoreMongo) GetTokens(ctx context.Context, skip, limit uint, filter model.TokenFilter) ([]model.Token, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbTokensColl)
+
+	// tokens issued before the expiration time was recorded are
+	// always listed
+	query := bson.M{
+		"$and": []interface{}{
+			filter,
+			bson.M{"$or": []bson.M{
+				{"expires_at": bson.M{"$exists": false}},
+				{"expires_at": bson.M{"$gt": time.Now()}},
+			}},
+		},
+	}
+
+	res := []model.Token{}
+
+	err := c.Find(query).Sort("-issued_at", "_id").Skip(int(skip)).Limit(int(limit)).All(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch tokens")
+	}
+
+	return res, nil
+}
+
 func (db *DataStoreMongo) DeleteToken(ctx context.Context, jti string) error {
 	s := db.session.Copy()
 	defer s.Close()
@@ -363,6 +418,10 @@ func (db *DataStoreMongo) Migrate(ctx context.Context, version string) error {
 		l.Infof("automigrate is OFF, will check db version compatibility")
 	}
 
+	if err := db.EnsureGlobalIndexes(ctx); err != nil {
+		return err
+	}
+
 	for _, d := range dbs {
 		// if not in multi tenant, then tenant will be "" and identity
 		// will be the same as default
@@ -421,6 +480,14 @@ func (db *DataStoreMongo) MigrateTenant(ctx context.Context, database, version s
 			ms:  db,
 			ctx: ctx,
 		},
+		&migration_1_6_0{
+			ms:  db,
+			ctx: ctx,
+		},
+		&migration_1_7_0{
+			ms:  db,
+			ctx: ctx,
+		},
 	}
 
 	ver, err := migrate.NewVersion(version)
@@ -598,6 +665,27 @@ func (db *DataStoreMongo) DeleteAuthSetForDevice(ctx context.Context, devId stri
 	return nil
 }
 
+func (db *DataStoreMongo) GetAuthSetsBefore(ctx context.Context, before time.Time, statuses []string, limit int) ([]model.AuthSet, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbAuthSetColl)
+
+	query := bson.M{
+		model.AuthSetKeyStatus:    bson.M{"$in": statuses},
+		model.AuthSetKeyTimestamp: bson.M{"$lt": before},
+	}
+
+	res := []model.AuthSet{}
+
+	err := c.Find(query).Sort(model.AuthSetKeyTimestamp, "_id").Limit(limit).All(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch auth sets")
+	}
+
+	return res, nil
+}
+
 func (db *DataStoreMongo) WithMultitenant() *DataStoreMongo {
 	db.multitenant = true
 	return db
@@ -605,11 +693,17 @@ func (db *DataStoreMongo) WithMultitenant() *DataStoreMongo {
 
 func (db *DataStoreMongo) WithAutomigrate() store.DataStore {
 	return &DataStoreMongo{
-		session:     db.session,
-		automigrate: true,
+		session:           db.session,
+		automigrate:       true,
+		idDataNormalizers: db.idDataNormalizers,
 	}
 }
 
+func (db *DataStoreMongo) WithIdDataNormalizers(n model.IdDataNormalizers) *DataStoreMongo {
+	db.idDataNormalizers = n
+	return db
+}
+
 func (db *DataStoreMongo) EnsureIndexes(ctx context.Context, s *mgo.Session) error {
 
 	// devices collection
@@ -641,6 +735,37 @@ func (db *DataStoreMongo) EnsureIndexes(ctx context.Context, s *mgo.Session) err
 	})
 }
 
+// EnsureGlobalIndexes creates the indexes of the collections shared by the
+// tenants, in the default database
+func (db *DataStoreMongo) EnsureGlobalIndexes(ctx context.Context) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	// challenges are dropped once expired
+	err := s.DB(DbName).C(DbChallengeColl).EnsureIndex(mgo.Index{
+		Key:         []string{model.AuthChallengeKeyExpiresAt},
+		Name:        indexChallenge_ExpiresAt,
+		ExpireAfter: time.Second,
+		Background:  true,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create auth challenge index")
+	}
+
+	// rate limit buckets are dropped once full
+	err = s.DB(DbName).C(DbRateLimitColl).EnsureIndex(mgo.Index{
+		Key:         []string{model.RateLimitBucketKeyExpiresAt},
+		Name:        indexRateLimit_ExpiresAt,
+		ExpireAfter: time.Second,
+		Background:  true,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create rate limit bucket index")
+	}
+
+	return nil
+}
+
 func (db *DataStoreMongo) PutLimit(ctx context.Context, lim model.Limit) error {
 	if lim.Name == "" {
 		return errors.New("empty limit name")
@@ -895,6 +1020,55 @@ func (db *DataStoreMongo) GetAuthSets(ctx context.Context, skip, limit int, filt
 	return resDevAdm, nil
 }
 
+func (db *DataStoreMongo) AddTrustedCA(ctx context.Context, ca model.TrustedCA) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbTrustedCAColl)
+
+	if err := c.Insert(ca); err != nil {
+		if mgo.IsDup(err) {
+			return store.ErrObjectExists
+		}
+		return errors.Wrap(err, "failed to store trusted CA")
+	}
+
+	return nil
+}
+
+func (db *DataStoreMongo) GetTrustedCAs(ctx context.Context) ([]model.TrustedCA, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbTrustedCAColl)
+
+	res := []model.TrustedCA{}
+
+	err := c.Find(nil).Sort("created_ts").All(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch trusted CAs")
+	}
+
+	return res, nil
+}
+
+func (db *DataStoreMongo) DeleteTrustedCA(ctx context.Context, id string) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbTrustedCAColl)
+
+	err := c.RemoveId(id)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return store.ErrTrustedCANotFound
+		}
+		return errors.Wrap(err, "failed to remove trusted CA")
+	}
+
+	return nil
+}
+
 func getDeviceStatus(statuses map[string]int) (string, error) {
 	if statuses[model.DevStatusAccepted] > 1 || statuses[model.DevStatusPreauth] > 1 {
 		return "", store.ErrDevStatusBroken
@@ -922,3 +1096,577 @@ func getDeviceStatus(statuses map[string]int) (string, error) {
 func (db *DataStoreMongo) GetTenantDbs() ([]string, error) {
 	return migrate.GetTenantDbs(db.session, ctxstore.IsTenantDb(DbName))
 }
+
+func (db *DataStoreMongo) AddAuthNonce(ctx context.Context, n model.AuthNonce) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbAuthNonceColl)
+
+	if err := c.Insert(n); err != nil {
+		if mgo.IsDup(err) {
+			return store.ErrObjectExists
+		}
+		return errors.Wrap(err, "failed to store auth nonce")
+	}
+
+	return nil
+}
+
+func (db *DataStoreMongo) AddAuthChallenge(ctx context.Context, ch model.AuthChallenge) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	// the challenge is issued before the tenant is known
+	c := s.DB(DbName).C(DbChallengeColl)
+
+	if err := c.Insert(ch); err != nil {
+		return errors.Wrap(err, "failed to store auth challenge")
+	}
+
+	return nil
+}
+
+func (db *DataStoreMongo) ConsumeAuthChallenge(ctx context.Context, nonce string) (*model.AuthChallenge, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(DbName).C(DbChallengeColl)
+
+	var res model.AuthChallenge
+
+	_, err := c.FindId(nonce).Apply(mgo.Change{Remove: true}, &res)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, store.ErrAuthChallengeNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch auth challenge")
+	}
+
+	return &res, nil
+}
+
+func (db *DataStoreMongo) PutTokenScopes(ctx context.Context, scopes model.TokenScopes) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbScopesColl)
+
+	_, err := c.UpsertId(tokenScopesId, scopes)
+	if err != nil {
+		return errors.Wrap(err, "failed to set token scopes")
+	}
+
+	return nil
+}
+
+func (db *DataStoreMongo) GetTokenScopes(ctx context.Context) (*model.TokenScopes, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbScopesColl)
+
+	res := model.TokenScopes{}
+	err := c.FindId(tokenScopesId).One(&res)
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, errors.Wrap(err, "failed to fetch token scopes")
+	}
+
+	if res.Scopes == nil {
+		res.Scopes = []string{}
+	}
+
+	return &res, nil
+}
+
+func (db *DataStoreMongo) PutTokenPolicy(ctx context.Context, policy model.TokenPolicy) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbPolicyColl)
+
+	_, err := c.UpsertId(tokenPolicyId, policy)
+	if err != nil {
+		return errors.Wrap(err, "failed to set token policy")
+	}
+
+	return nil
+}
+
+func (db *DataStoreMongo) GetTokenPolicy(ctx context.Context) (*model.TokenPolicy, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbPolicyColl)
+
+	res := model.TokenPolicy{}
+	err := c.FindId(tokenPolicyId).One(&res)
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, errors.Wrap(err, "failed to fetch token policy")
+	}
+
+	return &res, nil
+}
+
+func (db *DataStoreMongo) AddTenantKey(ctx context.Context, key model.TenantKey) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbKeysColl)
+
+	doc := struct {
+		Id              string `bson:"_id"`
+		model.TenantKey `bson:",inline"`
+	}{
+		Id:        tenantKeyId,
+		TenantKey: key,
+	}
+
+	if err := c.Insert(doc); err != nil {
+		if mgo.IsDup(err) {
+			return store.ErrObjectExists
+		}
+		return errors.Wrap(err, "failed to store tenant key")
+	}
+
+	return nil
+}
+
+func (db *DataStoreMongo) GetTenantKey(ctx context.Context) (*model.TenantKey, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbKeysColl)
+
+	res := model.TenantKey{}
+	err := c.FindId(tenantKeyId).One(&res)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, store.ErrTenantKeyNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch tenant key")
+	}
+
+	return &res, nil
+}
+
+func (db *DataStoreMongo) AddAuthFailure(ctx context.Context, lockout model.AuthLockout, since time.Time) (*model.AuthLockout, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbLockoutColl)
+
+	// restart counting after a quiet period
+	err := c.Update(
+		bson.M{
+			"_id":                             lockout.Id,
+			model.AuthLockoutKeyLastFailureTs: bson.M{"$lt": since},
+		},
+		bson.M{"$set": bson.M{model.AuthLockoutKeyFailures: 0}})
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, errors.Wrap(err, "failed to reset auth failures")
+	}
+
+	set := bson.M{
+		model.AuthLockoutKeyType:          lockout.Type,
+		"value":                           lockout.Value,
+		model.AuthLockoutKeyLastFailureTs: lockout.LastFailureTs,
+		model.AuthLockoutKeyExpiresAt:     lockout.ExpiresAt,
+	}
+	if lockout.IdData != "" {
+		set[model.AuthLockoutKeyIdData] = lockout.IdData
+	}
+
+	var res model.AuthLockout
+	_, err = c.FindId(lockout.Id).Apply(mgo.Change{
+		Update: bson.M{
+			"$inc": bson.M{model.AuthLockoutKeyFailures: 1},
+			"$set": set,
+		},
+		Upsert:    true,
+		ReturnNew: true,
+	}, &res)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to count auth failure")
+	}
+
+	return &res, nil
+}
+
+func (db *DataStoreMongo) SetAuthLockedUntil(ctx context.Context, id string, until time.Time) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbLockoutColl)
+
+	err := c.UpdateId(id, bson.M{
+		"$set": bson.M{model.AuthLockoutKeyLockedUntil: until},
+	})
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return store.ErrAuthLockoutNotFound
+		}
+		return errors.Wrap(err, "failed to lock out")
+	}
+
+	return nil
+}
+
+func (db *DataStoreMongo) GetAuthLockouts(ctx context.Context, skip, limit uint, filter model.AuthLockoutFilter) ([]model.AuthLockout, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbLockoutColl)
+
+	query := bson.M{}
+	if filter.Ids != nil {
+		query["_id"] = bson.M{"$in": filter.Ids}
+	}
+	if filter.Type != "" {
+		query[model.AuthLockoutKeyType] = filter.Type
+	}
+	if filter.LockedAt != nil {
+		query[model.AuthLockoutKeyLockedUntil] = bson.M{"$gt": *filter.LockedAt}
+	}
+
+	res := []model.AuthLockout{}
+
+	err := c.Find(query).Sort("-"+model.AuthLockoutKeyLastFailureTs, "_id").
+		Skip(int(skip)).Limit(int(limit)).All(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch auth lockouts")
+	}
+
+	return res, nil
+}
+
+func (db *DataStoreMongo) DeleteAuthLockout(ctx context.Context, id string) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbLockoutColl)
+
+	if err := c.RemoveId(id); err != nil {
+		if err == mgo.ErrNotFound {
+			return store.ErrAuthLockoutNotFound
+		}
+		return errors.Wrap(err, "failed to remove auth lockout")
+	}
+
+	return nil
+}
+
+func (db *DataStoreMongo) GetRateLimitBucket(ctx context.Context, key string) (*model.RateLimitBucket, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	// requests are limited before the tenant is known
+	c := s.DB(DbName).C(DbRateLimitColl)
+
+	res := model.RateLimitBucket{}
+	err := c.FindId(key).One(&res)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, store.ErrRateLimitBucketNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch rate limit bucket")
+	}
+
+	return &res, nil
+}
+
+func (db *DataStoreMongo) PutRateLimitBucket(ctx context.Context, bucket model.RateLimitBucket, rev int64) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(DbName).C(DbRateLimitColl)
+
+	bucket.Rev = rev + 1
+
+	var err error
+	if rev == 0 {
+		err = c.Insert(bucket)
+		if mgo.IsDup(err) {
+			return store.ErrRateLimitBucketConflict
+		}
+	} else {
+		err = c.Update(
+			bson.M{
+				"_id":                       bucket.Key,
+				model.RateLimitBucketKeyRev: rev,
+			},
+			bucket)
+		if err == mgo.ErrNotFound {
+			return store.ErrRateLimitBucketConflict
+		}
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to store rate limit bucket")
+	}
+
+	return nil
+}
+
+func (db *DataStoreMongo) AddClaimCode(ctx context.Context, code model.ClaimCode) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbClaimCodeColl)
+
+	if err := c.Insert(code); err != nil {
+		if mgo.IsDup(err) {
+			return store.ErrObjectExists
+		}
+		return errors.Wrap(err, "failed to store claim code")
+	}
+
+	return nil
+}
+
+func (db *DataStoreMongo) GetClaimCodes(ctx context.Context) ([]model.ClaimCode, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbClaimCodeColl)
+
+	res := []model.ClaimCode{}
+
+	err := c.Find(nil).Sort("created_ts").All(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch claim codes")
+	}
+
+	return res, nil
+}
+
+func (db *DataStoreMongo) ConsumeClaimCode(ctx context.Context, id string) (*model.ClaimCode, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbClaimCodeColl)
+
+	var res model.ClaimCode
+
+	_, err := c.FindId(id).Apply(mgo.Change{Remove: true}, &res)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, store.ErrClaimCodeNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch claim code")
+	}
+
+	return &res, nil
+}
+
+func (db *DataStoreMongo) DeleteClaimCode(ctx context.Context, id string) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbClaimCodeColl)
+
+	err := c.RemoveId(id)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return store.ErrClaimCodeNotFound
+		}
+		return errors.Wrap(err, "failed to remove claim code")
+	}
+
+	return nil
+}
+
+func (db *DataStoreMongo) AddAdmissionRule(ctx context.Context, rule model.AdmissionRule) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbAdmissionColl)
+
+	if err := c.Insert(rule); err != nil {
+		if mgo.IsDup(err) {
+			return store.ErrObjectExists
+		}
+		return errors.Wrap(err, "failed to store admission rule")
+	}
+
+	return nil
+}
+
+func (db *DataStoreMongo) GetAdmissionRules(ctx context.Context) ([]model.AdmissionRule, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbAdmissionColl)
+
+	res := []model.AdmissionRule{}
+
+	err := c.Find(nil).
+		Sort(model.AdmissionRuleKeyPriority, model.AdmissionRuleKeyCreatedTs).
+		All(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch admission rules")
+	}
+
+	return res, nil
+}
+
+func (db *DataStoreMongo) GetAdmissionRule(ctx context.Context, id string) (*model.AdmissionRule, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbAdmissionColl)
+
+	var res model.AdmissionRule
+
+	err := c.FindId(id).One(&res)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, store.ErrAdmissionRuleNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch admission rule")
+	}
+
+	return &res, nil
+}
+
+func (db *DataStoreMongo) UpdateAdmissionRule(ctx context.Context, rule model.AdmissionRule) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbAdmissionColl)
+
+	update := bson.M{
+		"name":       rule.Name,
+		"priority":   rule.Priority,
+		"conditions": rule.Conditions,
+		"key_types":  rule.KeyTypes,
+		"action":     rule.Action,
+		"updated_ts": rule.UpdatedTs,
+	}
+
+	err := c.UpdateId(rule.Id, bson.M{"$set": update})
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return store.ErrAdmissionRuleNotFound
+		}
+		return errors.Wrap(err, "failed to update admission rule")
+	}
+
+	return nil
+}
+
+func (db *DataStoreMongo) DeleteAdmissionRule(ctx context.Context, id string) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbAdmissionColl)
+
+	err := c.RemoveId(id)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return store.ErrAdmissionRuleNotFound
+		}
+		return errors.Wrap(err, "failed to remove admission rule")
+	}
+
+	return nil
+}
+
+func (db *DataStoreMongo) PutAdmissionWebhook(ctx context.Context, hook model.AdmissionWebhook) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbWebhookColl)
+
+	_, err := c.UpsertId(admissionWebhookId, hook)
+	if err != nil {
+		return errors.Wrap(err, "failed to set admission webhook")
+	}
+
+	return nil
+}
+
+func (db *DataStoreMongo) GetAdmissionWebhook(ctx context.Context) (*model.AdmissionWebhook, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbWebhookColl)
+
+	var res model.AdmissionWebhook
+
+	err := c.FindId(admissionWebhookId).One(&res)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, store.ErrAdmissionWebhookNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch admission webhook")
+	}
+
+	return &res, nil
+}
+
+func (db *DataStoreMongo) DeleteAdmissionWebhook(ctx context.Context) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbWebhookColl)
+
+	err := c.RemoveId(admissionWebhookId)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return store.ErrAdmissionWebhookNotFound
+		}
+		return errors.Wrap(err, "failed to remove admission webhook")
+	}
+
+	return nil
+}
+
+func (db *DataStoreMongo) PutIdentitySchema(ctx context.Context, schema model.IdentitySchema) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbSchemaColl)
+
+	_, err := c.UpsertId(identitySchemaId, schema)
+	if err != nil {
+		return errors.Wrap(err, "failed to set identity schema")
+	}
+
+	return nil
+}
+
+func (db *DataStoreMongo) GetIdentitySchema(ctx context.Context) (*model.IdentitySchema, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbSchemaColl)
+
+	var res model.IdentitySchema
+
+	err := c.FindId(identitySchemaId).One(&res)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, store.ErrIdentitySchemaNotFound
+		}
+		return nil, errors.Wrap(err, "failed to fetch identity schema")
+	}
+
+	return &res, nil
+}
+
+func (db *DataStoreMongo) DeleteIdentitySchema(ctx context.Context) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctxstore.DbFromContext(ctx, DbName)).C(DbSchemaColl)
+
+	err := c.RemoveId(identitySchemaId)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return store.ErrIdentitySchemaNotFound
+		}
+		return errors.Wrap(err, "failed to remove identity schema")
+	}
+
+	return nil
+}
@@ -0,0 +1,248 @@
+// Copyright 2019 Northern.tech AS
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package mongo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceauth/model"
+	uto "github.com/mendersoftware/deviceauth/utils/to"
+)
+
+// IdDataCollision is a device whose identity data, once normalized, is the
+// identity data of another device; the devices need to be merged.
+type IdDataCollision struct {
+	DeviceId string
+	// device with the same identity data
+	OtherDeviceId string
+	// normalized identity data
+	IdData string
+}
+
+// rehashed is the new identity data of a device or auth set
+type rehashed struct {
+	idData       string
+	idDataStruct map[string]interface{}
+	idDataSha256 []byte
+}
+
+func (db *DataStoreMongo) rehash(idData string) (*rehashed, error) {
+	canonical, err := model.CanonicalIdData(idData, db.idDataNormalizers)
+	if err != nil {
+		return nil, err
+	}
+
+	idDataStruct, err := decode(canonical)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256([]byte(canonical))
+
+	return &rehashed{
+		idData:       canonical,
+		idDataStruct: idDataStruct,
+		idDataSha256: hash[:],
+	}, nil
+}
+
+// rehashBatchSize is the number of documents fetched at once when rehashing
+const rehashBatchSize = 1000
+
+// keptDevice is the device kept for an identity data hash
+type keptDevice struct {
+	id string
+	// whether the device holds the identity data in the canonical form
+	canonical bool
+}
+
+// RehashIdData recomputes the identity data of the devices and auth sets
+// of the database in the canonical form, normalized as configured, and its
+// hash. Devices colliding with another device are not updated, nor are their
+// auth sets; they are returned, to be merged. With dryRun, only the
+// collisions are looked for.
+func (db *DataStoreMongo) RehashIdData(ctx context.Context, dbName string, dryRun bool) ([]IdDataCollision, error) {
+	l := log.FromContext(ctx)
+
+	s := db.session.Copy()
+	defer s.Close()
+
+	collisions := []IdDataCollision{}
+
+	devSelect := bson.M{
+		model.DevKeyIdData:       1,
+		model.DevKeyIdDataSha256: 1,
+	}
+
+	// the device kept for each new identity data hash; the one already
+	// holding the identity data, if any
+	kept := map[string]keptDevice{}
+
+	iter := s.DB(dbName).C(DbDevicesColl).Find(nil).
+		Select(devSelect).
+		Batch(rehashBatchSize).
+		Iter()
+
+	var dev model.Device
+	for iter.Next(&dev) {
+		r, err := db.rehash(dev.IdData)
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"failed to parse id data of device %v: %v", dev.Id, dev.IdData)
+		}
+
+		canonical := r.idData == dev.IdData
+		k, ok := kept[string(r.idDataSha256)]
+		if !ok || (canonical && !k.canonical) {
+			kept[string(r.idDataSha256)] = keptDevice{
+				id:        dev.Id,
+				canonical: canonical,
+			}
+		}
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to fetch devices")
+	}
+
+	collided := map[string]bool{}
+
+	// devices are iterated over in _id order, updated devices are not
+	// iterated over again
+	iter = s.DB(dbName).C(DbDevicesColl).Find(nil).
+		Select(devSelect).
+		Sort("_id").
+		Batch(rehashBatchSize).
+		Iter()
+
+	for iter.Next(&dev) {
+		r, err := db.rehash(dev.IdData)
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"failed to parse id data of device %v: %v", dev.Id, dev.IdData)
+		}
+
+		k, ok := kept[string(r.idDataSha256)]
+		if ok && k.id != dev.Id {
+			collisions = append(collisions, IdDataCollision{
+				DeviceId:      dev.Id,
+				OtherDeviceId: k.id,
+				IdData:        r.idData,
+			})
+			collided[dev.Id] = true
+			continue
+		}
+
+		if dryRun ||
+			(r.idData == dev.IdData && bytes.Equal(r.idDataSha256, dev.IdDataSha256)) {
+			continue
+		}
+
+		update := bson.M{
+			"$set": model.DeviceUpdate{
+				IdData:       r.idData,
+				IdDataStruct: r.idDataStruct,
+				IdDataSha256: r.idDataSha256,
+				UpdatedTs:    uto.TimePtr(time.Now().UTC()),
+			},
+		}
+
+		err = s.DB(dbName).C(DbDevicesColl).UpdateId(dev.Id, update)
+		if mgo.IsDup(err) {
+			// the identity data of a device added since the devices
+			// were scanned
+			var other model.Device
+			err = s.DB(dbName).C(DbDevicesColl).
+				Find(bson.M{model.DevKeyIdDataSha256: r.idDataSha256}).
+				Select(bson.M{"_id": 1}).
+				One(&other)
+			if err != nil {
+				return nil, errors.Wrapf(err,
+					"failed to fetch device colliding with device %v", dev.Id)
+			}
+
+			collisions = append(collisions, IdDataCollision{
+				DeviceId:      dev.Id,
+				OtherDeviceId: other.Id,
+				IdData:        r.idData,
+			})
+			collided[dev.Id] = true
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "failed to update device %v", dev.Id)
+		}
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to fetch devices")
+	}
+
+	iter = s.DB(dbName).C(DbAuthSetColl).Find(nil).
+		Select(bson.M{
+			model.AuthSetKeyDeviceId:     1,
+			model.AuthSetKeyIdData:       1,
+			model.AuthSetKeyIdDataSha256: 1,
+		}).
+		Sort("_id").
+		Batch(rehashBatchSize).
+		Iter()
+
+	var set model.AuthSet
+	for iter.Next(&set) {
+		if collided[set.DeviceId] {
+			continue
+		}
+
+		r, err := db.rehash(set.IdData)
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"failed to parse id data of auth set %v: %v", set.Id, set.IdData)
+		}
+
+		if dryRun ||
+			(r.idData == set.IdData && bytes.Equal(r.idDataSha256, set.IdDataSha256)) {
+			continue
+		}
+
+		update := bson.M{
+			"$set": model.AuthSetUpdate{
+				IdData:       r.idData,
+				IdDataStruct: r.idDataStruct,
+				IdDataSha256: r.idDataSha256,
+			},
+		}
+
+		err = s.DB(dbName).C(DbAuthSetColl).UpdateId(set.Id, update)
+		if mgo.IsDup(err) {
+			// same key and identity data as another auth set of the device
+			l.Warnf("auth set %v of device %v is a duplicate once normalized, not updated",
+				set.Id, set.DeviceId)
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "failed to update auth set %v", set.Id)
+		}
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to fetch auth sets")
+	}
+
+	return collisions, nil
+}
@@ -442,6 +442,90 @@ func TestStoreGetToken(t *testing.T) {
 	}
 }
 
+func TestStoreGetTokens(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestStoreGetTokens in short mode.")
+	}
+
+	d := getDb(context.Background())
+	defer d.session.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+
+	tokens := []*model.Token{
+		model.NewToken("t1", "dev1", "token1").
+			WithValidity(now.Unix()-30, now.Unix()+3600),
+		model.NewToken("t2", "dev1", "token2").
+			WithValidity(now.Unix()-20, now.Unix()+3600),
+		// expired
+		model.NewToken("t3", "dev1", "token3").
+			WithValidity(now.Unix()-7200, now.Unix()-3600),
+		// issued before validity was recorded
+		model.NewToken("t4", "dev1", "token4"),
+		model.NewToken("t5", "dev2", "token5").
+			WithValidity(now.Unix()-10, now.Unix()+3600),
+	}
+	tokens[1].AuthSetId = "aid2"
+
+	for _, tok := range tokens {
+		assert.NoError(t, d.AddToken(ctx, *tok))
+	}
+
+	testCases := map[string]struct {
+		skip   uint
+		limit  uint
+		filter model.TokenFilter
+
+		outIds []string
+	}{
+		"all of device": {
+			filter: model.TokenFilter{DevId: "dev1"},
+			outIds: []string{"t2", "t1", "t4"},
+		},
+		"latest of device": {
+			limit:  1,
+			filter: model.TokenFilter{DevId: "dev1"},
+			outIds: []string{"t2"},
+		},
+		"page": {
+			skip:   1,
+			limit:  1,
+			filter: model.TokenFilter{DevId: "dev1"},
+			outIds: []string{"t1"},
+		},
+		"by auth set": {
+			filter: model.TokenFilter{DevId: "dev1", AuthSetId: "aid2"},
+			outIds: []string{"t2"},
+		},
+		"by id, expired": {
+			filter: model.TokenFilter{Id: "t3"},
+			outIds: []string{},
+		},
+		"other device": {
+			filter: model.TokenFilter{DevId: "dev2"},
+			outIds: []string{"t5"},
+		},
+		"no tokens": {
+			filter: model.TokenFilter{DevId: "dev3"},
+			outIds: []string{},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			out, err := d.GetTokens(ctx, tc.skip, tc.limit, tc.filter)
+			assert.NoError(t, err)
+
+			ids := []string{}
+			for _, tok := range out {
+				ids = append(ids, tok.Id)
+			}
+			assert.Equal(t, tc.outIds, ids)
+		})
+	}
+}
+
 func TestStoreDeleteToken(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping TestDeleteToken in short mode.")
@@ -727,7 +811,7 @@ func TestStoreMigrate(t *testing.T) {
 		DbVersion + " no automigrate": {
 			automigrate: false,
 			version:     DbVersion,
-			err:         "failed to apply migrations: db needs migration: deviceauth has version 0.0.0, needs version 1.5.0",
+			err:         "failed to apply migrations: db needs migration: deviceauth has version 0.0.0, needs version 1.7.0",
 		},
 		DbVersion + " multitenant": {
 			automigrate: true,
@@ -739,7 +823,7 @@ func TestStoreMigrate(t *testing.T) {
 			automigrate: false,
 			tenantDbs:   []string{"deviceauth-tenant1id", "deviceauth-tenant2id"},
 			version:     DbVersion,
-			err:         "failed to apply migrations: db needs migration: deviceauth-tenant1id has version 0.0.0, needs version 1.5.0",
+			err:         "failed to apply migrations: db needs migration: deviceauth-tenant1id has version 0.0.0, needs version 1.7.0",
 		},
 		"0.1 error": {
 			automigrate: true,
@@ -773,6 +857,20 @@ func TestStoreMigrate(t *testing.T) {
 			if tc.err == "" {
 				assert.NoError(t, err)
 
+				// the indexes shared by the tenants are created
+				for coll, name := range map[string]string{
+					DbChallengeColl: indexChallenge_ExpiresAt,
+					DbRateLimitColl: indexRateLimit_ExpiresAt,
+				} {
+					idxs, err := db.session.DB(DbName).C(coll).Indexes()
+					assert.NoError(t, err)
+					names := []string{}
+					for _, idx := range idxs {
+						names = append(names, idx.Name)
+					}
+					assert.Contains(t, names, name)
+				}
+
 				// verify migration entry in all databases (>1 if multitenant)
 				if tc.automigrate {
 					dbs := []string{DbName}
@@ -1832,6 +1930,86 @@ func TestStoreDeleteAuthSetForDevice(t *testing.T) {
 	}
 }
 
+func TestStoreGetAuthSetsBefore(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestStoreGetAuthSetsBefore in short mode.")
+	}
+
+	d := getDb(context.Background())
+	defer d.session.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+
+	aset := func(id, status string, age int) model.AuthSet {
+		return model.AuthSet{
+			Id:        id,
+			DeviceId:  "dev-" + id,
+			IdData:    id,
+			PubKey:    id,
+			Status:    status,
+			Timestamp: uto.TimePtr(now.Add(-time.Duration(age) * time.Hour)),
+		}
+	}
+
+	asets := []model.AuthSet{
+		aset("1", model.DevStatusPending, 3),
+		aset("2", model.DevStatusRejected, 5),
+		aset("3", model.DevStatusAccepted, 4),
+		aset("4", model.DevStatusPending, 1),
+		aset("5", model.DevStatusPreauth, 6),
+		aset("6", model.DevStatusPending, 2),
+	}
+	for _, a := range asets {
+		assert.NoError(t, d.AddAuthSet(ctx, a))
+	}
+
+	statuses := []string{model.DevStatusPending, model.DevStatusRejected}
+
+	testCases := map[string]struct {
+		before   time.Time
+		statuses []string
+		limit    int
+
+		outIds []string
+	}{
+		"all": {
+			before:   now.Add(-90 * time.Minute),
+			statuses: statuses,
+			outIds:   []string{"2", "1", "6"},
+		},
+		"limit": {
+			before:   now.Add(-90 * time.Minute),
+			statuses: statuses,
+			limit:    2,
+			outIds:   []string{"2", "1"},
+		},
+		"status": {
+			before:   now,
+			statuses: []string{model.DevStatusAccepted},
+			outIds:   []string{"3"},
+		},
+		"none": {
+			before:   now.Add(-10 * time.Hour),
+			statuses: statuses,
+			outIds:   []string{},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			out, err := d.GetAuthSetsBefore(ctx, tc.before, tc.statuses, tc.limit)
+			assert.NoError(t, err)
+
+			ids := []string{}
+			for _, a := range out {
+				ids = append(ids, a.Id)
+			}
+			assert.Equal(t, tc.outIds, ids)
+		})
+	}
+}
+
 func TestStoreGetDeviceStatus(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping TestStoreGetDeviceStatus in short mode.")
@@ -2568,3 +2746,669 @@ func getIdDataHash(idData string) []byte {
 	hash.Write([]byte(idData))
 	return hash.Sum(nil)
 }
+
+func TestStoreTrustedCAs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestStoreTrustedCAs in short mode.")
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	later := now.Add(time.Minute)
+
+	ca1 := model.TrustedCA{
+		Id:          "fingerprint-1",
+		Subject:     "CN=Factory Root CA,O=Factory",
+		NotAfter:    now.Add(time.Hour),
+		Certificate: "cert-1",
+		CreatedTs:   &now,
+	}
+	ca2 := model.TrustedCA{
+		Id:          "fingerprint-2",
+		Subject:     "CN=Other CA,O=Other",
+		NotAfter:    now.Add(time.Hour),
+		Certificate: "cert-2",
+		CreatedTs:   &later,
+	}
+
+	dbCtx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: tenant,
+	})
+	dbCtxOtherTenant := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "other-" + tenant,
+	})
+
+	db := getDb(dbCtx)
+	defer db.session.Close()
+
+	cas, err := db.GetTrustedCAs(dbCtx)
+	assert.NoError(t, err)
+	assert.Len(t, cas, 0)
+
+	assert.NoError(t, db.AddTrustedCA(dbCtx, ca2))
+	assert.NoError(t, db.AddTrustedCA(dbCtx, ca1))
+	assert.NoError(t, db.AddTrustedCA(dbCtxOtherTenant, ca1))
+
+	err = db.AddTrustedCA(dbCtx, ca1)
+	assert.EqualError(t, err, store.ErrObjectExists.Error())
+
+	cas, err = db.GetTrustedCAs(dbCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ca1.Id, ca2.Id}, trustedCAIds(cas))
+
+	assert.NoError(t, db.DeleteTrustedCA(dbCtx, ca1.Id))
+	err = db.DeleteTrustedCA(dbCtx, ca1.Id)
+	assert.EqualError(t, err, store.ErrTrustedCANotFound.Error())
+
+	cas, err = db.GetTrustedCAs(dbCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ca2.Id}, trustedCAIds(cas))
+
+	// other tenant's CAs not affected
+	cas, err = db.GetTrustedCAs(dbCtxOtherTenant)
+	assert.NoError(t, err)
+	if assert.Len(t, cas, 1) {
+		assert.Equal(t, ca1.Subject, cas[0].Subject)
+		assert.Equal(t, ca1.Certificate, cas[0].Certificate)
+		assert.True(t, ca1.NotAfter.Equal(cas[0].NotAfter))
+	}
+}
+
+func trustedCAIds(cas []model.TrustedCA) []string {
+	ids := make([]string, len(cas))
+	for i, ca := range cas {
+		ids[i] = ca.Id
+	}
+	return ids
+}
+
+func TestStoreAddAuthNonce(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestStoreAddAuthNonce in short mode.")
+	}
+
+	d := getDb(context.Background())
+	defer d.session.Close()
+
+	ctx := context.Background()
+	exp := time.Now().Add(time.Minute)
+
+	err := d.AddAuthNonce(ctx, *model.NewAuthNonce([]byte("dev1"), "nonce1", exp))
+	assert.NoError(t, err)
+
+	// same nonce, other device
+	err = d.AddAuthNonce(ctx, *model.NewAuthNonce([]byte("dev2"), "nonce1", exp))
+	assert.NoError(t, err)
+
+	// replayed
+	err = d.AddAuthNonce(ctx, *model.NewAuthNonce([]byte("dev1"), "nonce1", exp))
+	assert.EqualError(t, err, store.ErrObjectExists.Error())
+
+	// nonces of other tenants are separate
+	tenantCtx := identity.WithContext(ctx, &identity.Identity{
+		Tenant: "foo",
+	})
+	err = d.AddAuthNonce(tenantCtx, *model.NewAuthNonce([]byte("dev1"), "nonce1", exp))
+	assert.NoError(t, err)
+}
+
+func TestStoreAuthChallenges(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestStoreAuthChallenges in short mode.")
+	}
+
+	d := getDb(context.Background())
+	defer d.session.Close()
+
+	ctx := context.Background()
+	// challenges are not scoped to tenants
+	tenantCtx := identity.WithContext(ctx, &identity.Identity{
+		Tenant: "foo",
+	})
+
+	ch, err := model.NewAuthChallenge(time.Minute)
+	assert.NoError(t, err)
+
+	err = d.AddAuthChallenge(ctx, *ch)
+	assert.NoError(t, err)
+
+	out, err := d.ConsumeAuthChallenge(tenantCtx, ch.Nonce)
+	assert.NoError(t, err)
+	if assert.NotNil(t, out) {
+		assert.Equal(t, ch.Nonce, out.Nonce)
+		assert.True(t, ch.ExpiresAt.Truncate(time.Millisecond).Equal(out.ExpiresAt))
+	}
+
+	// single use
+	_, err = d.ConsumeAuthChallenge(ctx, ch.Nonce)
+	assert.EqualError(t, err, store.ErrAuthChallengeNotFound.Error())
+}
+
+func TestStoreTokenScopes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestStoreTokenScopes in short mode.")
+	}
+
+	d := getDb(context.Background())
+	defer d.session.Close()
+
+	ctx := context.Background()
+
+	// not set
+	out, err := d.GetTokenScopes(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &model.TokenScopes{Scopes: []string{}}, out)
+
+	err = d.PutTokenScopes(ctx, model.TokenScopes{
+		Scopes: []string{"deployments", "inventory"},
+	})
+	assert.NoError(t, err)
+
+	err = d.PutTokenScopes(ctx, model.TokenScopes{
+		Scopes: []string{"inventory"},
+	})
+	assert.NoError(t, err)
+
+	out, err = d.GetTokenScopes(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &model.TokenScopes{Scopes: []string{"inventory"}}, out)
+
+	// scopes of other tenants are separate
+	tenantCtx := identity.WithContext(ctx, &identity.Identity{
+		Tenant: "foo",
+	})
+	out, err = d.GetTokenScopes(tenantCtx)
+	assert.NoError(t, err)
+	assert.Empty(t, out.Scopes)
+}
+
+func TestStoreTokenPolicy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestStoreTokenPolicy in short mode.")
+	}
+
+	d := getDb(context.Background())
+	defer d.session.Close()
+
+	ctx := context.Background()
+	tenantCtx := identity.WithContext(ctx, &identity.Identity{
+		Tenant: "foo",
+	})
+
+	// not set
+	out, err := d.GetTokenPolicy(tenantCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, &model.TokenPolicy{}, out)
+
+	err = d.PutTokenPolicy(tenantCtx, model.TokenPolicy{Audience: "aud1"})
+	assert.NoError(t, err)
+
+	err = d.PutTokenPolicy(tenantCtx, model.TokenPolicy{Audience: "aud2"})
+	assert.NoError(t, err)
+
+	out, err = d.GetTokenPolicy(tenantCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, &model.TokenPolicy{Audience: "aud2"}, out)
+
+	// policies of other tenants are separate
+	out, err = d.GetTokenPolicy(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &model.TokenPolicy{}, out)
+}
+
+func TestStoreTenantKey(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestStoreTenantKey in short mode.")
+	}
+
+	d := getDb(context.Background())
+	defer d.session.Close()
+
+	ctx := context.Background()
+	tenantCtx := identity.WithContext(ctx, &identity.Identity{
+		Tenant: "foo",
+	})
+
+	_, err := d.GetTenantKey(tenantCtx)
+	assert.Equal(t, store.ErrTenantKeyNotFound, err)
+
+	key := model.TenantKey{
+		KeyID:     "kid1",
+		Alg:       "ES256",
+		PrivKey:   []byte("encrypted"),
+		CreatedTs: time.Now().UTC().Round(time.Millisecond),
+	}
+
+	err = d.AddTenantKey(tenantCtx, key)
+	assert.NoError(t, err)
+
+	// only one key per tenant
+	err = d.AddTenantKey(tenantCtx, model.TenantKey{KeyID: "kid2"})
+	assert.Equal(t, store.ErrObjectExists, err)
+
+	out, err := d.GetTenantKey(tenantCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, key.KeyID, out.KeyID)
+	assert.Equal(t, key.Alg, out.Alg)
+	assert.Equal(t, key.PrivKey, out.PrivKey)
+	assert.True(t, key.CreatedTs.Equal(out.CreatedTs))
+
+	// keys of other tenants are separate
+	_, err = d.GetTenantKey(ctx)
+	assert.Equal(t, store.ErrTenantKeyNotFound, err)
+}
+
+func TestStoreAuthLockouts(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestStoreAuthLockouts in short mode.")
+	}
+
+	d := getDb(context.Background())
+	defer d.session.Close()
+
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "foo",
+	})
+
+	now := time.Now().UTC().Round(time.Millisecond)
+
+	failure := func(lockout *model.AuthLockout, at time.Time) *model.AuthLockout {
+		l := *lockout
+		l.LastFailureTs = at
+		l.ExpiresAt = at.Add(time.Hour)
+		out, err := d.AddAuthFailure(ctx, l, now.Add(-10*time.Minute))
+		assert.NoError(t, err)
+		return out
+	}
+
+	idLockout := model.NewIdentityAuthLockout([]byte{0x01, 0x02}, `{"mac":"foo"}`)
+	ipLockout := model.NewIPAuthLockout("192.0.2.1")
+
+	// stale failure, counting restarts
+	out := failure(idLockout, now.Add(-time.Hour))
+	assert.Equal(t, 1, out.Failures)
+	out = failure(idLockout, now.Add(-time.Second))
+	assert.Equal(t, 1, out.Failures)
+	out = failure(idLockout, now)
+	assert.Equal(t, 2, out.Failures)
+	assert.Equal(t, "identity:0102", out.Id)
+	assert.Equal(t, model.AuthLockoutTypeIdentity, out.Type)
+	assert.Equal(t, "0102", out.Value)
+	assert.Equal(t, `{"mac":"foo"}`, out.IdData)
+	assert.Nil(t, out.LockedUntil)
+
+	out = failure(ipLockout, now.Add(-time.Second))
+	assert.Equal(t, 1, out.Failures)
+	assert.Equal(t, "", out.IdData)
+
+	until := now.Add(time.Minute)
+	err := d.SetAuthLockedUntil(ctx, ipLockout.Id, until)
+	assert.NoError(t, err)
+	err = d.SetAuthLockedUntil(ctx, "ip:198.51.100.1", until)
+	assert.Equal(t, store.ErrAuthLockoutNotFound, err)
+
+	// all, most recent failure first
+	res, err := d.GetAuthLockouts(ctx, 0, 0, model.AuthLockoutFilter{})
+	assert.NoError(t, err)
+	if assert.Len(t, res, 2) {
+		assert.Equal(t, idLockout.Id, res[0].Id)
+		assert.Equal(t, ipLockout.Id, res[1].Id)
+		assert.True(t, until.Equal(*res[1].LockedUntil))
+	}
+
+	res, err = d.GetAuthLockouts(ctx, 0, 0, model.AuthLockoutFilter{
+		Type: model.AuthLockoutTypeIdentity,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, res, 1)
+
+	res, err = d.GetAuthLockouts(ctx, 0, 0, model.AuthLockoutFilter{
+		Ids:      []string{idLockout.Id, ipLockout.Id},
+		LockedAt: &now,
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, ipLockout.Id, res[0].Id)
+	}
+
+	// lockouts of other tenants are separate
+	res, err = d.GetAuthLockouts(context.Background(), 0, 0, model.AuthLockoutFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, res, 0)
+
+	err = d.DeleteAuthLockout(ctx, ipLockout.Id)
+	assert.NoError(t, err)
+	err = d.DeleteAuthLockout(ctx, ipLockout.Id)
+	assert.Equal(t, store.ErrAuthLockoutNotFound, err)
+
+	// counting restarts
+	out = failure(ipLockout, now)
+	assert.Equal(t, 1, out.Failures)
+	assert.Nil(t, out.LockedUntil)
+}
+
+func TestStoreRateLimitBuckets(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestStoreRateLimitBuckets in short mode.")
+	}
+
+	d := getDb(context.Background())
+	defer d.session.Close()
+
+	ctx := context.Background()
+	// buckets are not scoped to tenants
+	tenantCtx := identity.WithContext(ctx, &identity.Identity{
+		Tenant: "foo",
+	})
+
+	now := time.Now().UTC().Round(time.Millisecond)
+
+	_, err := d.GetRateLimitBucket(ctx, "devices:ip:192.0.2.1")
+	assert.Equal(t, store.ErrRateLimitBucketNotFound, err)
+
+	bucket := model.RateLimitBucket{
+		Key:       "devices:ip:192.0.2.1",
+		Tokens:    9,
+		UpdatedTs: now,
+		ExpiresAt: now.Add(time.Minute),
+	}
+
+	err = d.PutRateLimitBucket(ctx, bucket, 0)
+	assert.NoError(t, err)
+
+	// created concurrently
+	err = d.PutRateLimitBucket(ctx, bucket, 0)
+	assert.Equal(t, store.ErrRateLimitBucketConflict, err)
+
+	out, err := d.GetRateLimitBucket(tenantCtx, bucket.Key)
+	assert.NoError(t, err)
+	if assert.NotNil(t, out) {
+		assert.Equal(t, int64(1), out.Rev)
+		assert.Equal(t, float64(9), out.Tokens)
+		assert.True(t, now.Equal(out.UpdatedTs))
+	}
+
+	bucket.Tokens = 8
+	err = d.PutRateLimitBucket(ctx, bucket, 1)
+	assert.NoError(t, err)
+
+	// modified concurrently
+	err = d.PutRateLimitBucket(ctx, bucket, 1)
+	assert.Equal(t, store.ErrRateLimitBucketConflict, err)
+
+	out, err = d.GetRateLimitBucket(ctx, bucket.Key)
+	assert.NoError(t, err)
+	if assert.NotNil(t, out) {
+		assert.Equal(t, int64(2), out.Rev)
+		assert.Equal(t, float64(8), out.Tokens)
+	}
+}
+
+func TestStoreClaimCodes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestStoreClaimCodes in short mode.")
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	later := now.Add(time.Hour)
+
+	code1 := model.ClaimCode{
+		Id:        "code-1",
+		CreatedTs: now,
+		ExpiresAt: &later,
+	}
+	code2 := model.ClaimCode{
+		Id:        "code-2",
+		CreatedTs: later,
+	}
+
+	dbCtx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: tenant,
+	})
+	dbCtxOtherTenant := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "other-" + tenant,
+	})
+
+	db := getDb(dbCtx)
+	defer db.session.Close()
+
+	codes, err := db.GetClaimCodes(dbCtx)
+	assert.NoError(t, err)
+	assert.Len(t, codes, 0)
+
+	assert.NoError(t, db.AddClaimCode(dbCtx, code2))
+	assert.NoError(t, db.AddClaimCode(dbCtx, code1))
+
+	err = db.AddClaimCode(dbCtx, code1)
+	assert.EqualError(t, err, store.ErrObjectExists.Error())
+
+	codes, err = db.GetClaimCodes(dbCtx)
+	assert.NoError(t, err)
+	if assert.Len(t, codes, 2) {
+		assert.Equal(t, code1.Id, codes[0].Id)
+		assert.True(t, later.Equal(*codes[0].ExpiresAt))
+		assert.Equal(t, code2.Id, codes[1].Id)
+		assert.Nil(t, codes[1].ExpiresAt)
+	}
+
+	// codes of other tenants can't be used
+	_, err = db.ConsumeClaimCode(dbCtxOtherTenant, code1.Id)
+	assert.EqualError(t, err, store.ErrClaimCodeNotFound.Error())
+
+	// codes can be used once
+	res, err := db.ConsumeClaimCode(dbCtx, code1.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, code1.Id, res.Id)
+
+	_, err = db.ConsumeClaimCode(dbCtx, code1.Id)
+	assert.EqualError(t, err, store.ErrClaimCodeNotFound.Error())
+
+	assert.NoError(t, db.DeleteClaimCode(dbCtx, code2.Id))
+	err = db.DeleteClaimCode(dbCtx, code2.Id)
+	assert.EqualError(t, err, store.ErrClaimCodeNotFound.Error())
+
+	codes, err = db.GetClaimCodes(dbCtx)
+	assert.NoError(t, err)
+	assert.Len(t, codes, 0)
+}
+
+func TestStoreAdmissionRules(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestStoreAdmissionRules in short mode.")
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	later := now.Add(time.Hour)
+
+	rule1 := model.AdmissionRule{
+		Id:       "rule-1",
+		Name:     "factory",
+		Priority: 10,
+		Conditions: []model.AdmissionCondition{
+			{Attribute: "ip", Match: model.AdmissionMatchCIDR, Value: "10.1.0.0/16"},
+		},
+		Action:    model.AdmissionActionAccept,
+		CreatedTs: &later,
+		UpdatedTs: &later,
+	}
+	rule2 := model.AdmissionRule{
+		Id:        "rule-2",
+		Name:      "rsa",
+		Priority:  10,
+		KeyTypes:  []string{"rsa"},
+		Action:    model.AdmissionActionReject,
+		CreatedTs: &now,
+		UpdatedTs: &now,
+	}
+	rule3 := model.AdmissionRule{
+		Id:        "rule-3",
+		Name:      "ecdsa",
+		Priority:  1,
+		KeyTypes:  []string{"ecdsa"},
+		Action:    model.AdmissionActionPending,
+		CreatedTs: &later,
+		UpdatedTs: &later,
+	}
+
+	dbCtx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: tenant,
+	})
+	dbCtxOtherTenant := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "other-" + tenant,
+	})
+
+	db := getDb(dbCtx)
+	defer db.session.Close()
+
+	rules, err := db.GetAdmissionRules(dbCtx)
+	assert.NoError(t, err)
+	assert.Len(t, rules, 0)
+
+	assert.NoError(t, db.AddAdmissionRule(dbCtx, rule1))
+	assert.NoError(t, db.AddAdmissionRule(dbCtx, rule2))
+	assert.NoError(t, db.AddAdmissionRule(dbCtx, rule3))
+
+	err = db.AddAdmissionRule(dbCtx, rule1)
+	assert.EqualError(t, err, store.ErrObjectExists.Error())
+
+	// ordered by priority, then by creation
+	rules, err = db.GetAdmissionRules(dbCtx)
+	assert.NoError(t, err)
+	if assert.Len(t, rules, 3) {
+		assert.Equal(t, rule3.Id, rules[0].Id)
+		assert.Equal(t, rule2.Id, rules[1].Id)
+		assert.Equal(t, rule1.Id, rules[2].Id)
+		assert.Equal(t, rule1.Conditions, rules[2].Conditions)
+	}
+
+	rules, err = db.GetAdmissionRules(dbCtxOtherTenant)
+	assert.NoError(t, err)
+	assert.Len(t, rules, 0)
+
+	rule2.Action = model.AdmissionActionAccept
+	rule2.Priority = 20
+	rule2.UpdatedTs = &later
+	assert.NoError(t, db.UpdateAdmissionRule(dbCtx, rule2))
+
+	rule, err := db.GetAdmissionRule(dbCtx, rule2.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, model.AdmissionActionAccept, rule.Action)
+	assert.Equal(t, 20, rule.Priority)
+	assert.True(t, now.Equal(*rule.CreatedTs))
+	assert.True(t, later.Equal(*rule.UpdatedTs))
+
+	err = db.UpdateAdmissionRule(dbCtxOtherTenant, rule2)
+	assert.EqualError(t, err, store.ErrAdmissionRuleNotFound.Error())
+
+	assert.NoError(t, db.DeleteAdmissionRule(dbCtx, rule2.Id))
+	err = db.DeleteAdmissionRule(dbCtx, rule2.Id)
+	assert.EqualError(t, err, store.ErrAdmissionRuleNotFound.Error())
+
+	_, err = db.GetAdmissionRule(dbCtx, rule2.Id)
+	assert.EqualError(t, err, store.ErrAdmissionRuleNotFound.Error())
+}
+
+func TestStoreAdmissionWebhook(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestStoreAdmissionWebhook in short mode.")
+	}
+
+	hook := model.AdmissionWebhook{
+		URL:    "https://assets.example.com/admission",
+		Secret: "0123456789abcdef",
+	}
+
+	dbCtx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: tenant,
+	})
+	dbCtxOtherTenant := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "other-" + tenant,
+	})
+
+	db := getDb(dbCtx)
+	defer db.session.Close()
+
+	_, err := db.GetAdmissionWebhook(dbCtx)
+	assert.EqualError(t, err, store.ErrAdmissionWebhookNotFound.Error())
+
+	assert.NoError(t, db.PutAdmissionWebhook(dbCtx, hook))
+
+	res, err := db.GetAdmissionWebhook(dbCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, &hook, res)
+
+	_, err = db.GetAdmissionWebhook(dbCtxOtherTenant)
+	assert.EqualError(t, err, store.ErrAdmissionWebhookNotFound.Error())
+
+	// replaced
+	hook.URL = "https://assets.example.com/v2/admission"
+	assert.NoError(t, db.PutAdmissionWebhook(dbCtx, hook))
+
+	res, err = db.GetAdmissionWebhook(dbCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, &hook, res)
+
+	assert.NoError(t, db.DeleteAdmissionWebhook(dbCtx))
+	err = db.DeleteAdmissionWebhook(dbCtx)
+	assert.EqualError(t, err, store.ErrAdmissionWebhookNotFound.Error())
+}
+
+func TestStoreIdentitySchema(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestStoreIdentitySchema in short mode.")
+	}
+
+	// stored with millisecond precision
+	ts := time.Now().UTC().Truncate(time.Millisecond)
+	additional := false
+
+	schema := model.IdentitySchema{
+		Required: []string{"mac"},
+		Properties: map[string]model.IdentityProperty{
+			"mac": {
+				Type:    model.IdentityTypeString,
+				Pattern: "^([0-9a-f]{2}:){5}[0-9a-f]{2}$",
+			},
+			"sku": {
+				Type: model.IdentityTypeString,
+				Enum: []string{"acme-1", "acme-2"},
+			},
+		},
+		AdditionalProperties: &additional,
+		UpdatedTs:            &ts,
+	}
+
+	dbCtx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: tenant,
+	})
+	dbCtxOtherTenant := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "other-" + tenant,
+	})
+
+	db := getDb(dbCtx)
+	defer db.session.Close()
+
+	_, err := db.GetIdentitySchema(dbCtx)
+	assert.EqualError(t, err, store.ErrIdentitySchemaNotFound.Error())
+
+	assert.NoError(t, db.PutIdentitySchema(dbCtx, schema))
+
+	res, err := db.GetIdentitySchema(dbCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, &schema, res)
+
+	_, err = db.GetIdentitySchema(dbCtxOtherTenant)
+	assert.EqualError(t, err, store.ErrIdentitySchemaNotFound.Error())
+
+	// replaced
+	schema.Required = []string{"mac", "sku"}
+	schema.AdditionalProperties = nil
+	assert.NoError(t, db.PutIdentitySchema(dbCtx, schema))
+
+	res, err = db.GetIdentitySchema(dbCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, &schema, res)
+
+	assert.NoError(t, db.DeleteIdentitySchema(dbCtx))
+	err = db.DeleteIdentitySchema(dbCtx)
+	assert.EqualError(t, err, store.ErrIdentitySchemaNotFound.Error())
+}
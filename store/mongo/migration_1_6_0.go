@@ -0,0 +1,50 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package mongo
+
+import (
+	"context"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	ctxstore "github.com/mendersoftware/go-lib-micro/store"
+)
+
+// migration_1_6_0 recomputes the identity data hashes of the canonical
+// (RFC 8785), normalized identity data; colliding devices are reported.
+type migration_1_6_0 struct {
+	ms  *DataStoreMongo
+	ctx context.Context
+}
+
+func (m *migration_1_6_0) Up(from migrate.Version) error {
+	l := log.FromContext(m.ctx)
+
+	collisions, err := m.ms.RehashIdData(m.ctx,
+		ctxstore.DbFromContext(m.ctx, DbName), false)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range collisions {
+		l.Warnf("device %v has the identity data of device %v once normalized, "+
+			"the devices need to be merged: %v", c.DeviceId, c.OtherDeviceId, c.IdData)
+	}
+
+	return nil
+}
+
+func (m *migration_1_6_0) Version() migrate.Version {
+	return migrate.MakeVersion(1, 6, 0)
+}
@@ -0,0 +1,188 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package mongo
+
+import (
+	"context"
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	ctxstore "github.com/mendersoftware/go-lib-micro/store"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deviceauth/model"
+)
+
+func TestMigration_1_6_0(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMigration_1_6_0 in short mode.")
+	}
+
+	ts := time.Now()
+
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "foo",
+	})
+	db.Wipe()
+	db := NewDataStoreMongoWithSession(db.Session()).
+		WithIdDataNormalizers(model.IdDataNormalizers{
+			"mac": {model.IdDataNormalizerTrim, model.IdDataNormalizerMAC},
+		})
+	s := db.session
+
+	hash := func(idData string) []byte {
+		h := sha256.Sum256([]byte(idData))
+		return h[:]
+	}
+
+	devs := []model.Device{
+		{
+			// unsorted, not normalized
+			Id:        "1",
+			IdData:    `{"sn":"0001","mac":"00-00-00-00-00-0A"}`,
+			Status:    "accepted",
+			CreatedTs: ts,
+			UpdatedTs: ts,
+		},
+		{
+			// escaped as by encoding/json
+			Id:        "2",
+			IdData:    `{"mac":"00:00:00:00:00:02","sku":"a\u0026b"}`,
+			Status:    "pending",
+			CreatedTs: ts,
+			UpdatedTs: ts,
+		},
+		{
+			// already canonical, but the same device as 4
+			Id:        "3",
+			IdData:    `{"mac":"00:00:00:00:00:03"}`,
+			Status:    "accepted",
+			CreatedTs: ts,
+			UpdatedTs: ts,
+		},
+		{
+			Id:        "4",
+			IdData:    `{"mac":" 00:00:00:00:00:03 "}`,
+			Status:    "pending",
+			CreatedTs: ts,
+			UpdatedTs: ts,
+		},
+	}
+	for i := range devs {
+		devs[i].IdDataSha256 = hash(devs[i].IdData)
+	}
+
+	asets := []model.AuthSet{
+		{
+			Id:        "1",
+			DeviceId:  "1",
+			IdData:    devs[0].IdData,
+			Status:    "accepted",
+			PubKey:    "key1",
+			Timestamp: &ts,
+		},
+		{
+			Id:        "2",
+			DeviceId:  "2",
+			IdData:    devs[1].IdData,
+			Status:    "pending",
+			PubKey:    "key2",
+			Timestamp: &ts,
+		},
+		{
+			Id:        "3",
+			DeviceId:  "4",
+			IdData:    devs[3].IdData,
+			Status:    "pending",
+			PubKey:    "key4",
+			Timestamp: &ts,
+		},
+	}
+	for i := range asets {
+		asets[i].IdDataSha256 = hash(asets[i].IdData)
+	}
+
+	for _, d := range devs {
+		err := db.AddDevice(ctx, d)
+		assert.NoError(t, err)
+	}
+
+	for _, a := range asets {
+		err := db.AddAuthSet(ctx, a)
+		assert.NoError(t, err)
+	}
+
+	// dry run, only the collisions are reported
+	collisions, err := db.RehashIdData(ctx,
+		ctxstore.DbFromContext(ctx, DbName), true)
+	assert.NoError(t, err)
+	assert.Equal(t, []IdDataCollision{
+		{
+			DeviceId:      "4",
+			OtherDeviceId: "3",
+			IdData:        `{"mac":"00:00:00:00:00:03"}`,
+		},
+	}, collisions)
+
+	var dev model.Device
+	err = s.DB(ctxstore.DbFromContext(ctx, DbName)).
+		C(DbDevicesColl).FindId("1").One(&dev)
+	assert.NoError(t, err)
+	assert.Equal(t, devs[0].IdData, dev.IdData)
+
+	mig160 := migration_1_6_0{
+		ms:  db,
+		ctx: ctx,
+	}
+	err = mig160.Up(migrate.MakeVersion(1, 6, 0))
+	assert.NoError(t, err)
+
+	expected := map[string]string{
+		"1": `{"mac":"00:00:00:00:00:0a","sn":"0001"}`,
+		"2": `{"mac":"00:00:00:00:00:02","sku":"a&b"}`,
+		"3": `{"mac":"00:00:00:00:00:03"}`,
+		// collided, left as it was
+		"4": `{"mac":" 00:00:00:00:00:03 "}`,
+	}
+
+	for _, d := range devs {
+		err = s.DB(ctxstore.DbFromContext(ctx, DbName)).
+			C(DbDevicesColl).FindId(d.Id).One(&dev)
+		assert.NoError(t, err)
+
+		assert.Equal(t, expected[d.Id], dev.IdData)
+		assert.Equal(t, hash(expected[d.Id]), dev.IdDataSha256)
+	}
+
+	var set model.AuthSet
+	for _, as := range asets {
+		err = s.DB(ctxstore.DbFromContext(ctx, DbName)).
+			C(DbAuthSetColl).FindId(as.Id).One(&set)
+		assert.NoError(t, err)
+
+		assert.Equal(t, expected[as.DeviceId], set.IdData)
+		assert.Equal(t, hash(expected[as.DeviceId]), set.IdDataSha256)
+	}
+
+	// the collision remains until the devices are merged
+	collisions, err = db.RehashIdData(ctx,
+		ctxstore.DbFromContext(ctx, DbName), false)
+	assert.NoError(t, err)
+	assert.Len(t, collisions, 1)
+
+	db.session.Close()
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	ctxstore "github.com/mendersoftware/go-lib-micro/store"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceauth/model"
+)
+
+// migration_1_7_0 creates the TTL indexes dropping the expired documents
+type migration_1_7_0 struct {
+	ms  *DataStoreMongo
+	ctx context.Context
+}
+
+func (m *migration_1_7_0) Up(from migrate.Version) error {
+	s := m.ms.session.Copy()
+	defer s.Close()
+
+	database := s.DB(ctxstore.DbFromContext(m.ctx, DbName))
+
+	// nonces are dropped once expired
+	err := database.C(DbAuthNonceColl).EnsureIndex(mgo.Index{
+		Key:         []string{model.AuthNonceKeyExpiresAt},
+		Name:        indexAuthNonce_ExpiresAt,
+		ExpireAfter: time.Second,
+		Background:  true,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create auth nonce index")
+	}
+
+	// lockouts are dropped once expired
+	err = database.C(DbLockoutColl).EnsureIndex(mgo.Index{
+		Key:         []string{model.AuthLockoutKeyExpiresAt},
+		Name:        indexAuthLockout_ExpiresAt,
+		ExpireAfter: time.Second,
+		Background:  true,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create auth lockout index")
+	}
+
+	// claim codes are dropped once expired
+	err = database.C(DbClaimCodeColl).EnsureIndex(mgo.Index{
+		Key:         []string{model.ClaimCodeKeyExpiresAt},
+		Name:        indexClaimCode_ExpiresAt,
+		ExpireAfter: time.Second,
+		Background:  true,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create claim code index")
+	}
+
+	return nil
+}
+
+func (m *migration_1_7_0) Version() migrate.Version {
+	return migrate.MakeVersion(1, 7, 0)
+}
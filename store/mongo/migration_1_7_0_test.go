@@ -0,0 +1,68 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	ctxstore "github.com/mendersoftware/go-lib-micro/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigration_1_7_0(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMigration_1_7_0 in short mode.")
+	}
+
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "foo",
+	})
+	db.Wipe()
+	db := NewDataStoreMongoWithSession(db.Session())
+	s := db.session
+
+	mig170 := migration_1_7_0{
+		ms:  db,
+		ctx: ctx,
+	}
+	err := mig170.Up(migrate.MakeVersion(1, 7, 0))
+	assert.NoError(t, err)
+
+	expected := map[string]string{
+		DbAuthNonceColl: indexAuthNonce_ExpiresAt,
+		DbLockoutColl:   indexAuthLockout_ExpiresAt,
+		DbClaimCodeColl: indexClaimCode_ExpiresAt,
+	}
+
+	for coll, name := range expected {
+		idxs, err := s.DB(ctxstore.DbFromContext(ctx, DbName)).
+			C(coll).Indexes()
+		assert.NoError(t, err)
+
+		found := false
+		for _, idx := range idxs {
+			if idx.Name == name {
+				found = true
+				assert.Equal(t, time.Second, idx.ExpireAfter)
+			}
+		}
+		assert.True(t, found, "index %v was not found", name)
+	}
+
+	db.session.Close()
+}
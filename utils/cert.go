@@ -0,0 +1,145 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package utils
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ErrMsgCertVerify = "certificate verification failed"
+
+	//PEM identifier of a certificate
+	CertBlockType = "CERTIFICATE"
+
+	// identity attributes derived from a device certificate
+	CertAttrCommonName     = "cn"
+	CertAttrSerialNumber   = "serial_number"
+	CertAttrDNSNames       = "dns_names"
+	CertAttrEmailAddresses = "email_addresses"
+	CertAttrURIs           = "uris"
+)
+
+var (
+	ErrNoCertificate = errors.New("no certificate found")
+	ErrNoTrustedCA   = errors.New("no trusted CA certificates")
+)
+
+// ParseCertChain parses a sequence of PEM encoded certificates, leaf
+// certificate first, followed by the intermediate CAs (if any).
+func ParseCertChain(chain string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+
+	rest := []byte(chain)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != CertBlockType {
+			return nil, errors.Errorf("unexpected PEM block type: %s", block.Type)
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot decode certificate")
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, ErrNoCertificate
+	}
+
+	return certs, nil
+}
+
+func SerializeCert(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:  CertBlockType,
+		Bytes: cert.Raw,
+	}))
+}
+
+// CertFingerprint returns the hex encoded SHA256 digest of the DER encoded
+// certificate.
+func CertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyCertChain checks that the leaf certificate (chain[0]) is valid for
+// client authentication and chains up to one of the trusted roots, possibly
+// through the intermediates in chain[1:].
+func VerifyCertChain(chain []*x509.Certificate, roots []*x509.Certificate) error {
+	if len(chain) == 0 {
+		return errors.Wrap(ErrNoCertificate, ErrMsgCertVerify)
+	}
+	if len(roots) == 0 {
+		return errors.Wrap(ErrNoTrustedCA, ErrMsgCertVerify)
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, c := range roots {
+		opts.Roots.AddCert(c)
+	}
+	for _, c := range chain[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+
+	if _, err := chain[0].Verify(opts); err != nil {
+		return errors.Wrap(err, ErrMsgCertVerify)
+	}
+
+	return nil
+}
+
+// CertIdentity derives device identity attributes from the certificate
+// subject and subject alternative names. Attributes without a value are
+// omitted.
+func CertIdentity(cert *x509.Certificate) map[string]interface{} {
+	attrs := map[string]interface{}{}
+
+	if cert.Subject.CommonName != "" {
+		attrs[CertAttrCommonName] = cert.Subject.CommonName
+	}
+	if cert.Subject.SerialNumber != "" {
+		attrs[CertAttrSerialNumber] = cert.Subject.SerialNumber
+	}
+	if len(cert.DNSNames) > 0 {
+		attrs[CertAttrDNSNames] = cert.DNSNames
+	}
+	if len(cert.EmailAddresses) > 0 {
+		attrs[CertAttrEmailAddresses] = cert.EmailAddresses
+	}
+	if len(cert.URIs) > 0 {
+		uris := make([]string, len(cert.URIs))
+		for i, u := range cert.URIs {
+			uris[i] = u.String()
+		}
+		attrs[CertAttrURIs] = uris
+	}
+
+	return attrs
+}
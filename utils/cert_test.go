@@ -0,0 +1,164 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package utils
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	test "github.com/mendersoftware/deviceauth/utils/testing"
+)
+
+func loadCerts(t *testing.T, paths ...string) []*x509.Certificate {
+	certs, err := ParseCertChain(test.LoadCertChainStr(t, paths...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certs
+}
+
+func TestParseCertChain(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		chain string
+		len   int
+		err   string
+	}{
+		"ok, leaf only": {
+			chain: test.LoadCertChainStr(t, "testdata/cert_device.pem"),
+			len:   1,
+		},
+		"ok, with intermediate": {
+			chain: test.LoadCertChainStr(t,
+				"testdata/cert_device.pem",
+				"testdata/cert_intermediate.pem"),
+			len: 2,
+		},
+		"error, empty": {
+			chain: "",
+			err:   ErrNoCertificate.Error(),
+		},
+		"error, public key": {
+			chain: test.LoadPubKeyStr("testdata/public_ecdsa.pem", t),
+			err:   "unexpected PEM block type: PUBLIC KEY",
+		},
+		"error, garbage": {
+			chain: "-----BEGIN CERTIFICATE-----\nZm9vYmFy\n-----END CERTIFICATE-----\n",
+			err:   "cannot decode certificate",
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(fmt.Sprintf("tc %s", name), func(t *testing.T) {
+			t.Parallel()
+
+			certs, err := ParseCertChain(tc.chain)
+			if tc.err != "" {
+				assert.Error(t, err)
+				assert.True(t, strings.HasPrefix(err.Error(), tc.err), err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Len(t, certs, tc.len)
+			}
+		})
+	}
+}
+
+func TestVerifyCertChain(t *testing.T) {
+	t.Parallel()
+
+	root := loadCerts(t, "testdata/cert_ca.pem")
+	intermediate := loadCerts(t, "testdata/cert_intermediate.pem")
+	other := loadCerts(t, "testdata/cert_other_ca.pem")
+	leaf := loadCerts(t, "testdata/cert_device.pem")
+
+	testCases := map[string]struct {
+		chain []*x509.Certificate
+		roots []*x509.Certificate
+		err   string
+	}{
+		"ok, root trusted": {
+			chain: append(leaf, intermediate...),
+			roots: root,
+		},
+		"ok, intermediate trusted": {
+			chain: leaf,
+			roots: intermediate,
+		},
+		"ok, one of many trusted": {
+			chain: append(leaf, intermediate...),
+			roots: append(other, root...),
+		},
+		"error, missing intermediate": {
+			chain: leaf,
+			roots: root,
+			err:   "certificate verification failed: x509: certificate signed by unknown authority",
+		},
+		"error, untrusted": {
+			chain: append(leaf, intermediate...),
+			roots: other,
+			err:   "certificate verification failed: x509: certificate signed by unknown authority",
+		},
+		"error, no roots": {
+			chain: leaf,
+			err:   "certificate verification failed: no trusted CA certificates",
+		},
+		"error, no chain": {
+			roots: root,
+			err:   "certificate verification failed: no certificate found",
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(fmt.Sprintf("tc %s", name), func(t *testing.T) {
+			t.Parallel()
+
+			err := VerifyCertChain(tc.chain, tc.roots)
+			if tc.err != "" {
+				assert.Error(t, err)
+				assert.True(t, strings.HasPrefix(err.Error(), tc.err), err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCertIdentity(t *testing.T) {
+	t.Parallel()
+
+	leaf := loadCerts(t, "testdata/cert_device.pem")[0]
+	assert.Equal(t,
+		map[string]interface{}{
+			CertAttrCommonName:   "dev-0001",
+			CertAttrSerialNumber: "SN0001",
+			CertAttrDNSNames:     []string{"dev-0001.factory.example"},
+			CertAttrURIs:         []string{"urn:dev:0001"},
+		},
+		CertIdentity(leaf))
+
+	ca := loadCerts(t, "testdata/cert_ca.pem")[0]
+	assert.Equal(t,
+		map[string]interface{}{
+			CertAttrCommonName: "Factory Root CA",
+		},
+		CertIdentity(ca))
+}
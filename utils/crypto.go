@@ -16,12 +16,15 @@ package utils
 import (
 	"bytes"
 	"crypto"
-	"crypto/dsa"
 	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/pem"
 
 	"github.com/pkg/errors"
@@ -30,9 +33,20 @@ import (
 const (
 	ErrMsgVerify = "verification failed"
 
-	//PEM identifier of an RSA public key, needed for decoding
+	//PEM identifier of a public key, needed for decoding
 	//key content from a string
 	PubKeyBlockType = "PUBLIC KEY"
+
+	// public key types, see PubKeyType
+	KeyTypeRSA     = "rsa"
+	KeyTypeECDSA   = "ecdsa"
+	KeyTypeEd25519 = "ed25519"
+)
+
+var (
+	ErrUnsupportedPubKey = errors.New("unsupported public key type")
+	ErrUnsupportedCurve  = errors.New("unsupported elliptic curve")
+	ErrSignatureInvalid  = errors.New("invalid signature")
 )
 
 func VerifyAuthReqSign(signature string, pubkey interface{}, content []byte) error {
@@ -47,16 +61,45 @@ func VerifyAuthReqSign(signature string, pubkey interface{}, content []byte) err
 		return errors.Wrap(err, ErrMsgVerify)
 	}
 
-	key, ok := pubkey.(*rsa.PublicKey)
-	if !ok {
+	switch key := pubkey.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hash.Sum(nil), decodedSig)
+	case *ecdsa.PublicKey:
+		// signature is ASN.1 DER encoded (r, s)
+		if !ecdsa.VerifyASN1(key, hash.Sum(nil), decodedSig) {
+			err = ErrSignatureInvalid
+		}
+	case ed25519.PublicKey:
+		// ed25519 signs the message itself, not the digest
+		if !ed25519.Verify(key, content, decodedSig) {
+			err = ErrSignatureInvalid
+		}
+	default:
+		err = ErrUnsupportedPubKey
+	}
+
+	if err != nil {
 		return errors.Wrap(err, ErrMsgVerify)
 	}
 
-	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hash.Sum(nil), decodedSig)
+	return nil
+}
+
+// VerifyAuthReqHMAC verifies the signature of a request signed with a
+// pre-shared key: BASE64(HMAC-SHA256(secret, content)).
+func VerifyAuthReqHMAC(signature string, secret []byte, content []byte) error {
+	decodedSig, err := base64.StdEncoding.DecodeString(signature)
 	if err != nil {
 		return errors.Wrap(err, ErrMsgVerify)
 	}
 
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(content)
+
+	if !hmac.Equal(mac.Sum(nil), decodedSig) {
+		return errors.Wrap(ErrSignatureInvalid, ErrMsgVerify)
+	}
+
 	return nil
 }
 
@@ -75,13 +118,54 @@ func ParsePubKey(pubkey string) (interface{}, error) {
 	return key, nil
 }
 
-func SerializePubKey(key interface{}) (string, error) {
+// CheckPubKey verifies that the key is of a type accepted for device
+// authentication: RSA, ECDSA (P-256, P-384) or Ed25519.
+func CheckPubKey(key interface{}) error {
+	switch k := key.(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+		return nil
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256(), elliptic.P384():
+			return nil
+		}
+		return ErrUnsupportedCurve
+	default:
+		return ErrUnsupportedPubKey
+	}
+}
 
+// PubKeyType returns the type of the key, one of the KeyType* constants;
+// empty if not supported.
+func PubKeyType(key interface{}) string {
 	switch key.(type) {
-	case *rsa.PublicKey, *dsa.PublicKey, *ecdsa.PublicKey:
-		break
+	case *rsa.PublicKey:
+		return KeyTypeRSA
+	case *ecdsa.PublicKey:
+		return KeyTypeECDSA
+	case ed25519.PublicKey:
+		return KeyTypeEd25519
 	default:
-		return "", errors.New("unrecognizable public key type")
+		return ""
+	}
+}
+
+// PubKeyFingerprint returns the hex encoded SHA256 digest of the DER encoded
+// public key.
+func PubKeyFingerprint(key interface{}) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func SerializePubKey(key interface{}) (string, error) {
+
+	if err := CheckPubKey(key); err != nil {
+		return "", err
 	}
 
 	asn1, err := x509.MarshalPKIXPublicKey(key)
@@ -90,7 +174,7 @@ func SerializePubKey(key interface{}) (string, error) {
 	}
 
 	out := pem.EncodeToMemory(&pem.Block{
-		Type:  "PUBLIC KEY",
+		Type:  PubKeyBlockType,
 		Bytes: asn1,
 	})
 
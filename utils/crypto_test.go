@@ -14,8 +14,10 @@
 package utils
 
 import (
-	"crypto/rsa"
+	"crypto"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
 	"fmt"
 	"testing"
@@ -32,7 +34,7 @@ func TestVerifyAuthReqSign(t *testing.T) {
 	testCases := []struct {
 		content   string
 		pubkeyStr string
-		privkey   *rsa.PrivateKey
+		privkey   crypto.Signer
 		err       string
 	}{
 		{
@@ -57,6 +59,46 @@ func TestVerifyAuthReqSign(t *testing.T) {
 			test.LoadPrivKey("testdata/private_invalid.pem", t),
 			"verification failed: crypto/rsa: verification error",
 		},
+		{
+			//ecdsa, matching keypair
+			`{
+				"id_data": {"mac": "deadbeef"},
+				"tenant_token": "token"
+			}`,
+			test.LoadPubKeyStr("testdata/public_ecdsa.pem", t),
+			test.LoadPrivKeyPKCS8("testdata/private_ecdsa.pem", t),
+			"",
+		},
+		{
+			//ecdsa key, signed with rsa
+			`{
+				"id_data": {"mac": "deadbeef"},
+				"tenant_token": "token"
+			}`,
+			test.LoadPubKeyStr("testdata/public_ecdsa.pem", t),
+			test.LoadPrivKey("testdata/private.pem", t),
+			"verification failed: invalid signature",
+		},
+		{
+			//ed25519, matching keypair
+			`{
+				"id_data": {"mac": "deadbeef"},
+				"tenant_token": "token"
+			}`,
+			test.LoadPubKeyStr("testdata/public_ed25519.pem", t),
+			test.LoadPrivKeyPKCS8("testdata/private_ed25519.pem", t),
+			"",
+		},
+		{
+			//ed25519 key, signed with ecdsa
+			`{
+				"id_data": {"mac": "deadbeef"},
+				"tenant_token": "token"
+			}`,
+			test.LoadPubKeyStr("testdata/public_ed25519.pem", t),
+			test.LoadPrivKeyPKCS8("testdata/private_ecdsa.pem", t),
+			"verification failed: invalid signature",
+		},
 	}
 
 	for i := range testCases {
@@ -82,6 +124,44 @@ func TestVerifyAuthReqSign(t *testing.T) {
 	}
 }
 
+func TestVerifyAuthReqHMAC(t *testing.T) {
+	t.Parallel()
+
+	content := []byte(`{"id_data": "{\"mac\": \"deadbeef\"}"}`)
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	testCases := map[string]struct {
+		signature string
+		err       string
+	}{
+		"ok": {
+			signature: string(test.AuthReqHMAC(content, secret)),
+		},
+		"signed with a different key": {
+			signature: string(test.AuthReqHMAC(content, []byte("foo"))),
+			err:       "verification failed: invalid signature",
+		},
+		"signature not base64 encoded": {
+			signature: "foo!",
+			err:       "verification failed: illegal base64 data at input byte 3",
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := VerifyAuthReqHMAC(tc.signature, secret, content)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
 func TestParsePubKey(t *testing.T) {
 	t.Parallel()
 
@@ -92,6 +172,12 @@ func TestParsePubKey(t *testing.T) {
 		"ok": {
 			pubkey: test.LoadPubKeyStr("testdata/public.pem", t),
 		},
+		"ok, ecdsa": {
+			pubkey: test.LoadPubKeyStr("testdata/public_ecdsa.pem", t),
+		},
+		"ok, ed25519": {
+			pubkey: test.LoadPubKeyStr("testdata/public_ed25519.pem", t),
+		},
 		"error, bad pem block": {
 			pubkey: test.LoadPubKeyStr("testdata/public_bad_pem.pem", t),
 			err:    errors.New("cannot decode public key"),
@@ -120,6 +206,59 @@ func TestParsePubKey(t *testing.T) {
 	}
 }
 
+func TestPubKeyType(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		keyPath string
+		keyType string
+	}{
+		"rsa": {
+			keyPath: "testdata/public.pem",
+			keyType: KeyTypeRSA,
+		},
+		"ecdsa": {
+			keyPath: "testdata/public_ecdsa.pem",
+			keyType: KeyTypeECDSA,
+		},
+		"ed25519": {
+			keyPath: "testdata/public_ed25519.pem",
+			keyType: KeyTypeEd25519,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(fmt.Sprintf("tc %s", i), func(t *testing.T) {
+			t.Parallel()
+
+			key, err := ParsePubKey(test.LoadPubKeyStr(tc.keyPath, t))
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.keyType, PubKeyType(key))
+		})
+	}
+
+	assert.Equal(t, "", PubKeyType("foo"))
+}
+
+func TestPubKeyFingerprint(t *testing.T) {
+	t.Parallel()
+
+	key, err := ParsePubKey(test.LoadPubKeyStr("testdata/public_ed25519.pem", t))
+	assert.NoError(t, err)
+
+	block, _ := pem.Decode([]byte(test.LoadPubKeyStr("testdata/public_ed25519.pem", t)))
+	sum := sha256.Sum256(block.Bytes)
+
+	fp, err := PubKeyFingerprint(key)
+	assert.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(sum[:]), fp)
+
+	_, err = PubKeyFingerprint("foo")
+	assert.Error(t, err)
+}
+
 func TestSerializePubKey(t *testing.T) {
 	t.Parallel()
 
@@ -141,6 +280,18 @@ UwIDAQAB
 -----END PUBLIC KEY-----
 `,
 		},
+		"ok, ecdsa": {
+			keyPath: "testdata/public_ecdsa.pem",
+			out:     test.LoadPubKeyStr("testdata/public_ecdsa.pem", t),
+		},
+		"ok, ed25519": {
+			keyPath: "testdata/public_ed25519.pem",
+			out:     test.LoadPubKeyStr("testdata/public_ed25519.pem", t),
+		},
+		"error, ecdsa P-521": {
+			keyPath: "testdata/public_ecdsa_p521.pem",
+			err:     ErrUnsupportedCurve,
+		},
 	}
 
 	for i := range testCases {
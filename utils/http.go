@@ -16,6 +16,8 @@ package utils
 
 import (
 	"io/ioutil"
+	"net"
+	"net/http"
 	"strings"
 
 	"github.com/ant0ine/go-json-rest/rest"
@@ -41,3 +43,55 @@ func JoinURL(base, url string) string {
 	return base + url
 
 }
+
+// RemoteIP returns the address of the client; behind a proxy (such as the
+// API gateway), the address the proxy appended last to the X-Forwarded-For
+// header, as the preceding ones are set by the client.
+func RemoteIP(r *http.Request) string {
+	if fwd := r.Header[http.CanonicalHeaderKey("X-Forwarded-For")]; len(fwd) > 0 {
+		addrs := strings.Split(fwd[len(fwd)-1], ",")
+		return strings.TrimSpace(addrs[len(addrs)-1])
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+var nonPublicNets = mustParseCIDRs(
+	// "this" network
+	"0.0.0.0/8",
+	// carrier-grade NAT
+	"100.64.0.0/10",
+)
+
+// IsPublicIP tells if the address is a public unicast one, as opposed to
+// loopback, link-local (e.g. cloud metadata endpoints), private, multicast
+// or unspecified addresses.
+func IsPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		ip.IsUnspecified() || ip.IsPrivate() {
+		return false
+	}
+	for _, n := range nonPublicNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return ip.To16() != nil
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
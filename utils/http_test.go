@@ -14,6 +14,8 @@
 package utils
 
 import (
+	"net"
+	"net/http"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -28,3 +30,82 @@ func TestJoinURL(t *testing.T) {
 	assert.Equal(t, "http://foo:123/bar/baz", JoinURL("http://foo:123/bar", "/baz"))
 	assert.Equal(t, "http://foo:123/bar/baz", JoinURL("http://foo:123/bar", "baz"))
 }
+
+func TestRemoteIP(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		remoteAddr string
+		forwarded  []string
+		ip         string
+	}{
+		"direct": {
+			remoteAddr: "192.0.2.1:1234",
+			ip:         "192.0.2.1",
+		},
+		"direct, ipv6": {
+			remoteAddr: "[2001:db8::1]:1234",
+			ip:         "2001:db8::1",
+		},
+		"direct, no port": {
+			remoteAddr: "192.0.2.1",
+			ip:         "192.0.2.1",
+		},
+		"proxied": {
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  []string{"192.0.2.1"},
+			ip:         "192.0.2.1",
+		},
+		"proxied, spoofed": {
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  []string{"198.51.100.1, 192.0.2.1"},
+			ip:         "192.0.2.1",
+		},
+		"proxied, spoofed, multiple headers": {
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  []string{"198.51.100.1", "192.0.2.1"},
+			ip:         "192.0.2.1",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			r := &http.Request{
+				RemoteAddr: tc.remoteAddr,
+				Header:     http.Header{},
+			}
+			for _, fwd := range tc.forwarded {
+				r.Header.Add("X-Forwarded-For", fwd)
+			}
+			assert.Equal(t, tc.ip, RemoteIP(r))
+		})
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]bool{
+		"192.0.2.1":       true,
+		"8.8.8.8":         true,
+		"2001:db8::1":     true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::":              false,
+		"224.0.0.1":       false,
+		"::ffff:10.0.0.1": false,
+	}
+
+	for addr, public := range testCases {
+		assert.Equal(t, public, IsPublicIP(net.ParseIP(addr)), addr)
+	}
+	assert.False(t, IsPublicIP(nil))
+}
@@ -14,7 +14,15 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
 )
 
 // JsonSort decodes and re-encodes a json string to get a lexical sort on json keys.
@@ -34,3 +42,224 @@ func JsonSort(what string) (string, error) {
 
 	return string(enc), nil
 }
+
+// JsonDecode decodes a json string like json.Unmarshal, but numbers are
+// decoded as json.Number, so that they are re-encoded as received.
+func JsonDecode(what string, v interface{}) error {
+	// validate first, the decoder doesn't fail on trailing data
+	var raw json.RawMessage
+	if err := json.Unmarshal([]byte(what), &raw); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	return dec.Decode(v)
+}
+
+// JsonCanonical re-encodes a json string in the canonical form of RFC 8785
+// (JSON Canonicalization Scheme). Unlike JsonSort, the output doesn't depend
+// on the json package: object keys are sorted by their UTF-16 code units,
+// numbers are serialized as by ECMAScript and strings are escaped minimally.
+func JsonCanonical(what string) (string, error) {
+	var dec interface{}
+	if err := JsonDecode(what, &dec); err != nil {
+		return "", err
+	}
+
+	enc, err := JsonMarshalCanonical(dec)
+	if err != nil {
+		return "", err
+	}
+
+	return string(enc), nil
+}
+
+// JsonMarshalCanonical encodes a decoded json value in the canonical form of
+// RFC 8785; see JsonCanonical.
+func JsonMarshalCanonical(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := writeCanonical(&buf, v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeCanonical(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case string:
+		writeCanonicalString(buf, v)
+	case json.Number:
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return errors.Wrapf(err, "invalid number %s", v)
+		}
+		return writeCanonicalNumber(buf, f)
+	case float64:
+		return writeCanonicalNumber(buf, v)
+	case int:
+		return writeCanonicalNumber(buf, float64(v))
+	case int64:
+		return writeCanonicalNumber(buf, float64(v))
+	case []interface{}:
+		buf.WriteByte('[')
+		for i := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, v[i]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case []string:
+		buf.WriteByte('[')
+		for i := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeCanonicalString(buf, v[i])
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return lessUTF16(keys[i], keys[j])
+		})
+
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeCanonicalString(buf, k)
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return errors.Errorf("unsupported type %T", v)
+	}
+
+	return nil
+}
+
+// writeCanonicalString escapes only the quotation mark, the reverse solidus
+// and the control characters.
+func writeCanonicalString(buf *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hex[r>>4])
+				buf.WriteByte(hex[r&0xf])
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// writeCanonicalNumber serializes the number as ECMAScript's
+// Number.prototype.toString does.
+func writeCanonicalNumber(buf *bytes.Buffer, f float64) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return errors.Errorf("invalid number %v", f)
+	}
+
+	// covers negative zero
+	if f == 0 {
+		buf.WriteByte('0')
+		return nil
+	}
+
+	if f < 0 {
+		buf.WriteByte('-')
+		f = -f
+	}
+
+	// shortest digits that round trip, and the exponent
+	mantissa := strconv.FormatFloat(f, 'e', -1, 64)
+	i := strings.IndexByte(mantissa, 'e')
+	exp, err := strconv.Atoi(mantissa[i+1:])
+	if err != nil {
+		return err
+	}
+	digits := strings.Replace(mantissa[:i], ".", "", 1)
+
+	// the number is 0.digits * 10^n
+	k := len(digits)
+	n := exp + 1
+
+	switch {
+	case k <= n && n <= 21:
+		buf.WriteString(digits)
+		buf.WriteString(strings.Repeat("0", n-k))
+	case 0 < n && n <= 21:
+		buf.WriteString(digits[:n])
+		buf.WriteByte('.')
+		buf.WriteString(digits[n:])
+	case -6 < n && n <= 0:
+		buf.WriteString("0.")
+		buf.WriteString(strings.Repeat("0", -n))
+		buf.WriteString(digits)
+	default:
+		buf.WriteByte(digits[0])
+		if k > 1 {
+			buf.WriteByte('.')
+			buf.WriteString(digits[1:])
+		}
+		buf.WriteByte('e')
+		if n-1 > 0 {
+			buf.WriteByte('+')
+		}
+		buf.WriteString(strconv.Itoa(n - 1))
+	}
+
+	return nil
+}
+
+// lessUTF16 compares the strings by their UTF-16 code units.
+func lessUTF16(a, b string) bool {
+	ua := utf16.Encode([]rune(a))
+	ub := utf16.Encode([]rune(b))
+
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+
+	return len(ua) < len(ub)
+}
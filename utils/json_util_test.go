@@ -14,7 +14,9 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -72,3 +74,106 @@ func TestJsonSort(t *testing.T) {
 	}
 
 }
+
+func TestJsonCanonical(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		what string
+		out  string
+		err  error
+	}{
+		"sorted keys": {
+			what: `{"sn": "00001", "mac": "de:ad:be:ef", "attribute_foo": "foo"}`,
+			out:  `{"attribute_foo":"foo","mac":"de:ad:be:ef","sn":"00001"}`,
+		},
+		"nested": {
+			what: `{"b": {"z": [3, 2, {"y": null, "x": true}], "a": false}, "a": []}`,
+			out:  `{"a":[],"b":{"a":false,"z":[3,2,{"x":true,"y":null}]}}`,
+		},
+		// RFC 8785, 3.2.3
+		"utf-16 key order": {
+			what: `{"\u20ac": "Euro Sign", "\r": "Carriage Return", "\ufb33": "Hebrew Letter Dalet With Dagesh", "1": "One", "\ud83d\ude00": "Emoji: Grinning Face", "\u0080": "Control", "\u00f6": "Latin Small Letter O With Diaeresis"}`,
+			out:  "{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\",\"\u00f6\":\"Latin Small Letter O With Diaeresis\",\"\u20ac\":\"Euro Sign\",\"\U0001F600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}",
+		},
+		"string escaping": {
+			what: `{"s": "\u0022\u005c\u002f\b\f\n\r\t\u0001\u001f<>&\u2028\u00e9"}`,
+			out:  "{\"s\":\"\\\"\\\\/\\b\\f\\n\\r\\t\\u0001\\u001f<>&\u2028\u00e9\"}",
+		},
+		"numbers": {
+			what: `[1.0, -0, 1E2, 0.1e-6, 1e21, 12345678901234567890, -1.5e-7, 100.125]`,
+			out:  `[1,0,100,1e-7,1e+21,12345678901234567000,-1.5e-7,100.125]`,
+		},
+		"error, malformed": {
+			what: `{"mac": }`,
+			err:  errors.New("invalid character '}' looking for beginning of value"),
+		},
+		"error, trailing data": {
+			what: `{"mac": "de:ad:be:ef"} {}`,
+			err:  errors.New("invalid character '{' after top-level value"),
+		},
+		"error, number out of range": {
+			what: `{"n": 1e400}`,
+			err:  errors.New(`invalid number 1e400: strconv.ParseFloat: parsing "1e400": value out of range`),
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			out, err := JsonCanonical(tc.what)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.out, out)
+			}
+		})
+	}
+}
+
+func TestJsonMarshalCanonicalNumbers(t *testing.T) {
+	t.Parallel()
+
+	// RFC 8785, appendix B
+	testCases := map[uint64]string{
+		0x0000000000000000: "0",
+		0x8000000000000000: "0",
+		0x0000000000000001: "5e-324",
+		0x8000000000000001: "-5e-324",
+		0x7fefffffffffffff: "1.7976931348623157e+308",
+		0xffefffffffffffff: "-1.7976931348623157e+308",
+		0x4340000000000000: "9007199254740992",
+		0xc340000000000000: "-9007199254740992",
+		0x4430000000000000: "295147905179352830000",
+		0x44b52d02c7e14af5: "9.999999999999997e+22",
+		0x44b52d02c7e14af6: "1e+23",
+		0x44b52d02c7e14af7: "1.0000000000000001e+23",
+		0x444b1ae4d6e2ef4e: "999999999999999700000",
+		0x444b1ae4d6e2ef4f: "999999999999999900000",
+		0x444b1ae4d6e2ef50: "1e+21",
+		0x3eb0c6f7a0b5ed8c: "9.999999999999997e-7",
+		0x3eb0c6f7a0b5ed8d: "0.000001",
+		0x41b3de4355555553: "333333333.3333332",
+		0x41b3de4355555554: "333333333.33333325",
+		0x41b3de4355555555: "333333333.3333333",
+		0x41b3de4355555556: "333333333.3333334",
+		0x41b3de4355555557: "333333333.33333343",
+		0xbecbf647612f3696: "-0.0000033333333333333333",
+		0x43143ff3c1cb0959: "1424953923781206.2",
+	}
+
+	for bits, out := range testCases {
+		f := math.Float64frombits(bits)
+		enc, err := JsonMarshalCanonical(f)
+		assert.NoError(t, err)
+		assert.Equal(t, out, string(enc), "%016x", bits)
+	}
+
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		_, err := JsonMarshalCanonical(f)
+		assert.Error(t, err)
+	}
+}
@@ -16,6 +16,8 @@ package testing
 import (
 	"bytes"
 	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -27,16 +29,31 @@ import (
 )
 
 const (
-	PrivKeyBlockType = "RSA PRIVATE KEY"
+	PrivKeyBlockType      = "RSA PRIVATE KEY"
+	PrivKeyPKCS8BlockType = "PRIVATE KEY"
 )
 
-func AuthReqSign(data []byte, privkey *rsa.PrivateKey, t *testing.T) []byte {
-	hash := sha256.New()
-	if _, err := bytes.NewReader(data).WriteTo(hash); err != nil {
-		t.Fatal(err)
+// AuthReqSign signs the data the way a device would: PKCS#1 v1.5 for RSA,
+// ASN.1 encoded signature for ECDSA (both over SHA256 of the data), plain
+// Ed25519 signature over the data itself.
+func AuthReqSign(data []byte, privkey crypto.Signer, t *testing.T) []byte {
+	var digest []byte
+	var opts crypto.SignerOpts
+
+	switch privkey.(type) {
+	case ed25519.PrivateKey:
+		digest = data
+		opts = crypto.Hash(0)
+	default:
+		hash := sha256.New()
+		if _, err := bytes.NewReader(data).WriteTo(hash); err != nil {
+			t.Fatal(err)
+		}
+		digest = hash.Sum(nil)
+		opts = crypto.SHA256
 	}
 
-	sig, err := rsa.SignPKCS1v15(rand.Reader, privkey, crypto.SHA256, hash.Sum(nil))
+	sig, err := privkey.Sign(rand.Reader, digest, opts)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -47,6 +64,17 @@ func AuthReqSign(data []byte, privkey *rsa.PrivateKey, t *testing.T) []byte {
 	return b64
 }
 
+// AuthReqHMAC signs the data with the pre-shared key.
+func AuthReqHMAC(data []byte, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+
+	b64 := make([]byte, base64.StdEncoding.EncodedLen(mac.Size()))
+	base64.StdEncoding.Encode(b64, mac.Sum(nil))
+
+	return b64
+}
+
 func LoadPrivKey(path string, t *testing.T) *rsa.PrivateKey {
 	pem_data, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -68,6 +96,33 @@ func LoadPrivKey(path string, t *testing.T) *rsa.PrivateKey {
 	return key
 }
 
+// LoadPrivKeyPKCS8 loads any PKCS#8 encoded private key (RSA, ECDSA, Ed25519)
+func LoadPrivKeyPKCS8(path string, t *testing.T) crypto.Signer {
+	pem_data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := pem.Decode(pem_data)
+
+	if block == nil ||
+		block.Type != PrivKeyPKCS8BlockType {
+		t.Fatal("failed to decode PKCS#8 key")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		t.Fatal("not a signing key")
+	}
+
+	return signer
+}
+
 func LoadPubKeyStr(path string, t *testing.T) string {
 	pem_data, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -76,3 +131,17 @@ func LoadPubKeyStr(path string, t *testing.T) string {
 
 	return string(pem_data)
 }
+
+// LoadCertChainStr concatenates PEM encoded certificates, leaf first
+func LoadCertChainStr(t *testing.T, paths ...string) string {
+	var chain string
+	for _, path := range paths {
+		pem_data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chain += string(pem_data)
+	}
+
+	return chain
+}